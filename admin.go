@@ -0,0 +1,162 @@
+package greedydhcp
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// reacquireHandler serves POST /reacquire?ip=X, signalling the client of
+// target X to drop its lease and request a new one. lookup returns the
+// channel of a running target. Requests must carry the given token as a
+// bearer token.
+func reacquireHandler(logger *slog.Logger, token string, lookup func(ip string) (chan struct{}, bool)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !authorized(r, token) {
+			logger.Warn("Rejected unauthenticated reacquire request", "remote", r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ip := r.URL.Query().Get("ip")
+		if ip == "" {
+			http.Error(w, "missing ip", http.StatusBadRequest)
+			return
+		}
+
+		ch, ok := lookup(ip)
+		if !ok {
+			http.Error(w, "unknown target", http.StatusNotFound)
+			return
+		}
+
+		// A pending signal already covers this request.
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+
+		logger.Info("Re-acquire requested", "target", ip, "remote", r.RemoteAddr)
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// resetOptionCodesHandler serves POST /reset-option-codes?ip=X, forgetting
+// the option codes seen by target X, so that dhcp_server_option_seen only
+// shows those its leases are bound with from then on. known reports whether
+// a target is running, and seen is dhcp_server_option_seen. Requests must
+// carry the given token as a bearer token.
+func resetOptionCodesHandler(logger *slog.Logger, token string, known func(ip string) bool, seen *prometheus.GaugeVec) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !authorized(r, token) {
+			logger.Warn("Rejected unauthenticated option code reset request", "remote", r.RemoteAddr)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		ip := r.URL.Query().Get("ip")
+		if ip == "" {
+			http.Error(w, "missing ip", http.StatusBadRequest)
+			return
+		}
+
+		if !known(ip) {
+			http.Error(w, "unknown target", http.StatusNotFound)
+			return
+		}
+
+		removed := seen.DeletePartialMatch(prometheus.Labels{"ip": ip})
+		logger.Info("Reset option codes seen", "target", ip, "codes", removed, "remote", r.RemoteAddr)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// authorized reports whether r carries token as a bearer token.
+func authorized(r *http.Request, token string) bool {
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// eventsHandler serves GET /events, returning the recent lease events kept by
+// events as JSON, oldest first.
+func eventsHandler(events *eventRing) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(events.recent())
+	})
+}
+
+// livezHandler serves GET /livez and /healthz, answering 200 while the
+// process runs and 503 once it is shutting down.
+func livezHandler(shuttingDown *atomic.Bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if shuttingDown.Load() {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte("ok\n"))
+	})
+}
+
+// maxPendingListed is the most targets still waiting for their first lease
+// that /readyz lists.
+const maxPendingListed = 20
+
+// readyzHandler serves GET /readyz, answering 200 once every one of targets
+// has acquired a lease, as long as any target still holds one, and 503
+// before then, listing the targets still waiting, once every lease is lost
+// or when shutting down.
+func readyzHandler(shuttingDown *atomic.Bool, leases *leaseRegistry, targets func() []string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if shuttingDown.Load() {
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+			return
+		}
+
+		all := targets()
+		if pending := leases.neverBound(all); len(pending) > 0 {
+			listed := pending
+			if len(listed) > maxPendingListed {
+				listed = listed[:maxPendingListed]
+			}
+			msg := fmt.Sprintf("%d of %d targets waiting for a first lease: %s", len(pending), len(all), strings.Join(listed, ", "))
+			if len(listed) < len(pending) {
+				msg += fmt.Sprintf(" and %d more", len(pending)-len(listed))
+			}
+			http.Error(w, msg, http.StatusServiceUnavailable)
+			return
+		}
+
+		if leases.count() == 0 {
+			http.Error(w, "no lease held", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte("ok\n"))
+	})
+}
@@ -0,0 +1,199 @@
+package greedydhcp
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+)
+
+func TestReacquireHandler(t *testing.T) {
+	reacquire := make(chan struct{}, 1)
+	handler := reacquireHandler(testLogger(t), "secret", func(ip string) (chan struct{}, bool) {
+		return reacquire, ip == "10.0.0.1"
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		auth   string
+		want   int
+	}{
+		{name: "accepted", method: http.MethodPost, url: "/reacquire?ip=10.0.0.1", auth: "Bearer secret", want: http.StatusAccepted},
+		{name: "pending signal", method: http.MethodPost, url: "/reacquire?ip=10.0.0.1", auth: "Bearer secret", want: http.StatusAccepted},
+		{name: "wrong method", method: http.MethodGet, url: "/reacquire?ip=10.0.0.1", auth: "Bearer secret", want: http.StatusMethodNotAllowed},
+		{name: "missing token", method: http.MethodPost, url: "/reacquire?ip=10.0.0.1", want: http.StatusUnauthorized},
+		{name: "wrong token", method: http.MethodPost, url: "/reacquire?ip=10.0.0.1", auth: "Bearer nope", want: http.StatusUnauthorized},
+		{name: "missing ip", method: http.MethodPost, url: "/reacquire", auth: "Bearer secret", want: http.StatusBadRequest},
+		{name: "unknown target", method: http.MethodPost, url: "/reacquire?ip=10.0.0.2", auth: "Bearer secret", want: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+
+	select {
+	case <-reacquire:
+	default:
+		t.Error("expected the target to be signalled")
+	}
+}
+
+func TestResetOptionCodesHandler(t *testing.T) {
+	testMetrics.recordOptionCodes("10.0.0.1", []layers.DHCPOpt{layers.DHCPOptPad, layers.DHCPOptSubnetMask, layers.DHCPOptRouter, layers.DHCPOptEnd})
+	testMetrics.recordOptionCodes("10.0.0.3", []layers.DHCPOpt{layers.DHCPOptSubnetMask})
+	if hasSeries(t, testMetrics.dhcpServerOptionSeen, map[string]string{"ip": "10.0.0.1", "code": "0"}) {
+		t.Error("expected pad not to be recorded")
+	}
+	if !hasSeries(t, testMetrics.dhcpServerOptionSeen, map[string]string{"ip": "10.0.0.1", "code": "3"}) {
+		t.Fatal("expected the router option to be recorded")
+	}
+
+	handler := resetOptionCodesHandler(testLogger(t), "secret", func(ip string) bool {
+		return ip == "10.0.0.1" || ip == "10.0.0.3"
+	}, testMetrics.dhcpServerOptionSeen)
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		auth   string
+		want   int
+	}{
+		{name: "wrong method", method: http.MethodGet, url: "/reset-option-codes?ip=10.0.0.1", auth: "Bearer secret", want: http.StatusMethodNotAllowed},
+		{name: "wrong token", method: http.MethodPost, url: "/reset-option-codes?ip=10.0.0.1", auth: "Bearer nope", want: http.StatusUnauthorized},
+		{name: "missing ip", method: http.MethodPost, url: "/reset-option-codes", auth: "Bearer secret", want: http.StatusBadRequest},
+		{name: "unknown target", method: http.MethodPost, url: "/reset-option-codes?ip=10.0.0.2", auth: "Bearer secret", want: http.StatusNotFound},
+		{name: "reset", method: http.MethodPost, url: "/reset-option-codes?ip=10.0.0.1", auth: "Bearer secret", want: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			req.Header.Set("Authorization", tt.auth)
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("expected status %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+
+	if hasSeries(t, testMetrics.dhcpServerOptionSeen, map[string]string{"ip": "10.0.0.1"}) {
+		t.Error("expected the codes of the target to be forgotten")
+	}
+	if !hasSeries(t, testMetrics.dhcpServerOptionSeen, map[string]string{"ip": "10.0.0.3"}) {
+		t.Error("expected the codes of other targets to be kept")
+	}
+}
+
+func TestEventsHandler(t *testing.T) {
+	events := newEventRing(10, 0, testMetrics.greedydhcpHistoryBytes)
+	logLeaseEvent(testLogger(t), events, nil, "10.0.0.1", slog.LevelInfo, eventFailed, "DHCP exchange failed", nil, "reason", failureTimeout)
+
+	rec := httptest.NewRecorder()
+	eventsHandler(events).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	var got []leaseEvent
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Target != "10.0.0.1" || got[0].Event != eventFailed || got[0].Details["reason"] != failureTimeout {
+		t.Errorf("unexpected events %+v", got)
+	}
+
+	rec = httptest.NewRecorder()
+	eventsHandler(events).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/events", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected status %d, got %d", http.StatusMethodNotAllowed, rec.Code)
+	}
+}
+
+func TestHealthHandlers(t *testing.T) {
+	var shuttingDown atomic.Bool
+	leases := newLeaseRegistry(testMetrics.dhcpDuplicateBoundAddresses)
+	livez := livezHandler(&shuttingDown)
+	readyz := readyzHandler(&shuttingDown, leases, func() []string { return []string{"10.0.0.1"} })
+
+	code := func(h http.Handler) int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+
+	if c := code(livez); c != http.StatusOK {
+		t.Errorf("expected live, got %d", c)
+	}
+	if c := code(readyz); c != http.StatusServiceUnavailable {
+		t.Errorf("expected not ready without a lease, got %d", c)
+	}
+
+	leases.set("10.0.0.1", &dhclient.Lease{FixedAddress: net.IPv4(10, 0, 0, 1)})
+	if c := code(readyz); c != http.StatusOK {
+		t.Errorf("expected ready with a lease, got %d", c)
+	}
+
+	shuttingDown.Store(true)
+	if c := code(livez); c != http.StatusServiceUnavailable {
+		t.Errorf("expected not live while shutting down, got %d", c)
+	}
+	if c := code(readyz); c != http.StatusServiceUnavailable {
+		t.Errorf("expected not ready while shutting down, got %d", c)
+	}
+}
+
+func TestReadyzWaitsForEveryTarget(t *testing.T) {
+	var shuttingDown atomic.Bool
+	leases := newLeaseRegistry(testMetrics.dhcpDuplicateBoundAddresses)
+	readyz := readyzHandler(&shuttingDown, leases, func() []string {
+		return []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"}
+	})
+
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		readyz.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return rec
+	}
+
+	leases.set("10.0.0.1", &dhclient.Lease{FixedAddress: net.IPv4(10, 0, 0, 1)})
+	leases.set("10.0.0.3", &dhclient.Lease{FixedAddress: net.IPv4(10, 0, 0, 3)})
+	rec := serve()
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected not ready while a target waits for its first lease, got %d", rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "1 of 3") || !strings.Contains(body, "10.0.0.2") || strings.Contains(body, "10.0.0.3") {
+		t.Errorf("expected only the pending target to be listed, got %q", body)
+	}
+
+	// A target that acquired a lease once counts even after losing it.
+	leases.remove("10.0.0.1")
+	leases.set("10.0.0.2", &dhclient.Lease{FixedAddress: net.IPv4(10, 0, 0, 2)})
+	if rec := serve(); rec.Code != http.StatusOK {
+		t.Errorf("expected ready once every target acquired a lease, got %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,177 @@
+package greedydhcp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// anonymousConfig configures anonymous clients, which have no target address
+// and take whatever address the server offers, sampling the pool rather than
+// probing fixed addresses. Unlike stress clients, they are reported by the
+// address they hold.
+type anonymousConfig struct {
+	// clients is the number of anonymous clients. They are disabled when it
+	// is zero.
+	clients int
+	// subnet, if set, is the subnet offers must be in to be requested.
+	subnet *net.IPNet
+}
+
+// getAnonymousConfig reads the anonymous client settings from the
+// environment.
+func getAnonymousConfig() (anonymousConfig, error) {
+	var (
+		cfg anonymousConfig
+		err error
+	)
+
+	cfg.clients, err = getEnvInt("ANONYMOUS_CLIENTS", 0)
+	if err != nil {
+		return cfg, err
+	}
+
+	if cfg.clients < 0 {
+		return cfg, fmt.Errorf("ANONYMOUS_CLIENTS must not be negative, got %d", cfg.clients)
+	}
+
+	if subnet := os.Getenv("ANONYMOUS_SUBNET"); subnet != "" {
+		_, cfg.subnet, err = net.ParseCIDR(subnet)
+		if err != nil {
+			return cfg, fmt.Errorf("ANONYMOUS_SUBNET must be a CIDR: %w", err)
+		}
+		if cfg.subnet.IP.To4() == nil {
+			return cfg, fmt.Errorf("ANONYMOUS_SUBNET must be an IPv4 subnet, got %s", subnet)
+		}
+	}
+
+	return cfg, nil
+}
+
+// anonymousLeases tracks the address held by each anonymous client, so that
+// the series of an address are deleted once its client no longer holds it.
+type anonymousLeases struct {
+	metrics *metrics
+
+	mu   sync.Mutex
+	held map[string]string
+}
+
+// bound records lease as held by the client with the given hardware
+// address, replacing the series of any address it held before.
+func (a *anonymousLeases) bound(mac string, lease *dhclient.Lease) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	addr := lease.FixedAddress.String()
+	if prev, ok := a.held[mac]; ok && prev != addr {
+		a.metrics.deleteAnonymousSeries(prev)
+	}
+	a.held[mac] = addr
+
+	a.metrics.dhcpAnonymousLeaseInfo.DeletePartialMatch(prometheus.Labels{"ip": addr})
+	a.metrics.dhcpAnonymousLeaseInfo.WithLabelValues(addr, mac, lease.ServerID.String()).Set(1)
+	if lease.Infinite {
+		a.metrics.dhcpAnonymousLeaseExpiryTimestampSeconds.DeletePartialMatch(prometheus.Labels{"ip": addr})
+	} else {
+		a.metrics.dhcpAnonymousLeaseExpiryTimestampSeconds.WithLabelValues(addr).Set(float64(lease.Expire.Unix()))
+	}
+	a.metrics.dhcpAnonymousAddressesHeld.Set(float64(len(a.held)))
+}
+
+// lost forgets the address held by the client with the given hardware
+// address, deleting its series.
+func (a *anonymousLeases) lost(mac string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if addr, ok := a.held[mac]; ok {
+		a.metrics.deleteAnonymousSeries(addr)
+		delete(a.held, mac)
+	}
+	a.metrics.dhcpAnonymousAddressesHeld.Set(float64(len(a.held)))
+}
+
+// clear forgets every address held, deleting their series, once the clients
+// have stopped.
+func (a *anonymousLeases) clear() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for mac, addr := range a.held {
+		a.metrics.deleteAnonymousSeries(addr)
+		delete(a.held, mac)
+	}
+	a.metrics.dhcpAnonymousAddressesHeld.Set(0)
+}
+
+// deleteAnonymousSeries deletes the series of the anonymous lease of addr.
+func (m *metrics) deleteAnonymousSeries(addr string) {
+	m.dhcpAnonymousLeaseInfo.DeletePartialMatch(prometheus.Labels{"ip": addr})
+	m.dhcpAnonymousLeaseExpiryTimestampSeconds.DeletePartialMatch(prometheus.Labels{"ip": addr})
+}
+
+// runAnonymous starts anonymous.clients clients, each with a random hardware
+// address, and stops them all once ctx is done.
+func runAnonymous(ctx context.Context, wg *sync.WaitGroup, baseLogger *slog.Logger, cfg *clientConfig, anonymous anonymousConfig) {
+	defer wg.Done()
+
+	cfg.metrics.dhcpAnonymousBoundLeasesTotal.Add(0)
+	cfg.metrics.dhcpAnonymousRejectedOffersTotal.Add(0)
+	cfg.metrics.dhcpAnonymousAddressesHeld.Set(0)
+
+	logger := baseLogger.With("mode", "anonymous")
+	logger.Info("Starting anonymous clients", "clients", anonymous.clients, "subnet", anonymous.subnet)
+
+	leases := &anonymousLeases{metrics: cfg.metrics, held: map[string]string{}}
+	clients := make([]*dhclient.Client, 0, anonymous.clients)
+	defer func() {
+		logger.Info("Stopping anonymous clients", "clients", len(clients))
+		for _, client := range clients {
+			client.Stop()
+		}
+		leases.clear()
+	}()
+
+	for len(clients) < anonymous.clients {
+		mac, err := randomMAC()
+		if err != nil {
+			logger.Error("Unable to generate hardware address, no longer starting clients", "err", err)
+			break
+		}
+
+		clientLogger := logger.With("mac", mac)
+		key := mac.String()
+		client := newProbeClient(cfg, clientLogger, mac)
+		if subnet := anonymous.subnet; subnet != nil {
+			client.AcceptOffer = func(lease *dhclient.Lease) error {
+				if subnet.Contains(lease.FixedAddress) {
+					return nil
+				}
+				clientLogger.Warn("Rejecting offer outside of subnet", "addr", lease.FixedAddress, "subnet", subnet)
+				cfg.metrics.dhcpAnonymousRejectedOffersTotal.Inc()
+				return fmt.Errorf("%s is outside of %s", lease.FixedAddress, subnet)
+			}
+		}
+		client.OnBound = func(lease *dhclient.Lease) {
+			clientLogger.Debug("Anonymous client got lease", "addr", lease.FixedAddress, "server", lease.ServerID)
+			cfg.metrics.dhcpAnonymousBoundLeasesTotal.Inc()
+			leases.bound(key, lease)
+		}
+		client.OnExpire = func(lease *dhclient.Lease) {
+			clientLogger.Warn("Anonymous client lease expired", "addr", lease.FixedAddress)
+			leases.lost(key)
+		}
+
+		client.Start()
+		clients = append(clients, client)
+	}
+
+	<-ctx.Done()
+}
@@ -0,0 +1,102 @@
+package greedydhcp
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+)
+
+func startAnonymous(t *testing.T, iface *net.Interface, anonymous anonymousConfig) context.CancelFunc {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go runAnonymous(ctx, wg, testLogger(t), testClientConfig(iface), anonymous)
+	stop := func() {
+		cancel()
+		wg.Wait()
+	}
+	t.Cleanup(stop)
+
+	return stop
+}
+
+func TestRunAnonymousExportsBoundAddresses(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	_, subnet, _ := net.ParseCIDR("10.200.0.0/24")
+	stop := startAnonymous(t, iface, anonymousConfig{clients: 2, subnet: subnet})
+
+	waitFor(t, 10*time.Second, "anonymous clients to hold addresses", func() bool {
+		return metricValue(t, testMetrics.dhcpAnonymousAddressesHeld) == 2
+	})
+
+	for _, addr := range []string{"10.200.0.1", "10.200.0.2"} {
+		if !hasSeries(t, testMetrics.dhcpAnonymousLeaseInfo, map[string]string{"ip": addr, "server": "127.0.0.1"}) {
+			t.Errorf("expected the lease of %s to be exported", addr)
+		}
+		if !hasSeries(t, testMetrics.dhcpAnonymousLeaseExpiryTimestampSeconds, map[string]string{"ip": addr}) {
+			t.Errorf("expected the expiry of %s to be exported", addr)
+		}
+	}
+
+	stop()
+	if hasSeries(t, testMetrics.dhcpAnonymousLeaseInfo, map[string]string{}) {
+		t.Error("expected the leases to be deleted once the clients stopped")
+	}
+}
+
+func TestRunAnonymousRejectsOffersOutsideSubnet(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	before := metricValue(t, testMetrics.dhcpAnonymousRejectedOffersTotal)
+	_, subnet, _ := net.ParseCIDR("10.201.0.0/24")
+	startAnonymous(t, iface, anonymousConfig{clients: 1, subnet: subnet})
+
+	waitFor(t, 10*time.Second, "the offer to be rejected", func() bool {
+		return metricValue(t, testMetrics.dhcpAnonymousRejectedOffersTotal) > before
+	})
+
+	if v := metricValue(t, testMetrics.dhcpAnonymousAddressesHeld); v != 0 {
+		t.Errorf("expected no address to be held, got %v", v)
+	}
+}
+
+func TestAnonymousLeasesDeleteStaleSeries(t *testing.T) {
+	leases := &anonymousLeases{metrics: testMetrics, held: map[string]string{}}
+	t.Cleanup(leases.clear)
+
+	lease := &dhclient.Lease{
+		FixedAddress: net.ParseIP("10.200.1.1").To4(),
+		ServerID:     net.ParseIP("10.200.1.254").To4(),
+		Expire:       time.Now().Add(time.Hour),
+	}
+	leases.bound("02:00:00:00:00:01", lease)
+
+	moved := *lease
+	moved.FixedAddress = net.ParseIP("10.200.1.2").To4()
+	leases.bound("02:00:00:00:00:01", &moved)
+
+	if hasSeries(t, testMetrics.dhcpAnonymousLeaseInfo, map[string]string{"ip": "10.200.1.1"}) ||
+		hasSeries(t, testMetrics.dhcpAnonymousLeaseExpiryTimestampSeconds, map[string]string{"ip": "10.200.1.1"}) {
+		t.Error("expected the series of the previous address to be deleted")
+	}
+	if !hasSeries(t, testMetrics.dhcpAnonymousLeaseInfo, map[string]string{"ip": "10.200.1.2", "mac": "02:00:00:00:00:01"}) {
+		t.Error("expected the new address to be exported")
+	}
+
+	leases.lost("02:00:00:00:00:01")
+	if hasSeries(t, testMetrics.dhcpAnonymousLeaseInfo, map[string]string{"ip": "10.200.1.2"}) {
+		t.Error("expected the series to be deleted once the lease expired")
+	}
+	if v := metricValue(t, testMetrics.dhcpAnonymousAddressesHeld); v != 0 {
+		t.Errorf("expected no address to be held, got %v", v)
+	}
+}
@@ -0,0 +1,95 @@
+package greedydhcp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/mdlayher/packet"
+)
+
+// announceAddress sends a gratuitous ARP announcing addr as owned by hwAddr on
+// iface, as a client does once it binds a lease, then waits up to timeout for
+// another host to defend the address. It returns the hardware address of the
+// defender, or nil if none answered. It needs a raw socket, so the same
+// privileges as the DHCP clients.
+func announceAddress(iface *net.Interface, hwAddr net.HardwareAddr, addr net.IP, timeout time.Duration) (net.HardwareAddr, error) {
+	return sendARP(iface, hwAddr, addr, addr, timeout)
+}
+
+// probeAddress sends an ARP probe for addr from hwAddr on iface, which leaves
+// the sender address unset so that no host updates its cache (RFC 5227
+// section 2.1.1), then waits up to timeout for another host to answer for
+// addr. It returns the hardware address of that host, or nil if none answered.
+func probeAddress(iface *net.Interface, hwAddr net.HardwareAddr, addr net.IP, timeout time.Duration) (net.HardwareAddr, error) {
+	return sendARP(iface, hwAddr, net.IPv4zero, addr, timeout)
+}
+
+// sendARP broadcasts an ARP request for addr from hwAddr and sender, and
+// returns the hardware address of the first other host claiming addr within
+// timeout.
+func sendARP(iface *net.Interface, hwAddr net.HardwareAddr, sender, addr net.IP, timeout time.Duration) (net.HardwareAddr, error) {
+	conn, err := packet.Listen(iface, packet.Raw, int(layers.EthernetTypeARP), nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open arp socket: %w", err)
+	}
+	defer conn.Close()
+
+	addr = addr.To4()
+	eth := layers.Ethernet{
+		SrcMAC:       hwAddr,
+		DstMAC:       layers.EthernetBroadcast,
+		EthernetType: layers.EthernetTypeARP,
+	}
+	arp := layers.ARP{
+		AddrType:          layers.LinkTypeEthernet,
+		Protocol:          layers.EthernetTypeIPv4,
+		HwAddressSize:     6,
+		ProtAddressSize:   4,
+		Operation:         layers.ARPRequest,
+		SourceHwAddress:   hwAddr,
+		SourceProtAddress: sender.To4(),
+		DstHwAddress:      make([]byte, 6),
+		DstProtAddress:    addr,
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, &eth, &arp); err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := conn.WriteTo(buf.Bytes(), &packet.Addr{HardwareAddr: layers.EthernetBroadcast}); err != nil {
+		return nil, fmt.Errorf("unable to send arp request: %w", err)
+	}
+
+	frame := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFrom(frame)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return nil, nil
+			}
+			return nil, err
+		}
+
+		p := gopacket.NewPacket(frame[:n], layers.LayerTypeEthernet, gopacket.NoCopy)
+		reply, ok := p.Layer(layers.LayerTypeARP).(*layers.ARP)
+		if !ok {
+			continue
+		}
+
+		// Any host claiming the address other than ourselves defends it,
+		// whether by replying or by announcing it in turn.
+		if bytes.Equal(reply.SourceProtAddress, addr) && !bytes.Equal(reply.SourceHwAddress, hwAddr) {
+			return net.HardwareAddr(append([]byte(nil), reply.SourceHwAddress...)), nil
+		}
+	}
+}
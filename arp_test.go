@@ -0,0 +1,123 @@
+package greedydhcp
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/mdlayher/packet"
+)
+
+// defendAddress answers every ARP request for addr on iface with a reply from
+// hwAddr until the test ends, as a host already using addr would.
+func defendAddress(t *testing.T, iface *net.Interface, hwAddr net.HardwareAddr, addr net.IP) {
+	t.Helper()
+
+	conn, err := packet.Listen(iface, packet.Raw, int(layers.EthernetTypeARP), nil)
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("unable to open raw socket on %s: %v", iface.Name, err)
+		}
+		t.Fatalf("unable to open raw socket on %s: %v", iface.Name, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		frame := make([]byte, 1500)
+		for {
+			n, _, err := conn.ReadFrom(frame)
+			if err != nil {
+				return
+			}
+
+			p := gopacket.NewPacket(frame[:n], layers.LayerTypeEthernet, gopacket.NoCopy)
+			req, ok := p.Layer(layers.LayerTypeARP).(*layers.ARP)
+			if !ok || req.Operation != layers.ARPRequest || !bytes.Equal(req.DstProtAddress, addr.To4()) {
+				continue
+			}
+
+			eth := layers.Ethernet{
+				SrcMAC:       hwAddr,
+				DstMAC:       req.SourceHwAddress,
+				EthernetType: layers.EthernetTypeARP,
+			}
+			reply := layers.ARP{
+				AddrType:          layers.LinkTypeEthernet,
+				Protocol:          layers.EthernetTypeIPv4,
+				HwAddressSize:     6,
+				ProtAddressSize:   4,
+				Operation:         layers.ARPReply,
+				SourceHwAddress:   hwAddr,
+				SourceProtAddress: addr.To4(),
+				DstHwAddress:      req.SourceHwAddress,
+				DstProtAddress:    req.SourceProtAddress,
+			}
+
+			buf := gopacket.NewSerializeBuffer()
+			if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, &eth, &reply); err != nil {
+				t.Errorf("unable to serialize arp reply: %v", err)
+				return
+			}
+			conn.WriteTo(buf.Bytes(), &packet.Addr{HardwareAddr: req.SourceHwAddress})
+		}
+	}()
+}
+
+func TestAnnounceAddress(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+
+	ours := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	defender := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}
+	defendAddress(t, lo, defender, net.IPv4(10, 100, 0, 35))
+
+	got, err := announceAddress(lo, ours, net.IPv4(10, 100, 0, 35), time.Second)
+	if err != nil {
+		t.Fatalf("unable to announce address: %v", err)
+	}
+	if !bytes.Equal(got, defender) {
+		t.Errorf("expected %s to defend the address, got %v", defender, got)
+	}
+
+	got, err = announceAddress(lo, ours, net.IPv4(10, 100, 0, 36), 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unable to announce address: %v", err)
+	}
+	if got != nil {
+		t.Errorf("expected nobody to defend an unused address, got %s", got)
+	}
+}
+
+func TestProbeAddress(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+
+	ours := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	owner := net.HardwareAddr{0x02, 0, 0, 0, 0, 0x03}
+	defendAddress(t, lo, owner, net.IPv4(10, 100, 0, 37))
+
+	got, err := probeAddress(lo, ours, net.IPv4(10, 100, 0, 37), time.Second)
+	if err != nil {
+		t.Fatalf("unable to probe address: %v", err)
+	}
+	if !bytes.Equal(got, owner) {
+		t.Errorf("expected %s to answer for the address, got %v", owner, got)
+	}
+
+	got, err = probeAddress(lo, ours, net.IPv4(10, 100, 0, 38), 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("unable to probe address: %v", err)
+	}
+	if got != nil {
+		t.Errorf("expected nobody to answer for an unused address, got %s", got)
+	}
+}
@@ -0,0 +1,136 @@
+package greedydhcp
+
+import (
+	"sync"
+	"time"
+)
+
+// retryBackoff computes how long a target waits before retrying after
+// consecutive failures: base after the first one, doubling after each one
+// that follows, up to max.
+type retryBackoff struct {
+	base time.Duration
+	max  time.Duration
+
+	mu       sync.Mutex
+	failures int
+}
+
+// newRetryBackoff returns a backoff starting at base and capped at max, or
+// nil, which never waits, if base isn't positive.
+func newRetryBackoff(base, max time.Duration) *retryBackoff {
+	if base <= 0 {
+		return nil
+	}
+
+	return &retryBackoff{base: base, max: max}
+}
+
+// failure counts a failure and returns how long to wait before retrying.
+func (b *retryBackoff) failure() time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	delay := b.base
+	for i := 1; i < b.failures && delay < b.max; i++ {
+		delay *= 2
+	}
+	if delay > b.max {
+		delay = b.max
+	}
+
+	return delay
+}
+
+// reset forgets the failures counted so far.
+func (b *retryBackoff) reset() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+}
+
+// broadcast wakes every waiting client each time something happens.
+type broadcast struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+func newBroadcast() *broadcast {
+	return &broadcast{ch: make(chan struct{})}
+}
+
+// wait returns a channel closed the next time notify is called. A nil
+// broadcast returns a nil channel, which never is.
+func (b *broadcast) wait() <-chan struct{} {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.ch
+}
+
+// notify wakes everyone waiting.
+func (b *broadcast) notify() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	close(b.ch)
+	b.ch = make(chan struct{})
+}
+
+// linkNotifier tells the clients when the interface comes back up after
+// being down.
+type linkNotifier struct {
+	*broadcast
+
+	mu   sync.Mutex
+	down bool
+}
+
+func newLinkNotifier() *linkNotifier {
+	return &linkNotifier{broadcast: newBroadcast()}
+}
+
+// wait returns a channel closed the next time the interface comes back up.
+// A nil notifier returns a nil channel, which never is.
+func (n *linkNotifier) wait() <-chan struct{} {
+	if n == nil {
+		return nil
+	}
+
+	return n.broadcast.wait()
+}
+
+// observe records the current state of the interface, returning true and
+// waking the waiting clients if it just came back up.
+func (n *linkNotifier) observe(up bool) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if !up {
+		n.down = true
+		return false
+	}
+
+	if !n.down {
+		return false
+	}
+
+	n.down = false
+	n.notify()
+
+	return true
+}
@@ -0,0 +1,57 @@
+package greedydhcp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	b := newRetryBackoff(time.Second, 5*time.Second)
+
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := b.failure(); got != want {
+			t.Errorf("failure %d: expected %s, got %s", i+1, want, got)
+		}
+	}
+
+	b.reset()
+	if got := b.failure(); got != time.Second {
+		t.Errorf("expected the backoff to start over after a reset, got %s", got)
+	}
+
+	var disabled *retryBackoff
+	if got := disabled.failure(); got != 0 {
+		t.Errorf("expected a nil backoff to never wait, got %s", got)
+	}
+}
+
+func TestLinkNotifier(t *testing.T) {
+	n := newLinkNotifier()
+	up := n.wait()
+
+	if n.observe(true) {
+		t.Fatal("expected no notification while the link stays up")
+	}
+
+	n.observe(false)
+	n.observe(false)
+	if !n.observe(true) {
+		t.Fatal("expected a notification once the link comes back up")
+	}
+
+	select {
+	case <-up:
+	default:
+		t.Fatal("expected the waiting channel to be closed")
+	}
+
+	select {
+	case <-n.wait():
+		t.Fatal("expected a fresh channel for the next flap")
+	default:
+	}
+
+	if n.observe(true) {
+		t.Error("expected a single notification per flap")
+	}
+}
@@ -0,0 +1,147 @@
+package greedydhcp
+
+import (
+	"sync"
+	"time"
+)
+
+type breakerState int
+
+const (
+	// breakerClosed means requests are flowing normally.
+	breakerClosed breakerState = iota
+	// breakerOpen means requests are paused until the cooldown passes.
+	breakerOpen
+	// breakerHalfOpen means a single probe is in flight after a cooldown.
+	breakerHalfOpen
+)
+
+var breakerStates = []breakerState{breakerClosed, breakerOpen, breakerHalfOpen}
+
+func (s breakerState) String() string {
+	switch s {
+	case breakerClosed:
+		return "closed"
+	case breakerOpen:
+		return "open"
+	case breakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// breakerConfig configures a circuitBreaker. A threshold of zero disables the
+// breaker.
+type breakerConfig struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+}
+
+func getBreakerConfig() (breakerConfig, error) {
+	var (
+		cfg breakerConfig
+		err error
+	)
+
+	cfg.threshold, err = getEnvInt("BREAKER_THRESHOLD", 0)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.window, err = getEnvDuration("BREAKER_WINDOW", time.Minute)
+	if err != nil {
+		return cfg, err
+	}
+
+	cfg.cooldown, err = getEnvDuration("BREAKER_COOLDOWN", 5*time.Minute)
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// circuitBreaker opens after threshold consecutive failures within window.
+// Once the cooldown has passed the owner moves it to half-open, where the
+// next success closes it again and the next failure reopens it.
+type circuitBreaker struct {
+	cfg      breakerConfig
+	onChange func(breakerState)
+
+	mu           sync.Mutex
+	state        breakerState
+	failures     int
+	firstFailure time.Time
+}
+
+// newCircuitBreaker returns a closed breaker. onChange is called with the new
+// state on every transition, and once with the initial state.
+func newCircuitBreaker(cfg breakerConfig, onChange func(breakerState)) *circuitBreaker {
+	b := &circuitBreaker{cfg: cfg, onChange: onChange}
+	onChange(breakerClosed)
+	return b
+}
+
+func (b *circuitBreaker) setState(state breakerState) {
+	if b.state == state {
+		return
+	}
+
+	b.state = state
+	b.onChange(state)
+}
+
+// recordFailure counts a failure seen at now, opening the breaker if needed.
+func (b *circuitBreaker) recordFailure(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.cfg.threshold <= 0 || b.state == breakerOpen {
+		return
+	}
+
+	if b.state == breakerHalfOpen {
+		b.setState(breakerOpen)
+		return
+	}
+
+	if b.failures == 0 || now.Sub(b.firstFailure) > b.cfg.window {
+		b.failures = 0
+		b.firstFailure = now
+	}
+
+	b.failures++
+	if b.failures >= b.cfg.threshold {
+		b.failures = 0
+		b.setState(breakerOpen)
+	}
+}
+
+// recordSuccess resets the failure count and closes the breaker.
+func (b *circuitBreaker) recordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures = 0
+	b.setState(breakerClosed)
+}
+
+// halfOpen moves an open breaker to half-open to allow a probe.
+func (b *circuitBreaker) halfOpen() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerOpen {
+		b.setState(breakerHalfOpen)
+	}
+}
+
+// current returns the current state.
+func (b *circuitBreaker) current() breakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.state
+}
@@ -0,0 +1,83 @@
+package greedydhcp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	var changes []breakerState
+	b := newCircuitBreaker(
+		breakerConfig{threshold: 3, window: time.Minute, cooldown: time.Minute},
+		func(s breakerState) { changes = append(changes, s) },
+	)
+
+	now := time.Now()
+	b.recordFailure(now)
+	b.recordFailure(now.Add(time.Second))
+	if s := b.current(); s != breakerClosed {
+		t.Fatalf("expected closed after 2 failures, got %s", s)
+	}
+
+	b.recordFailure(now.Add(2 * time.Second))
+	if s := b.current(); s != breakerOpen {
+		t.Fatalf("expected open after 3 failures, got %s", s)
+	}
+
+	b.halfOpen()
+	if s := b.current(); s != breakerHalfOpen {
+		t.Fatalf("expected half-open, got %s", s)
+	}
+
+	b.recordFailure(now.Add(3 * time.Second))
+	if s := b.current(); s != breakerOpen {
+		t.Fatalf("expected a failed probe to reopen, got %s", s)
+	}
+
+	b.halfOpen()
+	b.recordSuccess()
+	if s := b.current(); s != breakerClosed {
+		t.Fatalf("expected a successful probe to close, got %s", s)
+	}
+
+	want := []breakerState{breakerClosed, breakerOpen, breakerHalfOpen, breakerOpen, breakerHalfOpen, breakerClosed}
+	if len(changes) != len(want) {
+		t.Fatalf("expected changes %v, got %v", want, changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("expected changes %v, got %v", want, changes)
+		}
+	}
+}
+
+func TestCircuitBreakerWindow(t *testing.T) {
+	b := newCircuitBreaker(
+		breakerConfig{threshold: 2, window: time.Second, cooldown: time.Minute},
+		func(breakerState) {},
+	)
+
+	now := time.Now()
+	b.recordFailure(now)
+	b.recordFailure(now.Add(2 * time.Second))
+	if s := b.current(); s != breakerClosed {
+		t.Fatalf("expected failures outside the window not to trip, got %s", s)
+	}
+
+	b.recordFailure(now.Add(2500 * time.Millisecond))
+	if s := b.current(); s != breakerOpen {
+		t.Fatalf("expected open, got %s", s)
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	b := newCircuitBreaker(breakerConfig{}, func(breakerState) {})
+
+	for i := 0; i < 10; i++ {
+		b.recordFailure(time.Now())
+	}
+
+	if s := b.current(); s != breakerClosed {
+		t.Fatalf("expected a disabled breaker to stay closed, got %s", s)
+	}
+}
@@ -0,0 +1,73 @@
+package greedydhcp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+	"github.com/mdlayher/packet"
+)
+
+// runChecks checks, without acquiring any lease, that clients could run on
+// iface for targets, whose configuration has already been validated. A line
+// is printed to w per check, and it returns whether all of them passed.
+func runChecks(w io.Writer, iface *net.Interface, targets []targetConfig) bool {
+	ok := true
+	report := func(name string, err error, detail string) {
+		if err != nil {
+			ok = false
+			fmt.Fprintf(w, "FAIL  %s: %v\n", name, err)
+			return
+		}
+		fmt.Fprintf(w, "PASS  %s: %s\n", name, detail)
+	}
+
+	report("interface", nil, fmt.Sprintf("%s (mac %s, index %d)", iface.Name, iface.HardwareAddr, iface.Index))
+
+	disabled := 0
+	namespaces := map[string]bool{}
+	for _, target := range targets {
+		if target.disabled {
+			disabled++
+		} else if target.netns != "" {
+			namespaces[target.netns] = true
+		}
+	}
+	report("config", nil, fmt.Sprintf("%d targets, %d disabled", len(targets), disabled))
+
+	report("raw socket on "+iface.Name, checkSocket(dhclient.ListenRaw(iface)), "opened")
+	for _, path := range sortedKeys(namespaces) {
+		report("raw socket in "+path, checkSocket(listenInNetns(path, iface.Name)), "opened")
+	}
+
+	if ok {
+		fmt.Fprintln(w, "All checks passed")
+	} else {
+		fmt.Fprintln(w, "Some checks failed")
+	}
+
+	return ok
+}
+
+// checkSocket closes conn if it was opened, and explains err if it wasn't
+// for lack of privileges.
+func checkSocket(conn *packet.Conn, err error) error {
+	if err != nil {
+		return withSocketHint(err)
+	}
+
+	return conn.Close()
+}
+
+// withSocketHint adds the capability raw sockets need to err if it is a
+// permission error.
+func withSocketHint(err error) error {
+	if errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("%w (raw sockets need CAP_NET_RAW)", err)
+	}
+
+	return err
+}
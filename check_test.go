@@ -0,0 +1,32 @@
+package greedydhcp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRunChecks(t *testing.T) {
+	iface := loopbackInterface(t)
+
+	var out bytes.Buffer
+	targets := []targetConfig{{addr: "10.0.0.1"}, {addr: "10.0.0.2", disabled: true}}
+	if !runChecks(&out, iface, targets) {
+		if strings.Contains(out.String(), "CAP_NET_RAW") {
+			t.Skip("raw sockets are not permitted")
+		}
+		t.Fatalf("expected every check to pass, got:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "PASS  config: 2 targets, 1 disabled") {
+		t.Errorf("expected the targets to be reported, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	targets = append(targets, targetConfig{addr: "10.0.0.3", netns: "/nonexistent/netns"})
+	if runChecks(&out, iface, targets) {
+		t.Fatalf("expected a missing namespace to fail, got:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "FAIL  raw socket in /nonexistent/netns") {
+		t.Errorf("expected the namespace to be reported, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,136 @@
+package greedydhcp
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Phases of a target that periodically releases its lease. These are used as
+// label values.
+const (
+	churnRequesting = "requesting"
+	churnHolding    = "holding"
+	churnReleasing  = "releasing"
+)
+
+var churnPhases = []string{churnRequesting, churnHolding, churnReleasing}
+
+// Outcomes of requesting a lease again after releasing one, comparing the
+// address bound to the released one.
+const (
+	churnSameAddress = "same_address"
+	churnNewAddress  = "new_address"
+)
+
+var churnReacquireOutcomes = []string{churnSameAddress, churnNewAddress}
+
+// churnCycle releases the lease of a target once it has been held for
+// holdTime, requesting a new one after cooldown. It outlives the client
+// restarts between cycles. A nil churnCycle, of a target without a hold time,
+// never releases its lease.
+type churnCycle struct {
+	logger     *slog.Logger
+	metrics    *metrics
+	targetAddr string
+	holdTime   time.Duration
+	cooldown   time.Duration
+	// cycles is dhcp_churn_cycles_total of the target.
+	cycles prometheus.Counter
+	// released is the address of the lease released at the end of the last
+	// hold time, until the next one is bound.
+	released net.IP
+}
+
+// newChurnCycle returns the churn cycle of targetAddr, or nil if holdTime
+// isn't positive.
+func newChurnCycle(logger *slog.Logger, m *metrics, targetAddr string, holdTime, cooldown time.Duration) *churnCycle {
+	if holdTime <= 0 {
+		return nil
+	}
+
+	logger.Info("Releasing and re-requesting the lease periodically", "hold_time", holdTime)
+	c := &churnCycle{
+		logger:     logger,
+		metrics:    m,
+		targetAddr: targetAddr,
+		holdTime:   holdTime,
+		cooldown:   cooldown,
+		cycles:     m.dhcpChurnCyclesTotal.WithLabelValues(targetAddr),
+	}
+	c.cycles.Add(0)
+	for _, outcome := range churnReacquireOutcomes {
+		m.dhcpChurnReacquiresTotal.WithLabelValues(targetAddr, outcome).Add(0)
+	}
+
+	return c
+}
+
+// setPhase exports phase as the one the target is in.
+func (c *churnCycle) setPhase(phase string) {
+	if c == nil {
+		return
+	}
+
+	for _, p := range churnPhases {
+		value := 0.0
+		if p == phase {
+			value = 1
+		}
+		c.metrics.dhcpChurnPhase.WithLabelValues(c.targetAddr, p).Set(value)
+	}
+}
+
+// hold starts holding the lease just bound, returning a channel that receives
+// once the hold time is up. A nil churnCycle returns a nil channel, which
+// never does.
+func (c *churnCycle) hold() <-chan time.Time {
+	if c == nil {
+		return nil
+	}
+
+	c.setPhase(churnHolding)
+	return time.After(c.holdTime)
+}
+
+// acquired records the acquisition of lease, comparing it to the lease
+// released last, if any.
+func (c *churnCycle) acquired(lease *dhclient.Lease) {
+	if c == nil || c.released == nil {
+		return
+	}
+
+	outcome := churnNewAddress
+	if c.released.Equal(lease.FixedAddress) {
+		outcome = churnSameAddress
+	}
+	c.logger.Debug("Got lease after releasing one", "released_addr", c.released, "addr", lease.FixedAddress, "outcome", outcome)
+	c.metrics.dhcpChurnReacquiresTotal.WithLabelValues(c.targetAddr, outcome).Inc()
+	c.released = nil
+}
+
+// cycled records the end of a hold time, at which released was released, or
+// nil if no lease was held any more, then waits out the cooldown. It returns
+// false if ctx is done first.
+func (c *churnCycle) cycled(ctx context.Context, released *dhclient.Lease) bool {
+	c.cycles.Inc()
+	if released != nil {
+		c.released = released.FixedAddress
+	}
+
+	if c.cooldown <= 0 {
+		return true
+	}
+
+	c.logger.Debug("Cooling down before requesting a new lease", "cooldown", c.cooldown)
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(c.cooldown):
+		return true
+	}
+}
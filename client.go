@@ -0,0 +1,2080 @@
+package greedydhcp
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/google/gopacket/layers"
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+	"github.com/mdlayher/packet"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// clientSettings are the settings shared by every client.
+type clientSettings struct {
+	// noDefaultParams skips adding dhclient.DefaultParamsRequestList, so no
+	// parameter request list is sent at all.
+	noDefaultParams bool
+	breaker         breakerConfig
+	// dscp is the DSCP value marked on sent packets.
+	dscp uint8
+	// minLeaseTime is the shortest lease considered acceptable. Shorter
+	// leases are counted, and declined if declineShortLeases is set.
+	minLeaseTime       time.Duration
+	declineShortLeases bool
+	// addressChangeAcquires treats a renewal granting a different address
+	// as a new acquisition rather than a renewal.
+	addressChangeAcquires bool
+	// acquireSLO, if set, is the longest acquiring a lease may take before
+	// it counts as an SLO breach, for targets without their own.
+	acquireSLO time.Duration
+	// maxFailures, if set, is the number of failed attempts in a row after
+	// which targets without their own are hard failed, and only retried
+	// every hardFailRetryInterval.
+	maxFailures           int
+	hardFailRetryInterval time.Duration
+	// stableGrace is how long a lease must be held before it is counted as
+	// stable.
+	stableGrace time.Duration
+	// retransmits and retransmitTimeout tune how persistently each DISCOVER
+	// and REQUEST is retried before an attempt fails.
+	retransmits       int
+	retransmitTimeout time.Duration
+	// receiveBufferSize, if set, is the size of the buffer replies are read
+	// into, in place of one sized for the interface MTU.
+	receiveBufferSize int
+	// responseJitterWindow is the number of recent response times of each
+	// target's server the jitter is measured over, none being if zero.
+	responseJitterWindow int
+	// renewJitter is the most each renewal is randomly delayed past T1.
+	renewJitter time.Duration
+	// renewScaling, if set, scales when leases are renewed to their length
+	// in place of their T1.
+	renewScaling *renewScaling
+	// duplicateAckWindow, if set, is how long to keep listening for further
+	// ACKs to a REQUEST once the first one is bound.
+	duplicateAckWindow time.Duration
+	// nakRetryDelay, if set, is how long to wait after a NAK before
+	// discovering afresh, in place of the second any failure is retried
+	// after.
+	nakRetryDelay *time.Duration
+	// xidSeed, if set, makes transaction IDs predictable. Each target counts
+	// up from the seed offset by its address, so targets don't share IDs.
+	xidSeed *uint32
+	// logDORA logs a summary of the whole exchange each time a lease is
+	// bound.
+	logDORA bool
+	// requestBootOptions adds the TFTP server and bootfile options to the
+	// default parameter request list.
+	requestBootOptions bool
+	// requestDomainSearch adds the domain search list option to the default
+	// parameter request list.
+	requestDomainSearch bool
+	// trackOptionCodes exports every option code leases were ever bound
+	// with.
+	trackOptionCodes bool
+	// recoverPanics recovers panics in clients and their callbacks, running
+	// them again after panicRestartDelay, rather than crashing.
+	recoverPanics     bool
+	panicRestartDelay time.Duration
+	// releaseOnExit releases every held lease when the client is stopped on
+	// shutdown, so that restarts don't use up the pool.
+	releaseOnExit bool
+	// releaseImportedOnExit also releases leases imported from
+	// OS_LEASE_FILES, which are the host's own, on shutdown. It is only set
+	// by setting RELEASE_ON_EXIT explicitly.
+	releaseImportedOnExit bool
+	// gratuitousARP announces every acquired address with a gratuitous ARP,
+	// waiting up to arpDefendTimeout for another host to defend it.
+	gratuitousARP    bool
+	arpDefendTimeout time.Duration
+	// arpRecheckInterval, if set, is how often the address held by each
+	// target is probed for, to catch hosts that start using it after it was
+	// bound.
+	arpRecheckInterval time.Duration
+	// applyMTU sets the interface MTU to the one handed out with a lease.
+	applyMTU bool
+	// squatMaxNAKs, if positive, makes each acquisition insist on the
+	// target address: it is requested straight away and requested again on
+	// every NAK, until the server gives in or this many NAKs are received.
+	squatMaxNAKs int
+	// maxMessageSize, if set, is sent as the maximum message size (option
+	// 57) with every message.
+	maxMessageSize uint16
+	// initReboot makes each target start by requesting its address in
+	// INIT-REBOOT, falling back to discovery if that fails.
+	initReboot bool
+	// pingGateway pings the first router of every bound lease, waiting up
+	// to gatewayPingTimeout for the reply.
+	pingGateway        bool
+	gatewayPingTimeout time.Duration
+	// vrf, if set, is the VRF device sockets routed by the host, such as
+	// the gateway ping's, are bound to.
+	vrf string
+	// startupStagger is how much longer each target started together waits
+	// than the one before it.
+	startupStagger time.Duration
+	// retryBackoffBase, if set, is how long a target waits before retrying
+	// after a failure, doubling with every consecutive one up to
+	// retryBackoffMax.
+	retryBackoffBase time.Duration
+	retryBackoffMax  time.Duration
+}
+
+// clientConfig holds the settings shared by every client, along with the
+// state of the prober they run in.
+type clientConfig struct {
+	clientSettings
+
+	// metrics are the metrics clients are reported in.
+	metrics *metrics
+	// iface is the interface clients run on. It may be switched while they
+	// run, so is read with currentIface.
+	iface   *net.Interface
+	ifaceMu sync.RWMutex
+	// ifaceChanged, if set, is notified when iface is switched.
+	ifaceChanged *broadcast
+	// statsd, if set, mirrors lease events to StatsD.
+	statsd *statsdEmitter
+	// results, if set, counts lease events for the report written on exit.
+	results *runResults
+	// series caps the number of targets with metric series.
+	series *seriesLRU
+	// startGate, if set, limits how many targets acquire their first lease
+	// at once.
+	startGate *startGate
+	// leases is shared by every client to record the lease it holds.
+	leases *leaseRegistry
+	// events keeps the recent lease events of every client.
+	events *eventRing
+	// publisher, if set, publishes every lease event to NATS.
+	publisher *natsPublisher
+	// pacer limits how fast all clients send packets.
+	pacer *sendPacer
+	// linkUp, if set, is notified when the interface comes back up, which
+	// resets the backoff of every target.
+	linkUp *linkNotifier
+	// vlans creates the VLAN interfaces of targets that run on one.
+	vlans *vlanManager
+	// socketDenied records the targets that gave up because they aren't
+	// allowed to open a raw socket.
+	socketDenied *deniedTargets
+	// hardFailed records the targets that are hard failed.
+	hardFailed *deniedTargets
+	// expectationsFailed records the targets that failed their
+	// expectation.
+	expectationsFailed *deniedTargets
+}
+
+// currentIface returns the interface clients run on.
+func (cfg *clientConfig) currentIface() *net.Interface {
+	cfg.ifaceMu.RLock()
+	defer cfg.ifaceMu.RUnlock()
+	return cfg.iface
+}
+
+// setIface switches the interface clients run on, restarting the running
+// ones.
+func (cfg *clientConfig) setIface(iface *net.Interface) {
+	cfg.ifaceMu.Lock()
+	cfg.iface = iface
+	cfg.ifaceMu.Unlock()
+
+	if cfg.ifaceChanged != nil {
+		cfg.ifaceChanged.notify()
+	}
+}
+
+// recordOptionCodes marks every option code in codes as seen by target. Pad
+// and end are only framing, so are left out.
+func (m *metrics) recordOptionCodes(target string, codes []layers.DHCPOpt) {
+	for _, code := range codes {
+		if code == layers.DHCPOptPad || code == layers.DHCPOptEnd {
+			continue
+		}
+		m.dhcpServerOptionSeen.WithLabelValues(target, strconv.Itoa(int(code))).Set(1)
+	}
+}
+
+// Policies for offers of another address than the one requested, set by
+// TARGET_OFFER_POLICY.
+const (
+	// offerPolicyAccept requests whatever is offered, as clients usually
+	// do.
+	offerPolicyAccept = "accept"
+	// offerPolicyAcceptLog requests whatever is offered, but warns about
+	// alternatives.
+	offerPolicyAcceptLog = "accept_log"
+	// offerPolicyReject doesn't request alternatives, failing the attempt
+	// so that the requested address is asked for again.
+	offerPolicyReject = "reject"
+)
+
+var offerPolicies = []string{offerPolicyAccept, offerPolicyAcceptLog, offerPolicyReject}
+
+// Checks that an offered address is free before it is requested, set by
+// TARGET_OFFER_PROBE.
+const (
+	// offerProbeARP sends an ARP probe for the address.
+	offerProbeARP = "arp"
+	// offerProbeARPPing pings the address too, for hosts that don't answer
+	// ARP from this segment, such as those behind a proxy ARP router.
+	offerProbeARPPing = "arp_ping"
+)
+
+var offerProbes = []string{offerProbeARP, offerProbeARPPing}
+
+// Outcomes of an offer probe, as labels of dhcp_offer_probes_total.
+const (
+	probeFree  = "free"
+	probeInUse = "in_use"
+	probeError = "error"
+)
+
+// probeOffer checks whether another host uses addr, with an ARP probe from
+// hwAddr on iface and, for offerProbeARPPing, a ping. It returns what
+// answered for the address, or an empty string if nothing did.
+func probeOffer(cfg *clientConfig, iface *net.Interface, hwAddr net.HardwareAddr, addr net.IP, probe string) (string, error) {
+	owner, err := probeAddress(iface, hwAddr, addr, cfg.arpDefendTimeout)
+	if err != nil {
+		return "", fmt.Errorf("unable to send ARP probe: %w", err)
+	}
+	if owner != nil {
+		return owner.String(), nil
+	}
+
+	if probe == offerProbeARPPing {
+		_, err := pingGateway(addr, cfg.arpDefendTimeout, cfg.vrf)
+		if err == nil {
+			return "echo reply", nil
+		}
+		if !errors.Is(err, errNoEchoReply) {
+			return "", err
+		}
+	}
+
+	return "", nil
+}
+
+// Paths a reply took, as labels of dhcp_replies_total.
+const (
+	replyDirect  = "direct"
+	replyRelayed = "relayed"
+)
+
+// Outcomes of an offer of another address, as labels of
+// dhcp_alternative_offers_total.
+const (
+	alternativeAccepted = "accepted"
+	alternativeRejected = "rejected"
+)
+
+// targetConfig holds the settings of a single target.
+type targetConfig struct {
+	// addr is the address to request.
+	addr string
+	// server, if set, is the only server accepted for this target, and
+	// requests are unicast to it.
+	server net.IP
+	// params, if not nil, is the parameter request list sent in place of the
+	// defaults, in this order.
+	params []layers.DHCPOpt
+	// rawOptions are sent as is with every DISCOVER and REQUEST.
+	rawOptions []dhclient.Option
+	// holdTime, if set, is how long to hold each lease before releasing it
+	// and requesting a new one.
+	holdTime time.Duration
+	// releaseCooldown is how long to wait after releasing a lease held for
+	// holdTime before requesting a new one.
+	releaseCooldown time.Duration
+	// requestDelay, if set, is how long to wait between an offer and
+	// requesting it.
+	requestDelay time.Duration
+	// expectTimeout, if set, is how long the target has to meet its
+	// expectation before it is failed: holding a lease for expectBound in
+	// a row, or just getting one if that isn't set.
+	expectTimeout time.Duration
+	expectBound   time.Duration
+	// secs is sent in the secs field of every DISCOVER and REQUEST, plus the
+	// seconds spent trying so far if secsElapsed is set.
+	secs        uint16
+	secsElapsed bool
+	// disabled targets are configured, but their client isn't run.
+	disabled bool
+	// giaddr, if set, is sent as the relay address of every message.
+	giaddr net.IP
+	// hwAddr, if set, is sent as the client hardware address in place of
+	// the interface's, for servers with reservations keyed on it.
+	hwAddr net.HardwareAddr
+	// acquireSLO, if set, is the longest acquiring a lease may take before
+	// it counts as an SLO breach, in place of clientConfig.acquireSLO.
+	acquireSLO time.Duration
+	// maxFailures, if set, is used in place of clientConfig.maxFailures.
+	maxFailures int
+	// logLevel, if set, is the level the target logs at in place of
+	// LOG_LEVEL.
+	logLevel *slog.Level
+	// offerPolicy is what to do with an offer of another address than the
+	// one requested, one of offerPolicies. Offers are accepted if unset.
+	offerPolicy string
+	// offerProbe, if set, is how an offered address is checked to be free
+	// before it is requested, one of offerProbes.
+	offerProbe string
+	// schedule, if set, is the windows the client runs during, being
+	// stopped outside of them.
+	schedule schedule
+	// fqdn, if set, is sent as the client FQDN option.
+	fqdn *clientFQDN
+	// hostname, if set, is sent as the host name option, for servers that
+	// log or match clients by it.
+	hostname string
+	// clientID, if set, is sent as the client identifier option, which
+	// servers key leases on in place of the chaddr.
+	clientID clientID
+	// quirks, if set, makes the client send deliberately non-standard
+	// messages.
+	quirks *dhclient.Quirks
+	// vlan, if set, is the ID of the VLAN the client runs on, through an
+	// interface created on top of the one clients run on.
+	vlan int
+	// netns, if set, is the path of the network namespace the client's
+	// socket is opened in, on the interface of the same name there.
+	netns string
+	// fallbackAddrs are requested in turn when the target address is NAKed.
+	fallbackAddrs []net.IP
+	// subnet, if set, is the subnet leases for this target are expected to
+	// be handed out from.
+	subnet *net.IPNet
+	// tags are added as labels to every metric of this target.
+	tags map[string]string
+	// dependsOn are the targets that must hold a lease before the client
+	// first starts.
+	dependsOn []string
+	// priority orders the start of targets held back by the start gate,
+	// highest first.
+	priority int
+	// startSlot, if set, must be granted before the client starts, and is
+	// released once the first attempt to acquire a lease is over.
+	startSlot *startTicket
+	// startDelay is how long the client waits before it first starts, so that
+	// targets started together don't all send their DISCOVER at once.
+	startDelay time.Duration
+	// restoredLease, if set, is renewed when the client first starts instead
+	// of discovering a new lease.
+	restoredLease *dhclient.Lease
+	// leaseImported is set if restoredLease was read from the host's own
+	// DHCP client.
+	leaseImported bool
+	// reacquire is signalled to drop the current lease and request a new
+	// one.
+	reacquire chan struct{}
+}
+
+// maxServerMessageLen is the longest server message exported as a label,
+// longer ones being truncated.
+const maxServerMessageLen = 128
+
+// setServerMessage exports message, sent by the server in a reply of type
+// msgType, as the latest one of target. Unprintable characters are replaced,
+// so that a misbehaving server can't garble the exposition.
+func (m *metrics) setServerMessage(target, msgType, message string) {
+	message = strings.Map(func(r rune) rune {
+		if !unicode.IsPrint(r) {
+			return '?'
+		}
+		return r
+	}, strings.ToValidUTF8(message, "?"))
+	if len(message) > maxServerMessageLen {
+		message = strings.ToValidUTF8(message[:maxServerMessageLen], "")
+	}
+
+	m.dhcpServerMessageInfo.DeletePartialMatch(prometheus.Labels{"ip": target})
+	m.dhcpServerMessageInfo.WithLabelValues(target, msgType, message).Set(1)
+}
+
+// announceLease announces addr bound on iface, counting in conflicts if
+// another host defends it.
+func announceLease(ctx context.Context, logger *slog.Logger, cfg *clientConfig, iface *net.Interface, addr net.IP, conflicts prometheus.Counter) {
+	defender, err := announceAddress(iface, iface.HardwareAddr, addr, cfg.arpDefendTimeout)
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err != nil {
+		logger.Warn("Unable to announce leased address", "addr", addr, "err", err)
+		return
+	}
+
+	if defender != nil {
+		logger.Warn("Another host defended the leased address, it may already be in use", "addr", addr, "defender", defender)
+		conflicts.Inc()
+		return
+	}
+
+	logger.Debug("Announced leased address", "addr", addr)
+}
+
+// recheckLease probes for addr bound on iface, counting in conflicts if
+// another host answers for it.
+func recheckLease(ctx context.Context, logger *slog.Logger, cfg *clientConfig, iface *net.Interface, addr net.IP, conflicts prometheus.Counter) {
+	owner, err := probeAddress(iface, iface.HardwareAddr, addr, cfg.arpDefendTimeout)
+	if ctx.Err() != nil {
+		return
+	}
+
+	if err != nil {
+		logger.Warn("Unable to probe for leased address", "addr", addr, "err", err)
+		return
+	}
+
+	if owner != nil {
+		logger.Warn("Another host started using the leased address", "addr", addr, "owner", owner)
+		conflicts.Inc()
+		return
+	}
+
+	logger.Debug("Leased address is still unused by other hosts", "addr", addr)
+}
+
+// vlanRetryDelay is how long a target waits before trying again to set up
+// its VLAN interface.
+const vlanRetryDelay = 10 * time.Second
+
+// exitReleaseTimeout is how long a client waits for its lease to be released
+// on shutdown before giving up on it.
+const exitReleaseTimeout = 2 * time.Second
+
+// errReleaseTimeout is returned by releaseWithin when the release isn't sent
+// in time.
+var errReleaseTimeout = errors.New("timed out sending release")
+
+// releaseWithin releases the lease of the stopped client, giving up after
+// timeout so that a hung send can't hold up shutdown. The timeout starts once
+// the release's turn to be sent comes up under MAX_PPS, so that a backlog of
+// releases isn't dropped. The release may still be sent after it gives up.
+func releaseWithin(client *dhclient.Client, timeout time.Duration) error {
+	paced := make(chan struct{})
+	if pace := client.Pace; pace != nil {
+		client.Pace = func(stop <-chan struct{}) bool {
+			defer close(paced)
+			return pace(stop)
+		}
+	} else {
+		close(paced)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- client.Release() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-paced:
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return errReleaseTimeout
+	}
+}
+
+// releaseHeld releases the lease of the stopped client if held is set,
+// returning it, or nil if the client held none. The client's lease may
+// otherwise be only an address it was requesting, which the server never
+// granted.
+func releaseHeld(logger *slog.Logger, cfg *clientConfig, targetAddr string, client *dhclient.Client, held bool) *dhclient.Lease {
+	released := client.Lease
+	if !held || released == nil {
+		return nil
+	}
+
+	if err := client.Release(); err != nil {
+		logger.Warn("Unable to release lease", "err", err)
+	} else {
+		logLeaseEvent(logger, cfg.events, cfg.publisher, targetAddr, slog.LevelInfo, eventReleased, "Released lease", released)
+	}
+
+	return released
+}
+
+// bootSettings are the PXE boot settings handed out with a lease.
+type bootSettings struct {
+	nextServer string
+	tftpServer string
+	bootFile   string
+}
+
+// newBootSettings returns the boot settings of lease. The TFTP server and
+// bootfile options take precedence over the BOOTP header fields they
+// replace.
+func newBootSettings(lease *dhclient.Lease) bootSettings {
+	var boot bootSettings
+	if lease.NextServer != nil && !lease.NextServer.IsUnspecified() {
+		boot.nextServer = lease.NextServer.String()
+	}
+
+	boot.tftpServer = lease.TFTPServerName
+	if boot.tftpServer == "" {
+		boot.tftpServer = lease.ServerName
+	}
+
+	boot.bootFile = lease.BootFileName
+	if boot.bootFile == "" {
+		boot.bootFile = lease.BootFile
+	}
+
+	return boot
+}
+
+// Reasons a lease may fail to be acquired or renewed. These are used as label
+// values, so the set must stay small and fixed.
+const (
+	failureTimeout  = "timeout"
+	failureNAK      = "nak"
+	failureDeclined = "declined"
+	failureRejected = "offer_rejected"
+	failureSocket   = "socket"
+	failureOther    = "other"
+)
+
+var failureReasons = []string{failureTimeout, failureNAK, failureDeclined, failureRejected, failureSocket, failureOther}
+
+// delayedRequestBound is the outcome of a delayed request that got a lease,
+// the others being the failure reasons.
+const delayedRequestBound = "bound"
+
+// diffOptionSets returns the option codes in cur but not in prev, and those
+// in prev but not in cur, both sorted.
+func diffOptionSets(prev, cur map[layers.DHCPOpt]bool) (added, removed []int) {
+	for code := range cur {
+		if !prev[code] {
+			added = append(added, int(code))
+		}
+	}
+	for code := range prev {
+		if !cur[code] {
+			removed = append(removed, int(code))
+		}
+	}
+	sort.Ints(added)
+	sort.Ints(removed)
+
+	return added, removed
+}
+
+// filterReasons lists every reason a received packet may be dropped for.
+var filterReasons = []dhclient.FilterReason{
+	dhclient.FilterWrongXID, dhclient.FilterWrongChaddr, dhclient.FilterMalformed,
+	dhclient.FilterWrongOp, dhclient.FilterBadMessageType,
+}
+
+// failureReason classifies an error reported by dhclient.Client.OnError.
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return failureTimeout
+	case errors.Is(err, dhclient.ErrNAK):
+		return failureNAK
+	case errors.Is(err, dhclient.ErrDeclined):
+		return failureDeclined
+	case errors.Is(err, dhclient.ErrOfferRejected):
+		return failureRejected
+	case errors.Is(err, dhclient.ErrSocket):
+		return failureSocket
+	default:
+		return failureOther
+	}
+}
+
+// doraTrace collects the steps of a single exchange with the server, so it can
+// be logged as one event once the lease is bound.
+type doraTrace struct {
+	discoverSent time.Time
+	offerAt      time.Time
+	offerServer  net.IP
+	offeredAddr  net.IP
+	requestSent  time.Time
+	ackAt        time.Time
+}
+
+// attrs returns the trace as log attributes, leaving out steps that didn't
+// happen, such as the DISCOVER and OFFER of a renewal.
+func (d *doraTrace) attrs(lease *dhclient.Lease) []any {
+	var attrs []any
+	if !d.discoverSent.IsZero() {
+		attrs = append(attrs,
+			"discover_sent", d.discoverSent,
+			"offer_received", d.offerAt,
+			"offer_server", d.offerServer,
+			"offered_addr", d.offeredAddr,
+		)
+	}
+
+	return append(attrs,
+		"request_sent", d.requestSent,
+		"ack_received", d.ackAt,
+		"ack_server", lease.ServerID,
+		"granted_addr", lease.FixedAddress,
+		"lease_time", sinceBound(lease, lease.Expire),
+		"total", d.ackAt.Sub(d.start()),
+	)
+}
+
+// sinceBound returns how long after lease was bound t is, as a log attribute,
+// or "infinite" for the times of an infinite lease, which are unset.
+func sinceBound(lease *dhclient.Lease, t time.Time) any {
+	if lease.Infinite {
+		return "infinite"
+	}
+
+	return t.Sub(lease.Bound)
+}
+
+// start returns when the exchange began.
+func (d *doraTrace) start() time.Time {
+	if !d.discoverSent.IsZero() {
+		return d.discoverSent
+	}
+
+	return d.requestSent
+}
+
+// Outcomes of a duplicate ACK, comparing its address to the one bound from the
+// first ACK.
+const (
+	duplicateSameAddress      = "same_address"
+	duplicateDifferentAddress = "different_address"
+)
+
+var duplicateAckOutcomes = []string{duplicateSameAddress, duplicateDifferentAddress}
+
+// maxTrackedServers caps the number of distinct servers remembered per target.
+const maxTrackedServers = 64
+
+// serverSet tracks the distinct servers that have answered a target.
+type serverSet struct {
+	mu      sync.Mutex
+	servers map[string]struct{}
+	full    bool
+}
+
+// add records the server, returning true if it hasn't been seen before. Once
+// the set is full, new servers are no longer recorded and full is returned
+// as true the first time this happens.
+func (s *serverSet) add(server net.IP) (isNew bool, full bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.servers == nil {
+		s.servers = map[string]struct{}{}
+	}
+
+	key := server.String()
+	if _, ok := s.servers[key]; ok {
+		return false, false
+	}
+
+	if len(s.servers) >= maxTrackedServers {
+		if s.full {
+			return false, false
+		}
+		s.full = true
+		return false, true
+	}
+
+	s.servers[key] = struct{}{}
+	return true, false
+}
+
+// len returns the number of distinct servers recorded.
+func (s *serverSet) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.servers)
+}
+
+func runClient(ctx context.Context, wg *sync.WaitGroup, baseLogger *slog.Logger, cfg *clientConfig, target targetConfig) {
+	defer wg.Done()
+	cfg.metrics.dhcpRunningClients.Inc()
+	defer cfg.metrics.dhcpRunningClients.Dec()
+
+	targetAddr := target.addr
+
+	myAcquiredMetric := cfg.metrics.dhcpAcquiredLeasesTotal.WithLabelValues(targetAddr)
+	myAcquiredMetric.Add(0)
+	myExpiryMetric := cfg.metrics.dhcpLeaseExpiryTimestampSeconds.WithLabelValues(targetAddr)
+	myExpiryMetric.Set(0)
+	myFailedMetric := cfg.metrics.dhcpFailedLeasesTotal.WithLabelValues(targetAddr)
+	myFailedMetric.Add(0)
+	myExpiredMetric := cfg.metrics.dhcpExpiredLeasesTotal.WithLabelValues(targetAddr)
+	myExpiredMetric.Add(0)
+	myAcquireFailuresMetric := cfg.metrics.dhcpAcquireFailuresTotal.WithLabelValues(targetAddr)
+	myAcquireFailuresMetric.Add(0)
+	myLostMetric := cfg.metrics.dhcpLostLeasesTotal.WithLabelValues(targetAddr)
+	myLostMetric.Add(0)
+	myT1Metric := cfg.metrics.dhcpLeaseT1Seconds.WithLabelValues(targetAddr)
+	myT1Metric.Set(0)
+	myT2Metric := cfg.metrics.dhcpLeaseT2Seconds.WithLabelValues(targetAddr)
+	myT2Metric.Set(0)
+	myRenewTimeMetric := cfg.metrics.dhcpLeaseRenewTimestampSeconds.WithLabelValues(targetAddr)
+	myRenewTimeMetric.Set(0)
+	myServersMetric := cfg.metrics.dhcpDistinctServersSeen.WithLabelValues(targetAddr)
+	myServersMetric.Set(0)
+	myShortLeaseMetric := cfg.metrics.dhcpShortLeasesTotal.WithLabelValues(targetAddr)
+	myShortLeaseMetric.Add(0)
+	myInvalidLeaseTimeMetric := cfg.metrics.dhcpInvalidLeaseTimeTotal.WithLabelValues(targetAddr)
+	myInvalidLeaseTimeMetric.Add(0)
+	myRestoredMetric := cfg.metrics.dhcpLeasesRestoredTotal.WithLabelValues(targetAddr)
+	myRestoredMetric.Add(0)
+	myImportedMetric := cfg.metrics.dhcpLeaseImported.WithLabelValues(targetAddr)
+	myImportedMetric.Set(0)
+	if target.leaseImported {
+		myImportedMetric.Set(1)
+	}
+	myReacquireMetric := cfg.metrics.dhcpManualReacquiresTotal.WithLabelValues(targetAddr)
+	myReacquireMetric.Add(0)
+	myDNSServersMetric := cfg.metrics.dhcpLeaseDNSServers.WithLabelValues(targetAddr)
+	myDNSServersMetric.Set(0)
+	myMTUMetric := cfg.metrics.dhcpLeaseInterfaceMTU.WithLabelValues(targetAddr)
+	myMTUMetric.Set(0)
+	myOptionBytesMetric := cfg.metrics.dhcpLeaseOptionBytes.WithLabelValues(targetAddr)
+	myOptionBytesMetric.Set(0)
+	myRenewalStreakMetric := cfg.metrics.dhcpRenewalStreak.WithLabelValues(targetAddr)
+	myRenewalStreakMetric.Set(0)
+	myOutageMetric := cfg.metrics.dhcpLeaseOutageSeconds.WithLabelValues(targetAddr)
+	myAddressChangedMetric := cfg.metrics.dhcpAddressChangedTotal.WithLabelValues(targetAddr)
+	myAddressChangedMetric.Add(0)
+	myRenewalIntervalMetric := cfg.metrics.dhcpRenewalIntervalSeconds.WithLabelValues(targetAddr)
+	myOptionSetChangedMetric := cfg.metrics.dhcpOptionSetChangedTotal.WithLabelValues(targetAddr)
+	myOptionSetChangedMetric.Add(0)
+	myOfferLatencyMetric := cfg.metrics.dhcpDiscoverToOfferSeconds.WithLabelValues(targetAddr)
+	myAckLatencyMetric := cfg.metrics.dhcpRequestToAckSeconds.WithLabelValues(targetAddr)
+	if target.offerProbe != "" {
+		for _, outcome := range []string{probeFree, probeInUse, probeError} {
+			cfg.metrics.dhcpOfferProbesTotal.WithLabelValues(targetAddr, outcome).Add(0)
+		}
+	}
+	myNAKToDiscoverMetric := cfg.metrics.dhcpNAKToDiscoverTransitionsTotal.WithLabelValues(targetAddr)
+	myNAKToDiscoverMetric.Add(0)
+	// responses is kept across client restarts, the server being the same.
+	var responses *responseWindow
+	if cfg.responseJitterWindow > 0 {
+		responses = newResponseWindow(cfg.responseJitterWindow)
+	}
+	recordResponse := func(rtt time.Duration) {
+		if responses == nil {
+			return
+		}
+		responses.add(rtt)
+		if jitter, ok := responses.jitter(); ok {
+			cfg.metrics.dhcpResponseJitterSeconds.WithLabelValues(targetAddr).Set(jitter.Seconds())
+		}
+	}
+	myAcquireDurationMetric := cfg.metrics.dhcpAcquireDurationSeconds.WithLabelValues(targetAddr)
+	myAcquisitionHistogram := cfg.metrics.dhcpLeaseAcquisitionDurationSeconds.WithLabelValues(targetAddr)
+	for _, reason := range failureReasons {
+		cfg.metrics.dhcpFailuresTotal.WithLabelValues(targetAddr, reason).Add(0)
+	}
+	for _, reason := range filterReasons {
+		cfg.metrics.dhcpPacketsFilteredTotal.WithLabelValues(targetAddr, string(reason)).Add(0)
+	}
+	for _, reason := range dhclient.MalformedReasons {
+		cfg.metrics.dhcpMalformedRepliesTotal.WithLabelValues(targetAddr, string(reason)).Add(0)
+	}
+
+	mySocketFailuresMetric := cfg.metrics.dhcpSocketCreateFailuresTotal.WithLabelValues(targetAddr)
+	mySocketFailuresMetric.Add(0)
+	myCrossInterfaceMetric := cfg.metrics.dhcpCrossInterfaceLeasesTotal.WithLabelValues(targetAddr)
+	myCrossInterfaceMetric.Add(0)
+	myOption119ErrorsMetric := cfg.metrics.dhcpOption119DecodeErrorsTotal.WithLabelValues(targetAddr)
+	myOption119ErrorsMetric.Add(0)
+	myMaxMessageSizeMetric := cfg.metrics.dhcpMaxMessageSizeBytes.WithLabelValues(targetAddr)
+	myTruncatedMetric := cfg.metrics.dhcpTruncatedRepliesTotal.WithLabelValues(targetAddr)
+	myTruncatedMetric.Add(0)
+	myTruncatedReadsMetric := cfg.metrics.dhcpTruncatedReadsTotal.WithLabelValues(targetAddr)
+	myTruncatedReadsMetric.Add(0)
+	myBytesSentMetric := cfg.metrics.dhcpBytesSentTotal.WithLabelValues(targetAddr)
+	myBytesSentMetric.Add(0)
+	myBytesReceivedMetric := cfg.metrics.dhcpBytesReceivedTotal.WithLabelValues(targetAddr)
+	myBytesReceivedMetric.Add(0)
+	if cfg.duplicateAckWindow > 0 {
+		for _, outcome := range duplicateAckOutcomes {
+			cfg.metrics.dhcpDuplicateAcksTotal.WithLabelValues(targetAddr, outcome).Add(0)
+		}
+	}
+	var myARPConflictsMetric prometheus.Counter
+	if cfg.gratuitousARP {
+		myARPConflictsMetric = cfg.metrics.dhcpGratuitousARPConflictsTotal.WithLabelValues(targetAddr)
+		myARPConflictsMetric.Add(0)
+	}
+	// The interfaces of another namespace can't be seen from here.
+	var myPostBindConflictsMetric prometheus.Counter
+	var arpRecheck <-chan time.Time
+	if cfg.arpRecheckInterval > 0 && target.netns == "" {
+		myPostBindConflictsMetric = cfg.metrics.dhcpPostBindConflictsTotal.WithLabelValues(targetAddr)
+		myPostBindConflictsMetric.Add(0)
+		ticker := time.NewTicker(cfg.arpRecheckInterval)
+		defer ticker.Stop()
+		arpRecheck = ticker.C
+	}
+
+	if target.logLevel != nil {
+		baseLogger = withLevel(baseLogger, *target.logLevel)
+	}
+	logger := baseLogger.With("target", targetAddr)
+	logger.Info("Will continually request a lease for target addr")
+	cfg.series.touch(targetAddr)
+	cfg.socketDenied.remove(targetAddr)
+	cfg.hardFailed.remove(targetAddr)
+	defer cfg.hardFailed.remove(targetAddr)
+
+	var expect *expectation
+	if target.expectTimeout > 0 {
+		logger.Info("Expecting target to hold a lease", "timeout", target.expectTimeout, "bound_for", target.expectBound)
+		setExpectation := func(outcome string) {
+			for _, o := range expectOutcomes {
+				value := 0.0
+				if o == outcome {
+					value = 1
+				}
+				cfg.metrics.dhcpTargetExpectation.WithLabelValues(targetAddr, o).Set(value)
+			}
+			cfg.results.expectation(targetAddr, outcome)
+		}
+		setExpectation(expectPending)
+		expect = newExpectation(target.expectTimeout, target.expectBound, func(outcome string) {
+			if outcome == expectFailed {
+				logger.Error("Target failed its expectation", "timeout", target.expectTimeout, "bound_for", target.expectBound)
+				cfg.expectationsFailed.add(targetAddr)
+			} else {
+				logger.Info("Target met its expectation", "bound_for", target.expectBound)
+			}
+			setExpectation(outcome)
+		})
+		defer expect.stop()
+	}
+
+	window := startScheduleGate(ctx, logger, cfg.metrics, targetAddr, target.schedule)
+
+	if target.startDelay > 0 {
+		logger.Debug("Staggering start", "delay", target.startDelay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(target.startDelay):
+		}
+	}
+
+	if len(target.dependsOn) > 0 {
+		myDependenciesMetric := cfg.metrics.dhcpWaitingForDependencies.WithLabelValues(targetAddr)
+		myDependenciesMetric.Set(1)
+		logger.Info("Waiting for dependencies to be bound", "depends_on", target.dependsOn)
+		if !cfg.leases.waitBound(ctx, target.dependsOn) {
+			return
+		}
+		myDependenciesMetric.Set(0)
+		logger.Info("Dependencies are bound, starting")
+
+		// Queueing only now keeps a start slot from being held while
+		// waiting, which a dependency may need.
+		target.startSlot = cfg.startGate.enqueue(target.priority)
+	}
+
+	defer target.startSlot.release()
+	if target.startSlot != nil {
+		waitStart := time.Now()
+		myWaitingMetric := cfg.metrics.dhcpWaitingForStartSlot.WithLabelValues(targetAddr)
+		myWaitingMetric.Set(1)
+		logger.Debug("Waiting for a start slot", "priority", target.priority)
+		if !target.startSlot.wait(ctx) {
+			return
+		}
+		myWaitingMetric.Set(0)
+		waited := time.Since(waitStart)
+		logger.Debug("Got a start slot", "waited", waited)
+		cfg.metrics.dhcpStartWaitSeconds.WithLabelValues(targetAddr).Observe(waited.Seconds())
+	}
+
+	var myServerAnsweringMetric prometheus.Gauge
+	if target.server != nil {
+		logger.Info("Unicasting requests to server", "server", target.server)
+		myServerAnsweringMetric = cfg.metrics.dhcpTargetServerAnswering.WithLabelValues(targetAddr)
+		myServerAnsweringMetric.Set(0)
+	} else {
+		logger.Debug("Broadcasting requests to any server")
+	}
+
+	servers := &serverSet{}
+	recordServer := func(lease *dhclient.Lease) {
+		if lease.ServerID == nil {
+			return
+		}
+
+		if myServerAnsweringMetric != nil {
+			myServerAnsweringMetric.Set(1)
+		}
+
+		isNew, full := servers.add(lease.ServerID)
+		if isNew {
+			logger.Info("New server answered target", "server", lease.ServerID, "distinct_servers", servers.len())
+			myServersMetric.Set(float64(servers.len()))
+		}
+		if full {
+			logger.Warn("Too many distinct servers answered target, no longer tracking new ones", "max", maxTrackedServers)
+		}
+	}
+
+	myDirectRepliesMetric := cfg.metrics.dhcpRepliesTotal.WithLabelValues(targetAddr, replyDirect)
+	myDirectRepliesMetric.Add(0)
+	myRelayedRepliesMetric := cfg.metrics.dhcpRepliesTotal.WithLabelValues(targetAddr, replyRelayed)
+	myRelayedRepliesMetric.Add(0)
+	// relay is the relay the last reply came through, empty if it came
+	// straight from its server.
+	var relay string
+	recordRelay := func(reply *dhclient.Lease) {
+		var current string
+		if reply.RelayAddr != nil {
+			current = reply.RelayAddr.String()
+			myRelayedRepliesMetric.Inc()
+		} else {
+			myDirectRepliesMetric.Inc()
+		}
+		if current == relay {
+			return
+		}
+
+		if current != "" {
+			logger.Info("Replies come through a relay", "relay", current, "server", reply.ServerID, "previous_relay", relay)
+		} else {
+			logger.Info("Replies no longer come through a relay", "server", reply.ServerID, "previous_relay", relay)
+		}
+		cfg.metrics.dhcpReplyRelayInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+		if current != "" {
+			cfg.metrics.dhcpReplyRelayInfo.WithLabelValues(targetAddr, current).Set(1)
+		}
+		relay = current
+	}
+
+	tripped := make(chan struct{}, 1)
+	breaker := newCircuitBreaker(cfg.breaker, func(state breakerState) {
+		logger.Info("Circuit breaker changed state", "state", state)
+		for _, s := range breakerStates {
+			value := 0.0
+			if s == state {
+				value = 1
+			}
+			cfg.metrics.dhcpCircuitBreakerState.WithLabelValues(targetAddr, s.String()).Set(value)
+		}
+
+		if state == breakerOpen {
+			select {
+			case tripped <- struct{}{}:
+			default:
+			}
+		}
+	})
+
+	churn := newChurnCycle(logger, cfg.metrics, targetAddr, target.holdTime, target.releaseCooldown)
+
+	if target.giaddr != nil {
+		logger.Info("Sending messages as relayed", "giaddr", target.giaddr)
+	}
+
+	if q := target.quirks; q != nil {
+		logger.Warn(
+			"Sending non-standard messages", "pad_options", q.PadOptions,
+			"trailing_padding", q.TrailingPadding, "omit_end", q.OmitEnd,
+		)
+	}
+
+	var myOutOfSubnetMetric prometheus.Counter
+	if target.subnet != nil {
+		logger.Debug("Expecting leases from subnet", "subnet", target.subnet)
+		myOutOfSubnetMetric = cfg.metrics.dhcpOutOfSubnetOffersTotal.WithLabelValues(targetAddr)
+		myOutOfSubnetMetric.Add(0)
+	}
+
+	// bound is signalled every time a lease is bound.
+	bound := make(chan struct{}, 1)
+
+	// stableTimer counts the lease as stable once it has been held for the
+	// grace period. It is started when a lease is acquired, and stopped if
+	// the lease is lost first.
+	var stableTimer *time.Timer
+
+	// acquireStart is when the client started acquiring a lease, or zero
+	// while one is held. It is kept across client restarts, so failed
+	// attempts count towards the time taken.
+	var acquireStart time.Time
+	acquireSLO := target.acquireSLO
+	if acquireSLO == 0 {
+		acquireSLO = cfg.acquireSLO
+	}
+	var mySLOBreachesMetric prometheus.Counter
+	if acquireSLO > 0 {
+		logger.Debug("Checking acquire latency", "slo", acquireSLO)
+		mySLOBreachesMetric = cfg.metrics.dhcpSLOBreachesTotal.WithLabelValues(targetAddr)
+		mySLOBreachesMetric.Add(0)
+	}
+	maxFailures := target.maxFailures
+	if maxFailures == 0 {
+		maxFailures = cfg.maxFailures
+	}
+	// failures counts the failed attempts in a row since a lease was last
+	// bound, and hardFailed is set once there are maxFailures of them.
+	var failures int
+	var hardFailed bool
+	myHardFailedMetric := cfg.metrics.dhcpTargetHardFailed.WithLabelValues(targetAddr)
+	myHardFailedMetric.Set(0)
+	// givingUp is signalled with the number of failures once the target is
+	// hard failed.
+	givingUp := make(chan int, 1)
+	myStableMetric := cfg.metrics.dhcpStableLeasesTotal.WithLabelValues(targetAddr)
+	myStableMetric.Add(0)
+	cancelStable := func() {
+		expect.lost()
+		if stableTimer != nil {
+			stableTimer.Stop()
+			stableTimer = nil
+		}
+	}
+
+	backoff := newRetryBackoff(cfg.retryBackoffBase, cfg.retryBackoffMax)
+	// backingOff is signalled with the delay to wait after a failure.
+	backingOff := make(chan time.Duration, 1)
+	// socketDenied is signalled when the raw socket can't be opened for
+	// lack of privileges.
+	socketDenied := make(chan error, 1)
+	// clientPanicked is signalled when the client's goroutine recovers from
+	// a panic, and so stops running.
+	clientPanicked := make(chan struct{}, 1)
+	// running is the client last started. It is stopped on the way out, in
+	// case runClient unwinds from a panic while it runs.
+	var running *dhclient.Client
+	defer func() {
+		if running != nil {
+			running.Stop()
+		}
+	}()
+	states := newStateClock(cfg.metrics.dhcpStateSecondsTotal, targetAddr)
+	defer states.flush()
+	stateFlush := time.NewTicker(stateFlushInterval)
+	defer stateFlush.Stop()
+	myBackoffMetric := cfg.metrics.dhcpRetryBackoffSeconds.WithLabelValues(targetAddr)
+	myBackoffMetric.Set(0)
+	linkUp := cfg.linkUp.wait()
+	ifaceChanged := cfg.ifaceChanged.wait()
+
+	// retryRequest is signalled to restart the client straight away after a
+	// NAK, to request the next address.
+	retryRequest := make(chan struct{}, 1)
+	squat := newSquatter(logger, cfg.metrics, targetAddr, cfg.squatMaxNAKs, retryRequest)
+	reboot := newInitReboot(logger, cfg.metrics, targetAddr, cfg.initReboot)
+	if target.requestDelay > 0 {
+		logger.Info("Delaying requests after offers", "delay", target.requestDelay)
+		cfg.metrics.dhcpDelayedRequestsTotal.WithLabelValues(targetAddr, delayedRequestBound).Add(0)
+		for _, reason := range failureReasons {
+			cfg.metrics.dhcpDelayedRequestsTotal.WithLabelValues(targetAddr, reason).Add(0)
+		}
+	}
+	offerPolicy := target.offerPolicy
+	if offerPolicy == "" {
+		offerPolicy = offerPolicyAccept
+	}
+	if offerPolicy == offerPolicyReject {
+		logger.Info("Rejecting offers of other addresses", "policy", offerPolicy)
+		cfg.metrics.dhcpAlternativeOffersTotal.WithLabelValues(targetAddr, offerPolicy, alternativeRejected).Add(0)
+	} else {
+		cfg.metrics.dhcpAlternativeOffersTotal.WithLabelValues(targetAddr, offerPolicy, alternativeAccepted).Add(0)
+	}
+	// wanted are the addresses an offer of isn't an alternative.
+	wanted := append([]net.IP{net.ParseIP(targetAddr).To4()}, target.fallbackAddrs...)
+	if target.params != nil {
+		logger.Info("Requesting configured params in order", "params", target.params)
+	}
+
+	preferences := newAddressPreferences(net.ParseIP(targetAddr).To4(), target.fallbackAddrs, cfg.metrics.dhcpPreferenceIndex.WithLabelValues(targetAddr))
+	if preferences != nil {
+		logger.Info("Requesting addresses in order of preference", "preferences", preferences.addrs)
+		if cfg.squatMaxNAKs > 0 {
+			logger.Warn("Not squatting on target address, as it has fallback addresses")
+		}
+	}
+
+	restoring := false
+	var dora doraTrace
+	// held is whether the current client holds a lease, as acknowledged by
+	// a server. The client's lease may otherwise be one it is only
+	// requesting, such as a squatted address, or a restored lease not yet
+	// renewed, neither of which is released.
+	var held bool
+	// imported is whether the held lease is the one imported from the
+	// host's own lease file, renewed rather than acquired.
+	var imported bool
+	// renewalStreak is the number of renewals in a row since the lease was
+	// acquired, or since the last failure.
+	var renewalStreak int
+	// lostAt is when the last held lease was lost, zero until one is and
+	// once the next is bound.
+	var lostAt time.Time
+	// boundAddr is the address of the last bound lease.
+	var boundAddr net.IP
+
+	var nextXID func() uint32
+	if cfg.xidSeed != nil {
+		xid := *cfg.xidSeed + binary.BigEndian.Uint32(net.ParseIP(targetAddr).To4())
+		logger.Info("Using predictable transaction IDs", "first_xid", fmt.Sprintf("%#08x", xid))
+		nextXID = func() uint32 {
+			current := xid
+			xid++
+			return current
+		}
+	}
+	// lastBound is when the lease was last bound, and is kept across client
+	// restarts so that every interval between binds is recorded.
+	var lastBound time.Time
+	// lastOptions is the set of option codes the last bound lease was sent
+	// with. There are at most 256 codes, so it stays small.
+	var lastOptions map[layers.DHCPOpt]bool
+	var listen func(*net.Interface) (*packet.Conn, error)
+	cfg.metrics.dhcpTargetNetnsInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+	if target.netns != "" {
+		logger.Info("Running in network namespace", "netns", target.netns)
+		cfg.metrics.dhcpTargetNetnsInfo.WithLabelValues(targetAddr, target.netns).Set(1)
+		listen = func(iface *net.Interface) (*packet.Conn, error) {
+			return listenInNetns(target.netns, iface.Name)
+		}
+	}
+
+	cfg.metrics.dhcpTargetVLANInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+	if target.vlan != 0 {
+		logger.Info("Running on VLAN", "vlan", target.vlan)
+		cfg.metrics.dhcpTargetVLANInfo.WithLabelValues(targetAddr, strconv.Itoa(target.vlan)).Set(1)
+	}
+
+outer:
+	for {
+		windowChanged, waited := window.waitOpen(ctx, logger)
+		if ctx.Err() != nil {
+			return
+		}
+		if waited {
+			backoff.reset()
+		}
+
+		held = false
+		imported = false
+		if acquireStart.IsZero() {
+			acquireStart = time.Now()
+		}
+		iface := cfg.currentIface()
+		if target.vlan != 0 {
+			vlanIface, err := cfg.vlans.ensure(iface, target.vlan)
+			if err != nil {
+				logger.Error("Unable to set up VLAN interface, retrying", "vlan", target.vlan, "delay", vlanRetryDelay, "err", err)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(vlanRetryDelay):
+				}
+				continue
+			}
+			iface = vlanIface
+		}
+		// afterNAK is whether the last reply was a NAK which the client
+		// hasn't discovered afresh since.
+		afterNAK := false
+		client := dhclient.Client{
+			Iface:  iface,
+			Logger: logger,
+			// Frames are built by the client rather than the kernel, so the
+			// DSCP is written straight into the IP header instead of being
+			// set with IP_TOS.
+			TOS:          cfg.dscp << 2,
+			Server:       target.server,
+			RelayAddr:    target.giaddr,
+			HardwareAddr: target.hwAddr,
+			Secs:         target.secs,
+			SecsElapsed:  target.secsElapsed,
+
+			Retransmits:       cfg.retransmits,
+			RetransmitTimeout: cfg.retransmitTimeout,
+			ReceiveBufferSize: cfg.receiveBufferSize,
+			RenewJitter:       cfg.renewJitter,
+			XIDFunc:           nextXID,
+
+			Listen: listen,
+			Pace:   cfg.pacer.wait,
+			Quirks: target.quirks,
+
+			OnState: func(state dhclient.State) {
+				// A NAK drops the lease, so the client goes back to INIT
+				// and selects afresh rather than requesting again.
+				if state == dhclient.StateSelecting && afterNAK {
+					afterNAK = false
+					logger.Debug("Discovering afresh after a NAK")
+					myNAKToDiscoverMetric.Inc()
+				}
+				states.enter(state)
+			},
+			OnOffer: func(lease *dhclient.Lease) {
+				recordServer(lease)
+				recordRelay(lease)
+				dora.offerServer = lease.ServerID
+				dora.offeredAddr = lease.FixedAddress
+			},
+			OnReply: func(sent, received layers.DHCPMsgType, rtt time.Duration) {
+				now := time.Now()
+				switch {
+				case sent == layers.DHCPMsgTypeDiscover && received == layers.DHCPMsgTypeOffer:
+					myOfferLatencyMetric.Observe(rtt.Seconds())
+					recordResponse(rtt)
+					cfg.statsd.timing("discover_to_offer", targetAddr, rtt)
+					dora.discoverSent, dora.offerAt = now.Add(-rtt), now
+				case sent == layers.DHCPMsgTypeRequest && received == layers.DHCPMsgTypeAck:
+					myAckLatencyMetric.Observe(rtt.Seconds())
+					recordResponse(rtt)
+					cfg.statsd.timing("request_to_ack", targetAddr, rtt)
+					dora.requestSent, dora.ackAt = now.Add(-rtt), now
+				}
+			},
+			OnSend: func(msgType layers.DHCPMsgType, size int) {
+				cfg.metrics.dhcpMessageSizeBytes.WithLabelValues(targetAddr, strings.ToLower(msgType.String())).Observe(float64(size))
+			},
+			OnTruncatedRead: func(length, size int) {
+				logger.Warn(
+					"Received packet didn't fit the receive buffer and lost its end, set RECEIVE_BUFFER_SIZE to read larger ones",
+					"length", length, "size", size,
+				)
+				myTruncatedReadsMetric.Inc()
+			},
+			OnBytesSent: func(n int) {
+				myBytesSentMetric.Add(float64(n))
+			},
+			OnBytesReceived: func(n int) {
+				myBytesReceivedMetric.Add(float64(n))
+			},
+			OnFilter: func(reason dhclient.FilterReason) {
+				cfg.metrics.dhcpPacketsFilteredTotal.WithLabelValues(targetAddr, string(reason)).Inc()
+				if reason.Malformed() {
+					cfg.metrics.dhcpMalformedRepliesTotal.WithLabelValues(targetAddr, string(reason)).Inc()
+				}
+			},
+			OnBound: func(lease *dhclient.Lease) {
+				preferences.bound(logger, lease)
+				cfg.series.touch(targetAddr)
+				// A renewal keeps the address held, as the client asks for
+				// it, so another one means the server reallocated it.
+				changed := held && boundAddr != nil && !boundAddr.Equal(lease.FixedAddress)
+				if changed {
+					logger.Warn(
+						"Renewal granted a different address, the server may have reallocated the lease",
+						"old_addr", boundAddr, "new_addr", lease.FixedAddress, "server", lease.ServerID,
+					)
+					myAddressChangedMetric.Inc()
+				}
+				boundAddr = lease.FixedAddress
+				if !held {
+					churn.acquired(lease)
+				}
+				renewed := held && !(changed && cfg.addressChangeAcquires)
+				event, msg := eventAcquired, "Got lease"
+				if renewed {
+					event, msg = eventRenewed, "Renewed lease"
+					renewalStreak++
+				} else {
+					renewalStreak = 0
+				}
+				myRenewalStreakMetric.Set(float64(renewalStreak))
+				if !held {
+					// A lease acquired rather than restored is our own.
+					imported = imported && restoring
+					latency := time.Since(acquireStart)
+					acquireStart = time.Time{}
+					myAcquireDurationMetric.Observe(latency.Seconds())
+					myAcquisitionHistogram.Observe(latency.Seconds())
+					cfg.statsd.count("acquired", targetAddr)
+					cfg.statsd.timing("acquire_duration", targetAddr, latency)
+					cfg.results.acquired(targetAddr, lease.ServerID, latency)
+					if !lostAt.IsZero() {
+						outage := time.Since(lostAt)
+						lostAt = time.Time{}
+						logger.Info("Lease outage ended", "outage", outage)
+						myOutageMetric.Observe(outage.Seconds())
+					}
+					if acquireSLO > 0 && latency > acquireSLO {
+						logger.Warn("Acquiring lease took longer than its SLO", "latency", latency, "slo", acquireSLO)
+						mySLOBreachesMetric.Inc()
+					}
+
+					// The interfaces of another namespace can't be seen
+					// from here.
+					if cfg.gratuitousARP && target.netns == "" {
+						go announceLease(ctx, logger, cfg, iface, lease.FixedAddress, myARPConflictsMetric)
+					}
+				}
+				if changed && cfg.addressChangeAcquires {
+					cfg.statsd.count("acquired", targetAddr)
+					cfg.results.acquired(targetAddr, lease.ServerID, 0)
+					cancelStable()
+				}
+				if !renewed && stableTimer == nil {
+					stableTimer = time.AfterFunc(cfg.stableGrace, myStableMetric.Inc)
+				}
+				expect.bound()
+				held = true
+				recordServer(lease)
+				recordRelay(lease)
+				logLeaseEvent(
+					logger, cfg.events, cfg.publisher, targetAddr, slog.LevelInfo, event, msg, lease,
+					"t1", sinceBound(lease, lease.Renew), "t2", sinceBound(lease, lease.Rebind),
+				)
+				if target.subnet != nil && !target.subnet.Contains(lease.FixedAddress) {
+					logger.Warn(
+						"Bound address is outside the expected subnet, the server may be misconfigured",
+						"addr", lease.FixedAddress, "subnet", target.subnet, "server", lease.ServerID,
+					)
+					myOutOfSubnetMetric.Inc()
+				}
+				myAcquiredMetric.Inc()
+				if lease.Infinite {
+					if !renewed {
+						logger.Info("Server granted an infinite lease, it won't be renewed", "addr", lease.FixedAddress)
+					}
+					myExpiryMetric.Set(math.Inf(1))
+					myT1Metric.Set(math.Inf(1))
+					myT2Metric.Set(math.Inf(1))
+				} else {
+					myExpiryMetric.Set(float64(lease.Expire.Unix()))
+					myT1Metric.Set(lease.Renew.Sub(lease.Bound).Seconds())
+					myT2Metric.Set(lease.Rebind.Sub(lease.Bound).Seconds())
+				}
+				myDNSServersMetric.Set(float64(len(lease.DNS)))
+				cfg.metrics.dhcpLeaseDNSServerInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+				for _, server := range lease.DNS {
+					cfg.metrics.dhcpLeaseDNSServerInfo.WithLabelValues(targetAddr, server.String()).Set(1)
+				}
+				cfg.metrics.dhcpLeaseNetmaskInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+				if lease.Netmask != nil {
+					cfg.metrics.dhcpLeaseNetmaskInfo.WithLabelValues(targetAddr, net.IP(lease.Netmask).String()).Set(1)
+				}
+				cfg.metrics.dhcpLeaseAddressInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+				cfg.metrics.dhcpLeaseAddressInfo.WithLabelValues(targetAddr, lease.FixedAddress.String()).Set(1)
+				cfg.metrics.dhcpLeaseRouterInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+				for _, router := range lease.Router {
+					cfg.metrics.dhcpLeaseRouterInfo.WithLabelValues(targetAddr, router.String()).Set(1)
+				}
+				// A lease from another server, or with other options, replaces
+				// the series of the last one rather than adding to it.
+				var serverID, router, subnetMask string
+				if lease.ServerID != nil {
+					serverID = lease.ServerID.String()
+				}
+				if len(lease.Router) > 0 {
+					router = lease.Router[0].String()
+				}
+				if lease.Netmask != nil {
+					subnetMask = net.IP(lease.Netmask).String()
+				}
+				cfg.metrics.dhcpLeaseInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+				cfg.metrics.dhcpLeaseInfo.WithLabelValues(targetAddr, serverID, router, subnetMask).Set(1)
+				cfg.metrics.dhcpLeaseNTPServerInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+				for _, server := range lease.NTPServers {
+					cfg.metrics.dhcpLeaseNTPServerInfo.WithLabelValues(targetAddr, server.String()).Set(1)
+				}
+				cfg.metrics.dhcpLeaseSearchDomainInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+				for _, domain := range lease.DomainSearch {
+					cfg.metrics.dhcpLeaseSearchDomainInfo.WithLabelValues(targetAddr, domain).Set(1)
+				}
+				logger.Info(
+					"Got network config", "netmask", net.IP(lease.Netmask), "routers", lease.Router,
+					"dns", lease.DNS, "domain", lease.DomainName, "mtu", lease.MTU, "ntp", lease.NTPServers,
+					"search", lease.DomainSearch,
+				)
+				if lease.Truncated {
+					if cfg.maxMessageSize == 0 {
+						logger.Warn(
+							"Reply has no end option and may have been truncated, set MAX_MESSAGE_SIZE to advertise a larger message size",
+							"option_bytes", lease.OptionBytes,
+						)
+					} else {
+						logger.Warn(
+							"Reply has no end option and may have been truncated",
+							"option_bytes", lease.OptionBytes, "max_message_size", cfg.maxMessageSize,
+						)
+					}
+					myTruncatedMetric.Inc()
+				}
+				if lease.DomainSearchErr != nil {
+					logger.Warn("Unable to decode domain search list, the server may misencode it", "err", lease.DomainSearchErr)
+					myOption119ErrorsMetric.Inc()
+				}
+				if lease.Message != "" {
+					logger.Info("Server sent a message", "type", "ack", "message", lease.Message)
+					cfg.metrics.setServerMessage(targetAddr, "ack", lease.Message)
+				}
+				cfg.metrics.dhcpLeaseBootInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+				if boot := newBootSettings(lease); boot != (bootSettings{}) {
+					logger.Info(
+						"Got boot config", "next_server", boot.nextServer, "tftp_server", boot.tftpServer,
+						"bootfile", boot.bootFile, "sname", lease.ServerName, "file", lease.BootFile,
+						"option_66", lease.TFTPServerName, "option_67", lease.BootFileName,
+					)
+					cfg.metrics.dhcpLeaseBootInfo.WithLabelValues(targetAddr, boot.nextServer, boot.tftpServer, boot.bootFile).Set(1)
+				}
+				myMTUMetric.Set(float64(lease.MTU))
+				if target.fqdn != nil {
+					if flags, ok := fqdnReplyFlags(lease); ok {
+						logger.Info(
+							"Server answered client FQDN", "server_updates", flags&fqdnFlagS != 0,
+							"overridden", flags&fqdnFlagO != 0, "no_updates", flags&fqdnFlagN != 0,
+						)
+						updates := 0.0
+						if flags&fqdnFlagS != 0 {
+							updates = 1
+						}
+						cfg.metrics.dhcpFQDNServerUpdates.WithLabelValues(targetAddr).Set(updates)
+					} else {
+						cfg.metrics.dhcpFQDNServerUpdates.DeleteLabelValues(targetAddr)
+					}
+				}
+				if cfg.applyMTU && lease.MTU != 0 && int(lease.MTU) != iface.MTU {
+					if err := setInterfaceMTU(iface.Name, int(lease.MTU)); err != nil {
+						logger.Error("Unable to apply mtu", "mtu", lease.MTU, "err", err)
+					} else {
+						logger.Info("Applied mtu to interface", "iface", iface.Name, "mtu", lease.MTU)
+					}
+				}
+				if others := cfg.leases.set(targetAddr, lease); len(others) > 0 {
+					logger.Warn("Address is also bound by other targets", "addr", lease.FixedAddress, "targets", others)
+				}
+				// The interfaces of another namespace can't be seen from
+				// here.
+				if target.netns == "" {
+					if subnets, err := localSubnets(); err != nil {
+						logger.Debug("Unable to list local subnets", "err", err)
+					} else if owner := crossInterfaceOwner(iface.Name, subnets, lease.FixedAddress); owner != "" {
+						logger.Warn(
+							"Leased address is on the subnet of another interface, the exchange may have leaked through it",
+							"addr", lease.FixedAddress, "iface", iface.Name, "other_iface", owner,
+						)
+						myCrossInterfaceMetric.Inc()
+					}
+				}
+				if cfg.pingGateway && len(lease.Router) > 0 {
+					// Pinging can take a while, so don't hold up the client.
+					go func(gateway net.IP) {
+						rtt, err := pingGateway(gateway, cfg.gatewayPingTimeout, cfg.vrf)
+						if ctx.Err() != nil {
+							return
+						}
+						if err != nil {
+							logger.Warn("Gateway is unreachable", "gateway", gateway, "err", err)
+							cfg.metrics.dhcpGatewayReachable.WithLabelValues(targetAddr).Set(0)
+							return
+						}
+						logger.Info("Gateway is reachable", "gateway", gateway, "rtt", rtt)
+						cfg.metrics.dhcpGatewayReachable.WithLabelValues(targetAddr).Set(1)
+					}(lease.Router[0])
+				}
+				select {
+				case bound <- struct{}{}:
+				default:
+				}
+				if cfg.logDORA {
+					logger.Info("DORA sequence", dora.attrs(lease)...)
+				}
+				dora = doraTrace{}
+				if !lastBound.IsZero() {
+					myRenewalIntervalMetric.Observe(lease.Bound.Sub(lastBound).Seconds())
+				}
+				lastBound = lease.Bound
+				options := make(map[layers.DHCPOpt]bool, len(lease.OptionCodes))
+				for _, code := range lease.OptionCodes {
+					options[code] = true
+				}
+				if added, removed := diffOptionSets(lastOptions, options); lastOptions != nil && len(added)+len(removed) > 0 {
+					logger.Debug("Option set changed since the last bind", "added", added, "removed", removed)
+					myOptionSetChangedMetric.Inc()
+				}
+				lastOptions = options
+				if cfg.trackOptionCodes {
+					cfg.metrics.recordOptionCodes(targetAddr, lease.OptionCodes)
+				}
+				myOptionBytesMetric.Set(float64(lease.OptionBytes))
+				if restoring {
+					logger.Info("Restored lease", "addr", lease.FixedAddress)
+					myRestoredMetric.Inc()
+					restoring = false
+				}
+				cfg.metrics.dhcpLastError.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+				if hardFailed {
+					logger.Info("Hard failed target got a lease, retrying normally again", "failures", failures)
+					hardFailed = false
+					myHardFailedMetric.Set(0)
+					cfg.hardFailed.remove(targetAddr)
+				}
+				failures = 0
+				breaker.recordSuccess()
+				backoff.reset()
+				myBackoffMetric.Set(0)
+				target.startSlot.release()
+			},
+			OnExpire: func(lease *dhclient.Lease) {
+				cfg.series.touch(targetAddr)
+				// The client passes nil when no lease was held, and the lease
+				// it was given when a restored or squatted address is
+				// NAKed. held is tracked here so that neither is mistaken
+				// for a lost lease.
+				renewalStreak = 0
+				myRenewalStreakMetric.Set(0)
+				if !held {
+					logger.Debug("Acquiring lease failed, will retry")
+					myFailedMetric.Inc()
+					myAcquireFailuresMetric.Inc()
+					return
+				}
+
+				held = false
+				imported = false
+				acquireStart = time.Now()
+				lostAt = acquireStart
+				logLeaseEvent(logger, cfg.events, cfg.publisher, targetAddr, slog.LevelInfo, eventExpired, "Lost held lease", lease)
+				myExpiredMetric.Inc()
+				myLostMetric.Inc()
+				cfg.statsd.count("expired", targetAddr)
+				cfg.results.expired(targetAddr)
+				cfg.leases.remove(targetAddr)
+				cancelStable()
+				myDNSServersMetric.Set(0)
+				myMTUMetric.Set(0)
+				myOptionBytesMetric.Set(0)
+				cfg.metrics.dhcpGatewayReachable.DeleteLabelValues(targetAddr)
+				cfg.metrics.dhcpFQDNServerUpdates.DeleteLabelValues(targetAddr)
+				cfg.metrics.dhcpLeaseDNSServerInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+				cfg.metrics.dhcpLeaseNetmaskInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+				cfg.metrics.dhcpLeaseRouterInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+				cfg.metrics.dhcpLeaseInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+				cfg.metrics.dhcpLeaseNTPServerInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+				cfg.metrics.dhcpLeaseSearchDomainInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+				cfg.metrics.dhcpLeaseAddressInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+				cfg.metrics.dhcpLeaseBootInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+			},
+			OnNAK: func(nak *dhclient.Lease) {
+				afterNAK = true
+				recordRelay(nak)
+				if nak.Message != "" {
+					logger.Warn("Server sent a message", "type", "nak", "message", nak.Message)
+					cfg.metrics.setServerMessage(targetAddr, "nak", nak.Message)
+				}
+			},
+			OnError: func(err error) {
+				if preferences.failed(logger, err) {
+					select {
+					case retryRequest <- struct{}{}:
+					default:
+					}
+				}
+				dora = doraTrace{}
+				renewalStreak = 0
+				myRenewalStreakMetric.Set(0)
+				cfg.series.touch(targetAddr)
+				reason := failureReason(err)
+				logLeaseEvent(logger, cfg.events, cfg.publisher, targetAddr, slog.LevelWarn, eventFailed, "DHCP exchange failed", nil, "reason", reason, "err", err)
+				cfg.metrics.dhcpFailuresTotal.WithLabelValues(targetAddr, reason).Inc()
+				cfg.statsd.count("failed", targetAddr, "reason", reason)
+				cfg.results.failed(targetAddr)
+				cfg.metrics.dhcpLastError.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+				cfg.metrics.dhcpLastError.WithLabelValues(targetAddr, reason).Set(1)
+				if errors.Is(err, dhclient.ErrInvalidLeaseTime) {
+					logger.Error("Server granted a lease time of zero, not binding the lease")
+					myInvalidLeaseTimeMetric.Inc()
+				}
+				if myServerAnsweringMetric != nil && reason == failureTimeout {
+					myServerAnsweringMetric.Set(0)
+				}
+				if reason == failureSocket {
+					mySocketFailuresMetric.Inc()
+					// Retrying can't help without the privileges.
+					if errors.Is(err, os.ErrPermission) {
+						select {
+						case socketDenied <- err:
+						default:
+						}
+					}
+				}
+				breaker.recordFailure(time.Now())
+				if !held {
+					failures++
+				}
+				// A NAK is an answer, so only back off when no server
+				// answered at all. Failed renewals are retried by the
+				// client while the lease is still held.
+				if !held && maxFailures > 0 && failures >= maxFailures {
+					select {
+					case givingUp <- failures:
+					default:
+					}
+				} else if !held && reason != failureNAK {
+					if delay := backoff.failure(); delay > 0 {
+						select {
+						case backingOff <- delay:
+						default:
+						}
+					}
+				}
+				target.startSlot.release()
+			},
+			Accept: func(lease *dhclient.Lease) error {
+				leaseTime := lease.Expire.Sub(lease.Bound)
+				if lease.Infinite || leaseTime >= cfg.minLeaseTime {
+					return nil
+				}
+
+				logger.Warn(
+					"Granted lease is shorter than the minimum acceptable lease time",
+					"addr", lease.FixedAddress, "lease_time", leaseTime, "min_lease_time", cfg.minLeaseTime,
+					"decline", cfg.declineShortLeases,
+				)
+				myShortLeaseMetric.Inc()
+
+				if cfg.declineShortLeases {
+					logLeaseEvent(logger, cfg.events, cfg.publisher, targetAddr, slog.LevelInfo, eventDeclined, "Declining lease", lease)
+					return fmt.Errorf("lease time %s is shorter than %s", leaseTime, cfg.minLeaseTime)
+				}
+
+				return nil
+			},
+		}
+
+		client.AcceptOffer = func(lease *dhclient.Lease) error {
+			for _, addr := range wanted {
+				if addr.Equal(lease.FixedAddress) {
+					return nil
+				}
+			}
+
+			if offerPolicy == offerPolicyReject {
+				logger.Warn("Rejecting offer of another address than requested", "addr", lease.FixedAddress, "server", lease.ServerID)
+				cfg.metrics.dhcpAlternativeOffersTotal.WithLabelValues(targetAddr, offerPolicy, alternativeRejected).Inc()
+				return fmt.Errorf("offered %s rather than a requested address", lease.FixedAddress)
+			}
+
+			if offerPolicy == offerPolicyAcceptLog {
+				logger.Warn("Accepting offer of another address than requested", "addr", lease.FixedAddress, "server", lease.ServerID)
+			} else {
+				logger.Debug("Accepting offer of another address than requested", "addr", lease.FixedAddress, "server", lease.ServerID)
+			}
+			cfg.metrics.dhcpAlternativeOffersTotal.WithLabelValues(targetAddr, offerPolicy, alternativeAccepted).Inc()
+			return nil
+		}
+
+		if target.offerProbe != "" {
+			acceptOffer := client.AcceptOffer
+			client.AcceptOffer = func(lease *dhclient.Lease) error {
+				if err := acceptOffer(lease); err != nil {
+					return err
+				}
+
+				owner, err := probeOffer(cfg, iface, client.Chaddr(), lease.FixedAddress, target.offerProbe)
+				switch {
+				case err != nil:
+					// Not knowing is no reason to go without an address.
+					logger.Warn("Unable to probe offered address, requesting it anyway", "addr", lease.FixedAddress, "probe", target.offerProbe, "err", err)
+					cfg.metrics.dhcpOfferProbesTotal.WithLabelValues(targetAddr, probeError).Inc()
+					return nil
+				case owner != "":
+					logger.Warn("Offered address is in use, declining it", "addr", lease.FixedAddress, "owner", owner, "server", lease.ServerID)
+					cfg.metrics.dhcpOfferProbesTotal.WithLabelValues(targetAddr, probeInUse).Inc()
+					return fmt.Errorf("%w by %s", dhclient.ErrAddressInUse, owner)
+				default:
+					logger.Info("Offered address is free, requesting it", "addr", lease.FixedAddress, "probe", target.offerProbe)
+					cfg.metrics.dhcpOfferProbesTotal.WithLabelValues(targetAddr, probeFree).Inc()
+					return nil
+				}
+			}
+		}
+		if cfg.renewScaling != nil {
+			client.RenewAt = cfg.renewScaling.renewAt
+		}
+		if cfg.nakRetryDelay != nil {
+			nakDelay := *cfg.nakRetryDelay
+			client.RetryDelay = func(err error) time.Duration {
+				if errors.Is(err, dhclient.ErrNAK) {
+					return nakDelay
+				}
+				return time.Second
+			}
+		}
+		onBound := client.OnBound
+		client.OnBound = func(lease *dhclient.Lease) {
+			if lease.Infinite {
+				myRenewTimeMetric.Set(math.Inf(1))
+			} else {
+				myRenewTimeMetric.Set(float64(client.RenewTime(lease).Unix()))
+			}
+			onBound(lease)
+		}
+
+		if target.params != nil {
+			for _, param := range target.params {
+				client.AddParamRequest(param)
+			}
+		} else if cfg.noDefaultParams {
+			logger.Debug("Not requesting any params", "params", []layers.DHCPOpt{})
+		} else {
+			for _, param := range dhclient.DefaultParamsRequestList {
+				logger.Debug("Adding default option", "param", param)
+				client.AddParamRequest(layers.DHCPOpt(param))
+			}
+			if cfg.requestBootOptions {
+				logger.Debug("Adding boot options", "params", []layers.DHCPOpt{dhclient.OptTFTPServerName, dhclient.OptBootFileName})
+				client.AddParamRequest(dhclient.OptTFTPServerName)
+				client.AddParamRequest(dhclient.OptBootFileName)
+			}
+			if cfg.requestDomainSearch {
+				logger.Debug("Adding domain search option", "param", layers.DHCPOptDomainSearch)
+				client.AddParamRequest(layers.DHCPOptDomainSearch)
+			}
+		}
+
+		logger.Debug("Adding option to request target address")
+		client.AddOption(
+			layers.DHCPOptRequestIP, net.ParseIP(targetAddr).To4(),
+		)
+
+		if target.fqdn != nil {
+			opt := target.fqdn.option()
+			logger.Info("Adding client FQDN option", "fqdn", target.fqdn.name, "flags", fmt.Sprintf("%#02x", target.fqdn.flags))
+			client.AddOption(opt.Type, opt.Data)
+		}
+
+		if target.hostname != "" {
+			logger.Info("Adding host name option", "hostname", target.hostname)
+			client.AddOption(layers.DHCPOptHostname, []byte(target.hostname))
+		}
+
+		if target.clientID != nil {
+			logger.Info("Adding client identifier option", "client_id", target.clientID)
+			client.AddOption(layers.DHCPOptClientID, target.clientID)
+		}
+
+		for _, opt := range target.rawOptions {
+			logger.Info("Adding raw option", "code", int(opt.Type), "data", hex.EncodeToString(opt.Data))
+			client.AddOption(opt.Type, opt.Data)
+		}
+
+		maxMessageSize := uint16(minMaxMessageSize)
+		if cfg.maxMessageSize != 0 {
+			maxMessageSize = cfg.maxMessageSize
+			logger.Debug("Adding max message size option", "size", maxMessageSize)
+			client.AddOption(layers.DHCPOptMaxMessageSize, maxMessageSizeOption(maxMessageSize))
+		}
+		checkOptionsSize(logger, client.DHCPOptions, maxMessageSize, cfg.maxMessageSize != 0)
+		myMaxMessageSizeMetric.Set(float64(maxMessageSize))
+
+		if target.restoredLease != nil {
+			if target.restoredLease.Infinite || time.Now().Before(target.restoredLease.Expire) {
+				logger.Info(
+					"Renewing restored lease", "addr", target.restoredLease.FixedAddress,
+					"server", target.restoredLease.ServerID, "expire", target.restoredLease.Expire,
+					"infinite", target.restoredLease.Infinite, "imported", target.leaseImported,
+				)
+				lease := *target.restoredLease
+				client.Lease = &lease
+				restoring = true
+				imported = target.leaseImported
+
+				// A failed renewal is otherwise retried until the lease
+				// expires, so give up on the restored lease straight away.
+				onError := client.OnError
+				client.OnError = func(err error) {
+					if restoring {
+						logger.Warn("Unable to restore lease, will request a new one", "err", err)
+						client.Lease = nil
+						restoring = false
+						imported = false
+					}
+					onError(err)
+				}
+			}
+
+			// The lease is only worth renewing the first time around.
+			target.restoredLease = nil
+		}
+
+		// Preferences are moved through by the client's OnBound and OnError.
+		if preferences != nil {
+			preferences.request(logger, &client, target.server)
+		} else if squat != nil && client.Lease == nil {
+			squat.request(&client, target.server)
+		} else if reboot.pending() && client.Lease == nil {
+			reboot.request(&client)
+		}
+
+		if cfg.duplicateAckWindow > 0 {
+			client.DuplicateWindow = cfg.duplicateAckWindow
+			client.OnDuplicate = func(first, duplicate *dhclient.Lease) {
+				outcome := duplicateSameAddress
+				if !duplicate.FixedAddress.Equal(first.FixedAddress) {
+					outcome = duplicateDifferentAddress
+				}
+				logger.Warn(
+					"Ignoring duplicate ACK to the same request, the first one is kept", "outcome", outcome,
+					"address", first.FixedAddress, "server", first.ServerID,
+					"duplicate_address", duplicate.FixedAddress, "duplicate_server", duplicate.ServerID,
+				)
+				cfg.metrics.dhcpDuplicateAcksTotal.WithLabelValues(targetAddr, outcome).Inc()
+			}
+		}
+
+		if target.requestDelay > 0 {
+			client.RequestDelay = target.requestDelay
+			// delayed is set from an offer until the delayed request
+			// for it is answered, or fails.
+			delayed := false
+
+			onOffer := client.OnOffer
+			client.OnOffer = func(lease *dhclient.Lease) {
+				delayed = true
+				onOffer(lease)
+			}
+
+			onBound := client.OnBound
+			client.OnBound = func(lease *dhclient.Lease) {
+				if delayed {
+					logger.Debug("Delayed request was acknowledged", "delay", target.requestDelay)
+					cfg.metrics.dhcpDelayedRequestsTotal.WithLabelValues(targetAddr, delayedRequestBound).Inc()
+					delayed = false
+				}
+				onBound(lease)
+			}
+
+			onError := client.OnError
+			client.OnError = func(err error) {
+				if delayed {
+					reason := failureReason(err)
+					if reason == failureNAK {
+						logger.Info("Server withdrew its offer during the request delay", "delay", target.requestDelay)
+					}
+					cfg.metrics.dhcpDelayedRequestsTotal.WithLabelValues(targetAddr, reason).Inc()
+					delayed = false
+				}
+				onError(err)
+			}
+		}
+
+		// The interface, and with it the chaddr, may have changed since
+		// the last client.
+		cfg.metrics.dhcpTargetChaddrInfo.DeletePartialMatch(prometheus.Labels{"ip": targetAddr})
+		cfg.metrics.dhcpTargetChaddrInfo.WithLabelValues(targetAddr, client.Chaddr().String()).Set(1)
+
+		logger.Info("Starting dhcp client", "chaddr", client.Chaddr())
+		churn.setPhase(churnRequesting)
+		if cfg.recoverPanics {
+			client.OnPanic = func(v any, stack []byte) {
+				recordPanic(logger, cfg.metrics.greedydhcpPanicsTotal, panicComponentClient, v, stack)
+				select {
+				case clientPanicked <- struct{}{}:
+				default:
+				}
+			}
+		}
+		client.Start()
+		running = &client
+
+		// A bind left over from the previous client must not start the
+		// hold timer of this one, nor a NAK it received restart this one.
+		select {
+		case <-bound:
+		default:
+		}
+		select {
+		case <-retryRequest:
+		default:
+		}
+		select {
+		case <-backingOff:
+		default:
+		}
+		select {
+		case <-givingUp:
+		default:
+		}
+
+		var hold <-chan time.Time
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				logger.Info("Stopping dhcp client")
+				client.Stop()
+				release := cfg.releaseOnExit || errors.Is(context.Cause(ctx), errTargetRemoved)
+				if imported {
+					release = cfg.releaseImportedOnExit
+				}
+				if released := client.Lease; held && released != nil && release {
+					if err := releaseWithin(&client, exitReleaseTimeout); err != nil {
+						logger.Warn("Unable to release lease on exit", "err", err)
+					} else {
+						logLeaseEvent(logger, cfg.events, cfg.publisher, targetAddr, slog.LevelInfo, eventReleased, "Released lease", released)
+					}
+				}
+				cfg.leases.remove(targetAddr)
+				cancelStable()
+				return
+			case <-target.reacquire:
+				logger.Info("Re-acquiring lease on request")
+				myReacquireMetric.Inc()
+				client.Stop()
+				cfg.leases.remove(targetAddr)
+				cancelStable()
+				continue outer
+			case <-retryRequest:
+				// Restarting skips the delay the client waits out after a
+				// failure.
+				client.Stop()
+				continue outer
+			case delay := <-backingOff:
+				logger.Info("Backing off before retrying", "delay", delay)
+				myBackoffMetric.Set(delay.Seconds())
+				client.Stop()
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				case <-linkUp:
+					linkUp = cfg.linkUp.wait()
+					logger.Info("Interface came back up, retrying straight away")
+					backoff.reset()
+				}
+				myBackoffMetric.Set(0)
+				continue outer
+			case n := <-givingUp:
+				if !hardFailed {
+					logger.Error(
+						"Target failed too many times in a row, marking it hard failed",
+						"failures", n, "max_failures", maxFailures, "retry_interval", cfg.hardFailRetryInterval,
+					)
+					hardFailed = true
+					myHardFailedMetric.Set(1)
+					cfg.hardFailed.add(targetAddr)
+				} else {
+					logger.Debug("Hard failed target failed again", "failures", n, "retry_interval", cfg.hardFailRetryInterval)
+				}
+				client.Stop()
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(cfg.hardFailRetryInterval):
+				case <-target.reacquire:
+					logger.Info("Retrying hard failed target on request")
+					myReacquireMetric.Inc()
+				case <-linkUp:
+					linkUp = cfg.linkUp.wait()
+					logger.Info("Interface came back up, retrying hard failed target")
+					backoff.reset()
+				}
+				continue outer
+			case <-clientPanicked:
+				logger.Warn("Restarting client after panic", "delay", cfg.panicRestartDelay)
+				client.Stop()
+				cfg.leases.remove(targetAddr)
+				cancelStable()
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(cfg.panicRestartDelay):
+				}
+				continue outer
+			case err := <-socketDenied:
+				logger.Error("Not allowed to open a raw socket, giving up on target", "err", withSocketHint(err))
+				client.Stop()
+				cfg.leases.remove(targetAddr)
+				cancelStable()
+				cfg.socketDenied.add(targetAddr)
+				<-ctx.Done()
+				return
+			case <-linkUp:
+				linkUp = cfg.linkUp.wait()
+				backoff.reset()
+			case <-stateFlush.C:
+				states.flush()
+			case <-arpRecheck:
+				if lease, ok := cfg.leases.get(targetAddr); ok {
+					go recheckLease(ctx, logger, cfg, iface, lease.FixedAddress, myPostBindConflictsMetric)
+				}
+			case <-ifaceChanged:
+				logger.Info("Interface changed, restarting client", "iface", cfg.currentIface().Name)
+				client.Stop()
+				cfg.leases.remove(targetAddr)
+				cancelStable()
+				ifaceChanged = cfg.ifaceChanged.wait()
+				continue outer
+			case <-bound:
+				if hold == nil {
+					hold = churn.hold()
+				}
+			case <-hold:
+				logger.Info("Hold time elapsed, releasing lease", "hold_time", target.holdTime)
+				churn.setPhase(churnReleasing)
+				client.Stop()
+				released := releaseHeld(logger, cfg, targetAddr, &client, held)
+				cfg.leases.remove(targetAddr)
+				cancelStable()
+				if !churn.cycled(ctx, released) {
+					return
+				}
+				continue outer
+			case <-windowChanged:
+				windowChanged = window.wait()
+				if window.isOpen() {
+					continue
+				}
+				logger.Info("Schedule window ended, releasing lease and stopping client")
+				client.Stop()
+				releaseHeld(logger, cfg, targetAddr, &client, held)
+				cfg.leases.remove(targetAddr)
+				cancelStable()
+				continue outer
+			case <-tripped:
+				break wait
+			}
+		}
+
+		logger.Warn("Too many consecutive failures, pausing requests", "cooldown", cfg.breaker.cooldown)
+		client.Stop()
+		cfg.leases.remove(targetAddr)
+		cancelStable()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cfg.breaker.cooldown):
+		case <-linkUp:
+			linkUp = cfg.linkUp.wait()
+			logger.Info("Interface came back up, ending pause early")
+			backoff.reset()
+		}
+
+		breaker.halfOpen()
+	}
+}
@@ -0,0 +1,1850 @@
+package greedydhcp
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// testMetrics are the metrics of the clients of every test. Each test uses
+// targets of its own, so they don't see each other's series.
+var testMetrics = newMetrics(false, nil)
+
+// testClientConfig returns a clientConfig using iface with defaults set.
+func testClientConfig(iface *net.Interface) *clientConfig {
+	return &clientConfig{
+		metrics: testMetrics,
+		iface:   iface,
+		leases:  newLeaseRegistry(testMetrics.dhcpDuplicateBoundAddresses),
+		series:  newSeriesLRU(slog.New(slog.NewTextHandler(io.Discard, nil)), 0, testMetrics.deleteTarget),
+	}
+}
+
+// startTestClient runs runClient for target until the test ends.
+func startTestClient(t *testing.T, cfg *clientConfig, target targetConfig) {
+	t.Helper()
+	startTestClientWithLogger(t, testLogger(t), cfg, target)
+}
+
+// startTestClientWithLogger is startTestClient, logging to logger.
+func startTestClientWithLogger(t *testing.T, logger *slog.Logger, cfg *clientConfig, target targetConfig) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go runClient(ctx, wg, logger, cfg, target)
+
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+}
+
+// logBuffer collects the logs of a client, which are written from goroutines
+// of its own.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// logger returns a logger writing to b at every level.
+func (b *logBuffer) logger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(b, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+func TestRunClientAcquiresLease(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.1"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	acquired := testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, acquired) >= 1
+	})
+
+	expiry := metricValue(t, testMetrics.dhcpLeaseExpiryTimestampSeconds.WithLabelValues(target))
+	if expiry < float64(time.Now().Add(30*time.Minute).Unix()) {
+		t.Errorf("expected expiry timestamp about an hour from now, got %v", expiry)
+	}
+
+	if v := metricValue(t, testMetrics.dhcpMessageSizeBytes.WithLabelValues(target, "discover").(prometheus.Metric)); v != 1 {
+		t.Errorf("expected the size of one DISCOVER to be recorded, got %v", v)
+	}
+
+	for name, m := range map[string]prometheus.Observer{
+		"discover to offer": testMetrics.dhcpDiscoverToOfferSeconds.WithLabelValues(target),
+		"request to ack":    testMetrics.dhcpRequestToAckSeconds.WithLabelValues(target),
+	} {
+		if v := metricValue(t, m.(prometheus.Metric)); v != 1 {
+			t.Errorf("expected one %s latency observation, got %v", name, v)
+		}
+	}
+}
+
+func TestRunClientExportsRenewalTimers(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	for _, tc := range []struct {
+		target string
+		t1, t2 time.Duration
+		// wantT1 and wantT2 are in seconds.
+		wantT1, wantT2 float64
+	}{
+		// Without T1 and T2, they default to half and 7/8 of the hour
+		// long lease.
+		{target: "10.100.0.92", wantT1: 1800, wantT2: 3150},
+		{target: "10.100.0.93", t1: 10 * time.Minute, t2: 20 * time.Minute, wantT1: 600, wantT2: 1200},
+	} {
+		srv.setRenewalTimes(tc.t1, tc.t2)
+		startTestClient(t, testClientConfig(iface), targetConfig{addr: tc.target})
+
+		waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+			return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(tc.target)) >= 1
+		})
+
+		if v := metricValue(t, testMetrics.dhcpLeaseT1Seconds.WithLabelValues(tc.target)); v != tc.wantT1 {
+			t.Errorf("%s: expected T1 of %vs, got %v", tc.target, tc.wantT1, v)
+		}
+		if v := metricValue(t, testMetrics.dhcpLeaseT2Seconds.WithLabelValues(tc.target)); v != tc.wantT2 {
+			t.Errorf("%s: expected T2 of %vs, got %v", tc.target, tc.wantT2, v)
+		}
+	}
+}
+
+func TestRunClientNakCountsFailure(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setNak(true)
+
+	target := "10.100.0.2"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "failure to be counted", func() bool {
+		return metricValue(t, testMetrics.dhcpFailedLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	if v := metricValue(t, testMetrics.dhcpAcquireFailuresTotal.WithLabelValues(target)); v < 1 {
+		t.Errorf("expected the failure to be counted as a failure to acquire, got %v", v)
+	}
+	if v := metricValue(t, testMetrics.dhcpLostLeasesTotal.WithLabelValues(target)); v != 0 {
+		t.Errorf("expected no lost leases, got %v", v)
+	}
+
+	if v := metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)); v != 0 {
+		t.Errorf("expected no acquired leases, got %v", v)
+	}
+}
+
+func TestRunClientExportsServerMessage(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setNak(true)
+	srv.setMessage("address\tnot available")
+
+	target := "10.100.0.35"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	nak := prometheus.Labels{"ip": target, "type": "nak", "message": "address?not available"}
+	waitFor(t, 10*time.Second, "NAK message to be exported", func() bool {
+		return hasSeries(t, testMetrics.dhcpServerMessageInfo, nak)
+	})
+
+	srv.setNak(false)
+	srv.setMessage("welcome")
+	waitFor(t, 20*time.Second, "ACK message to replace the NAK message", func() bool {
+		return hasSeries(t, testMetrics.dhcpServerMessageInfo, prometheus.Labels{"ip": target, "type": "ack", "message": "welcome"})
+	})
+	if hasSeries(t, testMetrics.dhcpServerMessageInfo, nak) {
+		t.Error("expected only the latest message to be exported")
+	}
+}
+
+func TestRunClientWaitsForDependencies(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	dependency := net.IPv4(10, 100, 0, 36)
+	srv.setNakAddrs(dependency)
+
+	cfg := testClientConfig(iface)
+	target := "10.100.0.37"
+	startTestClient(t, cfg, targetConfig{addr: target, dependsOn: []string{dependency.String()}})
+	startTestClient(t, cfg, targetConfig{addr: dependency.String()})
+
+	waitFor(t, 10*time.Second, "dependency to be NAKed", func() bool {
+		return metricValue(t, testMetrics.dhcpFailedLeasesTotal.WithLabelValues(dependency.String())) >= 1
+	})
+	if v := metricValue(t, testMetrics.dhcpWaitingForDependencies.WithLabelValues(target)); v != 1 {
+		t.Errorf("expected the target to wait for its dependency, got %v", v)
+	}
+	if v := metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)); v != 0 {
+		t.Errorf("expected the target not to start before its dependency is bound, got %v leases", v)
+	}
+
+	srv.setNakAddrs()
+	waitFor(t, 20*time.Second, "target to start once its dependency is bound", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) == 1
+	})
+	if v := metricValue(t, testMetrics.dhcpWaitingForDependencies.WithLabelValues(target)); v != 0 {
+		t.Errorf("expected the target to no longer wait, got %v", v)
+	}
+}
+
+func TestRunClientInitReboot(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	cfg := testClientConfig(iface)
+	cfg.initReboot = true
+	target := "10.100.0.38"
+	startTestClient(t, cfg, targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) == 1
+	})
+
+	if v := metricValue(t, testMetrics.dhcpInitRebootTotal.WithLabelValues(target, initRebootBound)); v != 1 {
+		t.Errorf("expected the lease to be got through INIT-REBOOT, got %v", v)
+	}
+	if discovers, _ := srv.counts(); discovers != 0 {
+		t.Errorf("expected no DISCOVER, got %d", discovers)
+	}
+}
+
+func TestRunClientInitRebootFallsBackOnNak(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	target := net.IPv4(10, 100, 0, 39)
+	srv.setNakAddrs(target)
+	srv.setOfferAddr(net.IPv4(10, 100, 0, 40))
+
+	cfg := testClientConfig(iface)
+	cfg.initReboot = true
+	startTestClient(t, cfg, targetConfig{addr: target.String()})
+
+	waitFor(t, 10*time.Second, "lease to be acquired through discovery", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target.String())) == 1
+	})
+
+	if v := metricValue(t, testMetrics.dhcpInitRebootTotal.WithLabelValues(target.String(), failureNAK)); v != 1 {
+		t.Errorf("expected the INIT-REBOOT to be NAKed, got %v", v)
+	}
+	if discovers, _ := srv.counts(); discovers == 0 {
+		t.Error("expected discovery to be fallen back to")
+	}
+}
+
+func TestRunClientNakOnRenewalExpiresLease(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setLeaseTime(2 * time.Second)
+
+	target := "10.100.0.3"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "lease to be renewed", func() bool {
+		return metricValue(t, testMetrics.dhcpRenewalStreak.WithLabelValues(target)) >= 1
+	})
+
+	srv.setNak(true)
+
+	waitFor(t, 10*time.Second, "lease to expire", func() bool {
+		return metricValue(t, testMetrics.dhcpExpiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	if v := metricValue(t, testMetrics.dhcpRenewalStreak.WithLabelValues(target)); v != 0 {
+		t.Errorf("expected the NAK to end the renewal streak, got %v", v)
+	}
+
+	if v := metricValue(t, testMetrics.dhcpLostLeasesTotal.WithLabelValues(target)); v < 1 {
+		t.Errorf("expected the lease to be counted as lost, got %v", v)
+	}
+}
+
+func TestRunClientObservesLeaseOutage(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setLeaseTime(2 * time.Second)
+
+	target := "10.100.0.42"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+	outage := testMetrics.dhcpLeaseOutageSeconds.WithLabelValues(target).(prometheus.Metric)
+	acquisition := testMetrics.dhcpLeaseAcquisitionDurationSeconds.WithLabelValues(target).(prometheus.Metric)
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+	acquiredAt := time.Now()
+	if v := metricValue(t, acquisition); v != 1 {
+		t.Errorf("expected the first acquisition to be observed, got %v", v)
+	}
+
+	if v := metricValue(t, outage); v != 0 {
+		t.Errorf("expected no outage before a lease is lost, got %v", v)
+	}
+
+	srv.setNak(true)
+	waitFor(t, 10*time.Second, "lease to expire", func() bool {
+		return metricValue(t, testMetrics.dhcpExpiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+	srv.setNak(false)
+
+	waitFor(t, 10*time.Second, "outage to be observed", func() bool {
+		return metricValue(t, outage) >= 1
+	})
+
+	// The second acquisition is timed from when the lease was lost, on the
+	// NAK of its renewal a second after it was bound.
+	pb := &dto.Metric{}
+	if err := acquisition.Write(pb); err != nil {
+		t.Fatal(err)
+	}
+	if n := pb.Histogram.GetSampleCount(); n != 2 {
+		t.Fatalf("expected both acquisitions to be observed, got %d", n)
+	}
+	if sum, since := pb.Histogram.GetSampleSum(), time.Since(acquiredAt).Seconds(); sum > since-0.5 {
+		t.Errorf("expected the second acquisition to be timed from the expiry, got %vs in total over %vs", sum, since)
+	}
+}
+
+func TestRunClientCountsAddressChanges(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setLeaseTime(2 * time.Second)
+
+	target := "10.100.0.48"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+	changed := testMetrics.dhcpAddressChangedTotal.WithLabelValues(target)
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+	if v := metricValue(t, changed); v != 0 {
+		t.Errorf("expected no address change on the first bind, got %v", v)
+	}
+
+	reallocated := "10.100.0.148"
+	srv.setAckAddr(net.ParseIP(reallocated))
+	waitFor(t, 10*time.Second, "address change to be counted", func() bool {
+		return metricValue(t, changed) >= 1
+	})
+
+	if !hasSeries(t, testMetrics.dhcpLeaseAddressInfo, map[string]string{"ip": target, "bound": reallocated}) {
+		t.Errorf("expected the reallocated address %s to be exported", reallocated)
+	}
+}
+
+func TestRunClientExportsLeaseInfo(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.79"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	want := map[string]string{"ip": target, "server_id": "127.0.0.1", "router": "127.0.0.1", "subnet_mask": "255.0.0.0"}
+	waitFor(t, 10*time.Second, "lease info to be exported", func() bool {
+		return hasSeries(t, testMetrics.dhcpLeaseInfo, want)
+	})
+}
+
+func TestRunClientSendsHostnameAndClientID(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.80"
+	id := clientID{1, 2, 0, 0, 0, 0, 0x80}
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target, hostname: "probe-80", clientID: id})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	if got := srv.lastOption(layers.DHCPOptHostname); string(got) != "probe-80" {
+		t.Errorf("expected host name probe-80, got %q", got)
+	}
+	if got := srv.lastOption(layers.DHCPOptClientID); !bytes.Equal(got, id) {
+		t.Errorf("expected client identifier %s, got %x", id, got)
+	}
+}
+
+func TestRunClientIgnoresDuplicateAcks(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	duplicate := "10.100.0.154"
+	srv.setDuplicateAckAddr(net.ParseIP(duplicate))
+
+	target := "10.100.0.54"
+	cfg := testClientConfig(iface)
+	cfg.duplicateAckWindow = time.Second
+	startTestClient(t, cfg, targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "duplicate ACK to be counted", func() bool {
+		return metricValue(t, testMetrics.dhcpDuplicateAcksTotal.WithLabelValues(target, duplicateDifferentAddress)) >= 1
+	})
+
+	if !hasSeries(t, testMetrics.dhcpLeaseAddressInfo, map[string]string{"ip": target, "bound": target}) {
+		t.Error("expected the address of the first ACK to stay bound")
+	}
+	if v := metricValue(t, testMetrics.dhcpDuplicateAcksTotal.WithLabelValues(target, duplicateSameAddress)); v != 0 {
+		t.Errorf("expected no duplicate with the same address, got %v", v)
+	}
+}
+
+func TestRunClientHoldsInfiniteLeases(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setLeaseTime(dhclient.InfiniteLeaseTime * time.Second)
+
+	target := "10.100.0.55"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+	if v := metricValue(t, testMetrics.dhcpLeaseExpiryTimestampSeconds.WithLabelValues(target)); !math.IsInf(v, 1) {
+		t.Errorf("expected the expiry of an infinite lease to be +Inf, got %v", v)
+	}
+	if v := metricValue(t, testMetrics.dhcpLeaseT1Seconds.WithLabelValues(target)); !math.IsInf(v, 1) {
+		t.Errorf("expected the T1 of an infinite lease to be +Inf, got %v", v)
+	}
+
+	// A lease whose times were taken literally would expire and be
+	// requested again straight away.
+	time.Sleep(2 * time.Second)
+	if _, requests := srv.counts(); requests != 1 {
+		t.Errorf("expected the infinite lease to be requested once, got %d requests", requests)
+	}
+}
+
+func TestRunClientRejectsZeroLeaseTime(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setLeaseTime(0)
+
+	target := "10.100.0.56"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "invalid lease time to be counted", func() bool {
+		return metricValue(t, testMetrics.dhcpInvalidLeaseTimeTotal.WithLabelValues(target)) >= 1
+	})
+	if v := metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)); v != 0 {
+		t.Errorf("expected no lease to be bound, got %v", v)
+	}
+	if v := metricValue(t, testMetrics.dhcpLeaseExpiryTimestampSeconds.WithLabelValues(target)); v != 0 {
+		t.Errorf("expected no expiry to be exported, got %v", v)
+	}
+}
+
+func TestRunClientRechecksBoundAddress(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.58"
+	cfg := testClientConfig(iface)
+	cfg.arpRecheckInterval = 200 * time.Millisecond
+	cfg.arpDefendTimeout = 100 * time.Millisecond
+	startTestClient(t, cfg, targetConfig{addr: target})
+	conflicts := testMetrics.dhcpPostBindConflictsTotal.WithLabelValues(target)
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+	time.Sleep(500 * time.Millisecond)
+	if v := metricValue(t, conflicts); v != 0 {
+		t.Fatalf("expected no conflict while nobody else uses the address, got %v", v)
+	}
+
+	// Another host starts using the address after it was bound.
+	defendAddress(t, iface, net.HardwareAddr{0x02, 0, 0, 0, 0, 0x04}, net.ParseIP(target))
+	waitFor(t, 10*time.Second, "post bind conflict to be counted", func() bool {
+		return metricValue(t, conflicts) >= 1
+	})
+}
+
+func TestRunClientDecidesExpectations(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.59"
+	cfg := testClientConfig(iface)
+	cfg.expectationsFailed = newDeniedTargets()
+	startTestClient(t, cfg, targetConfig{addr: target, expectTimeout: 10 * time.Second, expectBound: 300 * time.Millisecond})
+
+	waitFor(t, 10*time.Second, "expectation to pass", func() bool {
+		return metricValue(t, testMetrics.dhcpTargetExpectation.WithLabelValues(target, expectPassed)) == 1
+	})
+	if v := metricValue(t, testMetrics.dhcpTargetExpectation.WithLabelValues(target, expectPending)); v != 0 {
+		t.Errorf("expected the expectation to no longer be pending, got %v", v)
+	}
+	if cfg.expectationsFailed.any() {
+		t.Error("expected no failed expectation")
+	}
+}
+
+func TestRunClientFailsExpectationsPastTimeout(t *testing.T) {
+	// No server answers, so the target never gets a lease.
+	iface := loopbackInterface(t)
+
+	target := "10.100.0.60"
+	cfg := testClientConfig(iface)
+	cfg.expectationsFailed = newDeniedTargets()
+	startTestClient(t, cfg, targetConfig{addr: target, expectTimeout: 500 * time.Millisecond})
+
+	waitFor(t, 10*time.Second, "expectation to fail", func() bool {
+		return metricValue(t, testMetrics.dhcpTargetExpectation.WithLabelValues(target, expectFailed)) == 1
+	})
+	if !cfg.expectationsFailed.covers([]string{target}) {
+		t.Error("expected the failed expectation to be recorded")
+	}
+}
+
+func TestRunClientCountsTruncatedReplies(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setTruncate(true)
+
+	target := "10.100.0.49"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "truncated reply to be counted", func() bool {
+		return metricValue(t, testMetrics.dhcpTruncatedRepliesTotal.WithLabelValues(target)) >= 1
+	})
+}
+
+func TestRunClientReadsLargeReplies(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	// Far larger than the 1500 bytes of an Ethernet MTU, but within that
+	// of the loopback interface.
+	srv.setPadding(4000)
+
+	target := "10.100.0.61"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+	if v := metricValue(t, testMetrics.dhcpTruncatedReadsTotal.WithLabelValues(target)); v != 0 {
+		t.Errorf("expected no truncated read, got %v", v)
+	}
+	if v := metricValue(t, testMetrics.dhcpTruncatedRepliesTotal.WithLabelValues(target)); v != 0 {
+		t.Errorf("expected the reply to be read with its end option, got %v truncated", v)
+	}
+}
+
+func TestRunClientCountsTruncatedReads(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setPadding(2000)
+
+	target := "10.100.0.62"
+	cfg := testClientConfig(iface)
+	cfg.receiveBufferSize = 1518
+	startTestClient(t, cfg, targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "truncated read to be counted", func() bool {
+		return metricValue(t, testMetrics.dhcpTruncatedReadsTotal.WithLabelValues(target)) >= 1
+	})
+}
+
+func TestRunClientTracksRelays(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setLeaseTime(2 * time.Second)
+	relay := net.ParseIP("10.20.0.1")
+	srv.setRelayAddr(relay)
+
+	target := "10.100.0.64"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "relayed replies to be counted", func() bool {
+		return metricValue(t, testMetrics.dhcpRepliesTotal.WithLabelValues(target, replyRelayed)) >= 2
+	})
+	if !hasSeries(t, testMetrics.dhcpReplyRelayInfo, map[string]string{"ip": target, "relay": relay.String()}) {
+		t.Fatal("expected the relay to be exported")
+	}
+
+	// Renewals answered straight by the server drop the relay.
+	srv.setRelayAddr(nil)
+	waitFor(t, 10*time.Second, "direct reply to be counted", func() bool {
+		return metricValue(t, testMetrics.dhcpRepliesTotal.WithLabelValues(target, replyDirect)) >= 1
+	})
+	if hasSeries(t, testMetrics.dhcpReplyRelayInfo, map[string]string{"ip": target}) {
+		t.Error("expected the relay to no longer be exported")
+	}
+}
+
+func TestRunClientCountsStateTime(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setLeaseTime(2 * time.Second)
+
+	target := "10.100.0.50"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	// Renewing at T1 counts the time spent bound.
+	waitFor(t, 10*time.Second, "time bound to be counted", func() bool {
+		return metricValue(t, testMetrics.dhcpStateSecondsTotal.WithLabelValues(target, string(dhclient.StateBound))) > 0
+	})
+	for _, state := range []dhclient.State{dhclient.StateSelecting, dhclient.StateRequesting} {
+		if v := metricValue(t, testMetrics.dhcpStateSecondsTotal.WithLabelValues(target, string(state))); v <= 0 {
+			t.Errorf("expected time to be counted in %s, got %v", state, v)
+		}
+	}
+	if v := metricValue(t, testMetrics.dhcpStateSecondsTotal.WithLabelValues(target, string(dhclient.StateRebinding))); v != 0 {
+		t.Errorf("expected no time rebinding, got %v", v)
+	}
+}
+
+func TestRunClientDelaysRequests(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setNak(true)
+
+	target := "10.100.0.51"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target, requestDelay: 200 * time.Millisecond})
+
+	waitFor(t, 10*time.Second, "withdrawn offer to be counted", func() bool {
+		return metricValue(t, testMetrics.dhcpDelayedRequestsTotal.WithLabelValues(target, failureNAK)) >= 1
+	})
+
+	srv.setNak(false)
+	waitFor(t, 10*time.Second, "delayed request to be bound", func() bool {
+		return metricValue(t, testMetrics.dhcpDelayedRequestsTotal.WithLabelValues(target, delayedRequestBound)) >= 1
+	})
+}
+
+func TestRunClientExportsChaddr(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.52"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "chaddr to be exported", func() bool {
+		return hasSeries(t, testMetrics.dhcpTargetChaddrInfo, map[string]string{"ip": target, "mac": iface.HardwareAddr.String()})
+	})
+}
+
+func TestRunClientHardFailsAfterMaxFailures(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setNak(true)
+
+	target := "10.100.0.43"
+	cfg := testClientConfig(iface)
+	cfg.maxFailures = 2
+	cfg.hardFailRetryInterval = 2 * time.Second
+	cfg.hardFailed = newDeniedTargets()
+	startTestClient(t, cfg, targetConfig{addr: target})
+
+	hardFailed := testMetrics.dhcpTargetHardFailed.WithLabelValues(target)
+	waitFor(t, 10*time.Second, "target to be hard failed", func() bool {
+		return metricValue(t, hardFailed) == 1
+	})
+	if !cfg.hardFailed.covers([]string{target}) {
+		t.Error("expected the target to be recorded as hard failed")
+	}
+
+	_, requests := srv.counts()
+	time.Sleep(time.Second)
+	if _, after := srv.counts(); after != requests {
+		t.Errorf("expected no requests until the retry interval passed, got %d more", after-requests)
+	}
+
+	srv.setNak(false)
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	if v := metricValue(t, hardFailed); v != 0 {
+		t.Errorf("expected a lease to clear the hard failure, got %v", v)
+	}
+	if cfg.hardFailed.covers([]string{target}) {
+		t.Error("expected a lease to clear the recorded hard failure")
+	}
+}
+
+func TestRunClientExportsSearchDomains(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setLeaseTime(2 * time.Second)
+	srv.setDomainSearch([]byte("\x03eng\x07example\x03com\x00\x03ops\xc0\x04"))
+
+	target := "10.100.0.44"
+	cfg := testClientConfig(iface)
+	cfg.requestDomainSearch = true
+	startTestClient(t, cfg, targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "search domains to be exported", func() bool {
+		return hasSeries(t, testMetrics.dhcpLeaseSearchDomainInfo, map[string]string{"ip": target, "domain": "eng.example.com"}) &&
+			hasSeries(t, testMetrics.dhcpLeaseSearchDomainInfo, map[string]string{"ip": target, "domain": "ops.example.com"})
+	})
+
+	if !bytes.Contains(srv.lastParams(), []byte{byte(layers.DHCPOptDomainSearch)}) {
+		t.Errorf("expected option 119 to be requested, got %v", srv.lastParams())
+	}
+
+	srv.setDomainSearch([]byte("eng.example.com"))
+	waitFor(t, 10*time.Second, "decode error to be counted", func() bool {
+		return metricValue(t, testMetrics.dhcpOption119DecodeErrorsTotal.WithLabelValues(target)) >= 1
+	})
+
+	if hasSeries(t, testMetrics.dhcpLeaseSearchDomainInfo, map[string]string{"ip": target}) {
+		t.Error("expected no search domains from a list that doesn't decode")
+	}
+}
+
+func TestRunClientObservesStartWait(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	gate := newStartGate(1)
+	first := gate.enqueue(0)
+
+	target := "10.100.0.46"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target, startSlot: gate.enqueue(0)})
+
+	waitFor(t, 10*time.Second, "client to wait for a start slot", func() bool {
+		return metricValue(t, testMetrics.dhcpWaitingForStartSlot.WithLabelValues(target)) == 1
+	})
+	blocked := time.Now()
+	time.Sleep(200 * time.Millisecond)
+	held := time.Since(blocked)
+	first.release()
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	pb := &dto.Metric{}
+	if err := testMetrics.dhcpStartWaitSeconds.WithLabelValues(target).(prometheus.Metric).Write(pb); err != nil {
+		t.Fatal(err)
+	}
+	if n := pb.Histogram.GetSampleCount(); n != 1 {
+		t.Errorf("expected a single wait to be observed, got %d", n)
+	}
+	if sum := pb.Histogram.GetSampleSum(); sum < held.Seconds() {
+		t.Errorf("expected the wait to last at least the %s the slot was held, got %vs", held, sum)
+	}
+}
+
+func TestRunClientTimeoutDoesNotAcquire(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setSilent(true)
+
+	target := "10.100.0.4"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "discover to be sent", func() bool {
+		discovers, _ := srv.counts()
+		return discovers >= 1
+	})
+
+	time.Sleep(time.Second)
+
+	if v := metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)); v != 0 {
+		t.Errorf("expected no acquired leases, got %v", v)
+	}
+}
+
+func TestRunClientAcceptsMismatchedOffer(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setOfferAddr(net.ParseIP("10.100.0.250"))
+
+	target := "10.100.0.5"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+}
+
+func TestRunClientRejectsMismatchedOffer(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setOfferAddr(net.ParseIP("10.100.0.251"))
+
+	target := "10.100.0.63"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target, offerPolicy: offerPolicyReject})
+
+	waitFor(t, 10*time.Second, "offer to be rejected", func() bool {
+		return metricValue(t, testMetrics.dhcpFailuresTotal.WithLabelValues(target, failureRejected)) >= 1
+	})
+	if v := metricValue(t, testMetrics.dhcpAlternativeOffersTotal.WithLabelValues(target, offerPolicyReject, alternativeRejected)); v < 1 {
+		t.Errorf("expected the rejected offer to be counted, got %v", v)
+	}
+	if _, requests := srv.counts(); requests != 0 {
+		t.Errorf("expected the offer not to be requested, got %d requests", requests)
+	}
+
+	// Once the requested address is offered, it is taken.
+	srv.setOfferAddr(nil)
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+}
+
+func TestRunClientCircuitBreakerOpens(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setNak(true)
+
+	target := "10.100.0.6"
+	cfg := testClientConfig(iface)
+	cfg.breaker = breakerConfig{threshold: 2, window: time.Minute, cooldown: time.Hour}
+	startTestClient(t, cfg, targetConfig{addr: target})
+
+	open := testMetrics.dhcpCircuitBreakerState.WithLabelValues(target, breakerOpen.String())
+	waitFor(t, 10*time.Second, "circuit breaker to open", func() bool {
+		return metricValue(t, open) == 1
+	})
+
+	_, requests := srv.counts()
+	time.Sleep(2 * time.Second)
+	if _, after := srv.counts(); after != requests {
+		t.Errorf("expected no requests while the breaker is open, got %d more", after-requests)
+	}
+}
+
+func TestRunClientDeclinesShortLease(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setLeaseTime(5 * time.Second)
+
+	target := "10.100.0.7"
+	cfg := testClientConfig(iface)
+	cfg.minLeaseTime = time.Minute
+	cfg.declineShortLeases = true
+	startTestClient(t, cfg, targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "short lease to be counted", func() bool {
+		return metricValue(t, testMetrics.dhcpShortLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	if v := metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)); v != 0 {
+		t.Errorf("expected the short lease to be declined, got %v acquired", v)
+	}
+}
+
+func TestRunClientLastError(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setNak(true)
+
+	target := "10.100.0.8"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	nak := map[string]string{"ip": target, "reason": failureNAK}
+	waitFor(t, 10*time.Second, "last error to be a NAK", func() bool {
+		return hasSeries(t, testMetrics.dhcpLastError, nak)
+	})
+
+	srv.setNak(false)
+
+	waitFor(t, 10*time.Second, "last error to be cleared", func() bool {
+		return !hasSeries(t, testMetrics.dhcpLastError, map[string]string{"ip": target})
+	})
+}
+
+func TestRunClientUnicastsToConfiguredServer(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setServerIP(net.ParseIP("127.0.0.2"))
+
+	target := "10.100.0.9"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target, server: net.ParseIP("127.0.0.2").To4()})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	if n := srv.unicastRequests(); n == 0 {
+		t.Error("expected the request to be unicast to the server")
+	}
+	if v := metricValue(t, testMetrics.dhcpTargetServerAnswering.WithLabelValues(target)); v != 1 {
+		t.Errorf("expected the server to be marked as answering, got %v", v)
+	}
+}
+
+func TestRunClientIgnoresOtherServers(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.10"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target, server: net.ParseIP("127.0.0.3").To4()})
+
+	time.Sleep(2 * time.Second)
+
+	if v := metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)); v != 0 {
+		t.Errorf("expected offers from other servers to be ignored, got %v acquired", v)
+	}
+}
+
+func TestRunClientRenewsRestoredLease(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.11"
+	restored := &dhclient.Lease{
+		FixedAddress: net.ParseIP(target).To4(),
+		ServerID:     net.ParseIP("127.0.0.1").To4(),
+		Bound:        time.Now(),
+		Expire:       time.Now().Add(time.Hour),
+	}
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target, restoredLease: restored})
+
+	waitFor(t, 10*time.Second, "lease to be restored", func() bool {
+		return metricValue(t, testMetrics.dhcpLeasesRestoredTotal.WithLabelValues(target)) >= 1
+	})
+
+	if discovers, _ := srv.counts(); discovers != 0 {
+		t.Errorf("expected the restored lease to be renewed without a discover, got %d", discovers)
+	}
+}
+
+func TestRunClientCountsDistinctServers(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.94"
+	reacquire := make(chan struct{}, 1)
+	logs := &logBuffer{}
+	startTestClientWithLogger(t, logs.logger(), testClientConfig(iface), targetConfig{addr: target, reacquire: reacquire})
+
+	servers := testMetrics.dhcpDistinctServersSeen.WithLabelValues(target)
+	waitFor(t, 10*time.Second, "first server to be seen", func() bool {
+		return metricValue(t, servers) == 1
+	})
+
+	// The same server answering again isn't counted twice, while another
+	// one answering the new DISCOVER is.
+	srv.setServerIP(net.IPv4(127, 0, 0, 2))
+	reacquire <- struct{}{}
+	waitFor(t, 10*time.Second, "second server to be seen", func() bool {
+		return metricValue(t, servers) == 2
+	})
+
+	for _, server := range []string{"127.0.0.1", "127.0.0.2"} {
+		want := `msg="New server answered target" target=` + target + " server=" + server
+		if n := strings.Count(logs.String(), want); n != 1 {
+			t.Errorf("expected server %s to be logged once as new, got %d", server, n)
+		}
+	}
+}
+
+func TestServerSetCaps(t *testing.T) {
+	var s serverSet
+	for i := 0; i < maxTrackedServers; i++ {
+		if isNew, full := s.add(net.IPv4(10, 0, byte(i>>8), byte(i))); !isNew || full {
+			t.Fatalf("expected server %d to be recorded, got new %v full %v", i, isNew, full)
+		}
+	}
+
+	if isNew, _ := s.add(net.IPv4(10, 0, 0, 0)); isNew {
+		t.Error("expected a known server not to be new")
+	}
+	// Only the first server past the cap reports it, so that it is logged
+	// once.
+	if isNew, full := s.add(net.IPv4(10, 1, 0, 0)); isNew || !full {
+		t.Errorf("expected the set to be full, got new %v full %v", isNew, full)
+	}
+	if isNew, full := s.add(net.IPv4(10, 1, 0, 1)); isNew || full {
+		t.Errorf("expected the set to stay full quietly, got new %v full %v", isNew, full)
+	}
+	if n := s.len(); n != maxTrackedServers {
+		t.Errorf("expected %d servers, got %d", maxTrackedServers, n)
+	}
+}
+
+func TestRunClientMarksDSCP(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.95"
+	cfg := testClientConfig(iface)
+	// Expedited forwarding, the top six bits of the TOS byte.
+	cfg.dscp = 46
+	startTestClient(t, cfg, targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	if tos := srv.lastTOS(); tos != 0xb8 {
+		t.Errorf("expected a TOS byte of 0xb8, got %#x", tos)
+	}
+}
+
+func TestRunClientLogsDORA(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setLeaseTime(2 * time.Second)
+
+	doraLines := func(logs *logBuffer) []string {
+		var lines []string
+		for _, line := range strings.Split(logs.String(), "\n") {
+			if strings.Contains(line, `msg="DORA sequence"`) {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+
+	target := "10.100.0.97"
+	cfg := testClientConfig(iface)
+	cfg.logDORA = true
+	logs := &logBuffer{}
+	startTestClientWithLogger(t, logs.logger(), cfg, targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "lease to be renewed", func() bool {
+		return metricValue(t, testMetrics.dhcpRenewalStreak.WithLabelValues(target)) >= 1
+	})
+
+	lines := doraLines(logs)
+	if len(lines) < 2 {
+		t.Fatalf("expected a summary of the acquisition and the renewal, got %q", lines)
+	}
+	for _, want := range []string{
+		"discover_sent=", "offer_received=", "offer_server=127.0.0.1", "offered_addr=" + target,
+		"request_sent=", "ack_received=", "ack_server=127.0.0.1", "granted_addr=" + target, "lease_time=2s",
+	} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("expected %s in the summary of the acquisition, got %q", want, lines[0])
+		}
+	}
+	// A renewal is only a REQUEST and its ACK.
+	if strings.Contains(lines[1], "discover_sent=") || !strings.Contains(lines[1], "ack_received=") {
+		t.Errorf("expected the summary of the renewal to leave out the DISCOVER, got %q", lines[1])
+	}
+
+	quiet := "10.100.0.98"
+	logs = &logBuffer{}
+	startTestClientWithLogger(t, logs.logger(), testClientConfig(iface), targetConfig{addr: quiet})
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(quiet)) >= 1
+	})
+	if lines := doraLines(logs); len(lines) != 0 {
+		t.Errorf("expected no summary without LOG_DORA, got %q", lines)
+	}
+}
+
+func TestRunClientRetransmits(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setSilent(true)
+
+	target := "10.100.0.12"
+	cfg := testClientConfig(iface)
+	cfg.retransmits = 2
+	cfg.retransmitTimeout = 200 * time.Millisecond
+	startTestClient(t, cfg, targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "timeout to be counted", func() bool {
+		return metricValue(t, testMetrics.dhcpFailuresTotal.WithLabelValues(target, failureTimeout)) >= 1
+	})
+
+	if discovers, _ := srv.counts(); discovers < 3 {
+		t.Errorf("expected the discover to be sent 3 times before timing out, got %d", discovers)
+	}
+}
+
+func TestRunClientReacquires(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.13"
+	reacquire := make(chan struct{}, 1)
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target, reacquire: reacquire})
+
+	acquired := testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, acquired) >= 1
+	})
+
+	reacquire <- struct{}{}
+
+	waitFor(t, 10*time.Second, "lease to be re-acquired", func() bool {
+		return metricValue(t, acquired) >= 2
+	})
+
+	if v := metricValue(t, testMetrics.dhcpManualReacquiresTotal.WithLabelValues(target)); v != 1 {
+		t.Errorf("expected one manual re-acquisition, got %v", v)
+	}
+}
+
+func TestRunClientExposesNetworkConfig(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.14"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	if v := metricValue(t, testMetrics.dhcpLeaseDNSServers.WithLabelValues(target)); v != 2 {
+		t.Errorf("expected 2 dns servers, got %v", v)
+	}
+	if v := metricValue(t, testMetrics.dhcpLeaseInterfaceMTU.WithLabelValues(target)); v != 1400 {
+		t.Errorf("expected mtu 1400, got %v", v)
+	}
+	// The options of the fake server's ACK, each with its code and length.
+	if v := metricValue(t, testMetrics.dhcpLeaseOptionBytes.WithLabelValues(target)); v != 65 {
+		t.Errorf("expected 65 option bytes, got %v", v)
+	}
+	for _, server := range []string{"10.0.0.53", "10.0.1.53"} {
+		if !hasSeries(t, testMetrics.dhcpLeaseDNSServerInfo, map[string]string{"ip": target, "server": server}) {
+			t.Errorf("expected dns server %s to be exposed", server)
+		}
+	}
+	for _, server := range []string{"10.0.0.123", "10.0.1.123"} {
+		if !hasSeries(t, testMetrics.dhcpLeaseNTPServerInfo, map[string]string{"ip": target, "server": server}) {
+			t.Errorf("expected ntp server %s to be exposed", server)
+		}
+	}
+}
+
+func TestRunClientRecordsRenewalInterval(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setLeaseTime(2 * time.Second)
+
+	target := "10.100.0.17"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	interval := testMetrics.dhcpRenewalIntervalSeconds.WithLabelValues(target).(prometheus.Metric)
+	waitFor(t, 10*time.Second, "renewal to be bound", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 2
+	})
+
+	if v := metricValue(t, interval); v < 1 {
+		t.Errorf("expected the interval between binds to be recorded, got %v observations", v)
+	}
+}
+
+func TestRunClientUsesSeededXID(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.18"
+	cfg := testClientConfig(iface)
+	seed := uint32(0x1000)
+	cfg.xidSeed = &seed
+	startTestClient(t, cfg, targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	lease, ok := cfg.leases.snapshot()[target]
+	if !ok {
+		t.Fatal("expected the lease to be registered")
+	}
+	if want := seed + 0x0a640012; lease.XID != want {
+		t.Errorf("expected xid %#08x, got %#08x", want, lease.XID)
+	}
+}
+
+func TestRunClientRequestsConfiguredParams(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.19"
+	params := []layers.DHCPOpt{layers.DHCPOptRouter, layers.DHCPOptSubnetMask}
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target, params: params})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	if got := srv.lastParams(); !bytes.Equal(got, []byte{3, 1}) {
+		t.Errorf("expected params [3 1] in order, got %v", got)
+	}
+	if !hasSeries(t, testMetrics.dhcpLeaseNetmaskInfo, map[string]string{"ip": target, "netmask": "255.0.0.0"}) {
+		t.Error("expected the netmask to be exposed")
+	}
+	if !hasSeries(t, testMetrics.dhcpLeaseRouterInfo, map[string]string{"ip": target, "router": "127.0.0.1"}) {
+		t.Error("expected the router to be exposed")
+	}
+}
+
+func TestRunClientReleasesAfterHoldTime(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.20"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target, holdTime: 200 * time.Millisecond})
+
+	waitFor(t, 10*time.Second, "two churn cycles", func() bool {
+		return metricValue(t, testMetrics.dhcpChurnCyclesTotal.WithLabelValues(target)) >= 2
+	})
+
+	if n := srv.releaseCount(); n < 2 {
+		t.Errorf("expected a release per cycle, got %d", n)
+	}
+	if v := metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)); v < 2 {
+		t.Errorf("expected the lease to be requested again after each release, got %v acquired", v)
+	}
+}
+
+func TestRunClientCoolsDownAfterRelease(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.53"
+	start := time.Now()
+	startTestClient(t, testClientConfig(iface), targetConfig{
+		addr: target, holdTime: 200 * time.Millisecond, releaseCooldown: 500 * time.Millisecond,
+	})
+
+	waitFor(t, 10*time.Second, "released address to be bound again", func() bool {
+		return metricValue(t, testMetrics.dhcpChurnReacquiresTotal.WithLabelValues(target, churnSameAddress)) >= 1
+	})
+
+	if elapsed := time.Since(start); elapsed < 700*time.Millisecond {
+		t.Errorf("expected the cooldown to delay the next request, got a lease again after %s", elapsed)
+	}
+	if v := metricValue(t, testMetrics.dhcpChurnReacquiresTotal.WithLabelValues(target, churnNewAddress)); v != 0 {
+		t.Errorf("expected no new address, got %v", v)
+	}
+}
+
+func TestRunClientCountsFilteredPackets(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setDecoys(true)
+
+	target := "10.100.0.21"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	for _, reason := range filterReasons {
+		if v := metricValue(t, testMetrics.dhcpPacketsFilteredTotal.WithLabelValues(target, string(reason))); v < 1 {
+			t.Errorf("expected %s packets to be counted, got %v", reason, v)
+		}
+	}
+	for _, reason := range dhclient.MalformedReasons {
+		if v := metricValue(t, testMetrics.dhcpMalformedRepliesTotal.WithLabelValues(target, string(reason))); v < 1 {
+			t.Errorf("expected %s replies to be counted as malformed, got %v", reason, v)
+		}
+	}
+}
+
+func TestRunClientSendsNoDefaultParams(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	cfg := testClientConfig(iface)
+	cfg.noDefaultParams = true
+	target := "10.100.0.87"
+	startTestClient(t, cfg, targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	if discovers, _ := srv.counts(); discovers == 0 {
+		t.Fatal("expected a DISCOVER to be sent")
+	}
+	if params := srv.lastParams(); params != nil {
+		t.Errorf("expected no option 55 to be sent, got %v", params)
+	}
+}
+
+func TestRunClientIgnoresBOOTPRepliesToOthers(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setForeignBOOTP(true)
+
+	target := "10.100.0.86"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	if v := metricValue(t, testMetrics.dhcpPacketsFilteredTotal.WithLabelValues(target, string(dhclient.FilterWrongXID))); v < 1 {
+		t.Errorf("expected the BOOTP reply to be filtered by its transaction ID, got %v", v)
+	}
+	if v := metricValue(t, testMetrics.dhcpMalformedRepliesTotal.WithLabelValues(target, string(dhclient.FilterBadMessageType))); v != 0 {
+		t.Errorf("expected a BOOTP reply to another host not to be counted as malformed, got %v", v)
+	}
+}
+
+func TestRunClientSquatsOnTargetAddress(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.22"
+	cfg := testClientConfig(iface)
+	cfg.squatMaxNAKs = 3
+	startTestClient(t, cfg, targetConfig{addr: target})
+
+	waitFor(t, 5*time.Second, "target address to be won", func() bool {
+		return metricValue(t, testMetrics.dhcpSquatOutcomesTotal.WithLabelValues(target, squatWon)) == 1
+	})
+
+	if discovers, _ := srv.counts(); discovers != 0 {
+		t.Errorf("expected the address to be requested without discovery, got %d discovers", discovers)
+	}
+}
+
+func TestRunClientGivesUpSquatting(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setNak(true)
+
+	target := "10.100.0.23"
+	cfg := testClientConfig(iface)
+	cfg.squatMaxNAKs = 3
+	startTestClient(t, cfg, targetConfig{addr: target})
+
+	waitFor(t, 5*time.Second, "squatting to be given up", func() bool {
+		return metricValue(t, testMetrics.dhcpSquatOutcomesTotal.WithLabelValues(target, squatGaveUp)) == 1
+	})
+
+	if v := metricValue(t, testMetrics.dhcpSquatNAKs.WithLabelValues(target)); v != 3 {
+		t.Errorf("expected 3 NAKs before giving up, got %v", v)
+	}
+	if _, requests := srv.counts(); requests < 3 {
+		t.Errorf("expected a request per NAK, got %d", requests)
+	}
+	if v := metricValue(t, testMetrics.dhcpLostLeasesTotal.WithLabelValues(target)); v != 0 {
+		t.Errorf("expected NAKs of the target address not to count as lost leases, got %v", v)
+	}
+}
+
+func TestWaitTimeout(t *testing.T) {
+	if !waitTimeout(context.Background(), func() {}) {
+		t.Error("expected a returning wait to finish")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	block := make(chan struct{})
+	defer close(block)
+	if waitTimeout(ctx, func() { <-block }) {
+		t.Error("expected a blocked wait to time out")
+	}
+}
+
+func TestRunClientCountsOutOfSubnetLeases(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setOfferAddr(net.ParseIP("10.200.9.1"))
+
+	target := "10.100.0.24"
+	_, subnet, _ := net.ParseCIDR("10.100.0.0/24")
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target, subnet: subnet})
+
+	waitFor(t, 10*time.Second, "out of subnet lease to be counted", func() bool {
+		return metricValue(t, testMetrics.dhcpOutOfSubnetOffersTotal.WithLabelValues(target)) == 1
+	})
+}
+
+func TestRunClientPingsGateway(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.25"
+	cfg := testClientConfig(iface)
+	cfg.pingGateway = true
+	cfg.gatewayPingTimeout = time.Second
+	startTestClient(t, cfg, targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "gateway to be reachable", func() bool {
+		return hasSeries(t, testMetrics.dhcpGatewayReachable, map[string]string{"ip": target}) &&
+			metricValue(t, testMetrics.dhcpGatewayReachable.WithLabelValues(target)) == 1
+	})
+}
+
+func TestRunClientCountsChangedOptionSets(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setLeaseTime(2 * time.Second)
+
+	target := "10.100.0.26"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+	srv.setOmitDNS(true)
+
+	waitFor(t, 10*time.Second, "changed option set to be counted", func() bool {
+		return metricValue(t, testMetrics.dhcpOptionSetChangedTotal.WithLabelValues(target)) == 1
+	})
+}
+
+func TestRunClientFallsBackToPreferredAddresses(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.27"
+	fallbacks := []net.IP{net.ParseIP("10.100.0.127").To4(), net.ParseIP("10.100.0.128").To4()}
+	srv.setNakAddrs(net.ParseIP(target), fallbacks[0])
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target, fallbackAddrs: fallbacks})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	if v := metricValue(t, testMetrics.dhcpPreferenceIndex.WithLabelValues(target)); v != 2 {
+		t.Errorf("expected the second fallback to be bound, got preference %v", v)
+	}
+	if discovers, _ := srv.counts(); discovers != 0 {
+		t.Errorf("expected preferred addresses to be requested without discovery, got %d discovers", discovers)
+	}
+	if !hasSeries(t, testMetrics.dhcpLeaseAddressInfo, map[string]string{"ip": target, "bound": "10.100.0.128"}) {
+		t.Error("expected the bound fallback address to be exposed")
+	}
+}
+
+func TestRunClientSendsConfiguredSecs(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.29"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target, secs: 30, secsElapsed: true})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	// The exchange takes well under a second on loopback.
+	if secs := srv.lastSecs(); secs != 30 {
+		t.Errorf("expected secs to be 30, got %d", secs)
+	}
+}
+
+func TestRunClientCountsStableLeases(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.30"
+	cfg := testClientConfig(iface)
+	cfg.stableGrace = 300 * time.Millisecond
+	startTestClient(t, cfg, targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+	if v := metricValue(t, testMetrics.dhcpStableLeasesTotal.WithLabelValues(target)); v != 0 {
+		t.Errorf("expected the lease not to be stable straight away, got %v", v)
+	}
+
+	waitFor(t, 5*time.Second, "lease to be counted as stable", func() bool {
+		return metricValue(t, testMetrics.dhcpStableLeasesTotal.WithLabelValues(target)) == 1
+	})
+}
+
+func TestRunClientCountsSLOBreaches(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.31"
+	cfg := testClientConfig(iface)
+	cfg.acquireSLO = time.Hour
+	startTestClient(t, cfg, targetConfig{addr: target, acquireSLO: time.Nanosecond})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	if v := metricValue(t, testMetrics.dhcpSLOBreachesTotal.WithLabelValues(target)); v != 1 {
+		t.Errorf("expected the target's own SLO to be breached once, got %v", v)
+	}
+}
+
+func TestRunClientSendsConfiguredGiaddr(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.32"
+	giaddr := net.ParseIP("10.100.0.254").To4()
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target, giaddr: giaddr})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	if got := srv.lastGiaddr(); !got.Equal(giaddr) {
+		t.Errorf("expected giaddr %s, got %s", giaddr, got)
+	}
+}
+
+func TestRunClientSendsPacketQuirks(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.33"
+	quirks := &dhclient.Quirks{PadOptions: 4, TrailingPadding: 60, OmitEnd: true}
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target, quirks: quirks})
+
+	// The fake server is lenient, so the quirky messages still get a lease.
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+}
+
+func TestRunClientExportsBootSettings(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setBoot(true)
+
+	target := "10.100.0.34"
+	cfg := testClientConfig(iface)
+	cfg.requestBootOptions = true
+	startTestClient(t, cfg, targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "boot settings to be exported", func() bool {
+		return hasSeries(t, testMetrics.dhcpLeaseBootInfo, map[string]string{
+			"ip": target, "next_server": "127.0.0.1", "tftp_server": "127.0.0.1", "bootfile": "pxelinux.0",
+		})
+	})
+
+	params := srv.lastParams()
+	if !bytes.Contains(params, []byte{66, 67}) {
+		t.Errorf("expected the boot options to be requested, got %v", params)
+	}
+}
+
+func TestRunClientScalesRenewals(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	cfg := testClientConfig(iface)
+	cfg.renewScaling = &renewScaling{factor: 1, reference: 90 * time.Minute}
+	target := "10.100.0.65"
+	startTestClient(t, cfg, targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "lease to be bound", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) == 1
+	})
+
+	// The hour long lease is renewed a third of the way through rather than
+	// at its T1 halfway through.
+	renew := time.Unix(int64(metricValue(t, testMetrics.dhcpLeaseRenewTimestampSeconds.WithLabelValues(target))), 0)
+	if until := time.Until(renew); until < 19*time.Minute || until > 21*time.Minute {
+		t.Errorf("expected the renewal to be scheduled in 20m, got %s", until)
+	}
+	if v := metricValue(t, testMetrics.dhcpLeaseT1Seconds.WithLabelValues(target)); v != 1800 {
+		t.Errorf("expected the granted T1 to still be exported, got %v", v)
+	}
+}
+
+func TestRunClientCountsBytes(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setPadding(1000)
+
+	target := "10.100.0.66"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	// A DISCOVER and a REQUEST, each at least the headers and the fixed
+	// part of a DHCP message, answered by an OFFER and an ACK carrying the
+	// padding.
+	const minFrame = 14 + 20 + 8 + 240
+	if v := metricValue(t, testMetrics.dhcpBytesSentTotal.WithLabelValues(target)); v < 2*minFrame {
+		t.Errorf("expected at least %d bytes sent, got %v", 2*minFrame, v)
+	}
+	if v := metricValue(t, testMetrics.dhcpBytesReceivedTotal.WithLabelValues(target)); v < 2*(minFrame+1000) {
+		t.Errorf("expected at least %d bytes received, got %v", 2*(minFrame+1000), v)
+	}
+}
+
+func TestRunClientFollowsSchedule(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	// A window two days from now, which can't include the present.
+	later := (time.Now().Weekday() + 2) % 7
+	closed := "10.100.0.67"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: closed, schedule: schedule{{days: 1 << later, start: 0, end: time.Minute}}})
+	open := "10.100.0.68"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: open, schedule: schedule{{days: 0x7f, start: 0, end: 24 * time.Hour}}})
+
+	waitFor(t, 10*time.Second, "target in its window to bind", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(open)) == 1
+	})
+	if v := metricValue(t, testMetrics.dhcpTargetInSchedule.WithLabelValues(open)); v != 1 {
+		t.Errorf("expected the target to be in its window, got %v", v)
+	}
+	if v := metricValue(t, testMetrics.dhcpTargetInSchedule.WithLabelValues(closed)); v != 0 {
+		t.Errorf("expected the target to be outside of its window, got %v", v)
+	}
+	if v := metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(closed)); v != 0 {
+		t.Errorf("expected the target outside of its window not to bind, got %v", v)
+	}
+	if discovers, _ := srv.counts(); discovers != 1 {
+		t.Errorf("expected a single DISCOVER, got %d", discovers)
+	}
+}
+
+func TestRunClientMeasuresResponseJitter(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.69"
+	cfg := testClientConfig(iface)
+	cfg.responseJitterWindow = 4
+	startTestClient(t, cfg, targetConfig{addr: target})
+
+	// The OFFER and the ACK are two responses to deviate.
+	waitFor(t, 10*time.Second, "response jitter to be exported", func() bool {
+		return hasSeries(t, testMetrics.dhcpResponseJitterSeconds, map[string]string{"ip": target})
+	})
+	if v := metricValue(t, testMetrics.dhcpResponseJitterSeconds.WithLabelValues(target)); v < 0 || v > 1 {
+		t.Errorf("expected the jitter of a local server to be small, got %v", v)
+	}
+}
+
+func TestRunClientDiscoversAfreshAfterNAK(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	target := "10.100.0.70"
+	srv.setNakAddrs(net.ParseIP(target))
+
+	cfg := testClientConfig(iface)
+	noDelay := time.Duration(0)
+	cfg.nakRetryDelay = &noDelay
+	startTestClient(t, cfg, targetConfig{addr: target})
+
+	// Without the usual second between attempts, several NAKs come quickly.
+	waitFor(t, 5*time.Second, "fresh discoveries after NAKs", func() bool {
+		return metricValue(t, testMetrics.dhcpNAKToDiscoverTransitionsTotal.WithLabelValues(target)) >= 3
+	})
+
+	// Every REQUEST follows a DISCOVER of its own, none repeating the one
+	// that was refused.
+	if discovers, requests := srv.counts(); requests > discovers {
+		t.Errorf("expected no more REQUESTs than DISCOVERs, got %d and %d", requests, discovers)
+	}
+}
+
+func TestRunClientDeclinesOfferInUse(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	inUse := "10.100.0.71"
+	defendAddress(t, iface, net.HardwareAddr{0x02, 0, 0, 0, 0, 0x71}, net.ParseIP(inUse))
+	cfg := testClientConfig(iface)
+	cfg.arpDefendTimeout = 200 * time.Millisecond
+	startTestClient(t, cfg, targetConfig{addr: inUse, offerProbe: offerProbeARP})
+
+	waitFor(t, 10*time.Second, "offer of the address in use to be declined", func() bool {
+		return metricValue(t, testMetrics.dhcpOfferProbesTotal.WithLabelValues(inUse, probeInUse)) >= 1 && srv.declineCount() >= 1
+	})
+	if v := metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(inUse)); v != 0 {
+		t.Errorf("expected the address in use not to be bound, got %v", v)
+	}
+	if v := metricValue(t, testMetrics.dhcpFailuresTotal.WithLabelValues(inUse, failureRejected)); v < 1 {
+		t.Errorf("expected the attempt to fail as rejected, got %v", v)
+	}
+
+	free := "10.100.0.72"
+	startTestClient(t, cfg, targetConfig{addr: free, offerProbe: offerProbeARP})
+	waitFor(t, 10*time.Second, "free address to be bound", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(free)) == 1
+	})
+	if v := metricValue(t, testMetrics.dhcpOfferProbesTotal.WithLabelValues(free, probeFree)); v != 1 {
+		t.Errorf("expected one free probe, got %v", v)
+	}
+}
+
+func TestRunClientReleasesOnExit(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	for _, tc := range []struct {
+		target  string
+		release bool
+	}{
+		{"10.100.0.73", true},
+		{"10.100.0.74", false},
+	} {
+		cfg := testClientConfig(iface)
+		cfg.releaseOnExit = tc.release
+		before := srv.releaseCount()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		go runClient(ctx, wg, testLogger(t), cfg, targetConfig{addr: tc.target})
+
+		waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+			return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(tc.target)) >= 1
+		})
+		cancel()
+		wg.Wait()
+
+		if tc.release {
+			waitFor(t, 5*time.Second, "lease to be released", func() bool {
+				return srv.releaseCount() > before
+			})
+			continue
+		}
+		// Give a release that shouldn't have been sent time to arrive.
+		time.Sleep(200 * time.Millisecond)
+		if n := srv.releaseCount() - before; n != 0 {
+			t.Errorf("expected no release with RELEASE_ON_EXIT off, got %d", n)
+		}
+	}
+}
+
+func TestRunClientReleasesWhilePaced(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	// Every client is stopped before its lease is released, which mustn't
+	// stop the release from waiting for its turn with MAX_PPS set.
+	pacer := newSendPacer(1)
+	start := func(releaseOnExit bool, targets ...targetConfig) (stop func()) {
+		cfg := testClientConfig(iface)
+		cfg.pacer = pacer
+		cfg.releaseOnExit = releaseOnExit
+
+		ctx, cancel := context.WithCancel(context.Background())
+		wg := &sync.WaitGroup{}
+		for _, target := range targets {
+			wg.Add(1)
+			go runClient(ctx, wg, testLogger(t), cfg, target)
+		}
+		stop = func() {
+			cancel()
+			wg.Wait()
+		}
+		t.Cleanup(stop)
+		return stop
+	}
+
+	churned := "10.100.0.81"
+	stop := start(false, targetConfig{addr: churned, holdTime: 200 * time.Millisecond})
+	waitFor(t, 20*time.Second, "the lease to be released after its hold time", func() bool {
+		return srv.releaseCount() >= 1
+	})
+	stop()
+
+	exiting := []string{"10.100.0.82", "10.100.0.83"}
+	stop = start(true, targetConfig{addr: exiting[0]}, targetConfig{addr: exiting[1]})
+	for _, target := range exiting {
+		waitFor(t, 20*time.Second, "lease to be acquired", func() bool {
+			return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+		})
+	}
+	before := srv.releaseCount()
+	stop()
+
+	waitFor(t, 5*time.Second, "every lease to be released on exit", func() bool {
+		return srv.releaseCount()-before >= len(exiting)
+	})
+}
+
+func TestRunClientReleasesOnlyHeldLeasesOnExit(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	run := func(cfg *clientConfig, target targetConfig, ready func() bool) {
+		t.Helper()
+		cfg.releaseOnExit = true
+		before := srv.releaseCount()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		go runClient(ctx, wg, testLogger(t), cfg, target)
+
+		waitFor(t, 10*time.Second, "client to get going", ready)
+		cancel()
+		wg.Wait()
+
+		// Give a release that shouldn't have been sent time to arrive.
+		time.Sleep(200 * time.Millisecond)
+		if n := srv.releaseCount() - before; n != 0 {
+			t.Errorf("%s: expected no release, got %d", target.addr, n)
+		}
+	}
+
+	// Shutting down while the target address is still being requested
+	// leaves nothing to release.
+	srv.setSilent(true)
+	squat := testClientConfig(iface)
+	squat.squatMaxNAKs = 3
+	run(squat, targetConfig{addr: "10.100.0.89"}, func() bool {
+		_, requests := srv.counts()
+		return requests >= 1
+	})
+	_, before := srv.counts()
+	reboot := testClientConfig(iface)
+	reboot.initReboot = true
+	run(reboot, targetConfig{addr: "10.100.0.90"}, func() bool {
+		_, requests := srv.counts()
+		return requests > before
+	})
+	srv.setSilent(false)
+
+	// A lease imported from the host is left to it, even once renewed.
+	target := "10.100.0.91"
+	imported := &dhclient.Lease{
+		FixedAddress: net.ParseIP(target).To4(),
+		ServerID:     net.ParseIP("127.0.0.1").To4(),
+		Bound:        time.Now(),
+		Expire:       time.Now().Add(time.Hour),
+	}
+	run(testClientConfig(iface), targetConfig{addr: target, restoredLease: imported, leaseImported: true}, func() bool {
+		return metricValue(t, testMetrics.dhcpLeasesRestoredTotal.WithLabelValues(target)) >= 1
+	})
+}
+
+func TestRunClientPresentsTargetMAC(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.78"
+	mac, _ := net.ParseMAC("02:00:00:00:01:78")
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target, hwAddr: mac})
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+	if got := srv.lastChaddr(); got.String() != mac.String() {
+		t.Errorf("expected the target's MAC address %s to be sent, got %s", mac, got)
+	}
+}
@@ -0,0 +1,120 @@
+// Command greedy-dhcp holds DHCP leases for the targets configured in its
+// environment, exporting metrics about them.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	greedydhcp "github.com/learnitall/greedy-dhcp"
+)
+
+// Exit codes, so that scripts can tell failures apart.
+const (
+	// exitOK is a clean shutdown, such as on a signal.
+	exitOK = 0
+	// exitFailure is any failure without a code of its own, such as FAIL_FAST
+	// giving up, a failed --check, the metrics server failing while running
+	// or the clients not stopping in time.
+	exitFailure = 1
+	// exitConfig is an invalid setting or target configuration.
+	exitConfig = 2
+	// exitNoRawSocket is no target being allowed to open a raw socket.
+	exitNoRawSocket = 3
+	// exitNoInterface is no interface to bind to being found.
+	exitNoInterface = 4
+	// exitMetricsBind is the metrics server being unable to listen.
+	exitMetricsBind = 5
+	// exitHardFailed is every target being hard failed.
+	exitHardFailed = 6
+	// exitExpectationFailed is a target failing its expectation, in a run
+	// that otherwise stopped cleanly.
+	exitExpectationFailed = 7
+)
+
+// exitCode returns the exit code for err, as returned by
+// greedydhcp.ConfigFromEnv or greedydhcp.Run.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, greedydhcp.ErrInvalidConfig):
+		return exitConfig
+	case errors.Is(err, greedydhcp.ErrNoRawSocket):
+		return exitNoRawSocket
+	case errors.Is(err, greedydhcp.ErrNoInterface):
+		return exitNoInterface
+	case errors.Is(err, greedydhcp.ErrMetricsListen):
+		return exitMetricsBind
+	case errors.Is(err, greedydhcp.ErrHardFailed):
+		return exitHardFailed
+	case errors.Is(err, greedydhcp.ErrExpectationFailed):
+		return exitExpectationFailed
+	default:
+		return exitFailure
+	}
+}
+
+func main() {
+	check := flag.Bool("check", false, "validate the configuration and that the raw socket can be opened, then exit")
+	flag.Parse()
+
+	cfg, err := greedydhcp.ConfigFromEnv()
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	if err != nil {
+		logger.Error("Unable to read configuration", "err", err)
+		os.Exit(exitCode(err))
+	}
+
+	if *check {
+		if !greedydhcp.Check(os.Stdout, cfg) {
+			os.Exit(exitFailure)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	reload := make(chan struct{}, 1)
+	dump := make(chan struct{}, 1)
+	cfg.Reload, cfg.DumpState = reload, dump
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGUSR1)
+	go func() {
+		for sig := range sigs {
+			switch sig {
+			case syscall.SIGHUP:
+				logger.Info("Received SIGHUP, reloading targets")
+				notify(reload)
+			case syscall.SIGUSR1:
+				notify(dump)
+			default:
+				logger.Info("Received signal, exiting", "signal", sig)
+				cancel()
+			}
+		}
+	}()
+
+	if err := greedydhcp.Run(ctx, cfg); err != nil {
+		logger.Error("Exiting", "err", err)
+		os.Exit(exitCode(err))
+	}
+}
+
+// notify sends on c unless a send is already pending.
+func notify(c chan<- struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	greedydhcp "github.com/learnitall/greedy-dhcp"
+)
+
+func TestExitCode(t *testing.T) {
+	for err, want := range map[error]int{
+		nil: exitOK,
+		fmt.Errorf("%w: bad", greedydhcp.ErrInvalidConfig):                   exitConfig,
+		fmt.Errorf("%w: none", greedydhcp.ErrNoInterface):                    exitNoInterface,
+		greedydhcp.ErrNoRawSocket:                                            exitNoRawSocket,
+		fmt.Errorf("%w: busy", greedydhcp.ErrMetricsListen):                  exitMetricsBind,
+		greedydhcp.ErrHardFailed:                                             exitHardFailed,
+		greedydhcp.ErrExpectationFailed:                                      exitExpectationFailed,
+		greedydhcp.ErrNoLease:                                                exitFailure,
+		greedydhcp.ErrShutdownTimeout:                                        exitFailure,
+		errors.Join(greedydhcp.ErrHardFailed, greedydhcp.ErrShutdownTimeout): exitHardFailed,
+		errors.New("metrics server failed"):                                  exitFailure,
+	} {
+		if got := exitCode(err); got != want {
+			t.Errorf("expected exit code %d for %v, got %d", want, err, got)
+		}
+	}
+}
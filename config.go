@@ -0,0 +1,1192 @@
+package greedydhcp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+)
+
+// splitEscaped splits s on every sep that isn't preceded by a backslash. The
+// escapes are left in place so that the pieces can be split further; use
+// unescape once a piece won't be split again.
+func splitEscaped(s string, sep byte) ([]string, error) {
+	var (
+		parts []string
+		start int
+	)
+
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i == len(s)-1 {
+				return nil, fmt.Errorf("trailing backslash in %q", s)
+			}
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:]), nil
+}
+
+// unescape removes the backslash escapes from s.
+func unescape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+// parseTargetMap parses a per-target mapping of the form
+// "ip=value,ip=value". A value may contain '=', and ',' or '\' can be
+// included by escaping them with a backslash, e.g. "10.0.0.5=a\,b".
+func parseTargetMap(s string) (map[string]string, error) {
+	result := map[string]string{}
+	if s == "" {
+		return result, nil
+	}
+
+	entries, err := splitEscaped(s, ',')
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		kv, err := splitEscaped(entry, '=')
+		if err != nil {
+			return nil, err
+		}
+
+		if len(kv) < 2 {
+			return nil, fmt.Errorf("entry %q is not of the form ip=value", entry)
+		}
+
+		key := strings.TrimSpace(unescape(kv[0]))
+		if key == "" {
+			return nil, fmt.Errorf("entry %q has an empty target", entry)
+		}
+
+		if _, ok := result[key]; ok {
+			return nil, fmt.Errorf("target %s is set more than once", key)
+		}
+
+		// Only the first '=' separates the target from its value.
+		result[key] = unescape(strings.Join(kv[1:], "="))
+	}
+
+	return result, nil
+}
+
+// checkTargetMapKeys returns an error if the mapping read from the
+// environment variable name refers to a target that isn't configured.
+func checkTargetMapKeys(name string, m map[string]string, targets []targetConfig) error {
+	known := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		known[target.addr] = true
+	}
+
+	for key := range m {
+		if !known[key] {
+			return fmt.Errorf("%s references unknown target %s", name, key)
+		}
+	}
+
+	return nil
+}
+
+// validateTargetAddr returns an error if addr can't be requested. Only IPv4
+// is supported for now, so IPv6 addresses are rejected explicitly rather than
+// producing a client that sends an empty requested address.
+func validateTargetAddr(addr string) error {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return fmt.Errorf("target %q is not a valid IP address", addr)
+	}
+
+	if ip.To4() == nil {
+		return fmt.Errorf("target %q is an IPv6 address, only IPv4 targets are supported", addr)
+	}
+
+	return nil
+}
+
+// parseTargetMAC parses s as the unicast Ethernet address a target's client
+// presents instead of the interface's.
+func parseTargetMAC(s string) (net.HardwareAddr, error) {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(mac) != 6 {
+		return nil, fmt.Errorf("%s is not an Ethernet address", s)
+	}
+
+	if mac[0]&1 != 0 {
+		return nil, fmt.Errorf("%s is a multicast address", s)
+	}
+
+	return mac, nil
+}
+
+// parseIPv4 parses s as an IPv4 address.
+func parseIPv4(s string) (net.IP, error) {
+	ip := net.ParseIP(s)
+	if ip == nil || ip.To4() == nil {
+		return nil, fmt.Errorf("%q is not a valid IPv4 address", s)
+	}
+
+	return ip.To4(), nil
+}
+
+// defaultMaxRangeHosts is the default of MAX_RANGE_HOSTS, the most host
+// addresses a block in TARGET_ADDRS may expand to.
+const defaultMaxRangeHosts = 1024
+
+// expandTargetAddrs replaces every entry of TARGET_ADDRS in CIDR notation,
+// such as 10.0.0.0/28, with the host addresses of the block, failing if there
+// are more than maxHosts of them. An address may only be listed once, whether
+// explicitly or in a block.
+func expandTargetAddrs(entries []string, maxHosts int) ([]string, error) {
+	addrs := make([]string, 0, len(entries))
+	seen := map[string]string{}
+	add := func(entry, addr string) error {
+		ip, _, _ := strings.Cut(addr, "@")
+		if prev, ok := seen[ip]; ok && ip != "" {
+			if prev == entry {
+				return fmt.Errorf("target %s is listed more than once", ip)
+			}
+			return fmt.Errorf("target %s is listed more than once, in %s and %s", ip, prev, entry)
+		}
+		seen[ip] = entry
+		addrs = append(addrs, addr)
+		return nil
+	}
+
+	for _, entry := range entries {
+		if entry == "" || !strings.Contains(entry, "/") {
+			if err := add(entry, entry); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		hosts, err := rangeHosts(entry, maxHosts)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			if err := add(entry, host); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return addrs, nil
+}
+
+// rangeHosts returns the host addresses of the IPv4 block s, leaving out its
+// network and broadcast addresses unless it is a /31 or /32, which have none.
+func rangeHosts(s string, maxHosts int) ([]string, error) {
+	if strings.Contains(s, "@") {
+		return nil, fmt.Errorf("range %s can't have a MAC address, as every target needs its own", s)
+	}
+
+	ip, block, err := net.ParseCIDR(s)
+	if err != nil || ip.To4() == nil {
+		return nil, fmt.Errorf("range %q is not a valid IPv4 block", s)
+	}
+
+	ones, _ := block.Mask.Size()
+	first := uint64(binary.BigEndian.Uint32(block.IP.To4()))
+	last := first + 1<<(32-ones) - 1
+	if ones <= 30 {
+		first++
+		last--
+	}
+	if n := last - first + 1; n > uint64(maxHosts) {
+		return nil, fmt.Errorf("range %s has %d host addresses, more than MAX_RANGE_HOSTS (%d)", s, n, maxHosts)
+	}
+
+	hosts := make([]string, 0, last-first+1)
+	for n := first; n <= last; n++ {
+		hosts = append(hosts, net.IPv4(byte(n>>24), byte(n>>16), byte(n>>8), byte(n)).String())
+	}
+
+	return hosts, nil
+}
+
+// parseSubnet parses the IPv4 subnet, given in CIDR notation, that the
+// address of target is expected to be handed out from.
+func parseSubnet(s string, target string) (*net.IPNet, error) {
+	ip, subnet, err := net.ParseCIDR(s)
+	if err != nil || ip.To4() == nil {
+		return nil, fmt.Errorf("%q is not a valid IPv4 subnet", s)
+	}
+
+	if !subnet.Contains(net.ParseIP(target)) {
+		return nil, fmt.Errorf("%s is not in %s", target, subnet)
+	}
+
+	return subnet, nil
+}
+
+// parseNetns checks that s is the absolute path of a network namespace, e.g.
+// /var/run/netns/blue.
+func parseNetns(s string) (string, error) {
+	if !filepath.IsAbs(s) {
+		return "", fmt.Errorf("%q is not an absolute path", s)
+	}
+
+	return filepath.Clean(s), nil
+}
+
+// maxQuirkPadding is the most pad options or trailing bytes a message may be
+// sent with, keeping it within a standard MTU.
+const maxQuirkPadding = 1000
+
+// parsePacketQuirks parses the quirks applied to sent messages, given as a
+// list of the form "pad=n;trailing=n;no_end": n pad options before the end
+// option, n zero bytes after it, and no end option at all.
+func parsePacketQuirks(s string) (*dhclient.Quirks, error) {
+	quirks := &dhclient.Quirks{}
+	for _, entry := range strings.Split(s, ";") {
+		name, value, hasValue := strings.Cut(strings.TrimSpace(entry), "=")
+		switch name {
+		case "no_end":
+			if hasValue {
+				return nil, errors.New("no_end doesn't take a value")
+			}
+			quirks.OmitEnd = true
+		case "pad", "trailing":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || n > maxQuirkPadding {
+				return nil, fmt.Errorf("%s must be a number between 0 and %d, got %q", name, maxQuirkPadding, value)
+			}
+			if name == "pad" {
+				quirks.PadOptions = n
+			} else {
+				quirks.TrailingPadding = n
+			}
+		default:
+			return nil, fmt.Errorf("unknown quirk %q, expected pad, trailing or no_end", entry)
+		}
+	}
+
+	return quirks, nil
+}
+
+// parseVLAN parses a VLAN ID, which must be between 1 and 4094.
+func parseVLAN(s string) (int, error) {
+	id, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || id < 1 || id > 4094 {
+		return 0, fmt.Errorf("VLAN ID %q must be a number between 1 and 4094", s)
+	}
+
+	return id, nil
+}
+
+// getTargets builds the config of every target in targetAddrs, applying the
+// per-target settings read from the environment.
+func getTargets(targetAddrs []string) ([]targetConfig, error) {
+	targets := make([]targetConfig, 0, len(targetAddrs))
+	for _, entry := range targetAddrs {
+		if entry == "" {
+			return nil, errors.New("got empty target address")
+		}
+
+		addr, mac, hasMAC := strings.Cut(entry, "@")
+		if err := validateTargetAddr(addr); err != nil {
+			return nil, err
+		}
+
+		target := targetConfig{addr: addr}
+		if hasMAC {
+			var err error
+			target.hwAddr, err = parseTargetMAC(mac)
+			if err != nil {
+				return nil, fmt.Errorf("invalid TARGET_ADDRS entry %q: %w", entry, err)
+			}
+		}
+
+		targets = append(targets, target)
+	}
+
+	if server := os.Getenv("DHCP_SERVER"); server != "" {
+		ip, err := parseIPv4(server)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DHCP_SERVER: %w", err)
+		}
+
+		for i := range targets {
+			targets[i].server = ip
+		}
+	}
+
+	servers, err := parseTargetMap(os.Getenv("TARGET_SERVER"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_SERVER: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_SERVER", servers, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		server, ok := servers[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].server, err = parseIPv4(server)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_SERVER for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	rawOptions, err := parseTargetMap(os.Getenv("TARGET_RAW_OPTIONS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_RAW_OPTIONS: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_RAW_OPTIONS", rawOptions, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		opts, ok := rawOptions[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].rawOptions, err = parseRawOptions(opts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_RAW_OPTIONS for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	holdTimes, err := parseTargetMap(os.Getenv("TARGET_HOLD_TIME"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_HOLD_TIME: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_HOLD_TIME", holdTimes, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		holdTime, ok := holdTimes[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].holdTime, err = parseHoldTime(holdTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_HOLD_TIME for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	releaseCooldowns, err := parseTargetMap(os.Getenv("TARGET_RELEASE_COOLDOWN"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_RELEASE_COOLDOWN: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_RELEASE_COOLDOWN", releaseCooldowns, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		cooldown, ok := releaseCooldowns[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].releaseCooldown, err = parseReleaseCooldown(cooldown)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_RELEASE_COOLDOWN for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	requestDelays, err := parseTargetMap(os.Getenv("TARGET_REQUEST_DELAY"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_REQUEST_DELAY: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_REQUEST_DELAY", requestDelays, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		requestDelay, ok := requestDelays[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].requestDelay, err = parseRequestDelay(requestDelay)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_REQUEST_DELAY for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	offerPolicies, err := parseTargetMap(os.Getenv("TARGET_OFFER_POLICY"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_OFFER_POLICY: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_OFFER_POLICY", offerPolicies, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		policy, ok := offerPolicies[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].offerPolicy, err = parseOfferPolicy(policy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_OFFER_POLICY for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	offerProbes, err := parseTargetMap(os.Getenv("TARGET_OFFER_PROBE"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_OFFER_PROBE: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_OFFER_PROBE", offerProbes, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		probe, ok := offerProbes[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].offerProbe, err = parseOfferProbe(probe)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_OFFER_PROBE for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	expectTimeouts, err := parseTargetMap(os.Getenv("TARGET_EXPECT_TIMEOUT"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_EXPECT_TIMEOUT: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_EXPECT_TIMEOUT", expectTimeouts, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		timeout, ok := expectTimeouts[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].expectTimeout, err = parseExpectDuration(timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_EXPECT_TIMEOUT for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	expectBounds, err := parseTargetMap(os.Getenv("TARGET_EXPECT_BOUND"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_EXPECT_BOUND: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_EXPECT_BOUND", expectBounds, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		boundFor, ok := expectBounds[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].expectBound, err = parseExpectDuration(boundFor)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_EXPECT_BOUND for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	schedules, err := parseTargetMap(os.Getenv("TARGET_SCHEDULE"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_SCHEDULE: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_SCHEDULE", schedules, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		sched, ok := schedules[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].schedule, err = parseSchedule(sched)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_SCHEDULE for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	subnets, err := parseTargetMap(os.Getenv("TARGET_SUBNET"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_SUBNET: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_SUBNET", subnets, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		subnet, ok := subnets[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].subnet, err = parseSubnet(subnet, targets[i].addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_SUBNET for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	secs, err := parseTargetMap(os.Getenv("TARGET_SECS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_SECS: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_SECS", secs, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		value, ok := secs[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].secs, targets[i].secsElapsed, err = parseSecs(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_SECS for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	giaddrs, err := parseTargetMap(os.Getenv("TARGET_GIADDR"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_GIADDR: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_GIADDR", giaddrs, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		giaddr, ok := giaddrs[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].giaddr, err = parseIPv4(giaddr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_GIADDR for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	levels, err := parseTargetMap(os.Getenv("TARGET_LOG_LEVEL"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_LOG_LEVEL: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_LOG_LEVEL", levels, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		value, ok := levels[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		level, err := parseLogLevel(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_LOG_LEVEL for %s: %w", targets[i].addr, err)
+		}
+		targets[i].logLevel = &level
+	}
+
+	fqdns, err := parseTargetMap(os.Getenv("TARGET_FQDN"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_FQDN: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_FQDN", fqdns, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		value, ok := fqdns[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].fqdn, err = parseFQDN(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_FQDN for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	hostnames, err := parseTargetMap(os.Getenv("TARGET_HOSTNAME"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_HOSTNAME: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_HOSTNAME", hostnames, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		value, ok := hostnames[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].hostname, err = parseHostname(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_HOSTNAME for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	clientIDs, err := parseTargetMap(os.Getenv("TARGET_CLIENT_ID"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_CLIENT_ID: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_CLIENT_ID", clientIDs, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		value, ok := clientIDs[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].clientID, err = parseClientID(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_CLIENT_ID for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	quirks, err := parseTargetMap(os.Getenv("TARGET_PACKET_QUIRKS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_PACKET_QUIRKS: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_PACKET_QUIRKS", quirks, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		value, ok := quirks[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].quirks, err = parsePacketQuirks(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_PACKET_QUIRKS for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	vlans, err := parseTargetMap(os.Getenv("TARGET_VLAN"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_VLAN: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_VLAN", vlans, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		value, ok := vlans[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].vlan, err = parseVLAN(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_VLAN for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	namespaces, err := parseTargetMap(os.Getenv("TARGET_NETNS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_NETNS: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_NETNS", namespaces, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		path, ok := namespaces[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].netns, err = parseNetns(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_NETNS for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	tags, err := parseTargetMap(os.Getenv("TARGET_TAGS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_TAGS: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_TAGS", tags, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		list, ok := tags[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].tags, err = parseTags(list)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_TAGS for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	priorities, err := parseTargetMap(os.Getenv("TARGET_PRIORITY"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_PRIORITY: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_PRIORITY", priorities, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		priority, ok := priorities[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].priority, err = strconv.Atoi(priority)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_PRIORITY for %s: %q is not an integer", targets[i].addr, priority)
+		}
+	}
+
+	dependencies, err := parseTargetMap(os.Getenv("TARGET_DEPENDS_ON"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_DEPENDS_ON: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_DEPENDS_ON", dependencies, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		list, ok := dependencies[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		targets[i].dependsOn, err = parseDependencies(strings.Split(list, ";"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_DEPENDS_ON for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	params, err := parseTargetMap(os.Getenv("TARGET_PARAMS"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid TARGET_PARAMS: %w", err)
+	}
+
+	if err := checkTargetMapKeys("TARGET_PARAMS", params, targets); err != nil {
+		return nil, err
+	}
+
+	for i := range targets {
+		list, ok := params[targets[i].addr]
+		if !ok {
+			continue
+		}
+
+		codes := strings.Split(list, ";")
+		if list == "" {
+			codes = nil
+		}
+
+		targets[i].params, err = parseParams(codes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_PARAMS for %s: %w", targets[i].addr, err)
+		}
+	}
+
+	return targets, nil
+}
+
+// parseDependencies parses the addresses of the targets a target depends on.
+func parseDependencies(addrs []string) ([]string, error) {
+	seen := map[string]bool{}
+	var deps []string
+	for _, addr := range addrs {
+		ip, err := parseIPv4(strings.TrimSpace(addr))
+		if err != nil {
+			return nil, err
+		}
+
+		if seen[ip.String()] {
+			return nil, fmt.Errorf("%s is listed more than once", ip)
+		}
+		seen[ip.String()] = true
+		deps = append(deps, ip.String())
+	}
+
+	return deps, nil
+}
+
+// checkNoDefaultParams checks that no target requests params of its own if
+// noDefaultParams is set, as a target's params would silently override it.
+func checkNoDefaultParams(targets []targetConfig, noDefaultParams bool) error {
+	if !noDefaultParams {
+		return nil
+	}
+
+	for _, target := range targets {
+		if target.params != nil {
+			return fmt.Errorf("target %s has params set, but NO_DEFAULT_PARAMS is set", target.addr)
+		}
+	}
+
+	return nil
+}
+
+// checkDependencies checks that every target depended on is configured and
+// enabled, and that no target depends on itself, even through others.
+func checkDependencies(targets []targetConfig) error {
+	byAddr := make(map[string]targetConfig, len(targets))
+	for _, target := range targets {
+		byAddr[target.addr] = target
+	}
+
+	for _, target := range targets {
+		for _, dep := range target.dependsOn {
+			other, ok := byAddr[dep]
+			switch {
+			case !ok:
+				return fmt.Errorf("target %s depends on %s, which isn't a target", target.addr, dep)
+			case other.disabled:
+				return fmt.Errorf("target %s depends on %s, which is disabled", target.addr, dep)
+			}
+		}
+	}
+
+	// Depth first, a target on the path being visited again closing a cycle.
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := map[string]int{}
+	var path []string
+	var visit func(addr string) error
+	visit = func(addr string) error {
+		switch state[addr] {
+		case visiting:
+			for i, p := range path {
+				if p == addr {
+					return fmt.Errorf("targets form a dependency cycle: %s", strings.Join(append(path[i:], addr), " -> "))
+				}
+			}
+		case visited:
+			return nil
+		}
+
+		state[addr] = visiting
+		path = append(path, addr)
+		for _, dep := range byAddr[addr].dependsOn {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[addr] = visited
+
+		return nil
+	}
+
+	for _, target := range targets {
+		if err := visit(target.addr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseSecs parses the value of the secs field: a number of seconds, "elapsed"
+// for the seconds spent trying, or both as "n+elapsed".
+func parseSecs(s string) (secs uint16, elapsed bool, err error) {
+	s, elapsed = strings.CutSuffix(strings.TrimSpace(s), "elapsed")
+	if elapsed {
+		s = strings.TrimSuffix(s, "+")
+		if s == "" {
+			return 0, true, nil
+		}
+	}
+
+	n, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, false, fmt.Errorf("secs %q must be a number between 0 and 65535, \"elapsed\" or \"n+elapsed\"", s)
+	}
+
+	return uint16(n), elapsed, nil
+}
+
+// parseHoldTime parses the time a lease is held before being released.
+func parseHoldTime(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("hold time must be positive, got %s", d)
+	}
+
+	return d, nil
+}
+
+// parseReleaseCooldown parses the time to wait after a release before
+// requesting a new lease.
+func parseReleaseCooldown(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if d < 0 {
+		return 0, fmt.Errorf("release cooldown must not be negative, got %s", d)
+	}
+
+	return d, nil
+}
+
+// maxRequestDelay is the longest a request may be delayed after an offer,
+// well past how long servers hold offers.
+const maxRequestDelay = 10 * time.Minute
+
+// parseRequestDelay parses the delay between an offer and requesting it.
+func parseRequestDelay(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if d <= 0 || d > maxRequestDelay {
+		return 0, fmt.Errorf("request delay must be positive and at most %s, got %s", maxRequestDelay, d)
+	}
+
+	return d, nil
+}
+
+// parseOfferPolicy parses the policy for offers of other addresses.
+func parseOfferPolicy(s string) (string, error) {
+	for _, policy := range offerPolicies {
+		if s == policy {
+			return s, nil
+		}
+	}
+
+	return "", fmt.Errorf("offer policy %q must be one of accept, accept_log or reject", s)
+}
+
+// parseOfferProbe parses how an offered address is checked to be free.
+func parseOfferProbe(s string) (string, error) {
+	for _, probe := range offerProbes {
+		if s == probe {
+			return s, nil
+		}
+	}
+
+	return "", fmt.Errorf("offer probe %q must be one of arp or arp_ping", s)
+}
+
+// parseExpectDuration parses the timeout of an expectation, or how long it
+// expects a lease to be held for.
+func parseExpectDuration(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("must be positive, got %s", d)
+	}
+
+	return d, nil
+}
+
+// parseParams parses a parameter request list given as option codes, keeping
+// their order. The result is never nil, so an empty list can be told apart
+// from no list at all.
+func parseParams(codes []string) ([]layers.DHCPOpt, error) {
+	params := []layers.DHCPOpt{}
+	seen := map[int]bool{}
+	for _, codeStr := range codes {
+		code, err := strconv.Atoi(strings.TrimSpace(codeStr))
+		if err != nil {
+			return nil, fmt.Errorf("option code %q is not an integer", codeStr)
+		}
+
+		if code < 1 || code > 254 {
+			return nil, fmt.Errorf("option code %d must be between 1 and 254", code)
+		}
+
+		if seen[code] {
+			return nil, fmt.Errorf("option code %d is listed more than once", code)
+		}
+		seen[code] = true
+
+		params = append(params, layers.DHCPOpt(code))
+	}
+
+	return params, nil
+}
+
+// parseRawOptions parses a list of raw options of the form
+// "code=hexbytes;code=hexbytes", e.g. "250=deadbeef;251=00".
+func parseRawOptions(s string) ([]dhclient.Option, error) {
+	var opts []dhclient.Option
+	for _, entry := range strings.Split(s, ";") {
+		codeStr, hexStr, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("option %q is not of the form code=hexbytes", entry)
+		}
+
+		opt, err := parseRawOption(codeStr, hexStr)
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, opt)
+	}
+
+	return opts, nil
+}
+
+// parseRawOption parses a single raw option given its code and hex encoded
+// payload. Pad (0) and end (255) can't be sent as options.
+func parseRawOption(codeStr, hexStr string) (dhclient.Option, error) {
+	code, err := strconv.Atoi(codeStr)
+	if err != nil {
+		return dhclient.Option{}, fmt.Errorf("option code %q is not an integer", codeStr)
+	}
+
+	if code < 1 || code > 254 {
+		return dhclient.Option{}, fmt.Errorf("option code %d must be between 1 and 254", code)
+	}
+
+	data, err := hex.DecodeString(hexStr)
+	if err != nil {
+		return dhclient.Option{}, fmt.Errorf("option %d payload %q is not valid hex: %w", code, hexStr, err)
+	}
+
+	if len(data) > 255 {
+		return dhclient.Option{}, fmt.Errorf("option %d payload is longer than 255 bytes", code)
+	}
+
+	return dhclient.Option{Type: layers.DHCPOpt(code), Data: data}, nil
+}
+
+// getEnvBool parses the environment variable name as a boolean, returning
+// false if it is unset.
+func getEnvBool(name string) (bool, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%s is not a valid boolean: %w", name, err)
+	}
+
+	return b, nil
+}
+
+// getEnvInt parses the environment variable name as an integer, returning
+// def if it is unset.
+func getEnvInt(name string, def int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid integer: %w", name, err)
+	}
+
+	return i, nil
+}
+
+// getEnvFloat parses the environment variable name as a floating point
+// number, returning def if it is unset.
+func getEnvFloat(name string, def float64) (float64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid number: %w", name, err)
+	}
+
+	return f, nil
+}
+
+// getEnvDuration parses the environment variable name as a duration,
+// returning def if it is unset.
+func getEnvDuration(name string, def time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a valid duration: %w", name, err)
+	}
+
+	return d, nil
+}
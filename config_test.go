@@ -0,0 +1,548 @@
+package greedydhcp
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+)
+
+func TestParseTargetMap(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]string
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  map[string]string{},
+		},
+		{
+			name:  "simple",
+			input: "10.0.0.1=a,10.0.0.2=b",
+			want:  map[string]string{"10.0.0.1": "a", "10.0.0.2": "b"},
+		},
+		{
+			name:  "escaped comma",
+			input: `10.0.0.1=Acme\, Inc.,10.0.0.2=b`,
+			want:  map[string]string{"10.0.0.1": "Acme, Inc.", "10.0.0.2": "b"},
+		},
+		{
+			name:  "colons in value",
+			input: "10.0.0.1=02:00:00:00:00:01",
+			want:  map[string]string{"10.0.0.1": "02:00:00:00:00:01"},
+		},
+		{
+			name:  "equals in value",
+			input: "10.0.0.1=key=value",
+			want:  map[string]string{"10.0.0.1": "key=value"},
+		},
+		{
+			name:  "escaped equals in value",
+			input: `10.0.0.1=key\=value`,
+			want:  map[string]string{"10.0.0.1": "key=value"},
+		},
+		{
+			name:  "escaped backslash",
+			input: `10.0.0.1=a\\,10.0.0.2=b`,
+			want:  map[string]string{"10.0.0.1": `a\`, "10.0.0.2": "b"},
+		},
+		{
+			name:  "empty value",
+			input: "10.0.0.1=",
+			want:  map[string]string{"10.0.0.1": ""},
+		},
+		{
+			name:  "whitespace around target",
+			input: " 10.0.0.1 =a",
+			want:  map[string]string{"10.0.0.1": "a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTargetMap(tt.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseTargetMapErrors(t *testing.T) {
+	for _, input := range []string{
+		"10.0.0.1",
+		"10.0.0.1=a,,10.0.0.2=b",
+		"=a",
+		`10.0.0.1=a\`,
+		"10.0.0.1=a,10.0.0.1=b",
+		`10.0.0.1\=a`,
+	} {
+		t.Run(input, func(t *testing.T) {
+			if got, err := parseTargetMap(input); err == nil {
+				t.Errorf("expected an error, got %v", got)
+			}
+		})
+	}
+}
+
+func TestParseRawOptions(t *testing.T) {
+	got, err := parseRawOptions("250=deadbeef; 1=00")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []dhclient.Option{
+		{Type: layers.DHCPOpt(250), Data: []byte{0xde, 0xad, 0xbe, 0xef}},
+		{Type: layers.DHCPOpt(1), Data: []byte{0}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestParseRawOptionsErrors(t *testing.T) {
+	for _, input := range []string{
+		"250",
+		"0=00",
+		"255=00",
+		"foo=00",
+		"250=xyz",
+		"250=abc",
+		"250=00;",
+	} {
+		t.Run(input, func(t *testing.T) {
+			if got, err := parseRawOptions(input); err == nil {
+				t.Errorf("expected an error, got %v", got)
+			}
+		})
+	}
+}
+
+func TestValidateTargetAddr(t *testing.T) {
+	tests := []struct {
+		addr    string
+		wantErr string
+	}{
+		{addr: "10.0.0.1"},
+		{addr: "nope", wantErr: `target "nope" is not a valid IP address`},
+		{addr: "fd00::1", wantErr: `target "fd00::1" is an IPv6 address`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.addr, func(t *testing.T) {
+			err := validateTargetAddr(tt.addr)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParseParams(t *testing.T) {
+	got, err := parseParams([]string{"3", " 1", "6"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []layers.DHCPOpt{layers.DHCPOptRouter, layers.DHCPOptSubnetMask, layers.DHCPOptDNS}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	for _, codes := range [][]string{{"0"}, {"255"}, {"x"}, {"1", "1"}} {
+		if got, err := parseParams(codes); err == nil {
+			t.Errorf("expected an error for %v, got %v", codes, got)
+		}
+	}
+}
+
+func TestParseSubnet(t *testing.T) {
+	tests := []struct {
+		subnet  string
+		wantErr string
+	}{
+		{subnet: "10.0.0.0/24"},
+		{subnet: "10.0.0.7/24"},
+		{subnet: "nope", wantErr: `"nope" is not a valid IPv4 subnet`},
+		{subnet: "fd00::/64", wantErr: `"fd00::/64" is not a valid IPv4 subnet`},
+		{subnet: "10.0.1.0/24", wantErr: "10.0.0.1 is not in 10.0.1.0/24"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subnet, func(t *testing.T) {
+			_, err := parseSubnet(tt.subnet, "10.0.0.1")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestParseSecs(t *testing.T) {
+	tests := []struct {
+		value       string
+		wantSecs    uint16
+		wantElapsed bool
+		wantErr     bool
+	}{
+		{value: "30", wantSecs: 30},
+		{value: "elapsed", wantElapsed: true},
+		{value: "10+elapsed", wantSecs: 10, wantElapsed: true},
+		{value: "65536", wantErr: true},
+		{value: "-1", wantErr: true},
+		{value: "soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			secs, elapsed, err := parseSecs(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %d", secs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if secs != tt.wantSecs || elapsed != tt.wantElapsed {
+				t.Errorf("expected %d and elapsed %v, got %d and %v", tt.wantSecs, tt.wantElapsed, secs, elapsed)
+			}
+		})
+	}
+}
+
+func TestLoadTargetsNetnsNeedsFlag(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("TARGET_ADDRS", "10.0.0.1")
+	t.Setenv("TARGET_NETNS", "10.0.0.1=/var/run/netns/blue")
+
+	if _, err := loadTargets(testLogger(t)); err == nil || !strings.Contains(err.Error(), "ENABLE_NETNS") {
+		t.Fatalf("expected an error about ENABLE_NETNS, got %v", err)
+	}
+
+	t.Setenv("ENABLE_NETNS", "1")
+	targets, err := loadTargets(testLogger(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if targets[0].netns != "/var/run/netns/blue" {
+		t.Errorf("expected the namespace to be set, got %q", targets[0].netns)
+	}
+
+	t.Setenv("TARGET_NETNS", "10.0.0.1=netns/blue")
+	if _, err := loadTargets(testLogger(t)); err == nil {
+		t.Error("expected an error for a relative path")
+	}
+}
+
+func TestGetLoggerWritesToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "greedydhcp.log")
+	t.Setenv("LOG_FILE", path)
+
+	logger, err := getLogger(fileSettings{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger.Info("hello from the test")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read the log file: %v", err)
+	}
+	if !strings.Contains(string(data), "hello from the test") {
+		t.Errorf("expected the message in the log file, got %q", data)
+	}
+
+	t.Setenv("LOG_MAX_SIZE_MB", "0")
+	if _, err := getLogger(fileSettings{}); err == nil {
+		t.Error("expected an error for a zero maximum size")
+	}
+}
+
+func TestParsePacketQuirks(t *testing.T) {
+	quirks, err := parsePacketQuirks("pad=4; trailing=60;no_end")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &dhclient.Quirks{PadOptions: 4, TrailingPadding: 60, OmitEnd: true}
+	if !reflect.DeepEqual(quirks, want) {
+		t.Errorf("expected %+v, got %+v", want, quirks)
+	}
+
+	for _, s := range []string{"pad=-1", "trailing=1001", "pad", "no_end=1", "shuffle"} {
+		if _, err := parsePacketQuirks(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestLoadTargetsPacketQuirksNeedFlag(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("TARGET_ADDRS", "10.0.0.1")
+	t.Setenv("TARGET_PACKET_QUIRKS", "10.0.0.1=no_end")
+
+	if _, err := loadTargets(testLogger(t)); err == nil || !strings.Contains(err.Error(), "ENABLE_PACKET_QUIRKS") {
+		t.Fatalf("expected an error about ENABLE_PACKET_QUIRKS, got %v", err)
+	}
+
+	t.Setenv("ENABLE_PACKET_QUIRKS", "1")
+	targets, err := loadTargets(testLogger(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if targets[0].quirks == nil || !targets[0].quirks.OmitEnd {
+		t.Errorf("expected the quirks to be set, got %+v", targets[0].quirks)
+	}
+}
+
+func TestLoadTargetsVLAN(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("TARGET_ADDRS", "10.0.0.1")
+	t.Setenv("TARGET_VLAN", "10.0.0.1=10")
+
+	if _, err := loadTargets(testLogger(t)); err == nil || !strings.Contains(err.Error(), "ENABLE_VLANS") {
+		t.Fatalf("expected an error about ENABLE_VLANS, got %v", err)
+	}
+
+	t.Setenv("ENABLE_VLANS", "1")
+	targets, err := loadTargets(testLogger(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if targets[0].vlan != 10 {
+		t.Errorf("expected VLAN 10, got %d", targets[0].vlan)
+	}
+
+	t.Setenv("TARGET_VLAN", "10.0.0.1=4095")
+	if _, err := loadTargets(testLogger(t)); err == nil {
+		t.Error("expected an error for an out of range VLAN")
+	}
+}
+
+func TestLoadTargetsOfferProbe(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("TARGET_ADDRS", "10.0.0.1")
+	t.Setenv("TARGET_OFFER_PROBE", "10.0.0.1=arp_ping")
+
+	if _, err := loadTargets(testLogger(t)); err == nil || !strings.Contains(err.Error(), "ENABLE_OFFER_PROBES") {
+		t.Fatalf("expected an error about ENABLE_OFFER_PROBES, got %v", err)
+	}
+
+	t.Setenv("ENABLE_OFFER_PROBES", "1")
+	targets, err := loadTargets(testLogger(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if targets[0].offerProbe != offerProbeARPPing {
+		t.Errorf("expected the arp_ping probe, got %q", targets[0].offerProbe)
+	}
+
+	t.Setenv("TARGET_OFFER_PROBE", "10.0.0.1=dad")
+	if _, err := loadTargets(testLogger(t)); err == nil {
+		t.Error("expected an error for an unknown probe")
+	}
+}
+
+func TestLoadTargetsMAC(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("TARGET_ADDRS", "10.0.0.1@02:00:00:00:00:01,10.0.0.2")
+
+	targets, err := loadTargets(testLogger(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if targets[0].addr != "10.0.0.1" || targets[0].hwAddr.String() != "02:00:00:00:00:01" {
+		t.Errorf("expected 10.0.0.1 with its MAC address, got %s with %v", targets[0].addr, targets[0].hwAddr)
+	}
+	if targets[1].hwAddr != nil {
+		t.Errorf("expected 10.0.0.2 to use the interface's MAC address, got %v", targets[1].hwAddr)
+	}
+
+	for _, addrs := range []string{"10.0.0.1@02:00:00:00:00", "10.0.0.1@01:00:5e:00:00:01", "10.0.0.1@"} {
+		t.Setenv("TARGET_ADDRS", addrs)
+		if _, err := loadTargets(testLogger(t)); err == nil || !strings.Contains(err.Error(), addrs) {
+			t.Errorf("expected an error naming %q, got %v", addrs, err)
+		}
+	}
+
+	t.Setenv("TARGET_ADDRS", "10.0.0.1@02:00:00:00:00:01,10.0.0.2@02:00:00:00:00:01")
+	if _, err := loadTargets(testLogger(t)); err == nil || !strings.Contains(err.Error(), "both have") {
+		t.Errorf("expected an error about the shared MAC address, got %v", err)
+	}
+}
+
+func TestLoadTargetsIdentity(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("TARGET_ADDRS", "10.0.0.1,10.0.0.2,10.0.0.3")
+	t.Setenv("TARGET_HOSTNAME", "10.0.0.1=probe-1")
+	t.Setenv("TARGET_CLIENT_ID", "10.0.0.1=01020000000001")
+	t.Setenv("TARGET_RAW_OPTIONS", "10.0.0.3=12=6f74686572")
+	t.Setenv("HOSTNAME_TEMPLATE", "greedy-{ip}")
+
+	targets, err := loadTargets(testLogger(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, want := range []string{"probe-1", "greedy-10-0-0-2", ""} {
+		if targets[i].hostname != want {
+			t.Errorf("expected %s to send host name %q, got %q", targets[i].addr, want, targets[i].hostname)
+		}
+	}
+	if targets[0].clientID.String() != "01020000000001" || targets[1].clientID != nil {
+		t.Errorf("expected only 10.0.0.1 to send a client identifier, got %v and %v", targets[0].clientID, targets[1].clientID)
+	}
+
+	t.Setenv("TARGET_HOSTNAME", "10.0.0.3=probe-3")
+	if _, err := loadTargets(testLogger(t)); err == nil || !strings.Contains(err.Error(), "raw option") {
+		t.Errorf("expected an error about the host name also sent as a raw option, got %v", err)
+	}
+	t.Setenv("TARGET_HOSTNAME", "")
+
+	t.Setenv("TARGET_CLIENT_ID", "10.0.0.1=01020000000001,10.0.0.2=01020000000001")
+	if _, err := loadTargets(testLogger(t)); err == nil || !strings.Contains(err.Error(), "both have") {
+		t.Errorf("expected an error about the shared client identifier, got %v", err)
+	}
+	t.Setenv("TARGET_CLIENT_ID", "")
+
+	t.Setenv("HOSTNAME_TEMPLATE", "greedy")
+	if _, err := loadTargets(testLogger(t)); err == nil || !strings.Contains(err.Error(), "HOSTNAME_TEMPLATE") {
+		t.Errorf("expected an error about a template without {ip}, got %v", err)
+	}
+}
+
+func TestExpandTargetAddrs(t *testing.T) {
+	tests := []struct {
+		entries []string
+		want    []string
+		wantErr string
+	}{
+		{entries: []string{"10.0.0.1", "10.0.0.2@02:00:00:00:00:01"}, want: []string{"10.0.0.1", "10.0.0.2@02:00:00:00:00:01"}},
+		{entries: []string{"10.0.0.0/30", "10.0.1.5"}, want: []string{"10.0.0.1", "10.0.0.2", "10.0.1.5"}},
+		{entries: []string{"10.0.0.7/29"}, want: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5", "10.0.0.6"}},
+		{entries: []string{"10.0.0.0/31"}, want: []string{"10.0.0.0", "10.0.0.1"}},
+		{entries: []string{"10.0.0.9/32"}, want: []string{"10.0.0.9"}},
+		{entries: []string{"10.0.0.0/28"}, wantErr: "14 host addresses"},
+		{entries: []string{"10.0.0.0/8"}, wantErr: "MAX_RANGE_HOSTS"},
+		{entries: []string{"10.0.0.0/30", "10.0.0.2"}, wantErr: "in 10.0.0.0/30 and 10.0.0.2"},
+		{entries: []string{"10.0.0.1", "10.0.0.1@02:00:00:00:00:01"}, wantErr: "more than once"},
+		{entries: []string{"10.0.0.0/30@02:00:00:00:00:01"}, wantErr: "can't have a MAC address"},
+		{entries: []string{"10.0.0.0/33"}, wantErr: "not a valid IPv4 block"},
+		{entries: []string{"fd00::/126"}, wantErr: "not a valid IPv4 block"},
+	}
+
+	for _, tt := range tests {
+		t.Run(strings.Join(tt.entries, ","), func(t *testing.T) {
+			got, err := expandTargetAddrs(tt.entries, 8)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLoadTargetsRange(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("TARGET_ADDRS", "10.0.0.0/29,10.0.1.5")
+	t.Setenv("TARGET_SERVER", "10.0.0.3=10.0.0.254")
+
+	targets, err := loadTargets(testLogger(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(targets) != 7 {
+		t.Fatalf("expected 7 targets, got %d", len(targets))
+	}
+	if !targets[2].server.Equal(net.ParseIP("10.0.0.254")) {
+		t.Errorf("expected per-target settings to apply to addresses of a range, got server %v", targets[2].server)
+	}
+
+	t.Setenv("MAX_RANGE_HOSTS", "4")
+	if _, err := loadTargets(testLogger(t)); err == nil || !strings.Contains(err.Error(), "MAX_RANGE_HOSTS") {
+		t.Errorf("expected an error about MAX_RANGE_HOSTS, got %v", err)
+	}
+}
+
+func TestLoadTargetsExpectations(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("TARGET_ADDRS", "10.0.0.1")
+	t.Setenv("TARGET_EXPECT_BOUND", "10.0.0.1=1m")
+
+	if _, err := loadTargets(testLogger(t)); err == nil || !strings.Contains(err.Error(), "no expectation timeout") {
+		t.Fatalf("expected an error about the missing timeout, got %v", err)
+	}
+
+	t.Setenv("TARGET_EXPECT_TIMEOUT", "10.0.0.1=1m")
+	if _, err := loadTargets(testLogger(t)); err == nil || !strings.Contains(err.Error(), "can't be done within") {
+		t.Fatalf("expected an error about the bound time not fitting the timeout, got %v", err)
+	}
+
+	t.Setenv("TARGET_EXPECT_TIMEOUT", "10.0.0.1=5m")
+	targets, err := loadTargets(testLogger(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if targets[0].expectTimeout != 5*time.Minute || targets[0].expectBound != time.Minute {
+		t.Errorf("expected a 5m timeout and 1m bound time, got %s and %s", targets[0].expectTimeout, targets[0].expectBound)
+	}
+}
+
+func TestLoadTargetsDependencies(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("TARGET_ADDRS", "10.0.0.1,10.0.0.2,10.0.0.3")
+	t.Setenv("TARGET_DEPENDS_ON", "10.0.0.2=10.0.0.1,10.0.0.3=10.0.0.1;10.0.0.2")
+
+	targets, err := loadTargets(testLogger(t))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deps := targets[2].dependsOn; len(deps) != 2 || deps[0] != "10.0.0.1" || deps[1] != "10.0.0.2" {
+		t.Errorf("unexpected dependencies %v", deps)
+	}
+
+	for value, want := range map[string]string{
+		"10.0.0.1=10.0.0.1": "10.0.0.1 -> 10.0.0.1",
+		"10.0.0.1=10.0.0.4": "isn't a target",
+		"10.0.0.1=10.0.0.2,10.0.0.2=10.0.0.3,10.0.0.3=10.0.0.1": "10.0.0.1 -> 10.0.0.2 -> 10.0.0.3 -> 10.0.0.1",
+	} {
+		t.Setenv("TARGET_DEPENDS_ON", value)
+		if _, err := loadTargets(testLogger(t)); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected an error about %q for %s, got %v", want, value, err)
+		}
+	}
+}
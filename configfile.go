@@ -0,0 +1,712 @@
+package greedydhcp
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/google/gopacket/layers"
+	"gopkg.in/yaml.v3"
+)
+
+// maxFallbackAddrs is the most fallback addresses a target may have.
+const maxFallbackAddrs = 8
+
+// fileConfig is the layout of the file given by CONFIG_FILE.
+type fileConfig struct {
+	// Iface, MetricsAddr and LogLevel stand in for the environment
+	// variables of the same name, see readFileSettings.
+	Iface       string       `yaml:"iface"`
+	MetricsAddr string       `yaml:"metrics_addr"`
+	LogLevel    string       `yaml:"log_level"`
+	Targets     []fileTarget `yaml:"targets"`
+}
+
+// fileTarget is a single entry of fileConfig.Targets.
+type fileTarget struct {
+	IP              string            `yaml:"ip"`
+	Server          string            `yaml:"server"`
+	RawOptions      map[int]string    `yaml:"raw_options"`
+	Params          *[]int            `yaml:"params"`
+	HoldTime        string            `yaml:"hold_time"`
+	RequestDelay    string            `yaml:"request_delay"`
+	ReleaseCooldown string            `yaml:"release_cooldown"`
+	OfferPolicy     string            `yaml:"offer_policy"`
+	OfferProbe      string            `yaml:"offer_probe"`
+	ExpectTimeout   string            `yaml:"expect_timeout"`
+	ExpectBound     string            `yaml:"expect_bound"`
+	Schedule        string            `yaml:"schedule"`
+	Priority        int               `yaml:"priority"`
+	Subnet          string            `yaml:"subnet"`
+	Tags            map[string]string `yaml:"tags"`
+	Fallbacks       []string          `yaml:"fallback_addrs"`
+	Enabled         *bool             `yaml:"enabled"`
+	Secs            string            `yaml:"secs"`
+	Netns           string            `yaml:"netns"`
+	Giaddr          string            `yaml:"giaddr"`
+	MAC             string            `yaml:"mac"`
+	AcquireSLO      string            `yaml:"acquire_latency_slo"`
+	MaxFailures     int               `yaml:"max_failures"`
+	Quirks          string            `yaml:"packet_quirks"`
+	FQDN            string            `yaml:"fqdn"`
+	Hostname        string            `yaml:"hostname"`
+	ClientID        string            `yaml:"client_id"`
+	LogLevel        string            `yaml:"log_level"`
+	VLAN            *int              `yaml:"vlan"`
+	DependsOn       []string          `yaml:"depends_on"`
+}
+
+// readConfigFile parses the YAML file at path, rejecting unknown fields.
+func readConfigFile(path string) (fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fileConfig{}, err
+	}
+
+	var cfg fileConfig
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fileConfig{}, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// fileSettings are the global settings of a config file, each empty unless
+// the file sets it.
+type fileSettings struct {
+	iface       string
+	metricsAddr string
+	logLevel    string
+}
+
+// readFileSettings reads the global settings of the YAML file at path,
+// which are read ahead of the environment variables they stand in for.
+// Unlike with targets, the file overrides a variable that is already set,
+// the settings both set to different values being returned. The file's
+// iface also overrides IFACE_MAC. Settings are only read at startup, not on
+// a reload.
+func readFileSettings(path string) (fileSettings, []targetConflict, error) {
+	cfg, err := readConfigFile(path)
+	if err != nil {
+		return fileSettings{}, nil, err
+	}
+
+	if cfg.LogLevel != "" {
+		if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+			return fileSettings{}, nil, fmt.Errorf("log_level: %w", err)
+		}
+	}
+
+	if cfg.MetricsAddr != "" {
+		if err := checkMetricsAddr(cfg.MetricsAddr); err != nil {
+			return fileSettings{}, nil, fmt.Errorf("metrics_addr: %w", err)
+		}
+	}
+
+	var conflicts []targetConflict
+	for _, setting := range []struct{ name, value string }{
+		{"IFACE", cfg.Iface},
+		{"IFACE_MAC", cfg.Iface},
+		{"METRICS_ADDR", cfg.MetricsAddr},
+		{"LOG_LEVEL", cfg.LogLevel},
+	} {
+		if setting.value == "" {
+			continue
+		}
+
+		if env := os.Getenv(setting.name); env != "" && env != setting.value {
+			conflicts = append(conflicts, targetConflict{setting: setting.name, file: setting.value, env: env})
+		}
+	}
+
+	return fileSettings{iface: cfg.Iface, metricsAddr: cfg.MetricsAddr, logLevel: cfg.LogLevel}, conflicts, nil
+}
+
+// loadConfigFile reads the targets defined in the YAML file at path.
+func loadConfigFile(path string) ([]targetConfig, error) {
+	cfg, err := readConfigFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.Targets) == 0 {
+		return nil, fmt.Errorf("%s defines no targets", path)
+	}
+
+	seen := map[string]bool{}
+	targets := make([]targetConfig, 0, len(cfg.Targets))
+	for i, t := range cfg.Targets {
+		if err := validateTargetAddr(t.IP); err != nil {
+			return nil, fmt.Errorf("targets[%d].ip: %w", i, err)
+		}
+
+		if seen[t.IP] {
+			return nil, fmt.Errorf("targets[%d].ip: %s is listed more than once", i, t.IP)
+		}
+		seen[t.IP] = true
+
+		target := targetConfig{addr: t.IP, priority: t.Priority, disabled: t.Enabled != nil && !*t.Enabled}
+		if t.Server != "" {
+			target.server, err = parseIPv4(t.Server)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].server: %w", i, err)
+			}
+		}
+
+		if t.Giaddr != "" {
+			target.giaddr, err = parseIPv4(t.Giaddr)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].giaddr: %w", i, err)
+			}
+		}
+
+		codes := make([]int, 0, len(t.RawOptions))
+		for code := range t.RawOptions {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+
+		for _, code := range codes {
+			opt, err := parseRawOption(strconv.Itoa(code), t.RawOptions[code])
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].raw_options: %w", i, err)
+			}
+			target.rawOptions = append(target.rawOptions, opt)
+		}
+
+		if len(t.Fallbacks) > maxFallbackAddrs {
+			return nil, fmt.Errorf("targets[%d].fallback_addrs: at most %d addresses may be listed, got %d", i, maxFallbackAddrs, len(t.Fallbacks))
+		}
+
+		listed := map[string]bool{t.IP: true}
+		for _, addr := range t.Fallbacks {
+			ip, err := parseIPv4(addr)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].fallback_addrs: %w", i, err)
+			}
+
+			if listed[ip.String()] {
+				return nil, fmt.Errorf("targets[%d].fallback_addrs: %s is listed more than once", i, ip)
+			}
+			listed[ip.String()] = true
+
+			target.fallbackAddrs = append(target.fallbackAddrs, ip)
+		}
+
+		if len(t.DependsOn) > 0 {
+			target.dependsOn, err = parseDependencies(t.DependsOn)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].depends_on: %w", i, err)
+			}
+		}
+
+		if t.Secs != "" {
+			target.secs, target.secsElapsed, err = parseSecs(t.Secs)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].secs: %w", i, err)
+			}
+		}
+
+		if t.VLAN != nil {
+			target.vlan, err = parseVLAN(strconv.Itoa(*t.VLAN))
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].vlan: %w", i, err)
+			}
+		}
+
+		if t.LogLevel != "" {
+			level, err := parseLogLevel(t.LogLevel)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].log_level: %w", i, err)
+			}
+			target.logLevel = &level
+		}
+
+		if t.FQDN != "" {
+			target.fqdn, err = parseFQDN(t.FQDN)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].fqdn: %w", i, err)
+			}
+		}
+
+		if t.Hostname != "" {
+			target.hostname, err = parseHostname(t.Hostname)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].hostname: %w", i, err)
+			}
+		}
+
+		if t.ClientID != "" {
+			target.clientID, err = parseClientID(t.ClientID)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].client_id: %w", i, err)
+			}
+		}
+
+		if t.Quirks != "" {
+			target.quirks, err = parsePacketQuirks(t.Quirks)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].packet_quirks: %w", i, err)
+			}
+		}
+
+		if t.Netns != "" {
+			target.netns, err = parseNetns(t.Netns)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].netns: %w", i, err)
+			}
+		}
+
+		if t.Subnet != "" {
+			target.subnet, err = parseSubnet(t.Subnet, t.IP)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].subnet: %w", i, err)
+			}
+		}
+
+		for name, value := range t.Tags {
+			if err := validateTag(name, value); err != nil {
+				return nil, fmt.Errorf("targets[%d].tags: %w", i, err)
+			}
+		}
+		if len(t.Tags) > 0 {
+			target.tags = t.Tags
+		}
+
+		if t.AcquireSLO != "" {
+			target.acquireSLO, err = time.ParseDuration(t.AcquireSLO)
+			if err == nil && target.acquireSLO <= 0 {
+				err = fmt.Errorf("must be positive, got %s", target.acquireSLO)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].acquire_latency_slo: %w", i, err)
+			}
+		}
+
+		if t.MaxFailures < 0 {
+			return nil, fmt.Errorf("targets[%d].max_failures: must not be negative, got %d", i, t.MaxFailures)
+		}
+		target.maxFailures = t.MaxFailures
+
+		if t.HoldTime != "" {
+			target.holdTime, err = parseHoldTime(t.HoldTime)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].hold_time: %w", i, err)
+			}
+		}
+
+		if t.ReleaseCooldown != "" {
+			target.releaseCooldown, err = parseReleaseCooldown(t.ReleaseCooldown)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].release_cooldown: %w", i, err)
+			}
+		}
+
+		if t.RequestDelay != "" {
+			target.requestDelay, err = parseRequestDelay(t.RequestDelay)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].request_delay: %w", i, err)
+			}
+		}
+
+		if t.OfferPolicy != "" {
+			target.offerPolicy, err = parseOfferPolicy(t.OfferPolicy)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].offer_policy: %w", i, err)
+			}
+		}
+
+		if t.Schedule != "" {
+			target.schedule, err = parseSchedule(t.Schedule)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].schedule: %w", i, err)
+			}
+		}
+
+		if t.OfferProbe != "" {
+			target.offerProbe, err = parseOfferProbe(t.OfferProbe)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].offer_probe: %w", i, err)
+			}
+		}
+
+		if t.MAC != "" {
+			target.hwAddr, err = parseTargetMAC(t.MAC)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].mac: %w", i, err)
+			}
+		}
+
+		if t.ExpectTimeout != "" {
+			target.expectTimeout, err = parseExpectDuration(t.ExpectTimeout)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].expect_timeout: %w", i, err)
+			}
+		}
+
+		if t.ExpectBound != "" {
+			target.expectBound, err = parseExpectDuration(t.ExpectBound)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].expect_bound: %w", i, err)
+			}
+		}
+
+		if t.Params != nil {
+			codes := make([]string, 0, len(*t.Params))
+			for _, code := range *t.Params {
+				codes = append(codes, strconv.Itoa(code))
+			}
+
+			target.params, err = parseParams(codes)
+			if err != nil {
+				return nil, fmt.Errorf("targets[%d].params: %w", i, err)
+			}
+		}
+
+		targets = append(targets, target)
+	}
+
+	return targets, nil
+}
+
+// loadTargets reads the configured targets, from CONFIG_FILE and from
+// TARGET_ADDRS and the per-target variables. When both are set, they are
+// merged by mergeTargets, each conflict being logged to logger.
+func loadTargets(logger *slog.Logger) ([]targetConfig, error) {
+	path := os.Getenv("CONFIG_FILE")
+	targetAddrsStr := os.Getenv("TARGET_ADDRS")
+	if path == "" && targetAddrsStr == "" {
+		return nil, errors.New("neither TARGET_ADDRS nor ANONYMOUS_CLIENTS is set")
+	}
+
+	var fileTargets, envTargets []targetConfig
+	var err error
+	if path != "" {
+		fileTargets, err = loadConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if targetAddrsStr != "" {
+		maxRangeHosts, err := getEnvInt("MAX_RANGE_HOSTS", defaultMaxRangeHosts)
+		if err != nil {
+			return nil, err
+		}
+		if maxRangeHosts <= 0 {
+			return nil, fmt.Errorf("MAX_RANGE_HOSTS must be positive, got %d", maxRangeHosts)
+		}
+
+		addrs, err := expandTargetAddrs(strings.Split(targetAddrsStr, ","), maxRangeHosts)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TARGET_ADDRS: %w", err)
+		}
+
+		envTargets, err = getTargets(addrs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	targets := fileTargets
+	switch {
+	case path == "":
+		targets = envTargets
+	case targetAddrsStr != "":
+		targets = mergeTargets(logger, fileTargets, envTargets)
+	}
+
+	// Entering a namespace needs CAP_SYS_ADMIN, so it has to be asked for
+	// explicitly.
+	enableNetns, err := getEnvBool("ENABLE_NETNS")
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse ENABLE_NETNS: %w", err)
+	}
+
+	// Quirks make deliberately broken packets, so they are only sent when
+	// asked for.
+	enableQuirks, err := getEnvBool("ENABLE_PACKET_QUIRKS")
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse ENABLE_PACKET_QUIRKS: %w", err)
+	}
+
+	// Creating VLAN interfaces changes the host's network configuration,
+	// so it has to be asked for too.
+	enableVLANs, err := getEnvBool("ENABLE_VLANS")
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse ENABLE_VLANS: %w", err)
+	}
+
+	// Probing offers delays every acquisition and adds traffic, so it is
+	// only done when asked for.
+	enableOfferProbes, err := getEnvBool("ENABLE_OFFER_PROBES")
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse ENABLE_OFFER_PROBES: %w", err)
+	}
+
+	if err := checkDependencies(targets); err != nil {
+		return nil, err
+	}
+
+	if template := os.Getenv("HOSTNAME_TEMPLATE"); template != "" {
+		for i := range targets {
+			if targets[i].hostname != "" || hasRawOption(targets[i], layers.DHCPOptHostname) {
+				continue
+			}
+			if targets[i].hostname, err = expandHostnameTemplate(template, targets[i].addr); err != nil {
+				return nil, fmt.Errorf("invalid HOSTNAME_TEMPLATE for %s: %w", targets[i].addr, err)
+			}
+		}
+	}
+
+	// A server sees targets sharing a MAC address as a single client.
+	macTargets := map[string]string{}
+	for _, target := range targets {
+		if target.hwAddr == nil {
+			continue
+		}
+		if other, ok := macTargets[target.hwAddr.String()]; ok {
+			return nil, fmt.Errorf("targets %s and %s both have the MAC address %s", other, target.addr, target.hwAddr)
+		}
+		macTargets[target.hwAddr.String()] = target.addr
+	}
+
+	// Nor does it tell apart targets sharing a client identifier.
+	clientIDTargets := map[string]string{}
+	for _, target := range targets {
+		if target.clientID == nil {
+			continue
+		}
+		if other, ok := clientIDTargets[target.clientID.String()]; ok {
+			return nil, fmt.Errorf("targets %s and %s both have the client identifier %s", other, target.addr, target.clientID)
+		}
+		clientIDTargets[target.clientID.String()] = target.addr
+	}
+
+	for _, target := range targets {
+		if target.netns != "" && !enableNetns {
+			return nil, fmt.Errorf("target %s has a network namespace set, but ENABLE_NETNS isn't set", target.addr)
+		}
+
+		if target.quirks != nil && !enableQuirks {
+			return nil, fmt.Errorf("target %s has packet quirks set, but ENABLE_PACKET_QUIRKS isn't set", target.addr)
+		}
+
+		if target.vlan != 0 && !enableVLANs {
+			return nil, fmt.Errorf("target %s has a VLAN set, but ENABLE_VLANS isn't set", target.addr)
+		}
+
+		if target.offerProbe != "" && !enableOfferProbes {
+			return nil, fmt.Errorf("target %s has an offer probe set, but ENABLE_OFFER_PROBES isn't set", target.addr)
+		}
+
+		if target.releaseCooldown > 0 && target.holdTime == 0 {
+			return nil, fmt.Errorf("target %s has a release cooldown set, but no hold time", target.addr)
+		}
+
+		if target.expectBound > 0 && target.expectTimeout == 0 {
+			return nil, fmt.Errorf("target %s has an expected bound time set, but no expectation timeout", target.addr)
+		}
+
+		if target.expectTimeout > 0 && target.expectBound >= target.expectTimeout {
+			return nil, fmt.Errorf("target %s expects to be bound for %s, which can't be done within its expectation timeout of %s", target.addr, target.expectBound, target.expectTimeout)
+		}
+
+		if target.vlan != 0 && target.netns != "" {
+			return nil, fmt.Errorf("target %s can't have both a VLAN and a network namespace set", target.addr)
+		}
+
+		if target.hostname != "" && hasRawOption(target, layers.DHCPOptHostname) ||
+			target.clientID != nil && hasRawOption(target, layers.DHCPOptClientID) {
+			return nil, fmt.Errorf("target %s sets the host name or client identifier both as a raw option and with its own setting", target.addr)
+		}
+
+		// The interfaces of another namespace can't be probed from here.
+		if target.offerProbe != "" && target.netns != "" {
+			return nil, fmt.Errorf("target %s can't have both an offer probe and a network namespace set", target.addr)
+		}
+	}
+
+	return targets, nil
+}
+
+// hasRawOption reports whether target sends option code as a raw option.
+func hasRawOption(target targetConfig, code layers.DHCPOpt) bool {
+	for _, opt := range target.rawOptions {
+		if opt.Type == code {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mergeTargets merges the targets of the config file with those of the
+// environment. Targets defined by only one of them are kept as they are, file
+// targets first. For targets defined by both, the file's settings are the
+// base and every setting the environment sets overrides the file's, a warning
+// being logged with both values where they differ.
+func mergeTargets(logger *slog.Logger, fileTargets, envTargets []targetConfig) []targetConfig {
+	index := make(map[string]int, len(fileTargets))
+	merged := append([]targetConfig(nil), fileTargets...)
+	for i, target := range merged {
+		index[target.addr] = i
+	}
+
+	for _, env := range envTargets {
+		i, ok := index[env.addr]
+		if !ok {
+			merged = append(merged, env)
+			continue
+		}
+
+		for _, c := range mergeTarget(&merged[i], env) {
+			logger.Warn(
+				"Target is set differently by CONFIG_FILE and the environment, using the environment's setting",
+				"target", env.addr, "setting", c.setting, "file", c.file, "env", c.env,
+			)
+		}
+	}
+
+	return merged
+}
+
+// targetConflict is a setting, of a target or a global one, that has
+// different values in the config file and the environment.
+type targetConflict struct {
+	setting   string
+	file, env string
+}
+
+// mergeTarget overrides the settings of base with those set by override,
+// returning the settings they both set to different values.
+func mergeTarget(base *targetConfig, override targetConfig) []targetConflict {
+	var conflicts []targetConflict
+	overrideSetting(&conflicts, "server", &base.server, override.server)
+	overrideSetting(&conflicts, "params", &base.params, override.params)
+	overrideSetting(&conflicts, "raw_options", &base.rawOptions, override.rawOptions)
+	overrideSetting(&conflicts, "hold_time", &base.holdTime, override.holdTime)
+	overrideSetting(&conflicts, "release_cooldown", &base.releaseCooldown, override.releaseCooldown)
+	overrideSetting(&conflicts, "request_delay", &base.requestDelay, override.requestDelay)
+	overrideSetting(&conflicts, "offer_policy", &base.offerPolicy, override.offerPolicy)
+	overrideSetting(&conflicts, "offer_probe", &base.offerProbe, override.offerProbe)
+	overrideSetting(&conflicts, "expect_timeout", &base.expectTimeout, override.expectTimeout)
+	overrideSetting(&conflicts, "expect_bound", &base.expectBound, override.expectBound)
+	overrideSetting(&conflicts, "schedule", &base.schedule, override.schedule)
+	overrideSetting(&conflicts, "secs", &base.secs, override.secs)
+	overrideSetting(&conflicts, "secs_elapsed", &base.secsElapsed, override.secsElapsed)
+	overrideSetting(&conflicts, "disabled", &base.disabled, override.disabled)
+	overrideSetting(&conflicts, "giaddr", &base.giaddr, override.giaddr)
+	overrideSetting(&conflicts, "mac", &base.hwAddr, override.hwAddr)
+	overrideSetting(&conflicts, "acquire_latency_slo", &base.acquireSLO, override.acquireSLO)
+	overrideSetting(&conflicts, "max_failures", &base.maxFailures, override.maxFailures)
+	overrideSetting(&conflicts, "log_level", &base.logLevel, override.logLevel)
+	overrideSetting(&conflicts, "fqdn", &base.fqdn, override.fqdn)
+	overrideSetting(&conflicts, "hostname", &base.hostname, override.hostname)
+	overrideSetting(&conflicts, "client_id", &base.clientID, override.clientID)
+	overrideSetting(&conflicts, "packet_quirks", &base.quirks, override.quirks)
+	overrideSetting(&conflicts, "vlan", &base.vlan, override.vlan)
+	overrideSetting(&conflicts, "netns", &base.netns, override.netns)
+	overrideSetting(&conflicts, "fallback_addrs", &base.fallbackAddrs, override.fallbackAddrs)
+	overrideSetting(&conflicts, "subnet", &base.subnet, override.subnet)
+	overrideSetting(&conflicts, "tags", &base.tags, override.tags)
+	overrideSetting(&conflicts, "priority", &base.priority, override.priority)
+	overrideSetting(&conflicts, "depends_on", &base.dependsOn, override.dependsOn)
+
+	return conflicts
+}
+
+// overrideSetting sets *base to override if override is set, i.e. not the
+// zero value, recording a conflict in conflicts if *base was set to something
+// else.
+func overrideSetting[T any](conflicts *[]targetConflict, setting string, base *T, override T) {
+	if reflect.ValueOf(&override).Elem().IsZero() {
+		return
+	}
+
+	if !reflect.ValueOf(base).Elem().IsZero() && !reflect.DeepEqual(*base, override) {
+		*conflicts = append(*conflicts, targetConflict{
+			setting: setting,
+			file:    settingString(*base),
+			env:     settingString(override),
+		})
+	}
+	*base = override
+}
+
+// settingString formats a setting for logging, following pointers.
+func settingString(v any) string {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Pointer && !rv.IsNil() {
+		v = rv.Elem().Interface()
+	}
+
+	return fmt.Sprintf("%+v", v)
+}
+
+// configDebounce is how long the config file must go without being written
+// before a change is applied.
+const configDebounce = 500 * time.Millisecond
+
+// watchConfigFile signals changed each time the file at path is written,
+// once writes have settled for configDebounce. The directory is watched
+// rather than the file, so files replaced by a rename, such as mounted
+// ConfigMaps, keep being picked up. The returned function stops watching.
+func watchConfigFile(logger *slog.Logger, path string, changed chan<- struct{}) (func(), error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	path = filepath.Clean(path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+
+	go func() {
+		var debounce <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+
+				// ConfigMaps swap the ..data symlink rather than the file.
+				if filepath.Clean(event.Name) != path && filepath.Base(event.Name) != "..data" {
+					continue
+				}
+
+				logger.Debug("Detected config file change", "path", event.Name, "op", event.Op)
+				debounce = time.After(configDebounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Warn("Error while watching config file", "err", err)
+			case <-debounce:
+				debounce = nil
+				logger.Info("Config file changed", "path", path)
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return func() { watcher.Close() }, nil
+}
@@ -0,0 +1,244 @@
+package greedydhcp
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:    "targets",
+			content: "targets:\n  - ip: 10.0.0.1\n  - ip: 10.0.0.2\n    server: 10.0.0.254\n",
+			want:    []string{"10.0.0.1", "10.0.0.2"},
+		},
+		{name: "empty", content: "", wantErr: "defines no targets"},
+		{name: "invalid ip", content: "targets:\n  - ip: nope\n", wantErr: "targets[0].ip"},
+		{name: "invalid server", content: "targets:\n  - ip: 10.0.0.1\n    server: nope\n", wantErr: "targets[0].server"},
+		{name: "duplicate", content: "targets:\n  - ip: 10.0.0.1\n  - ip: 10.0.0.1\n", wantErr: "more than once"},
+		{name: "fallbacks", content: "targets:\n  - ip: 10.0.0.1\n    fallback_addrs: [10.0.0.2, 10.0.0.3]\n", want: []string{"10.0.0.1"}},
+		{name: "invalid fallback", content: "targets:\n  - ip: 10.0.0.1\n    fallback_addrs: [nope]\n", wantErr: "targets[0].fallback_addrs"},
+		{name: "duplicate fallback", content: "targets:\n  - ip: 10.0.0.1\n    fallback_addrs: [10.0.0.1]\n", wantErr: "more than once"},
+		{name: "acquire slo", content: "targets:\n  - ip: 10.0.0.1\n    acquire_latency_slo: 5s\n", want: []string{"10.0.0.1"}},
+		{name: "invalid acquire slo", content: "targets:\n  - ip: 10.0.0.1\n    acquire_latency_slo: -5s\n", wantErr: "targets[0].acquire_latency_slo"},
+		{name: "release cooldown", content: "targets:\n  - ip: 10.0.0.1\n    hold_time: 10m\n    release_cooldown: 30s\n", want: []string{"10.0.0.1"}},
+		{name: "negative release cooldown", content: "targets:\n  - ip: 10.0.0.1\n    release_cooldown: -1s\n", wantErr: "targets[0].release_cooldown"},
+		{name: "request delay", content: "targets:\n  - ip: 10.0.0.1\n    request_delay: 30s\n", want: []string{"10.0.0.1"}},
+		{name: "invalid request delay", content: "targets:\n  - ip: 10.0.0.1\n    request_delay: 1h\n", wantErr: "targets[0].request_delay"},
+		{name: "offer policy", content: "targets:\n  - ip: 10.0.0.1\n    offer_policy: accept_log\n", want: []string{"10.0.0.1"}},
+		{name: "invalid offer policy", content: "targets:\n  - ip: 10.0.0.1\n    offer_policy: maybe\n", wantErr: "targets[0].offer_policy"},
+		{name: "offer probe", content: "targets:\n  - ip: 10.0.0.1\n    offer_probe: arp\n", want: []string{"10.0.0.1"}},
+		{name: "invalid offer probe", content: "targets:\n  - ip: 10.0.0.1\n    offer_probe: dad\n", wantErr: "targets[0].offer_probe"},
+		{name: "mac", content: "targets:\n  - ip: 10.0.0.1\n    mac: 02:00:00:00:00:01\n", want: []string{"10.0.0.1"}},
+		{name: "multicast mac", content: "targets:\n  - ip: 10.0.0.1\n    mac: 01:00:5e:00:00:01\n", wantErr: "targets[0].mac"},
+		{name: "hostname", content: "targets:\n  - ip: 10.0.0.1\n    hostname: probe-1\n    client_id: 01020000000001\n", want: []string{"10.0.0.1"}},
+		{name: "invalid hostname", content: "targets:\n  - ip: 10.0.0.1\n    hostname: probe_1\n", wantErr: "targets[0].hostname"},
+		{name: "invalid client id", content: "targets:\n  - ip: 10.0.0.1\n    client_id: 01\n", wantErr: "targets[0].client_id"},
+		{name: "schedule", content: "targets:\n  - ip: 10.0.0.1\n    schedule: Mon-Fri 08:00-17:00\n", want: []string{"10.0.0.1"}},
+		{name: "invalid schedule", content: "targets:\n  - ip: 10.0.0.1\n    schedule: 8am-5pm\n", wantErr: "targets[0].schedule"},
+		{name: "expectation", content: "targets:\n  - ip: 10.0.0.1\n    expect_timeout: 5m\n    expect_bound: 1m\n", want: []string{"10.0.0.1"}},
+		{name: "invalid expectation timeout", content: "targets:\n  - ip: 10.0.0.1\n    expect_timeout: 0s\n", wantErr: "targets[0].expect_timeout"},
+		{name: "max failures", content: "targets:\n  - ip: 10.0.0.1\n    max_failures: 20\n", want: []string{"10.0.0.1"}},
+		{name: "negative max failures", content: "targets:\n  - ip: 10.0.0.1\n    max_failures: -1\n", wantErr: "targets[0].max_failures"},
+		{name: "unknown field", content: "targets:\n  - ip: 10.0.0.1\n    hwaddr: nope\n", wantErr: "line 3"},
+		{name: "settings", content: "iface: eth0\nlog_level: debug\ntargets:\n  - ip: 10.0.0.1\n", want: []string{"10.0.0.1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+
+			targets, err := loadConfigFile(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(targets) != len(tt.want) {
+				t.Fatalf("expected %d targets, got %d", len(tt.want), len(targets))
+			}
+			for i, addr := range tt.want {
+				if targets[i].addr != addr {
+					t.Errorf("expected target %d to be %s, got %s", i, addr, targets[i].addr)
+				}
+			}
+		})
+	}
+}
+
+func TestReadFileSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "iface: eth1\nmetrics_addr: :9090\nlog_level: debug\ntargets:\n  - ip: 10.0.0.1\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("IFACE", "")
+	t.Setenv("IFACE_MAC", "")
+	t.Setenv("METRICS_ADDR", ":9090")
+	t.Setenv("LOG_LEVEL", "warn")
+	file, conflicts, err := readFileSettings(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if want := (fileSettings{iface: "eth1", metricsAddr: ":9090", logLevel: "debug"}); file != want {
+		t.Errorf("expected %+v, got %+v", want, file)
+	}
+	if level := os.Getenv("LOG_LEVEL"); level != "warn" {
+		t.Errorf("expected LOG_LEVEL to be left as it was, got %q", level)
+	}
+	if len(conflicts) != 1 || conflicts[0].setting != "LOG_LEVEL" || conflicts[0].file != "debug" || conflicts[0].env != "warn" {
+		t.Errorf("expected only LOG_LEVEL to conflict, got %+v", conflicts)
+	}
+
+	for content, wantErr := range map[string]string{
+		"log_level: loud\n":             "log_level",
+		"metrics_addr: nope\n":          "metrics_addr",
+		"iface: eth1\nlogLevel: info\n": "line 2",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := readFileSettings(path); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("%q: expected an error containing %q, got %v", content, wantErr, err)
+		}
+	}
+}
+
+func TestConfigFromEnvReadsFileSettings(t *testing.T) {
+	iface := loopbackInterface(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "iface: " + iface.Name + "\nmetrics_addr: 127.0.0.1:9090\nlog_level: error\ntargets:\n  - ip: 10.0.0.1\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("TARGET_ADDRS", "")
+	t.Setenv("IFACE", "")
+	t.Setenv("IFACE_MAC", "02:00:00:00:00:99")
+	t.Setenv("METRICS_ADDR", ":9100")
+	t.Setenv("LOG_LEVEL", "debug")
+	cfg, err := ConfigFromEnv()
+	if err != nil {
+		t.Fatalf("expected the file's iface to replace IFACE_MAC, got %v", err)
+	}
+
+	if cfg.Interface.Name != iface.Name {
+		t.Errorf("expected interface %s, got %s", iface.Name, cfg.Interface.Name)
+	}
+	if cfg.MetricsAddr != "127.0.0.1:9090" {
+		t.Errorf("expected the file's metrics address, got %q", cfg.MetricsAddr)
+	}
+	if cfg.Logger.Enabled(context.Background(), slog.LevelWarn) {
+		t.Error("expected the file's log level")
+	}
+	for name, want := range map[string]string{"IFACE": "", "METRICS_ADDR": ":9100", "LOG_LEVEL": "debug"} {
+		if got := os.Getenv(name); got != want {
+			t.Errorf("expected %s to be left as %q, got %q", name, want, got)
+		}
+	}
+}
+
+func TestWatchConfigFileDebounces(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("targets: []\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := make(chan struct{}, 1)
+	stop, err := watchConfigFile(testLogger(t), path, changed)
+	if err != nil {
+		t.Fatalf("unable to watch config file: %v", err)
+	}
+	t.Cleanup(stop)
+
+	for i := 0; i < 5; i++ {
+		if err := os.WriteFile(path, []byte("targets: []\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the change to be signalled")
+	}
+
+	select {
+	case <-changed:
+		t.Error("expected successive writes to be signalled once")
+	case <-time.After(2 * configDebounce):
+	}
+}
+
+func TestLoadTargetsMergesFileAndEnv(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "targets:\n  - ip: 10.0.0.1\n    priority: 5\n    hold_time: 10m\n  - ip: 10.0.0.2\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("TARGET_ADDRS", "10.0.0.1,10.0.0.3")
+	t.Setenv("TARGET_PRIORITY", "10.0.0.1=7")
+	t.Setenv("TARGET_SERVER", "10.0.0.1=10.0.0.254")
+
+	var logs bytes.Buffer
+	targets, err := loadTargets(slog.New(slog.NewTextHandler(&logs, nil)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var addrs []string
+	for _, target := range targets {
+		addrs = append(addrs, target.addr)
+	}
+	if got := strings.Join(addrs, ","); got != "10.0.0.1,10.0.0.2,10.0.0.3" {
+		t.Fatalf("expected file targets then env ones, got %s", got)
+	}
+
+	merged := targets[0]
+	if merged.priority != 7 {
+		t.Errorf("expected the env priority to win, got %d", merged.priority)
+	}
+	if merged.holdTime != 10*time.Minute {
+		t.Errorf("expected the file hold time to be kept, got %s", merged.holdTime)
+	}
+	if merged.server.String() != "10.0.0.254" {
+		t.Errorf("expected the env server to be added, got %s", merged.server)
+	}
+
+	// Only the priority is set differently by both.
+	if n := strings.Count(logs.String(), "level=WARN"); n != 1 {
+		t.Errorf("expected a single conflict warning, got %d:\n%s", n, logs.String())
+	}
+	if !strings.Contains(logs.String(), "setting=priority file=5 env=7") {
+		t.Errorf("expected the warning to name both values, got:\n%s", logs.String())
+	}
+
+	// The merged targets are validated like any others.
+	t.Setenv("TARGET_DEPENDS_ON", "10.0.0.3=10.0.0.4")
+	if _, err := loadTargets(testLogger(t)); err == nil {
+		t.Error("expected an error for a dependency on an unknown target")
+	}
+}
@@ -0,0 +1,743 @@
+package greedydhcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/mdlayher/packet"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// fakeDHCPServer is a minimal DHCP server used to drive runClient in tests.
+// It listens on a raw socket on the same interface as the client under test,
+// so its replies take the same path a real server's would.
+type fakeDHCPServer struct {
+	t     *testing.T
+	iface *net.Interface
+	conn  *packet.Conn
+	done  chan struct{}
+
+	mu        sync.Mutex
+	serverIP  net.IP
+	leaseTime time.Duration
+	nak       bool
+	nakAddrs  map[string]bool
+	silent    bool
+	offerAddr net.IP
+	decoys    bool
+	// renewTime and rebindTime, if set, are sent as T1 and T2 with every
+	// OFFER and ACK.
+	renewTime  time.Duration
+	rebindTime time.Duration
+	// foreignBOOTP, if set, sends a BOOTP reply to another host, without a
+	// message type, ahead of every reply.
+	foreignBOOTP bool
+	omitDNS      bool
+	boot         bool
+	message      string
+	// truncate, if set, leaves the end option out of every reply.
+	truncate bool
+	// ackAddr, if set, is given in every ACK instead of the requested
+	// address.
+	ackAddr net.IP
+	// duplicateAckAddr, if set, is given in a second ACK sent after every
+	// ACK, as by the other server of a failover pair.
+	duplicateAckAddr net.IP
+	// domainSearch, if set, is sent as option 119 with every OFFER and
+	// ACK.
+	domainSearch []byte
+	// relayAddr, if set, is sent as the giaddr of every reply, as if it
+	// came through a relay.
+	relayAddr net.IP
+	// padding, if set, is the number of bytes of vendor-specific options
+	// sent last with every OFFER and ACK, to make them large.
+	padding int
+	// poolSize, if set, is the most clients given an address without
+	// requesting one, others being ignored.
+	poolSize int
+	// allocated holds the address given to each client that didn't request
+	// one, keyed by chaddr.
+	allocated map[string]net.IP
+	// params is the parameter request list of the last packet.
+	params []byte
+	// secs is the secs field of the last packet.
+	secs uint16
+	// tos is the TOS byte of the IP header of the last packet.
+	tos uint8
+	// giaddr is the giaddr field of the last packet.
+	giaddr net.IP
+	// chaddr is the chaddr field of the last packet.
+	chaddr net.HardwareAddr
+	// options holds the options of the last packet, keyed by code.
+	options   map[layers.DHCPOpt][]byte
+	discovers int
+	requests  int
+	unicasts  int
+	releases  int
+	declines  int
+}
+
+// newFakeDHCPServer starts a fake server on the given interface. The test is
+// skipped if a raw socket can't be opened, such as when running without
+// CAP_NET_RAW.
+func newFakeDHCPServer(t *testing.T, iface *net.Interface) *fakeDHCPServer {
+	t.Helper()
+
+	conn, err := packet.Listen(iface, packet.Raw, int(layers.EthernetTypeIPv4), nil)
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			t.Skipf("unable to open raw socket on %s: %v", iface.Name, err)
+		}
+		t.Fatalf("unable to open raw socket on %s: %v", iface.Name, err)
+	}
+
+	s := &fakeDHCPServer{
+		t:         t,
+		iface:     iface,
+		conn:      conn,
+		done:      make(chan struct{}),
+		serverIP:  net.IPv4(127, 0, 0, 1).To4(),
+		leaseTime: time.Hour,
+		allocated: map[string]net.IP{},
+	}
+
+	go s.serve()
+
+	t.Cleanup(func() {
+		conn.Close()
+		<-s.done
+	})
+
+	return s
+}
+
+// loopbackInterface returns the loopback interface, which tests use in place
+// of a real segment. The loopback interface has no hardware address, so a
+// locally administered one is filled in for frames to be serialized.
+func loopbackInterface(t *testing.T) *net.Interface {
+	t.Helper()
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatalf("unable to list interfaces: %v", err)
+	}
+
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			iface.HardwareAddr = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+			return &iface
+		}
+	}
+
+	t.Skip("no loopback interface available")
+	return nil
+}
+
+// setServerIP sets the server identifier sent in every reply.
+func (s *fakeDHCPServer) setServerIP(ip net.IP) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.serverIP = ip.To4()
+}
+
+// unicastRequests returns the number of REQUESTs that were sent directly to
+// the server rather than broadcast.
+func (s *fakeDHCPServer) unicastRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.unicasts
+}
+
+// setLeaseTime sets the lease time granted in every ACK.
+func (s *fakeDHCPServer) setLeaseTime(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.leaseTime = d
+}
+
+// setRenewalTimes sets the T1 and T2 sent with every OFFER and ACK.
+func (s *fakeDHCPServer) setRenewalTimes(t1, t2 time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.renewTime, s.rebindTime = t1, t2
+}
+
+// setNak makes the server reply to every REQUEST with a NAK.
+func (s *fakeDHCPServer) setNak(nak bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nak = nak
+}
+
+// setNakAddrs makes the server reply with a NAK to every REQUEST for one of
+// addrs.
+func (s *fakeDHCPServer) setNakAddrs(addrs ...net.IP) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nakAddrs = map[string]bool{}
+	for _, addr := range addrs {
+		s.nakAddrs[addr.String()] = true
+	}
+}
+
+// setSilent makes the server drop every packet, simulating a timeout.
+func (s *fakeDHCPServer) setSilent(silent bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.silent = silent
+}
+
+// setDecoys makes the server precede every reply with a copy for another
+// transaction, a copy for another client and a truncated packet.
+func (s *fakeDHCPServer) setDecoys(decoys bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.decoys = decoys
+}
+
+// setOmitDNS makes the server leave the DNS option out of its replies.
+func (s *fakeDHCPServer) setForeignBOOTP(foreign bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.foreignBOOTP = foreign
+}
+
+func (s *fakeDHCPServer) setOmitDNS(omit bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.omitDNS = omit
+}
+
+// setBoot makes the server hand out boot settings: itself as siaddr and
+// TFTP server (option 66), and a bootfile in the file field.
+func (s *fakeDHCPServer) setBoot(boot bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.boot = boot
+}
+
+// setMessage makes the server send message (option 56) with every ACK and
+// NAK.
+func (s *fakeDHCPServer) setMessage(message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.message = message
+}
+
+// setDomainSearch makes the server send data as the domain search list
+// (option 119), as encoded.
+func (s *fakeDHCPServer) setDomainSearch(data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.domainSearch = data
+}
+
+// setRelayAddr makes the server send addr as the giaddr of its replies.
+func (s *fakeDHCPServer) setRelayAddr(addr net.IP) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.relayAddr = addr.To4()
+}
+
+// setPadding makes the server send about n bytes of vendor-specific options
+// after the others in every OFFER and ACK.
+func (s *fakeDHCPServer) setPadding(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.padding = n
+}
+
+// setPoolSize makes the server ignore clients once size of them have been
+// given an address.
+func (s *fakeDHCPServer) setPoolSize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.poolSize = size
+}
+
+// setOfferAddr makes the server offer addr instead of the requested address.
+func (s *fakeDHCPServer) setOfferAddr(addr net.IP) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offerAddr = addr.To4()
+}
+
+// setTruncate makes the server leave the end option out of its replies.
+func (s *fakeDHCPServer) setTruncate(truncate bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.truncate = truncate
+}
+
+// setAckAddr makes the server ACK with addr instead of the requested address.
+func (s *fakeDHCPServer) setAckAddr(addr net.IP) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ackAddr = addr.To4()
+}
+
+// setDuplicateAckAddr makes the server follow every ACK with another one for
+// the same transaction, giving addr.
+func (s *fakeDHCPServer) setDuplicateAckAddr(addr net.IP) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.duplicateAckAddr = addr.To4()
+}
+
+// releaseCount returns the number of RELEASEs seen so far.
+func (s *fakeDHCPServer) releaseCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.releases
+}
+
+// declineCount returns the number of DECLINEs seen so far.
+func (s *fakeDHCPServer) declineCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.declines
+}
+
+// lastParams returns the parameter request list of the last packet seen.
+func (s *fakeDHCPServer) lastParams() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.params
+}
+
+// lastSecs returns the secs field of the last packet received.
+func (s *fakeDHCPServer) lastSecs() uint16 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.secs
+}
+
+// lastTOS returns the TOS byte of the IP header of the last packet
+// received.
+func (s *fakeDHCPServer) lastTOS() uint8 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tos
+}
+
+// lastGiaddr returns the giaddr field of the last packet received.
+func (s *fakeDHCPServer) lastGiaddr() net.IP {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.giaddr
+}
+
+// lastChaddr returns the chaddr field of the last packet received.
+func (s *fakeDHCPServer) lastChaddr() net.HardwareAddr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.chaddr
+}
+
+// lastOption returns the data of option code in the last packet received, or
+// nil if it wasn't sent.
+func (s *fakeDHCPServer) lastOption(code layers.DHCPOpt) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.options[code]
+}
+
+// counts returns the number of DISCOVERs and REQUESTs seen so far.
+func (s *fakeDHCPServer) counts() (discovers int, requests int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.discovers, s.requests
+}
+
+func (s *fakeDHCPServer) serve() {
+	defer close(s.done)
+
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		pkt := gopacket.NewPacket(buf[:n], layers.LayerTypeEthernet, gopacket.Default)
+		dhcpLayer, ok := pkt.Layer(layers.LayerTypeDHCPv4).(*layers.DHCPv4)
+		if !ok || dhcpLayer.Operation != layers.DHCPOpRequest {
+			continue
+		}
+		// Skip the decoys sent to the client port.
+		if udp, ok := pkt.Layer(layers.LayerTypeUDP).(*layers.UDP); ok && udp.DstPort != 67 {
+			continue
+		}
+		if ip, ok := pkt.Layer(layers.LayerTypeIPv4).(*layers.IPv4); ok {
+			s.mu.Lock()
+			s.tos = ip.TOS
+			s.mu.Unlock()
+		}
+
+		eth, _ := pkt.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+		unicast := eth != nil && !bytes.Equal(eth.DstMAC, layers.EthernetBroadcast)
+
+		if reply := s.handle(dhcpLayer, unicast); reply != nil {
+			s.mu.Lock()
+			decoys, foreignBOOTP := s.decoys, s.foreignBOOTP
+			s.mu.Unlock()
+			if decoys {
+				s.sendDecoys(reply)
+			}
+			if foreignBOOTP {
+				s.sendForeignBOOTP(reply)
+			}
+			if err := s.sendReply(reply); err != nil {
+				s.t.Logf("fake dhcp server: unable to send reply: %v", err)
+			}
+			s.sendDuplicateAck(reply)
+		}
+	}
+}
+
+// handle builds the reply to req, returning nil if no reply should be sent.
+func (s *fakeDHCPServer) handle(req *layers.DHCPv4, unicast bool) *layers.DHCPv4 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		msgType   layers.DHCPMsgType
+		requested net.IP
+	)
+	s.secs = req.Secs
+	s.giaddr = req.RelayAgentIP
+	s.chaddr = append(net.HardwareAddr(nil), req.ClientHWAddr...)
+	s.options = make(map[layers.DHCPOpt][]byte, len(req.Options))
+	for _, opt := range req.Options {
+		s.options[opt.Type] = append([]byte(nil), opt.Data...)
+		switch opt.Type {
+		case layers.DHCPOptMessageType:
+			if len(opt.Data) == 1 {
+				msgType = layers.DHCPMsgType(opt.Data[0])
+			}
+		case layers.DHCPOptRequestIP:
+			// A server would only read the first, so a client sending
+			// another is asking for an address it won't get.
+			if requested != nil {
+				s.t.Errorf("fake dhcp server: requested address option sent more than once, %v then %v", requested, net.IP(opt.Data))
+			}
+			requested = net.IP(opt.Data).To4()
+		case layers.DHCPOptParamsRequest:
+			s.params = append([]byte(nil), opt.Data...)
+		}
+	}
+
+	var replyType layers.DHCPMsgType
+	switch msgType {
+	case layers.DHCPMsgTypeDiscover:
+		s.discovers++
+		replyType = layers.DHCPMsgTypeOffer
+	case layers.DHCPMsgTypeRequest:
+		s.requests++
+		if unicast {
+			s.unicasts++
+		}
+		replyType = layers.DHCPMsgTypeAck
+		if s.nak || s.nakAddrs[requested.String()] {
+			replyType = layers.DHCPMsgTypeNak
+		}
+	case layers.DHCPMsgTypeRelease:
+		s.releases++
+		return nil
+	case layers.DHCPMsgTypeDecline:
+		s.declines++
+		return nil
+	default:
+		return nil
+	}
+
+	if s.silent {
+		return nil
+	}
+
+	addr := requested
+	if s.offerAddr != nil && msgType == layers.DHCPMsgTypeDiscover {
+		addr = s.offerAddr
+	}
+	if s.ackAddr != nil && msgType == layers.DHCPMsgTypeRequest {
+		addr = s.ackAddr
+	}
+	if addr == nil {
+		key := req.ClientHWAddr.String()
+		if _, ok := s.allocated[key]; !ok {
+			if s.poolSize > 0 && len(s.allocated) >= s.poolSize {
+				return nil
+			}
+			s.allocated[key] = net.IPv4(10, 200, 0, byte(len(s.allocated)+1)).To4()
+		}
+		addr = s.allocated[key]
+	}
+
+	reply := &layers.DHCPv4{
+		Operation:    layers.DHCPOpReply,
+		HardwareType: req.HardwareType,
+		Xid:          req.Xid,
+		Flags:        req.Flags,
+		ClientHWAddr: req.ClientHWAddr,
+		RelayAgentIP: s.relayAddr,
+	}
+	reply.Options = append(reply.Options,
+		layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(replyType)}),
+		layers.NewDHCPOption(layers.DHCPOptServerID, s.serverIP),
+	)
+
+	if s.message != "" && replyType != layers.DHCPMsgTypeOffer {
+		reply.Options = append(reply.Options, layers.NewDHCPOption(layers.DHCPOptMessage, []byte(s.message)))
+	}
+
+	if replyType != layers.DHCPMsgTypeNak {
+		reply.YourClientIP = addr
+		if s.domainSearch != nil {
+			reply.Options = append(reply.Options, layers.NewDHCPOption(layers.DHCPOptDomainSearch, s.domainSearch))
+		}
+
+		leaseTime := make([]byte, 4)
+		binary.BigEndian.PutUint32(leaseTime, uint32(s.leaseTime/time.Second))
+		reply.Options = append(reply.Options,
+			layers.NewDHCPOption(layers.DHCPOptLeaseTime, leaseTime),
+			layers.NewDHCPOption(layers.DHCPOptSubnetMask, net.CIDRMask(8, 32)),
+			layers.NewDHCPOption(layers.DHCPOptRouter, s.serverIP),
+			layers.NewDHCPOption(layers.DHCPOptDomainName, []byte("example.test")),
+			layers.NewDHCPOption(layers.DHCPOptInterfaceMTU, []byte{0x05, 0x78}),
+			layers.NewDHCPOption(layers.DHCPOptNTPServers, []byte{10, 0, 0, 123, 10, 0, 1, 123}),
+		)
+		if s.renewTime > 0 {
+			reply.Options = append(reply.Options,
+				layers.NewDHCPOption(layers.DHCPOptT1, binary.BigEndian.AppendUint32(nil, uint32(s.renewTime/time.Second))),
+			)
+		}
+		if s.rebindTime > 0 {
+			reply.Options = append(reply.Options,
+				layers.NewDHCPOption(layers.DHCPOptT2, binary.BigEndian.AppendUint32(nil, uint32(s.rebindTime/time.Second))),
+			)
+		}
+		if s.boot {
+			reply.NextServerIP = s.serverIP
+			reply.File = []byte("pxelinux.0")
+			reply.Options = append(reply.Options,
+				layers.NewDHCPOption(layers.DHCPOpt(66), []byte(s.serverIP.String())),
+			)
+		}
+		if !s.omitDNS {
+			reply.Options = append(reply.Options,
+				layers.NewDHCPOption(layers.DHCPOptDNS, []byte{10, 0, 0, 53, 10, 0, 1, 53}),
+			)
+		}
+		for left := s.padding; left > 0; left -= 257 {
+			reply.Options = append(reply.Options,
+				layers.NewDHCPOption(layers.DHCPOptVendorOption, make([]byte, min(left, 255))),
+			)
+		}
+	}
+
+	return reply
+}
+
+// sendDecoys sends packets that a client waiting for reply should drop.
+func (s *fakeDHCPServer) sendDecoys(reply *layers.DHCPv4) {
+	wrongXID := *reply
+	wrongXID.Xid++
+	wrongChaddr := *reply
+	wrongChaddr.ClientHWAddr = net.HardwareAddr{0x02, 0, 0, 0, 0, 0xfe}
+	wrongOp := *reply
+	wrongOp.Operation = layers.DHCPOpRequest
+	badType := *reply
+	badType.Options = append([]layers.DHCPOption{
+		layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(layers.DHCPMsgTypeDiscover)}),
+	}, reply.Options[1:]...)
+
+	decoys := []gopacket.SerializableLayer{&wrongXID, &wrongChaddr, &wrongOp, &badType, gopacket.Payload("truncated")}
+	for _, decoy := range decoys {
+		if err := s.send(decoy); err != nil {
+			s.t.Logf("fake dhcp server: unable to send decoy: %v", err)
+		}
+	}
+}
+
+// sendForeignBOOTP sends a BOOTP reply to a host other than the one reply is
+// for.
+func (s *fakeDHCPServer) sendForeignBOOTP(reply *layers.DHCPv4) {
+	bootp := *reply
+	bootp.Xid++
+	bootp.ClientHWAddr = net.HardwareAddr{0x02, 0, 0, 0, 0, 0xfd}
+	bootp.Options = nil
+	if err := s.send(&bootp); err != nil {
+		s.t.Logf("fake dhcp server: unable to send bootp reply: %v", err)
+	}
+}
+
+// sendDuplicateAck sends a copy of reply giving the duplicate ACK address, if
+// it is an ACK and one is set.
+func (s *fakeDHCPServer) sendDuplicateAck(reply *layers.DHCPv4) {
+	s.mu.Lock()
+	addr := s.duplicateAckAddr
+	s.mu.Unlock()
+	// The message type is always the first option.
+	if addr == nil || layers.DHCPMsgType(reply.Options[0].Data[0]) != layers.DHCPMsgTypeAck {
+		return
+	}
+
+	duplicate := *reply
+	duplicate.YourClientIP = addr
+	if err := s.send(&duplicate); err != nil {
+		s.t.Logf("fake dhcp server: unable to send duplicate ack: %v", err)
+	}
+}
+
+// sendReply sends reply, leaving out its end option if the server truncates
+// replies.
+func (s *fakeDHCPServer) sendReply(reply *layers.DHCPv4) error {
+	s.mu.Lock()
+	truncate := s.truncate
+	s.mu.Unlock()
+	if !truncate {
+		return s.send(reply)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := reply.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	return s.send(gopacket.Payload(data[:len(data)-1]))
+}
+
+func (s *fakeDHCPServer) send(reply gopacket.SerializableLayer) error {
+	s.mu.Lock()
+	serverIP := s.serverIP
+	s.mu.Unlock()
+
+	eth := layers.Ethernet{
+		EthernetType: layers.EthernetTypeIPv4,
+		SrcMAC:       s.iface.HardwareAddr,
+		DstMAC:       layers.EthernetBroadcast,
+	}
+	ip := layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		SrcIP:    serverIP,
+		DstIP:    net.IPv4bcast.To4(),
+		Protocol: layers.IPProtocolUDP,
+	}
+	udp := layers.UDP{
+		SrcPort: 67,
+		DstPort: 68,
+	}
+	if err := udp.SetNetworkLayerForChecksum(&ip); err != nil {
+		return err
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		ComputeChecksums: true,
+		FixLengths:       true,
+	}
+	if err := gopacket.SerializeLayers(buf, opts, &eth, &ip, &udp, reply); err != nil {
+		return err
+	}
+
+	_, err := s.conn.WriteTo(buf.Bytes(), &packet.Addr{HardwareAddr: eth.DstMAC})
+	return err
+}
+
+type testLogWriter struct {
+	t *testing.T
+}
+
+func (w testLogWriter) Write(p []byte) (int, error) {
+	w.t.Log(string(p))
+	return len(p), nil
+}
+
+// testLogger returns a logger that writes to the test's log, or discards
+// everything when running without -v.
+func testLogger(t *testing.T) *slog.Logger {
+	var w io.Writer = io.Discard
+	if testing.Verbose() {
+		w = testLogWriter{t}
+	}
+
+	return slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// metricValue returns the current value of a counter or gauge, or the number
+// of observations of a histogram.
+func metricValue(t *testing.T, m prometheus.Metric) float64 {
+	t.Helper()
+
+	pb := &dto.Metric{}
+	if err := m.Write(pb); err != nil {
+		t.Fatalf("unable to read metric: %v", err)
+	}
+
+	if pb.Counter != nil {
+		return pb.Counter.GetValue()
+	}
+
+	if pb.Histogram != nil {
+		return float64(pb.Histogram.GetSampleCount())
+	}
+
+	return pb.Gauge.GetValue()
+}
+
+// hasSeries reports whether c currently exports a series with all of the
+// given label values.
+func hasSeries(t *testing.T, c prometheus.Collector, labels map[string]string) bool {
+	t.Helper()
+
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	found := false
+	for m := range ch {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			t.Fatalf("unable to read metric: %v", err)
+		}
+
+		matched := 0
+		for _, pair := range pb.Label {
+			if v, ok := labels[pair.GetName()]; ok && v == pair.GetValue() {
+				matched++
+			}
+		}
+		if matched == len(labels) {
+			found = true
+		}
+	}
+
+	return found
+}
+
+// waitFor polls cond until it returns true, failing the test after timeout.
+func waitFor(t *testing.T, timeout time.Duration, msg string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", msg)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
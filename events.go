@@ -0,0 +1,177 @@
+package greedydhcp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Lease events are logged with an "event" attribute naming the transition, so
+// that they can be told apart from other log lines and filtered on reliably.
+// Every event carries the "target" attribute of the client's logger, and the
+// "addr", "server" and "ttl" of the lease involved when there is one.
+const (
+	// eventAcquired is a lease being bound while none was held.
+	eventAcquired = "acquired"
+	// eventRenewed is a held lease being renewed or rebound.
+	eventRenewed = "renewed"
+	// eventExpired is a held lease being lost, whether it expired or was
+	// NAKed by the server.
+	eventExpired = "expired"
+	// eventFailed is an attempt to acquire or keep a lease failing. It
+	// carries the "reason" the failure is counted under and the "err".
+	eventFailed = "failed"
+	// eventReleased is a held lease being given back to the server.
+	eventReleased = "released"
+	// eventDeclined is an offered lease being turned down.
+	eventDeclined = "declined"
+)
+
+// leaseEvent is an event kept by an eventRing.
+type leaseEvent struct {
+	Time    time.Time         `json:"time"`
+	Target  string            `json:"target"`
+	Event   string            `json:"event"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// eventOverheadBytes approximates the memory taken by an event besides its
+// strings: its fields, map header and slot in the ring.
+const eventOverheadBytes = 128
+
+// size approximates the memory taken by e, in bytes.
+func (e leaseEvent) size() int {
+	n := eventOverheadBytes + len(e.Target) + len(e.Event) + len(e.Message)
+	for k, v := range e.Details {
+		// Each map entry also holds two string headers.
+		n += len(k) + len(v) + 32
+	}
+
+	return n
+}
+
+// eventRing keeps the most recent lease events of every target, up to a
+// number of events and an approximate number of bytes. Once the bytes run out,
+// every target is given an equal share of them, and the oldest events of the
+// target over its share are dropped first, so that a flapping target can't
+// push out the history of every other one.
+type eventRing struct {
+	size     int
+	maxBytes int
+
+	mu      sync.Mutex
+	entries []leaseEvent // oldest first
+	bytes   int
+	// targetBytes holds the bytes taken by the events of each target.
+	targetBytes map[string]int
+	// bytesMetric is greedydhcp_history_bytes.
+	bytesMetric prometheus.Gauge
+}
+
+// newEventRing returns a ring keeping the last size events, taking up to
+// maxBytes bytes reported in bytesMetric, or nil, which keeps none, if size
+// isn't positive. maxBytes isn't capped if it isn't positive.
+func newEventRing(size, maxBytes int, bytesMetric prometheus.Gauge) *eventRing {
+	if size <= 0 {
+		return nil
+	}
+
+	return &eventRing{size: size, maxBytes: maxBytes, targetBytes: map[string]int{}, bytesMetric: bytesMetric}
+}
+
+// add records e, dropping the oldest events once the ring is full.
+func (r *eventRing) add(e leaseEvent) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries = append(r.entries, e)
+	r.bytes += e.size()
+	r.targetBytes[e.Target] += e.size()
+	if len(r.entries) > r.size {
+		r.drop(0)
+	}
+	for r.maxBytes > 0 && r.bytes > r.maxBytes && len(r.entries) > 1 {
+		r.drop(r.oldestOfLargest())
+	}
+	r.bytesMetric.Set(float64(r.bytes))
+}
+
+// oldestOfLargest returns the index of the oldest event of the target whose
+// events take the most bytes.
+func (r *eventRing) oldestOfLargest() int {
+	largest := ""
+	for target, n := range r.targetBytes {
+		if n > r.targetBytes[largest] || (n == r.targetBytes[largest] && target < largest) {
+			largest = target
+		}
+	}
+	for i, e := range r.entries {
+		if e.Target == largest {
+			return i
+		}
+	}
+
+	return 0
+}
+
+// drop removes the event at index i.
+func (r *eventRing) drop(i int) {
+	e := r.entries[i]
+	r.entries = append(r.entries[:i], r.entries[i+1:]...)
+	r.bytes -= e.size()
+	r.targetBytes[e.Target] -= e.size()
+	if r.targetBytes[e.Target] <= 0 {
+		delete(r.targetBytes, e.Target)
+	}
+}
+
+// recent returns the kept events, oldest first.
+func (r *eventRing) recent() []leaseEvent {
+	if r == nil {
+		return []leaseEvent{}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]leaseEvent{}, r.entries...)
+}
+
+// logLeaseEvent logs event of target at level with msg, records it in events
+// and publishes it with publisher. lease may be nil if the event doesn't
+// involve one.
+func logLeaseEvent(logger *slog.Logger, events *eventRing, publisher *natsPublisher, target string, level slog.Level, event, msg string, lease *dhclient.Lease, attrs ...any) {
+	var args []any
+	if lease != nil {
+		var ttl any = "infinite"
+		if !lease.Infinite {
+			ttl = time.Until(lease.Expire).Round(time.Second)
+		}
+		args = append(args, "addr", lease.FixedAddress, "server", lease.ServerID, "ttl", ttl)
+	}
+	args = append(args, attrs...)
+
+	logger.Log(context.Background(), level, msg, append([]any{"event", event}, args...)...)
+
+	if events == nil && publisher == nil {
+		return
+	}
+
+	details := map[string]string{}
+	for i := 0; i+1 < len(args); i += 2 {
+		details[fmt.Sprint(args[i])] = fmt.Sprint(args[i+1])
+	}
+	e := leaseEvent{Time: time.Now(), Target: target, Event: event, Message: msg, Details: details}
+	events.add(e)
+	publisher.publish(e)
+}
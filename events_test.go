@@ -0,0 +1,119 @@
+package greedydhcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+)
+
+func TestLogLeaseEvent(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil)).With("target", "10.0.0.1")
+
+	lease := &dhclient.Lease{
+		FixedAddress: net.IPv4(10, 0, 0, 1),
+		ServerID:     net.IPv4(10, 0, 0, 254),
+		Expire:       time.Now().Add(time.Hour),
+	}
+	logLeaseEvent(logger, nil, nil, "10.0.0.1", slog.LevelInfo, eventAcquired, "Got lease", lease, "t1", time.Minute)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]any{
+		"event":  eventAcquired,
+		"target": "10.0.0.1",
+		"addr":   "10.0.0.1",
+		"server": "10.0.0.254",
+		"ttl":    float64(time.Hour),
+		"t1":     float64(time.Minute),
+	} {
+		if entry[key] != want {
+			t.Errorf("expected %s to be %v, got %v", key, want, entry[key])
+		}
+	}
+
+	buf.Reset()
+	logLeaseEvent(logger, nil, nil, "10.0.0.1", slog.LevelWarn, eventFailed, "DHCP exchange failed", nil, "reason", failureTimeout)
+	entry = nil
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["event"] != eventFailed || entry["reason"] != failureTimeout {
+		t.Errorf("unexpected entry %v", entry)
+	}
+	if _, ok := entry["addr"]; ok {
+		t.Errorf("expected no lease attributes without a lease, got %v", entry)
+	}
+}
+
+func TestEventRing(t *testing.T) {
+	r := newEventRing(3, 0, testMetrics.greedydhcpHistoryBytes)
+	for _, target := range []string{"10.0.0.1", "10.0.0.2"} {
+		r.add(leaseEvent{Target: target})
+	}
+	if got := r.recent(); len(got) != 2 || got[0].Target != "10.0.0.1" {
+		t.Fatalf("unexpected events %v", got)
+	}
+
+	for _, target := range []string{"10.0.0.3", "10.0.0.4", "10.0.0.5"} {
+		r.add(leaseEvent{Target: target})
+	}
+	got := r.recent()
+	if len(got) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(got))
+	}
+	for i, want := range []string{"10.0.0.3", "10.0.0.4", "10.0.0.5"} {
+		if got[i].Target != want {
+			t.Errorf("expected event %d to be for %s, got %s", i, want, got[i].Target)
+		}
+	}
+
+	var disabled *eventRing
+	disabled.add(leaseEvent{})
+	if got := disabled.recent(); len(got) != 0 {
+		t.Errorf("expected no events from a nil ring, got %v", got)
+	}
+}
+
+func TestEventRingMaxBytes(t *testing.T) {
+	event := func(target string) leaseEvent {
+		return leaseEvent{Target: target, Event: eventFailed, Message: "Acquiring lease failed"}
+	}
+	size := event("10.0.0.1").size()
+
+	r := newEventRing(100, 4*size, testMetrics.greedydhcpHistoryBytes)
+	r.add(event("10.0.0.1"))
+	for i := 0; i < 5; i++ {
+		r.add(event("10.0.0.2"))
+	}
+
+	// The flapping target is over its share, so it loses its own events
+	// rather than the other target's.
+	got := r.recent()
+	if len(got) != 4 {
+		t.Fatalf("expected 4 events to fit, got %d", len(got))
+	}
+	if got[0].Target != "10.0.0.1" {
+		t.Errorf("expected the event of 10.0.0.1 to be kept, got %v", got)
+	}
+	if v := readMetric(testMetrics.greedydhcpHistoryBytes); v != float64(4*size) {
+		t.Errorf("expected %d history bytes, got %v", 4*size, v)
+	}
+
+	r.add(event("10.0.0.1"))
+	r.add(event("10.0.0.1"))
+	counts := map[string]int{}
+	for _, e := range r.recent() {
+		counts[e.Target]++
+	}
+	if counts["10.0.0.1"] != 2 || counts["10.0.0.2"] != 2 {
+		t.Errorf("expected the bytes to be shared equally, got %v", counts)
+	}
+}
@@ -0,0 +1,124 @@
+package greedydhcp
+
+import (
+	"sync"
+	"time"
+)
+
+// Outcomes of a target's expectation, as labels of dhcp_target_expectation.
+const (
+	expectPending = "pending"
+	expectPassed  = "passed"
+	expectFailed  = "failed"
+)
+
+var expectOutcomes = []string{expectPending, expectPassed, expectFailed}
+
+// expectation decides whether a target met what a test expects of it over
+// its whole lifecycle: holding a lease for boundFor in a row, or just getting
+// one if boundFor is zero, within timeout of starting. Once decided, the
+// outcome doesn't change. A nil expectation ignores every call.
+type expectation struct {
+	boundFor time.Duration
+	// onDecided is called once the outcome is decided, with mu held.
+	onDecided func(outcome string)
+
+	mu      sync.Mutex
+	outcome string
+	stopped bool
+	// deadline fails the expectation once the timeout passes.
+	deadline *time.Timer
+	// held passes the expectation once the lease has been held for
+	// boundFor, and is nil while no lease is held.
+	held *time.Timer
+}
+
+// newExpectation starts the timeout of an expectation, calling onDecided
+// with its outcome once it is decided.
+func newExpectation(timeout, boundFor time.Duration, onDecided func(outcome string)) *expectation {
+	e := &expectation{boundFor: boundFor, onDecided: onDecided, outcome: expectPending}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.deadline = time.AfterFunc(timeout, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		e.decide(expectFailed)
+	})
+
+	return e
+}
+
+// bound records that a lease was bound. Renewals of a lease already held
+// don't restart the time it has been held for.
+func (e *expectation) bound() {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.outcome != expectPending || e.held != nil {
+		return
+	}
+	if e.boundFor == 0 {
+		e.decide(expectPassed)
+		return
+	}
+
+	var held *time.Timer
+	held = time.AfterFunc(e.boundFor, func() {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		// The lease may have been lost as the timer fired.
+		if e.held == held {
+			e.decide(expectPassed)
+		}
+	})
+	e.held = held
+}
+
+// lost records that the lease was lost, so that it has to be held for
+// boundFor again from the next one.
+func (e *expectation) lost() {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stopHeld()
+}
+
+// stop leaves the expectation undecided if it isn't yet, as the client is
+// stopping.
+func (e *expectation) stop() {
+	if e == nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.stopped = true
+	e.deadline.Stop()
+	e.stopHeld()
+}
+
+// decide sets the outcome, unless it is already decided. e.mu must be held.
+func (e *expectation) decide(outcome string) {
+	if e.stopped || e.outcome != expectPending {
+		return
+	}
+
+	e.outcome = outcome
+	e.deadline.Stop()
+	e.stopHeld()
+	e.onDecided(outcome)
+}
+
+// stopHeld stops the held timer, if any. e.mu must be held.
+func (e *expectation) stopHeld() {
+	if e.held != nil {
+		e.held.Stop()
+		e.held = nil
+	}
+}
@@ -0,0 +1,122 @@
+package greedydhcp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+)
+
+// optClientFQDN is the client FQDN option of RFC 4702, which gopacket has no
+// constant for.
+const optClientFQDN = layers.DHCPOpt(81)
+
+// Flags of the client FQDN option.
+const (
+	// fqdnFlagS asks the server to update the A record, or tells the client
+	// that it did.
+	fqdnFlagS = 0x01
+	// fqdnFlagO is set by a server that overrode the client's choice of S.
+	fqdnFlagO = 0x02
+	// fqdnFlagE marks the name as encoded in DNS wire format.
+	fqdnFlagE = 0x04
+	// fqdnFlagN asks the server not to update any record.
+	fqdnFlagN = 0x08
+)
+
+// clientFQDN is the client FQDN option sent by a target.
+type clientFQDN struct {
+	name  string
+	flags byte
+}
+
+// parseFQDN parses a client FQDN of the form "name" or "name;flags", where
+// flags is any of the letters S, N and E, e.g. "host.example.com;SE".
+func parseFQDN(s string) (*clientFQDN, error) {
+	name, flagStr, _ := strings.Cut(strings.TrimSpace(s), ";")
+	name = strings.TrimSuffix(name, ".")
+	if err := validateDomainName(name); err != nil {
+		return nil, err
+	}
+
+	fqdn := &clientFQDN{name: name}
+	for _, flag := range strings.TrimSpace(flagStr) {
+		switch flag {
+		case 'S':
+			fqdn.flags |= fqdnFlagS
+		case 'N':
+			fqdn.flags |= fqdnFlagN
+		case 'E':
+			fqdn.flags |= fqdnFlagE
+		default:
+			return nil, fmt.Errorf("unknown flag %q, expected S, N or E", flag)
+		}
+	}
+
+	if fqdn.flags&fqdnFlagS != 0 && fqdn.flags&fqdnFlagN != 0 {
+		return nil, errors.New("flags S and N can't both be set")
+	}
+
+	return fqdn, nil
+}
+
+// validateDomainName checks that name is a valid host name, with labels of 1
+// to 63 letters, digits or hyphens.
+func validateDomainName(name string) error {
+	if name == "" {
+		return errors.New("name is empty")
+	}
+
+	if len(name) > 253 {
+		return fmt.Errorf("name %q is longer than 253 bytes", name)
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return fmt.Errorf("name %q has a label that is empty or longer than 63 bytes", name)
+		}
+
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("label %q of name %q starts or ends with a hyphen", label, name)
+		}
+
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+				return fmt.Errorf("label %q of name %q has an invalid character %q", label, name, c)
+			}
+		}
+	}
+
+	return nil
+}
+
+// option encodes fqdn as the option sent to servers. RCODE1 and RCODE2 are
+// left zero, as clients should send them.
+func (fqdn *clientFQDN) option() dhclient.Option {
+	data := []byte{fqdn.flags, 0, 0}
+	if fqdn.flags&fqdnFlagE == 0 {
+		data = append(data, fqdn.name...)
+	} else {
+		for _, label := range strings.Split(fqdn.name, ".") {
+			data = append(data, byte(len(label)))
+			data = append(data, label...)
+		}
+		data = append(data, 0)
+	}
+
+	return dhclient.Option{Type: optClientFQDN, Data: data}
+}
+
+// fqdnReplyFlags returns the flags of the client FQDN option the server sent
+// with lease, if it sent one.
+func fqdnReplyFlags(lease *dhclient.Lease) (byte, bool) {
+	for _, opt := range lease.OtherOptions {
+		if opt.Type == optClientFQDN && len(opt.Data) > 0 {
+			return opt.Data[0], true
+		}
+	}
+
+	return 0, false
+}
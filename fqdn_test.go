@@ -0,0 +1,48 @@
+package greedydhcp
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+)
+
+func TestParseFQDN(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []byte
+	}{
+		{"host.example.com", append([]byte{0, 0, 0}, "host.example.com"...)},
+		{"host.example.com.;S", append([]byte{fqdnFlagS, 0, 0}, "host.example.com"...)},
+		{"host.example;SE", []byte{fqdnFlagS | fqdnFlagE, 0, 0, 4, 'h', 'o', 's', 't', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 0}},
+		{"host;N", append([]byte{fqdnFlagN, 0, 0}, "host"...)},
+	}
+
+	for _, tt := range tests {
+		fqdn, err := parseFQDN(tt.in)
+		if err != nil {
+			t.Fatalf("%q: unexpected error: %v", tt.in, err)
+		}
+		opt := fqdn.option()
+		if opt.Type != optClientFQDN || !bytes.Equal(opt.Data, tt.want) {
+			t.Errorf("%q: expected %v, got %d %v", tt.in, tt.want, opt.Type, opt.Data)
+		}
+	}
+
+	for _, s := range []string{"", "host;SN", "host;O", "-host.example", "host..example", "host_name"} {
+		if _, err := parseFQDN(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestFQDNReplyFlags(t *testing.T) {
+	lease := &dhclient.Lease{OtherOptions: []dhclient.Option{{Type: optClientFQDN, Data: []byte{fqdnFlagS | fqdnFlagO, 255, 255}}}}
+	if flags, ok := fqdnReplyFlags(lease); !ok || flags != fqdnFlagS|fqdnFlagO {
+		t.Errorf("expected flags %#x, got %#x and %v", fqdnFlagS|fqdnFlagO, flags, ok)
+	}
+
+	if _, ok := fqdnReplyFlags(&dhclient.Lease{}); ok {
+		t.Error("expected no flags without the option")
+	}
+}
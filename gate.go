@@ -0,0 +1,116 @@
+package greedydhcp
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// startGate limits how many targets may be starting at once. Slots are
+// granted to the waiting targets with the highest priority first, and in the
+// order they were queued among equal priorities.
+type startGate struct {
+	mu      sync.Mutex
+	free    int
+	waiting []*startTicket
+	nextSeq uint64
+}
+
+// startTicket is a target's place in a startGate.
+type startTicket struct {
+	gate     *startGate
+	priority int
+	seq      uint64
+	granted  chan struct{}
+	released bool
+}
+
+// newStartGate returns a gate letting limit targets start at once, or nil,
+// which lets every target start straight away, if limit isn't positive.
+func newStartGate(limit int) *startGate {
+	if limit <= 0 {
+		return nil
+	}
+
+	return &startGate{free: limit}
+}
+
+// enqueue queues a target with the given priority, granting it a slot
+// straight away if one is free. A nil gate returns a nil ticket, whose
+// methods do nothing.
+func (g *startGate) enqueue(priority int) *startTicket {
+	if g == nil {
+		return nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	t := &startTicket{gate: g, priority: priority, seq: g.nextSeq, granted: make(chan struct{})}
+	g.nextSeq++
+	g.waiting = append(g.waiting, t)
+	g.dispatch()
+
+	return t
+}
+
+// dispatch grants free slots to the waiting tickets. g.mu must be held.
+func (g *startGate) dispatch() {
+	sort.SliceStable(g.waiting, func(i, j int) bool {
+		if g.waiting[i].priority != g.waiting[j].priority {
+			return g.waiting[i].priority > g.waiting[j].priority
+		}
+		return g.waiting[i].seq < g.waiting[j].seq
+	})
+
+	for g.free > 0 && len(g.waiting) > 0 {
+		close(g.waiting[0].granted)
+		g.waiting = g.waiting[1:]
+		g.free--
+	}
+}
+
+// wait blocks until t is granted a slot, returning false if ctx is done
+// first.
+func (t *startTicket) wait(ctx context.Context) bool {
+	if t == nil {
+		return true
+	}
+
+	select {
+	case <-t.granted:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// release gives up t's slot, or its place in the queue if it hasn't been
+// granted one yet. Only the first call has any effect.
+func (t *startTicket) release() {
+	if t == nil {
+		return
+	}
+
+	g := t.gate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if t.released {
+		return
+	}
+	t.released = true
+
+	select {
+	case <-t.granted:
+		g.free++
+	default:
+		for i, w := range g.waiting {
+			if w == t {
+				g.waiting = append(g.waiting[:i], g.waiting[i+1:]...)
+				break
+			}
+		}
+	}
+	g.dispatch()
+}
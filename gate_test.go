@@ -0,0 +1,72 @@
+package greedydhcp
+
+import (
+	"context"
+	"testing"
+)
+
+// granted reports whether t has been granted a slot.
+func granted(t *startTicket) bool {
+	select {
+	case <-t.granted:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestStartGateGrantsByPriority(t *testing.T) {
+	g := newStartGate(1)
+
+	first := g.enqueue(0)
+	low := g.enqueue(0)
+	high := g.enqueue(10)
+	if !granted(first) || granted(low) || granted(high) {
+		t.Fatal("expected only the first ticket to be granted a slot")
+	}
+
+	first.release()
+	if !granted(high) || granted(low) {
+		t.Fatal("expected the freed slot to go to the highest priority")
+	}
+
+	// Releasing twice must not free a second slot.
+	first.release()
+	if granted(low) {
+		t.Fatal("expected a repeated release to be ignored")
+	}
+
+	high.release()
+	if !granted(low) {
+		t.Fatal("expected the last ticket to be granted a slot")
+	}
+}
+
+func TestStartGateReleaseWhileWaiting(t *testing.T) {
+	g := newStartGate(1)
+
+	first := g.enqueue(0)
+	cancelled := g.enqueue(0)
+	last := g.enqueue(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if cancelled.wait(ctx) {
+		t.Fatal("expected wait to give up once the context is done")
+	}
+	cancelled.release()
+
+	first.release()
+	if !granted(last) {
+		t.Fatal("expected a released waiting ticket to give up its place")
+	}
+}
+
+func TestStartGateUnlimited(t *testing.T) {
+	g := newStartGate(0)
+	ticket := g.enqueue(0)
+	if !ticket.wait(context.Background()) {
+		t.Fatal("expected an unlimited gate to never wait")
+	}
+	ticket.release()
+}
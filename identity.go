@@ -0,0 +1,57 @@
+package greedydhcp
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// hostnameTemplateIP is replaced with the target's address, dots turned to
+// hyphens, when HOSTNAME_TEMPLATE is expanded.
+const hostnameTemplateIP = "{ip}"
+
+// clientID is the client identifier option (61) sent by a target.
+type clientID []byte
+
+// parseClientID parses a client identifier given as hex, e.g.
+// "01020000000001" for a hardware type of 1 followed by a MAC address. RFC
+// 2132 requires at least 2 bytes.
+func parseClientID(s string) (clientID, error) {
+	data, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("%q is not valid hex: %w", s, err)
+	}
+
+	if len(data) < 2 || len(data) > 255 {
+		return nil, fmt.Errorf("client identifier must be 2 to 255 bytes, got %d", len(data))
+	}
+
+	return data, nil
+}
+
+func (id clientID) String() string {
+	return hex.EncodeToString(id)
+}
+
+// parseHostname parses the host name option (12) sent by a target, a valid
+// host name of one or more labels.
+func parseHostname(s string) (string, error) {
+	name := strings.TrimSuffix(strings.TrimSpace(s), ".")
+	if err := validateDomainName(name); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// expandHostnameTemplate returns the host name of the target at addr from
+// template, in which every hostnameTemplateIP is replaced with addr, e.g.
+// "greedy-10-0-0-5" for "greedy-{ip}".
+func expandHostnameTemplate(template, addr string) (string, error) {
+	if !strings.Contains(template, hostnameTemplateIP) {
+		return "", errors.New("template doesn't contain " + hostnameTemplateIP + ", so every target would send the same host name")
+	}
+
+	return parseHostname(strings.ReplaceAll(template, hostnameTemplateIP, strings.ReplaceAll(addr, ".", "-")))
+}
@@ -0,0 +1,41 @@
+package greedydhcp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseClientID(t *testing.T) {
+	id, err := parseClientID("01020000000005")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []byte{1, 2, 0, 0, 0, 0, 5}; !bytes.Equal(id, want) {
+		t.Errorf("expected %v, got %v", want, id)
+	}
+	if id.String() != "01020000000005" {
+		t.Errorf("expected the identifier to format as hex, got %s", id)
+	}
+
+	for _, s := range []string{"", "01", "0g", "012"} {
+		if _, err := parseClientID(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestExpandHostnameTemplate(t *testing.T) {
+	name, err := expandHostnameTemplate("greedy-{ip}", "10.0.0.5")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name != "greedy-10-0-0-5" {
+		t.Errorf("expected greedy-10-0-0-5, got %s", name)
+	}
+
+	for _, template := range []string{"greedy", "greedy_{ip}", "-{ip}"} {
+		if _, err := expandHostnameTemplate(template, "10.0.0.5"); err == nil {
+			t.Errorf("expected an error for %q", template)
+		}
+	}
+}
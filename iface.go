@@ -0,0 +1,453 @@
+package greedydhcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Interface types, as the type label of dhcp_interface_info.
+const (
+	ifaceTypePhysical = "physical"
+	ifaceTypeBridge   = "bridge"
+	ifaceTypeBond     = "bond"
+	ifaceTypeVLAN     = "vlan"
+	ifaceTypeVirtual  = "virtual"
+	ifaceTypeUnknown  = "unknown"
+)
+
+// ifaceType returns the type of iface, unknown if it can't be told.
+func ifaceType(iface *net.Interface) string {
+	kind, _, err := interfaceKind(iface.Name)
+	if err != nil {
+		return ifaceTypeUnknown
+	}
+
+	return kind
+}
+
+// setInterfaceInfo exports iface as the one clients run on.
+func (m *metrics) setInterfaceInfo(iface *net.Interface) {
+	m.dhcpInterfaceInfo.WithLabelValues(
+		iface.Name, iface.HardwareAddr.String(), strconv.Itoa(iface.Index), ifaceType(iface), interfaceVRF(iface.Name),
+	).Set(1)
+}
+
+// watchInterfaceIndex periodically looks iface up by name and warns if its
+// index no longer matches the one selected at startup, which happens when the
+// interface is recreated and leaves raw sockets bound to a stale index.
+func watchInterfaceIndex(ctx context.Context, logger *slog.Logger, m *metrics, iface *net.Interface, interval time.Duration) {
+	m.setInterfaceInfo(iface)
+	m.dhcpInterfaceIndexChangesTotal.Add(0)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := iface.Index
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current, err := net.InterfaceByName(iface.Name)
+		if err != nil {
+			logger.Warn("Unable to look up interface", "iface", iface.Name, "err", err)
+			continue
+		}
+
+		if current.Index == last {
+			continue
+		}
+
+		logger.Warn(
+			"Interface index changed since it was selected",
+			"iface", iface.Name, "configured_index", iface.Index, "previous_index", last, "index", current.Index,
+		)
+		m.dhcpInterfaceIndexChangesTotal.Inc()
+		last = current.Index
+	}
+}
+
+// Ways of handling a change of the interface MAC address, as set by
+// IFACE_MAC_CHANGE.
+const (
+	macChangeRestart = "restart"
+	macChangeLog     = "log"
+)
+
+// watchInterfaceMAC periodically looks the interface clients run on up by
+// name and checks that its MAC address is still the one they use, which a
+// bond failing over or an admin can change under them. Replies to requests
+// sent with the old address as chaddr are no longer delivered, so unless
+// action is macChangeLog, clients are restarted with the new one.
+func watchInterfaceMAC(ctx context.Context, logger *slog.Logger, cfg *clientConfig, interval time.Duration, action string) {
+	cfg.metrics.dhcpInterfaceMACChangesTotal.Add(0)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// last is the address last seen, which is only the one clients use if
+	// they are restarted on changes.
+	last := cfg.currentIface().HardwareAddr
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		iface := cfg.currentIface()
+		if action == macChangeRestart {
+			last = iface.HardwareAddr
+		}
+		current, err := net.InterfaceByName(iface.Name)
+		if err != nil || bytes.Equal(current.HardwareAddr, last) {
+			continue
+		}
+
+		logger.Warn(
+			"Interface MAC address changed", "iface", iface.Name, "previous", last, "mac", current.HardwareAddr,
+			"action", action,
+		)
+		cfg.metrics.dhcpInterfaceMACChangesTotal.Inc()
+		last = current.HardwareAddr
+		cfg.metrics.dhcpInterfaceInfo.Reset()
+		cfg.metrics.setInterfaceInfo(current)
+		if action == macChangeRestart {
+			cfg.setIface(current)
+		}
+	}
+}
+
+// watchInterfaceState periodically looks iface up by name and notifies
+// linkUp when it comes back up after being seen down, so that clients don't
+// wait out a backoff accrued while the link was gone.
+func watchInterfaceState(ctx context.Context, logger *slog.Logger, m *metrics, iface *net.Interface, interval time.Duration, linkUp *linkNotifier) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current, err := net.InterfaceByName(iface.Name)
+		if err != nil {
+			// A removed interface is as good as down.
+			linkUp.observe(false)
+			continue
+		}
+
+		if linkUp.observe(current.Flags&net.FlagUp != 0) {
+			logger.Info("Interface came back up, resetting retry backoff", "iface", iface.Name)
+			m.dhcpLinkUpResetsTotal.Inc()
+		}
+	}
+}
+
+// usableInterface reports whether the interface named name exists, is up and
+// has at least one address, as getInterface requires at startup.
+func usableInterface(name string) bool {
+	iface, err := net.InterfaceByName(name)
+	if err != nil || iface.Flags&net.FlagUp == 0 {
+		return false
+	}
+
+	addrs, err := iface.Addrs()
+	return err == nil && len(addrs) > 0
+}
+
+// watchInterfaceAddrs periodically checks that the interface clients run on
+// is still usable: it may be taken down or removed, and its own address may
+// come from DHCP and lapse. Once it has been unusable for at least grace,
+// selectIface is run again at every check, and clients are switched to the
+// interface it picks if that is a different one. Otherwise they keep waiting
+// for the interface to recover.
+func watchInterfaceAddrs(ctx context.Context, logger *slog.Logger, cfg *clientConfig, interval, grace time.Duration, selectIface func() (*net.Interface, error)) {
+	cfg.metrics.dhcpInterfaceReselectionsTotal.Add(0)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// since is when the interface was first seen unusable, zero while it
+	// is usable.
+	var since time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := cfg.currentIface()
+		if usableInterface(current.Name) {
+			if !since.IsZero() {
+				logger.Info("Interface is usable again", "iface", current.Name, "after", time.Since(since))
+				since = time.Time{}
+			}
+			continue
+		}
+
+		if since.IsZero() {
+			logger.Warn("Interface is down, gone or has no addresses left", "iface", current.Name, "reselect_after", grace)
+			since = time.Now()
+		}
+
+		if time.Since(since) < grace {
+			continue
+		}
+
+		next, err := selectIface()
+		if err != nil {
+			logger.Debug("No usable interface, waiting for the current one to recover", "err", err)
+			continue
+		}
+
+		if next.Name == current.Name {
+			continue
+		}
+
+		logger.Warn(
+			"Migrating clients to interface", "iface", next.Name, "mac", next.HardwareAddr, "type", ifaceType(next),
+			"previous", current.Name, "unusable_for", time.Since(since),
+		)
+		cfg.metrics.dhcpInterfaceReselectionsTotal.Inc()
+		cfg.metrics.dhcpInterfaceInfo.Reset()
+		cfg.metrics.setInterfaceInfo(next)
+		cfg.setIface(next)
+		since = time.Time{}
+	}
+}
+
+// localSubnets returns the subnets configured on every local interface, keyed
+// by interface name.
+func localSubnets() (map[string][]*net.IPNet, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	subnets := make(map[string][]*net.IPNet, len(ifaces))
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				subnets[iface.Name] = append(subnets[iface.Name], ipNet)
+			}
+		}
+	}
+
+	return subnets, nil
+}
+
+// crossInterfaceOwner returns the name of another interface whose subnets
+// contain addr, if none of the subnets of own do. Such a lease most likely
+// came from the network of that interface rather than the one it was
+// requested on.
+func crossInterfaceOwner(own string, subnets map[string][]*net.IPNet, addr net.IP) string {
+	for _, subnet := range subnets[own] {
+		if subnet.Contains(addr) {
+			return ""
+		}
+	}
+
+	names := make([]string, 0, len(subnets))
+	for name := range subnets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == own {
+			continue
+		}
+		for _, subnet := range subnets[name] {
+			if subnet.Contains(addr) {
+				return name
+			}
+		}
+	}
+
+	return ""
+}
+
+// offSubnetTargets returns the addresses of the targets outside every IPv4
+// network of addrs, the addresses of the interface clients run on, and
+// whether it has any such network to check them against. Targets that request
+// through a relay, or run in another namespace or on a VLAN, are expected to
+// be on other networks and aren't checked.
+func offSubnetTargets(targets []targetConfig, addrs []net.Addr) ([]string, bool) {
+	var networks []*net.IPNet
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			networks = append(networks, ipNet)
+		}
+	}
+	if len(networks) == 0 {
+		return nil, false
+	}
+
+	var off []string
+	for _, target := range targets {
+		if target.giaddr != nil || target.netns != "" || target.vlan != 0 {
+			continue
+		}
+
+		ip := net.ParseIP(target.addr)
+		inSubnet := false
+		for _, network := range networks {
+			if network.Contains(ip) {
+				inSubnet = true
+				break
+			}
+		}
+		if !inSubnet {
+			off = append(off, target.addr)
+		}
+	}
+
+	return off, true
+}
+
+// checkTargetSubnets warns about the targets outside every subnet of iface,
+// which the server most likely never offers, or returns an error naming them
+// if strict is set. Targets aren't checked if iface has no IPv4 address.
+func checkTargetSubnets(logger *slog.Logger, iface *net.Interface, targets []targetConfig, strict bool) error {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		logger.Warn("Unable to get the addresses of the interface, not checking targets against its subnets", "iface", iface.Name, "err", err)
+		return nil
+	}
+
+	off, ok := offSubnetTargets(targets, addrs)
+	if !ok {
+		logger.Debug("Interface has no IPv4 address, not checking targets against its subnets", "iface", iface.Name)
+		return nil
+	}
+	if len(off) == 0 {
+		return nil
+	}
+
+	if strict {
+		return fmt.Errorf("targets %s are outside every subnet of %s", strings.Join(off, ", "), iface.Name)
+	}
+	logger.Warn("Targets are outside every subnet of the interface, so the server will likely never offer them", "iface", iface.Name, "targets", off)
+
+	return nil
+}
+
+// getInterface returns the first interface that is up, is not a loopback and
+// has at least one address. If name is set, the interface with that name is
+// returned instead, failing if it is missing, down or a member of a bridge or
+// bond, rather than falling back to another one. If mac is not nil, only the
+// interface with the given hardware address is considered. An interface
+// selected by name or mac needs no address: clients only use raw sockets on
+// it, so it can be a dedicated probe interface with management traffic,
+// including the metrics server, elsewhere. Interfaces whose addresses can't
+// be listed are logged and skipped, and so are the members of a bridge or
+// bond: clients must run on the bridge or bond itself, which shares its MAC
+// address with its members, as replies are delivered to it rather than to the
+// member they arrive on. The error returned when no interface is usable lists
+// the ones skipped and why.
+func getInterface(logger *slog.Logger, name string, mac net.HardwareAddr) (*net.Interface, error) {
+	if name != "" {
+		return getInterfaceByName(name)
+	}
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var skipped []string
+	skip := func(iface net.Interface, reason string) {
+		skipped = append(skipped, fmt.Sprintf("%s (%s)", iface.Name, reason))
+	}
+
+	for _, iface := range interfaces {
+		if mac != nil && !bytes.Equal(iface.HardwareAddr, mac) {
+			continue
+		}
+
+		if (iface.Flags & net.FlagUp) == 0 {
+			skip(iface, "down")
+			continue
+		}
+
+		if (iface.Flags & net.FlagLoopback) != 0 {
+			skip(iface, "loopback")
+			continue
+		}
+
+		addrs, err := iface.Addrs()
+		if err != nil {
+			err = fmt.Errorf("unable to get addrs for iface %s: %w", iface.Name, err)
+			logger.Warn("Skipping interface", "iface", iface.Name, "err", err)
+			skip(iface, err.Error())
+			continue
+		}
+
+		if len(addrs) == 0 && mac == nil {
+			skip(iface, "no addresses")
+			continue
+		}
+
+		if _, master, err := interfaceKind(iface.Name); err == nil && master != "" {
+			logger.Debug("Skipping interface enslaved to another one", "iface", iface.Name, "master", master)
+			skip(iface, "member of "+master)
+			continue
+		}
+
+		return &iface, nil
+	}
+
+	msg := "unable to find interface"
+	if mac != nil {
+		msg = fmt.Sprintf("unable to find usable interface with mac %s", mac)
+	}
+	if len(skipped) > 0 {
+		return nil, fmt.Errorf("%s, skipped: %s", msg, strings.Join(skipped, ", "))
+	}
+
+	return nil, errors.New(msg)
+}
+
+// getInterfaceByName returns the interface called name, as described by
+// getInterface.
+func getInterfaceByName(name string) (*net.Interface, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		var names []string
+		if interfaces, err := net.Interfaces(); err == nil {
+			for _, iface := range interfaces {
+				names = append(names, iface.Name)
+			}
+		}
+		return nil, fmt.Errorf("unable to find interface %s, have: %s: %w", name, strings.Join(names, ", "), err)
+	}
+
+	if (iface.Flags & net.FlagUp) == 0 {
+		return nil, fmt.Errorf("interface %s is down", name)
+	}
+
+	if _, master, err := interfaceKind(name); err == nil && master != "" {
+		return nil, fmt.Errorf("interface %s is a member of %s, which clients must run on instead", name, master)
+	}
+
+	return iface, nil
+}
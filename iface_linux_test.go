@@ -0,0 +1,252 @@
+//go:build linux
+
+package greedydhcp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vishvananda/netlink"
+)
+
+// TestMetricsServedWithoutInterfaceAddress runs a client on an interface
+// without any address, selected by MAC as a dedicated probe interface would
+// be, while metrics are served on loopback.
+func TestMetricsServedWithoutInterfaceAddress(t *testing.T) {
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "gdprobe0"}, PeerName: "gdprobe1"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		if errors.Is(err, os.ErrPermission) || errors.Is(err, errors.ErrUnsupported) {
+			t.Skipf("unable to create a veth pair: %v", err)
+		}
+		t.Fatalf("unable to create a veth pair: %v", err)
+	}
+	t.Cleanup(func() { netlink.LinkDel(veth) })
+
+	for _, name := range []string{"gdprobe0", "gdprobe1"} {
+		// Keep the kernel from adding a link-local address.
+		os.WriteFile("/proc/sys/net/ipv6/conf/"+name+"/disable_ipv6", []byte("1"), 0o644)
+		link, err := netlink.LinkByName(name)
+		if err == nil {
+			err = netlink.LinkSetUp(link)
+		}
+		if err != nil {
+			t.Fatalf("unable to bring %s up: %v", name, err)
+		}
+	}
+
+	link, err := netlink.LinkByName("gdprobe0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	iface, err := getInterface(testLogger(t), "", link.Attrs().HardwareAddr)
+	if err != nil {
+		t.Fatalf("expected the interface to be selected by MAC without an address, got %v", err)
+	}
+	if addrs, _ := iface.Addrs(); len(addrs) != 0 {
+		t.Fatalf("expected %s to have no address, got %v", iface.Name, addrs)
+	}
+
+	peer, err := netlink.LinkByName("gdprobe1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	peerIface, err := getInterface(testLogger(t), "", peer.Attrs().HardwareAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newFakeDHCPServer(t, peerIface)
+
+	target := "10.100.0.41"
+	startTestClient(t, testClientConfig(iface), targetConfig{addr: target})
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(testMetrics.dhcpAcquiredLeasesTotal)
+	server := httptest.NewServer(promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unable to scrape metrics: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if !strings.Contains(string(body), `dhcp_acquired_leases_total{ip="`+target+`"}`) {
+		t.Error("expected the target's metrics to be served")
+	}
+}
+
+// TestGetInterfaceSkipsBridgeMembers selects an interface by the MAC address
+// of a bridge member, which the bridge shares, and expects the bridge.
+func TestGetInterfaceSkipsBridgeMembers(t *testing.T) {
+	bridge := &netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: "gdbr0"}}
+	if err := netlink.LinkAdd(bridge); err != nil {
+		if errors.Is(err, os.ErrPermission) || errors.Is(err, errors.ErrUnsupported) {
+			t.Skipf("unable to create a bridge: %v", err)
+		}
+		t.Fatalf("unable to create a bridge: %v", err)
+	}
+	t.Cleanup(func() { netlink.LinkDel(bridge) })
+
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "gdprobe2"}, PeerName: "gdprobe3"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatalf("unable to create a veth pair: %v", err)
+	}
+	t.Cleanup(func() { netlink.LinkDel(veth) })
+
+	member, err := netlink.LinkByName("gdprobe2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkSetMaster(member, bridge); err != nil {
+		t.Fatalf("unable to add gdprobe2 to the bridge: %v", err)
+	}
+	for _, name := range []string{"gdbr0", "gdprobe2", "gdprobe3"} {
+		link, err := netlink.LinkByName(name)
+		if err == nil {
+			err = netlink.LinkSetUp(link)
+		}
+		if err != nil {
+			t.Fatalf("unable to bring %s up: %v", name, err)
+		}
+	}
+
+	kind, master, err := interfaceKind("gdprobe2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kind != ifaceTypeVirtual || master != "gdbr0" {
+		t.Errorf("expected gdprobe2 to be a virtual member of gdbr0, got type %q and master %q", kind, master)
+	}
+	if kind, master, _ := interfaceKind("gdbr0"); kind != ifaceTypeBridge || master != "" {
+		t.Errorf("expected gdbr0 to be a bridge without a master, got type %q and master %q", kind, master)
+	}
+
+	// The bridge takes the MAC address of its only member.
+	iface, err := getInterface(testLogger(t), "", member.Attrs().HardwareAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iface.Name != "gdbr0" {
+		t.Errorf("expected the bridge to be selected, got %s", iface.Name)
+	}
+}
+
+// TestInterfaceVRF runs on an interface enslaved to a VRF, which unlike a
+// bridge member is the one clients run on.
+func TestInterfaceVRF(t *testing.T) {
+	vrf := &netlink.Vrf{LinkAttrs: netlink.LinkAttrs{Name: "gdvrf0"}, Table: 4242}
+	if err := netlink.LinkAdd(vrf); err != nil {
+		if errors.Is(err, os.ErrPermission) || errors.Is(err, errors.ErrUnsupported) {
+			t.Skipf("unable to create a vrf: %v", err)
+		}
+		t.Fatalf("unable to create a vrf: %v", err)
+	}
+	t.Cleanup(func() { netlink.LinkDel(vrf) })
+
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "gdprobe4"}, PeerName: "gdprobe5"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatalf("unable to create a veth pair: %v", err)
+	}
+	t.Cleanup(func() { netlink.LinkDel(veth) })
+
+	member, err := netlink.LinkByName("gdprobe4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkSetMaster(member, vrf); err != nil {
+		t.Fatalf("unable to add gdprobe4 to the vrf: %v", err)
+	}
+	for _, name := range []string{"gdvrf0", "gdprobe4"} {
+		link, err := netlink.LinkByName(name)
+		if err == nil {
+			err = netlink.LinkSetUp(link)
+		}
+		if err != nil {
+			t.Fatalf("unable to bring %s up: %v", name, err)
+		}
+	}
+
+	if err := checkVRF("gdvrf0"); err != nil {
+		t.Errorf("expected gdvrf0 to be a vrf, got %v", err)
+	}
+	if err := checkVRF("gdprobe5"); err == nil {
+		t.Error("expected gdprobe5 not to be a vrf")
+	}
+	if vrf := interfaceVRF("gdprobe4"); vrf != "gdvrf0" {
+		t.Errorf("expected gdprobe4 to belong to gdvrf0, got %q", vrf)
+	}
+
+	iface, err := getInterface(testLogger(t), "", member.Attrs().HardwareAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iface.Name != "gdprobe4" {
+		t.Errorf("expected the vrf member to be selected, got %s", iface.Name)
+	}
+
+	lc := net.ListenConfig{Control: func(network, address string, c syscall.RawConn) error {
+		return bindToDevice(c, "gdvrf0")
+	}}
+	conn, err := lc.ListenPacket(context.Background(), "udp4", "0.0.0.0:0")
+	if err != nil {
+		t.Fatalf("expected a socket to be bound to the vrf, got %v", err)
+	}
+	conn.Close()
+}
+
+// TestGetInterfaceByName selects interfaces by name, which unlike automatic
+// selection fails rather than falling back to another interface.
+func TestGetInterfaceByName(t *testing.T) {
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "gdprobe6"}, PeerName: "gdprobe7"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		if errors.Is(err, os.ErrPermission) || errors.Is(err, errors.ErrUnsupported) {
+			t.Skipf("unable to create a veth pair: %v", err)
+		}
+		t.Fatalf("unable to create a veth pair: %v", err)
+	}
+	t.Cleanup(func() { netlink.LinkDel(veth) })
+
+	// Loopback is never picked automatically, but may be named.
+	if iface, err := getInterface(testLogger(t), "lo", nil); err != nil || iface.Name != "lo" {
+		t.Errorf("expected lo to be selected by name, got %v, %v", iface, err)
+	}
+
+	if _, err := getInterface(testLogger(t), "gdmissing0", nil); err == nil || !strings.Contains(err.Error(), "gdprobe6") {
+		t.Errorf("expected a missing interface to fail, listing those there are, got %v", err)
+	}
+
+	if _, err := getInterface(testLogger(t), "gdprobe6", nil); err == nil || !strings.Contains(err.Error(), "down") {
+		t.Errorf("expected a down interface to fail, got %v", err)
+	}
+
+	link, err := netlink.LinkByName("gdprobe6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = getInterface(testLogger(t), "", link.Attrs().HardwareAddr)
+	if err == nil || !strings.Contains(err.Error(), "gdprobe6 (down)") {
+		t.Errorf("expected the error to say why gdprobe6 was skipped, got %v", err)
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		t.Fatalf("unable to bring gdprobe6 up: %v", err)
+	}
+	if iface, err := getInterface(testLogger(t), "gdprobe6", nil); err != nil || iface.Name != "gdprobe6" {
+		t.Errorf("expected gdprobe6 to be selected by name without an address, got %v, %v", iface, err)
+	}
+}
@@ -0,0 +1,246 @@
+package greedydhcp
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchInterfaceIndexDetectsChange(t *testing.T) {
+	iface := loopbackInterface(t)
+	stale := *iface
+	stale.Index = iface.Index + 1000
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchInterfaceIndex(ctx, testLogger(t), testMetrics, &stale, 10*time.Millisecond)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	waitFor(t, 5*time.Second, "index change to be counted", func() bool {
+		return metricValue(t, testMetrics.dhcpInterfaceIndexChangesTotal) >= 1
+	})
+
+	time.Sleep(50 * time.Millisecond)
+	if v := metricValue(t, testMetrics.dhcpInterfaceIndexChangesTotal); v != 1 {
+		t.Errorf("expected the change to be counted once, got %v", v)
+	}
+}
+
+func TestWatchInterfaceMACRestartsClients(t *testing.T) {
+	lo := loopbackInterface(t)
+	stale := *lo
+	stale.HardwareAddr = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x05}
+	cfg := &clientConfig{metrics: testMetrics, iface: &stale, ifaceChanged: newBroadcast()}
+	changed := cfg.ifaceChanged.wait()
+	before := metricValue(t, testMetrics.dhcpInterfaceMACChangesTotal)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchInterfaceMAC(ctx, testLogger(t), cfg, 10*time.Millisecond, macChangeRestart)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected clients to be told the interface changed")
+	}
+
+	current, err := net.InterfaceByName(lo.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.currentIface().HardwareAddr; got.String() != current.HardwareAddr.String() {
+		t.Errorf("expected clients to use the new MAC address %q, got %q", current.HardwareAddr, got)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if v := metricValue(t, testMetrics.dhcpInterfaceMACChangesTotal) - before; v != 1 {
+		t.Errorf("expected the change to be counted once, got %v", v)
+	}
+}
+
+func TestWatchInterfaceMACOnlyLogs(t *testing.T) {
+	lo := loopbackInterface(t)
+	stale := *lo
+	stale.HardwareAddr = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x06}
+	cfg := &clientConfig{metrics: testMetrics, iface: &stale, ifaceChanged: newBroadcast()}
+	changed := cfg.ifaceChanged.wait()
+	before := metricValue(t, testMetrics.dhcpInterfaceMACChangesTotal)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchInterfaceMAC(ctx, testLogger(t), cfg, 10*time.Millisecond, macChangeLog)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	waitFor(t, 5*time.Second, "MAC change to be counted", func() bool {
+		return metricValue(t, testMetrics.dhcpInterfaceMACChangesTotal)-before >= 1
+	})
+
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-changed:
+		t.Error("expected clients not to be restarted")
+	default:
+	}
+	if v := metricValue(t, testMetrics.dhcpInterfaceMACChangesTotal) - before; v != 1 {
+		t.Errorf("expected the change to be counted once, got %v", v)
+	}
+}
+
+func TestWatchInterfaceAddrsReselects(t *testing.T) {
+	lo := loopbackInterface(t)
+	gone := &net.Interface{Name: "greedydhcp-gone", Index: 1000}
+	cfg := &clientConfig{metrics: testMetrics, iface: gone, ifaceChanged: newBroadcast()}
+	changed := cfg.ifaceChanged.wait()
+	before := metricValue(t, testMetrics.dhcpInterfaceReselectionsTotal)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchInterfaceAddrs(ctx, testLogger(t), cfg, 10*time.Millisecond, 0, func() (*net.Interface, error) {
+			return lo, nil
+		})
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected clients to be told the interface changed")
+	}
+
+	if got := cfg.currentIface(); got.Name != lo.Name {
+		t.Errorf("expected to switch to %s, got %s", lo.Name, got.Name)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if v := metricValue(t, testMetrics.dhcpInterfaceReselectionsTotal) - before; v != 1 {
+		t.Errorf("expected a single reselection, got %v", v)
+	}
+}
+
+func TestWatchInterfaceAddrsWaitsOutGrace(t *testing.T) {
+	lo := loopbackInterface(t)
+	gone := &net.Interface{Name: "greedydhcp-gone", Index: 1000}
+	cfg := &clientConfig{metrics: testMetrics, iface: gone, ifaceChanged: newBroadcast()}
+	changed := cfg.ifaceChanged.wait()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	start := time.Now()
+	go func() {
+		watchInterfaceAddrs(ctx, testLogger(t), cfg, 10*time.Millisecond, 300*time.Millisecond, func() (*net.Interface, error) {
+			return lo, nil
+		})
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	select {
+	case <-changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected clients to be told the interface changed")
+	}
+
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("expected the interface to be kept for the grace period, switched after %s", elapsed)
+	}
+}
+
+func TestCrossInterfaceOwner(t *testing.T) {
+	mustParse := func(s string) *net.IPNet {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ipNet
+	}
+
+	subnets := map[string][]*net.IPNet{
+		"eth0": {mustParse("10.0.0.0/24")},
+		"eth1": {mustParse("10.1.0.0/24"), mustParse("10.2.0.0/24")},
+		"eth2": {},
+	}
+
+	tests := []struct {
+		own  string
+		addr string
+		want string
+	}{
+		{"eth0", "10.0.0.5", ""},
+		{"eth0", "10.2.0.5", "eth1"},
+		{"eth1", "10.0.0.5", "eth0"},
+		{"eth0", "192.168.0.5", ""},
+		{"eth2", "10.0.0.5", "eth0"},
+	}
+
+	for _, tt := range tests {
+		if got := crossInterfaceOwner(tt.own, subnets, net.ParseIP(tt.addr)); got != tt.want {
+			t.Errorf("%s on %s: expected %q, got %q", tt.addr, tt.own, tt.want, got)
+		}
+	}
+}
+
+func TestOffSubnetTargets(t *testing.T) {
+	addrs := []net.Addr{
+		&net.IPNet{IP: net.ParseIP("10.0.0.2"), Mask: net.CIDRMask(24, 32)},
+		&net.IPNet{IP: net.ParseIP("192.168.1.2"), Mask: net.CIDRMask(24, 32)},
+		&net.IPNet{IP: net.ParseIP("fd00::2"), Mask: net.CIDRMask(64, 128)},
+	}
+	targets := []targetConfig{
+		{addr: "10.0.0.5"},
+		{addr: "192.168.1.5"},
+		{addr: "10.1.0.5"},
+		{addr: "10.2.0.5", giaddr: net.ParseIP("10.2.0.1")},
+		{addr: "10.3.0.5", vlan: 10},
+		{addr: "10.4.0.5", netns: "/var/run/netns/blue"},
+	}
+
+	off, ok := offSubnetTargets(targets, addrs)
+	if !ok || len(off) != 1 || off[0] != "10.1.0.5" {
+		t.Errorf("expected only 10.1.0.5 to be off subnet, got %v and %v", off, ok)
+	}
+
+	if off, ok := offSubnetTargets(targets, addrs[2:]); ok || off != nil {
+		t.Errorf("expected nothing to be checked without an IPv4 address, got %v and %v", off, ok)
+	}
+}
+
+func TestCheckTargetSubnetsStrict(t *testing.T) {
+	iface := loopbackInterface(t)
+	targets := []targetConfig{{addr: "127.0.0.5"}, {addr: "10.100.0.5"}}
+
+	if err := checkTargetSubnets(testLogger(t), iface, targets, false); err != nil {
+		t.Errorf("expected only a warning, got %v", err)
+	}
+	if err := checkTargetSubnets(testLogger(t), iface, targets, true); err == nil || !strings.Contains(err.Error(), "10.100.0.5") || strings.Contains(err.Error(), "127.0.0.5") {
+		t.Errorf("expected an error naming only 10.100.0.5, got %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+//go:build linux
+
+package greedydhcp
+
+import (
+	"github.com/vishvananda/netlink"
+)
+
+// interfaceKind returns the type of the named interface, and the name of the
+// bridge or bond it is enslaved to, empty if it isn't.
+func interfaceKind(name string) (kind, master string, err error) {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch link.Type() {
+	case "bridge":
+		kind = ifaceTypeBridge
+	case "bond":
+		kind = ifaceTypeBond
+	case "vlan":
+		kind = ifaceTypeVLAN
+	case "device":
+		kind = ifaceTypePhysical
+	default:
+		kind = ifaceTypeVirtual
+	}
+
+	// Interfaces of a VRF are enslaved to it too, but only for routing:
+	// replies are still delivered to them.
+	if index := link.Attrs().MasterIndex; index != 0 {
+		if m, err := netlink.LinkByIndex(index); err == nil && (m.Type() == "bridge" || m.Type() == "bond") {
+			master = m.Attrs().Name
+		}
+	}
+
+	return kind, master, nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package greedydhcp
+
+// interfaceKind can't tell the type of an interface outside of Linux, nor
+// whether it is enslaved.
+func interfaceKind(name string) (kind, master string, err error) {
+	return ifaceTypeUnknown, "", nil
+}
@@ -0,0 +1,166 @@
+package greedydhcp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxTagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// influxField is a single field of a line.
+type influxField struct {
+	name  string
+	value float64
+}
+
+// renderLineProtocol renders families in InfluxDB line protocol, one line per
+// series timestamped with now. The measurement is the metric name and the
+// labels are its tags. Counters and gauges have a single "value" field,
+// histograms a "count", "sum" and a field per bucket upper bound, and
+// summaries a "count", "sum" and a field per quantile.
+func renderLineProtocol(families []*dto.MetricFamily, now time.Time) []byte {
+	var buf bytes.Buffer
+	ts := strconv.FormatInt(now.UnixNano(), 10)
+
+	for _, family := range families {
+		for _, m := range family.Metric {
+			fields := influxFields(family.GetType(), m)
+			if len(fields) == 0 {
+				continue
+			}
+
+			buf.WriteString(influxMeasurementEscaper.Replace(family.GetName()))
+			labels := append([]*dto.LabelPair{}, m.Label...)
+			sort.Slice(labels, func(i, j int) bool { return labels[i].GetName() < labels[j].GetName() })
+			for _, label := range labels {
+				// Influx has no empty tags, which is what a missing one is.
+				if label.GetValue() == "" {
+					continue
+				}
+				fmt.Fprintf(&buf, ",%s=%s", influxTagEscaper.Replace(label.GetName()), influxTagEscaper.Replace(label.GetValue()))
+			}
+
+			for i, field := range fields {
+				sep := ","
+				if i == 0 {
+					sep = " "
+				}
+				fmt.Fprintf(&buf, "%s%s=%s", sep, influxTagEscaper.Replace(field.name), strconv.FormatFloat(field.value, 'g', -1, 64))
+			}
+			fmt.Fprintf(&buf, " %s\n", ts)
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// influxFields returns the fields of m, leaving out values that Influx can't
+// store, such as NaN and the +Inf bucket bound.
+func influxFields(kind dto.MetricType, m *dto.Metric) []influxField {
+	var fields []influxField
+	add := func(name string, value float64) {
+		if !math.IsNaN(value) && !math.IsInf(value, 0) {
+			fields = append(fields, influxField{name, value})
+		}
+	}
+
+	switch kind {
+	case dto.MetricType_COUNTER:
+		add("value", m.GetCounter().GetValue())
+	case dto.MetricType_GAUGE:
+		add("value", m.GetGauge().GetValue())
+	case dto.MetricType_UNTYPED:
+		add("value", m.GetUntyped().GetValue())
+	case dto.MetricType_HISTOGRAM:
+		h := m.GetHistogram()
+		add("count", float64(h.GetSampleCount()))
+		add("sum", h.GetSampleSum())
+		for _, b := range h.GetBucket() {
+			if !math.IsInf(b.GetUpperBound(), 1) {
+				add(strconv.FormatFloat(b.GetUpperBound(), 'g', -1, 64), float64(b.GetCumulativeCount()))
+			}
+		}
+	case dto.MetricType_SUMMARY:
+		s := m.GetSummary()
+		add("count", float64(s.GetSampleCount()))
+		add("sum", s.GetSampleSum())
+		for _, q := range s.GetQuantile() {
+			add(strconv.FormatFloat(q.GetQuantile(), 'g', -1, 64), q.GetValue())
+		}
+	}
+
+	return fields
+}
+
+// runInfluxExporter renders the metrics of g in InfluxDB line protocol every
+// interval until ctx is done, writing them to stdout if output is "stdout" and
+// POSTing them to output as a URL, e.g. an InfluxDB or Telegraf write
+// endpoint, otherwise.
+func runInfluxExporter(ctx context.Context, logger *slog.Logger, g prometheus.Gatherer, output string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		families, err := g.Gather()
+		if err != nil {
+			logger.Warn("Unable to gather metrics for influx export", "err", err)
+			continue
+		}
+
+		if err := writeInflux(ctx, output, renderLineProtocol(families, time.Now())); err != nil {
+			logger.Warn("Unable to export metrics to influx", "output", output, "err", err)
+		}
+	}
+}
+
+// writeInflux writes lines to output, as described by runInfluxExporter.
+func writeInflux(ctx context.Context, output string, lines []byte) error {
+	if output == "stdout" {
+		_, err := os.Stdout.Write(lines)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, output, bytes.NewReader(lines))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package greedydhcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestRenderLineProtocol(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	gauge := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_gauge", Help: "help"}, []string{"ip", "team"})
+	gauge.WithLabelValues("10.0.0.1", "net ops").Set(2.5)
+	gauge.WithLabelValues("10.0.0.2", "").Set(1)
+	histogram := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "test_seconds", Help: "help", Buckets: []float64{0.5, 1}})
+	histogram.Observe(0.7)
+	reg.MustRegister(gauge, histogram)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := string(renderLineProtocol(families, time.Unix(1, 0)))
+	want := `test_gauge,ip=10.0.0.1,team=net\ ops value=2.5 1000000000
+test_gauge,ip=10.0.0.2 value=1 1000000000
+test_seconds count=1,sum=0.7,0.5=0,1=1 1000000000
+`
+	if got != want {
+		t.Errorf("unexpected lines:\n%s\nexpected:\n%s", got, want)
+	}
+}
@@ -0,0 +1,77 @@
+package greedydhcp
+
+import (
+	"log/slog"
+	"net"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+)
+
+// initRebootBound is the outcome of an INIT-REBOOT that got a lease, the
+// others being the failure reasons.
+const initRebootBound = "bound"
+
+// initReboot requests the address of a target in INIT-REBOOT the first time
+// its client starts, falling back to discovery if that fails. A nil
+// initReboot, of a target that doesn't, never requests an address.
+type initReboot struct {
+	logger     *slog.Logger
+	metrics    *metrics
+	targetAddr string
+	// rebooting is set until the request is answered, or fails.
+	rebooting bool
+}
+
+// newInitReboot returns the INIT-REBOOT of targetAddr, or nil if enabled
+// isn't set.
+func newInitReboot(logger *slog.Logger, m *metrics, targetAddr string, enabled bool) *initReboot {
+	if !enabled {
+		return nil
+	}
+
+	m.dhcpInitRebootTotal.WithLabelValues(targetAddr, initRebootBound).Add(0)
+	for _, reason := range failureReasons {
+		m.dhcpInitRebootTotal.WithLabelValues(targetAddr, reason).Add(0)
+	}
+
+	return &initReboot{logger: logger, metrics: m, targetAddr: targetAddr, rebooting: true}
+}
+
+// pending reports whether the INIT-REBOOT is still to be answered.
+func (r *initReboot) pending() bool {
+	return r != nil && r.rebooting
+}
+
+// request makes client, which is yet to be started, request the target
+// address in INIT-REBOOT, recording the outcome.
+func (r *initReboot) request(client *dhclient.Client) {
+	r.logger.Info("Requesting target address in INIT-REBOOT")
+	// As in squatting, starting with a lease skips the DISCOVER. Without a
+	// server ID the REQUEST is broadcast, as RFC 2131 has it in
+	// INIT-REBOOT.
+	client.Lease = &dhclient.Lease{FixedAddress: net.ParseIP(r.targetAddr).To4()}
+
+	onBound := client.OnBound
+	client.OnBound = func(lease *dhclient.Lease) {
+		if r.rebooting {
+			r.logger.Info("Got lease through INIT-REBOOT", "addr", lease.FixedAddress)
+			r.metrics.dhcpInitRebootTotal.WithLabelValues(r.targetAddr, initRebootBound).Inc()
+			r.rebooting = false
+		}
+		onBound(lease)
+	}
+
+	onError := client.OnError
+	client.OnError = func(err error) {
+		if r.rebooting {
+			reason := failureReason(err)
+			r.logger.Warn("INIT-REBOOT failed, falling back to discovery", "reason", reason, "err", err)
+			r.metrics.dhcpInitRebootTotal.WithLabelValues(r.targetAddr, reason).Inc()
+			// A NAK already dropped the lease, while a renewal would
+			// otherwise be retried after a timeout.
+			client.Lease = nil
+			r.rebooting = false
+		}
+		onError(err)
+	}
+}
@@ -0,0 +1,1084 @@
+package dhclient
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
+	"os"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/mdlayher/packet"
+)
+
+const responseTimeout = time.Second * 5
+
+// broadcastFlag asks the server to broadcast its replies (RFC 2131 section 2)
+const broadcastFlag = 0x8000
+
+var (
+	// ErrNAK is returned when the server rejects a request
+	ErrNAK = errors.New("received NAK")
+	// ErrDeclined is returned when a lease is declined by Client.Accept
+	ErrDeclined = errors.New("declined lease")
+	// ErrOfferRejected is returned when an offer is rejected by
+	// Client.AcceptOffer
+	ErrOfferRejected = errors.New("rejected offer")
+	// ErrAddressInUse can be wrapped by the error Client.AcceptOffer
+	// returns to have the offered address declined, as another host uses it
+	ErrAddressInUse = errors.New("address in use")
+	// ErrSocket is returned when the raw socket can't be opened
+	ErrSocket = errors.New("unable to open raw socket")
+	// ErrStopped is returned when the client is stopped while waiting to
+	// send
+	ErrStopped = errors.New("client stopped")
+	// ErrInvalidLeaseTime is returned when an ACK grants a lease time of
+	// zero, which would expire the lease as soon as it is bound
+	ErrInvalidLeaseTime = errors.New("invalid lease time of zero")
+)
+
+// InfiniteLeaseTime is the lease time, in seconds, of a lease that never
+// expires (RFC 2131 section 3.3)
+const InfiniteLeaseTime = 0xffffffff
+
+// Callback is a function called on certain events
+type Callback func(*Lease)
+
+// ErrorCallback is a function called when an attempt to acquire or renew a
+// lease fails
+type ErrorCallback func(error)
+
+// ReplyCallback is a function called when a reply to a sent packet is
+// received, with the time since the packet was last transmitted
+type ReplyCallback func(sent, received layers.DHCPMsgType, rtt time.Duration)
+
+// DuplicateCallback is a function called when another ACK to a REQUEST
+// arrives after the first one was bound, with both leases
+type DuplicateCallback func(first, duplicate *Lease)
+
+// SendCallback is a function called when a packet has been sent, with the
+// size of the DHCP message in bytes
+type SendCallback func(msgType layers.DHCPMsgType, size int)
+
+// FilterReason says why a received packet was dropped
+type FilterReason string
+
+// Reasons passed to a FilterCallback
+const (
+	FilterWrongXID    FilterReason = "wrong_xid"    // A reply to another transaction
+	FilterWrongChaddr FilterReason = "wrong_chaddr" // A reply for another hardware address
+	FilterMalformed   FilterReason = "malformed"    // Sent to the client port but not valid DHCP
+	FilterWrongOp     FilterReason = "wrong_op"     // Sent to the client port with op BOOTREQUEST
+	// A BOOTREPLY without a message type, or with one servers don't send
+	FilterBadMessageType FilterReason = "bad_message_type"
+)
+
+// MalformedReasons are the FilterReasons of replies dropped for being
+// malformed rather than for another client
+var MalformedReasons = []FilterReason{FilterMalformed, FilterWrongOp, FilterBadMessageType}
+
+// Malformed reports whether reason is one of MalformedReasons
+func (reason FilterReason) Malformed() bool {
+	for _, r := range MalformedReasons {
+		if r == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// BytesCallback is a function called with the length of a frame sent or
+// received, Ethernet header included
+type BytesCallback func(n int)
+
+// TruncatedReadCallback is a function called when a received packet didn't
+// fit the receive buffer, with its length and the size of the buffer
+type TruncatedReadCallback func(length, size int)
+
+// FilterCallback is a function called when a received packet is dropped
+// while waiting for a reply
+type FilterCallback func(FilterReason)
+
+// PanicCallback is a function called with the value and stack of a panic
+// recovered in the client's goroutine
+type PanicCallback func(v any, stack []byte)
+
+// State is a state of the client, after those of RFC 2131 section 4.4
+type State string
+
+// States passed to a StateCallback
+const (
+	StateInit       State = "init"       // Without a lease, between attempts to acquire one
+	StateSelecting  State = "selecting"  // Waiting for an offer
+	StateRequesting State = "requesting" // Requesting an offered or already known address
+	StateBound      State = "bound"      // Holding a lease until T1
+	StateRenewing   State = "renewing"   // Renewing a held lease past T1
+	StateRebinding  State = "rebinding"  // Acquiring a lease again past T2, while still holding one
+)
+
+// States lists every State
+var States = []State{StateInit, StateSelecting, StateRequesting, StateBound, StateRenewing, StateRebinding}
+
+// StateCallback is a function called when the client moves to another state
+type StateCallback func(State)
+
+// AcceptFunc is called with an acknowledged lease before it is bound. Returning
+// an error declines the lease.
+type AcceptFunc func(*Lease) error
+
+// Quirks alters the encoding of the options of sent messages
+type Quirks struct {
+	// PadOptions is the number of pad options (code 0) sent before the end
+	// option.
+	PadOptions int
+	// TrailingPadding is the number of zero bytes sent after the end option.
+	TrailingPadding int
+	// OmitEnd leaves out the end option (code 255).
+	OmitEnd bool
+}
+
+// Client is a DHCP client instance
+type Client struct {
+	Hostname string
+	Iface    *net.Interface
+	Lease    *Lease         // The current lease
+	OnOffer  Callback       // On receipt of an offer
+	OnBound  Callback       // On renew or rebound
+	OnExpire Callback       // On expiration of a lease
+	OnError  ErrorCallback  // On failure to acquire or renew a lease
+	OnNAK    Callback       // On receipt of a NAK, with the NAK as the lease
+	OnReply  ReplyCallback  // On receipt of a reply to a DISCOVER or REQUEST
+	OnFilter FilterCallback // On dropping a received packet
+	OnSend   SendCallback   // On sending a packet
+	OnState  StateCallback  // On moving to another state, starting with StateInit
+	// On receipt of another ACK to a REQUEST within DuplicateWindow
+	OnDuplicate DuplicateCallback
+	// On receipt of a packet cut short by the receive buffer, which is still
+	// handled as far as it was read
+	OnTruncatedRead TruncatedReadCallback
+	// On sending a frame, and on receiving one that passed the transaction
+	// ID and chaddr checks, as those for other clients sharing the
+	// interface aren't this client's traffic
+	OnBytesSent     BytesCallback
+	OnBytesReceived BytesCallback
+	// On a panic in the client's goroutine, such as in a callback. If set,
+	// the panic is recovered and the client stops running, but Stop must
+	// still be called. Otherwise the panic crashes the program.
+	OnPanic     PanicCallback
+	Accept      AcceptFunc // Decides whether an acknowledged lease is bound
+	AcceptOffer AcceptFunc // Decides whether an offer is requested
+	DHCPOptions []Option   // List of options to send on discovery and requests
+	Logger      *slog.Logger
+	TOS         uint8 // IP type of service byte set on sent packets
+
+	// XIDFunc, if set, returns the transaction ID of each exchange instead
+	// of a random one.
+	XIDFunc func() uint32
+
+	// HardwareAddr, if set, is sent as the client hardware address instead
+	// of the interface's. Replies are then requested to be broadcast, as
+	// they would otherwise be sent to an address the interface doesn't have.
+	HardwareAddr net.HardwareAddr
+
+	// Retransmits is the number of times an unanswered DISCOVER or REQUEST
+	// is sent again before the attempt fails.
+	Retransmits int
+	// RetransmitTimeout is how long to wait for a reply to each
+	// transmission. Defaults to 5 seconds.
+	RetransmitTimeout time.Duration
+
+	// ReceiveBufferSize is the size of the buffer each received packet is
+	// read into, Ethernet header included. Defaults to the interface MTU
+	// plus the Ethernet header and a VLAN tag, and at least enough for a
+	// 1500 byte packet.
+	ReceiveBufferSize int
+
+	// RequestDelay, if set, is how long to wait after an offer before
+	// requesting it, to probe how long servers hold their offers.
+	RequestDelay time.Duration
+
+	// DuplicateWindow, if set, is how long to keep listening after an ACK
+	// for further ACKs to the same REQUEST, as both servers of a failover
+	// pair may send. The first ACK is always the one bound, the others are
+	// only reported to OnDuplicate. Late replies to a retransmitted REQUEST
+	// are reported too, as they share its transaction ID. Stopping the
+	// client waits for the window to end.
+	DuplicateWindow time.Duration
+
+	// RenewJitter is the most a renewal is randomly delayed past T1, so that
+	// leases bound at the same time don't all renew together. Renewals are
+	// never delayed past T2.
+	RenewJitter time.Duration
+
+	// RetryDelay, if set, returns how long to wait after a failed attempt
+	// before the next one, in place of a second. After a NAK the next
+	// attempt is always a fresh DISCOVER, the lease having been dropped.
+	RetryDelay func(err error) time.Duration
+
+	// RenewAt, if set, returns when a lease is renewed in place of its T1,
+	// such as a time scaled to the length of the lease. Renewals are never
+	// scheduled before the lease was bound nor past T2.
+	RenewAt func(*Lease) time.Time
+
+	// Server, if set, restricts the client to offers from this server and
+	// unicasts requests to it once its hardware address is known.
+	Server net.IP
+
+	// RelayAddr, if set, is sent in the giaddr field, so that servers treat
+	// messages as relayed from its subnet and send their replies to it.
+	RelayAddr net.IP
+
+	// Secs is sent in the secs field of every DISCOVER and REQUEST. If
+	// SecsElapsed is set, the seconds since the client started trying to
+	// acquire or renew the lease are added to it.
+	Secs        uint16
+	SecsElapsed bool
+
+	// Pace, if set, is called before every packet is sent and may block to
+	// limit the send rate. It returns false if stop is closed first, in
+	// which case the packet isn't sent.
+	Pace func(stop <-chan struct{}) bool
+
+	// Listen, if set, opens the raw socket for each transaction instead of
+	// packet.Listen, e.g. to open it in another network namespace.
+	Listen func(iface *net.Interface) (*packet.Conn, error)
+
+	// Quirks, if set, makes sent messages deliberately non-standard, to
+	// probe how strictly servers parse them.
+	Quirks *Quirks
+
+	conn      *packet.Conn     // Raw socket
+	serverMAC net.HardwareAddr // Hardware address of Server, learned from its replies
+	xid       uint32           // Transaction ID
+	trying    time.Time        // When the current attempt to acquire or renew began
+	state     State
+	rebind    bool
+	shutdown  atomic.Bool
+	notify    chan struct{}  // Is closed on shutdown
+	wg        sync.WaitGroup // For graceful shutdown
+}
+
+// Lease is an assignment by the DHCP server
+type Lease struct {
+	ServerID     net.IP
+	FixedAddress net.IP
+	Netmask      net.IPMask
+	NextServer   net.IP
+	Broadcast    net.IP
+	Router       []net.IP
+	DNS          []net.IP
+	TimeServer   []net.IP
+	NTPServers   []net.IP
+	DomainName   string
+	MTU          uint16
+	Message      string // option 56, a message from the server
+
+	// Domain search list, from option 119. DomainSearchErr is set instead
+	// if the option was sent but could not be decoded.
+	DomainSearch    []string
+	DomainSearchErr error
+
+	// Boot settings, from the BOOTP header fields and their option forms
+	ServerName     string // sname header field
+	BootFile       string // file header field
+	TFTPServerName string // option 66
+	BootFileName   string // option 67
+
+	// Other options
+	OtherOptions []Option
+	// Codes of every option the lease was sent with, in order
+	OptionCodes []layers.DHCPOpt
+	// Size of the options the lease was sent with, as encoded
+	OptionBytes int
+	// Set if the options ran to the end of the reply without an end option,
+	// as they do when a server truncates it to fit a message size
+	Truncated bool
+
+	XID uint32 // Transaction ID of the reply the lease came from
+	// giaddr of the reply the lease came from, set if it was relayed
+	RelayAddr net.IP
+
+	// Set if the lease time is InfiniteLeaseTime. The lease is then never
+	// renewed nor expires, and Renew, Rebind and Expire are zero.
+	Infinite bool
+
+	Bound  time.Time
+	Renew  time.Time
+	Rebind time.Time
+	Expire time.Time
+}
+
+// Boot options gopacket has no constants for
+const (
+	OptTFTPServerName layers.DHCPOpt = 66
+	OptBootFileName   layers.DHCPOpt = 67
+)
+
+// DefaultParamsRequestList is a list of params to be requested from the server
+var DefaultParamsRequestList = []layers.DHCPOpt{
+	layers.DHCPOptSubnetMask,   // Subnet Mask
+	layers.DHCPOptRouter,       // Router
+	layers.DHCPOptTimeServer,   // Time Server
+	layers.DHCPOptDNS,          // Domain Name Server
+	layers.DHCPOptDomainName,   // Domain Name
+	layers.DHCPOptInterfaceMTU, // Interface MTU
+	layers.DHCPOptNTPServers,   // Network Time Protocol Servers
+}
+
+// AddOption adds an DHCP option
+func (client *Client) AddOption(optType layers.DHCPOpt, data []byte) {
+	client.DHCPOptions = append(client.DHCPOptions, Option{optType, data})
+}
+
+// AddParamRequest adds an parameter to parameter request list, if not included yet.
+func (client *Client) AddParamRequest(dhcpOpt layers.DHCPOpt) {
+
+	// search for existing parameter request list
+	for i := range client.DHCPOptions {
+		if client.DHCPOptions[i].Type == layers.DHCPOptParamsRequest {
+			// extend existing list
+			client.DHCPOptions[i].AddByte(byte(dhcpOpt))
+			return
+		}
+	}
+
+	// option not added yet
+	client.AddOption(layers.DHCPOptParamsRequest, []byte{byte(dhcpOpt)})
+}
+
+// Start starts the client
+func (client *Client) Start() {
+	if client.Logger == nil {
+		client.Logger = slog.New(&discardHandler{})
+	}
+
+	// Add default DHCP options if none added yet.
+	if client.DHCPOptions == nil {
+		for _, param := range DefaultParamsRequestList {
+			client.AddParamRequest(param)
+		}
+		client.AddOption(layers.DHCPOptHostname, []byte(client.Hostname))
+	}
+
+	if client.notify != nil {
+		panic(fmt.Sprintf("client for %s already started", client.Iface.Name))
+	}
+	client.notify = make(chan struct{})
+	client.setState(StateInit)
+	client.wg.Add(1)
+	go client.run()
+}
+
+// Stop stops the client. Stopping it again does nothing.
+func (client *Client) Stop() {
+	if !client.shutdown.CompareAndSwap(false, true) {
+		return
+	}
+	client.Logger.Debug("shutting down dhclient")
+	close(client.notify)
+
+	client.wg.Wait()
+}
+
+// Renew triggers the renewal of the current lease
+func (client *Client) Renew() {
+	select {
+	case client.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Rebind forgets the current lease and triggers acquirement of a new one
+func (client *Client) Rebind() {
+	client.rebind = true
+	client.Lease = nil
+	client.Renew()
+}
+
+func (client *Client) run() {
+	defer client.wg.Done()
+	if cb := client.OnPanic; cb != nil {
+		defer func() {
+			if v := recover(); v != nil {
+				cb(v, debug.Stack())
+			}
+		}()
+	}
+
+	for !client.shutdown.Load() {
+		client.runOnce()
+	}
+}
+
+func (client *Client) runOnce() {
+	if client.trying.IsZero() {
+		client.trying = time.Now()
+	}
+
+	var err error
+	if client.Lease == nil || client.rebind {
+		if client.Lease != nil {
+			client.setState(StateRebinding)
+		}
+		// request new lease
+		err = client.withConnection(client.discoverAndRequest)
+		if err == nil {
+			// try to renew the lease in the future
+			client.rebind = false
+		}
+	} else {
+		// renew existing lease, or request one given rather than bound
+		if client.state == StateBound || client.state == StateRenewing {
+			client.setState(StateRenewing)
+		} else {
+			client.setState(StateRequesting)
+		}
+		err = client.withConnection(client.renew)
+	}
+
+	if errors.Is(err, ErrStopped) {
+		return
+	}
+
+	if err != nil {
+		client.Logger.Error("failed to acquire lease", "error", err)
+		if client.Lease == nil {
+			client.setState(StateInit)
+		}
+		if cb := client.OnError; cb != nil {
+			cb(err)
+		}
+		delay := time.Second
+		if retryDelay := client.RetryDelay; retryDelay != nil {
+			delay = retryDelay(err)
+		}
+		select {
+		case <-client.notify:
+		case <-time.After(delay):
+		}
+		return
+	}
+
+	client.trying = time.Time{}
+
+	if client.Lease.Infinite {
+		// only renewed or rebound on demand
+		<-client.notify
+		return
+	}
+
+	renew := client.jitteredRenewTime(client.Lease)
+
+	select {
+	case <-client.notify:
+		return
+	case <-time.After(time.Until(client.Lease.Expire)):
+		// remove lease and request a new one
+		client.unbound()
+	case <-time.After(time.Until(client.Lease.Rebind)):
+		// keep lease and request a new one
+		client.rebind = true
+	case <-time.After(time.Until(renew)):
+		// renew the lease
+	}
+}
+
+// jitteredRenewTime returns when lease is renewed: its RenewTime, delayed by
+// up to RenewJitter but no later than T2
+func (client *Client) jitteredRenewTime(lease *Lease) time.Time {
+	renew := client.RenewTime(lease)
+	if client.RenewJitter <= 0 {
+		return renew
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(client.RenewJitter)))
+	if limit := lease.Rebind.Sub(renew); jitter > limit {
+		jitter = limit
+	}
+	client.Logger.Debug("applying renewal jitter", "jitter", jitter)
+
+	return renew.Add(jitter)
+}
+
+// RenewTime returns when lease is scheduled to be renewed, before any
+// jitter: its T1, unless RenewAt says otherwise. It is zero for an infinite
+// lease.
+func (client *Client) RenewTime(lease *Lease) time.Time {
+	if lease.Infinite || client.RenewAt == nil {
+		return lease.Renew
+	}
+
+	renew := client.RenewAt(lease)
+	if renew.Before(lease.Bound) {
+		renew = lease.Bound
+	}
+	if renew.After(lease.Rebind) {
+		renew = lease.Rebind
+	}
+
+	return renew
+}
+
+// setState moves the client to state
+func (client *Client) setState(state State) {
+	if state == client.state {
+		return
+	}
+	client.state = state
+	if cb := client.OnState; cb != nil {
+		cb(state)
+	}
+}
+
+// unbound removes the lease
+func (client *Client) unbound() {
+	client.setState(StateInit)
+	if cb := client.OnExpire; cb != nil {
+		cb(client.Lease)
+	}
+	client.Lease = nil
+}
+
+// ListenRaw opens the raw socket used by a client on iface.
+func ListenRaw(iface *net.Interface) (*packet.Conn, error) {
+	return packet.Listen(iface, packet.Raw, int(layers.EthernetTypeIPv4), nil)
+}
+
+func (client *Client) withConnection(f func() error) error {
+	listen := client.Listen
+	if listen == nil {
+		listen = ListenRaw
+	}
+	conn, err := listen(client.Iface)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSocket, err)
+	}
+	client.conn = conn
+	if client.XIDFunc != nil {
+		client.xid = client.XIDFunc()
+	} else {
+		client.xid = rand.Uint32()
+	}
+	client.Logger.Debug("starting transaction", "xid", fmt.Sprintf("%#08x", client.xid))
+
+	defer func() {
+		client.conn.Close()
+		client.conn = nil
+	}()
+
+	return f()
+}
+
+func (client *Client) discoverAndRequest() error {
+	// Rebinding goes through the same exchange, but keeps the lease held
+	rebinding := client.state == StateRebinding
+	if !rebinding {
+		client.setState(StateSelecting)
+	}
+	lease, err := client.discover()
+	if err != nil {
+		return err
+	}
+	if client.RequestDelay > 0 {
+		client.Logger.Debug("delaying request", "delay", client.RequestDelay)
+		select {
+		case <-client.notify:
+			return ErrStopped
+		case <-time.After(client.RequestDelay):
+		}
+	}
+	if !rebinding {
+		client.setState(StateRequesting)
+	}
+	return client.request(lease)
+}
+
+func (client *Client) renew() error {
+	return client.request(client.Lease)
+}
+
+func (client *Client) discover() (*Lease, error) {
+	_, lease, err := client.exchange(layers.DHCPMsgTypeDiscover, client.DHCPOptions, layers.DHCPMsgTypeOffer)
+	if err != nil {
+		return nil, err
+	}
+
+	if cb := client.OnOffer; cb != nil {
+		cb(lease)
+	}
+
+	// a rejected offer is left to lapse, which is all a server expects of a
+	// client that doesn't want it, unless the address is in use, which the
+	// server is told about so that it offers another
+	if accept := client.AcceptOffer; accept != nil {
+		if reason := accept(lease); reason != nil {
+			err := fmt.Errorf("%w: %w", ErrOfferRejected, reason)
+			if errors.Is(reason, ErrAddressInUse) {
+				if declineErr := client.decline(lease); declineErr != nil {
+					err = errors.Join(err, declineErr)
+				}
+			}
+			return nil, err
+		}
+	}
+
+	return lease, nil
+}
+
+// requestOptions returns the options of a REQUEST for lease. Its address
+// replaces any requested address among the client's options, such as one
+// asked for in the DISCOVER, as servers only read the first.
+func (client *Client) requestOptions(lease *Lease) []Option {
+	options := make([]Option, 0, len(client.DHCPOptions)+2)
+	for _, option := range client.DHCPOptions {
+		if option.Type != layers.DHCPOptRequestIP {
+			options = append(options, option)
+		}
+	}
+	options = append(options, Option{layers.DHCPOptRequestIP, []byte(lease.FixedAddress)})
+	// A lease that wasn't offered, such as one being requested straight
+	// away, may not know its server.
+	if lease.ServerID != nil {
+		options = append(options, Option{layers.DHCPOptServerID, []byte(lease.ServerID)})
+	}
+
+	return options
+}
+
+func (client *Client) request(lease *Lease) error {
+	options := client.requestOptions(lease)
+	msgType, lease, err := client.exchange(layers.DHCPMsgTypeRequest, options, layers.DHCPMsgTypeAck, layers.DHCPMsgTypeNak)
+	if err != nil {
+		return err
+	}
+
+	switch msgType {
+	case layers.DHCPMsgTypeAck:
+		if lease.Infinite {
+			// whatever T1 and T2 say, there is nothing to renew
+			lease.Renew, lease.Rebind = time.Time{}, time.Time{}
+		} else {
+			if lease.Expire.IsZero() {
+				err = errors.New("expire value is zero")
+				break
+			}
+			if !lease.Expire.After(lease.Bound) {
+				err = ErrInvalidLeaseTime
+				break
+			}
+			// support DHCP servers that do not send option 58 and 59
+			// this is using the Microsoft suggested defaults
+			if lease.Renew.IsZero() {
+				lease.Renew = lease.Bound.Add(lease.Expire.Sub(lease.Bound) / 2)
+			}
+			if lease.Rebind.IsZero() {
+				lease.Rebind = lease.Bound.Add(lease.Expire.Sub(lease.Bound) / 1000 * 875)
+			}
+		}
+
+		if accept := client.Accept; accept != nil {
+			if reason := accept(lease); reason != nil {
+				err = fmt.Errorf("%w: %w", ErrDeclined, reason)
+				if declineErr := client.decline(lease); declineErr != nil {
+					err = errors.Join(err, declineErr)
+				}
+				if client.Lease != nil {
+					client.unbound()
+				}
+				break
+			}
+		}
+
+		client.Lease = lease
+		client.setState(StateBound)
+
+		// call the handler
+		if cb := client.OnBound; cb != nil {
+			cb(lease)
+		}
+
+		client.watchDuplicates(lease)
+	case layers.DHCPMsgTypeNak:
+		err = ErrNAK
+		if lease.Message != "" {
+			err = fmt.Errorf("%w: %q", ErrNAK, lease.Message)
+		}
+		if cb := client.OnNAK; cb != nil {
+			cb(lease)
+		}
+		client.unbound()
+	default:
+		err = fmt.Errorf("unexpected response: %s", msgType.String())
+	}
+
+	return err
+}
+
+// exchange sends a DHCP packet and waits for a reply of one of the given
+// types, retransmitting it up to client.Retransmits times if none arrives
+func (client *Client) exchange(msgType layers.DHCPMsgType, options []Option, replyTypes ...layers.DHCPMsgType) (layers.DHCPMsgType, *Lease, error) {
+	for attempt := 0; ; attempt++ {
+		sent := time.Now()
+		if err := client.sendPacket(msgType, options); err != nil {
+			return 0, nil, err
+		}
+
+		replyType, lease, err := client.waitForResponse(replyTypes...)
+		if errors.Is(err, os.ErrDeadlineExceeded) && attempt < client.Retransmits {
+			client.Logger.Debug("no reply, retransmitting", "type", msgType, "attempt", attempt+1)
+			continue
+		}
+
+		if cb := client.OnReply; cb != nil && err == nil {
+			cb(msgType, replyType, time.Since(sent))
+		}
+
+		return replyType, lease, err
+	}
+}
+
+// watchDuplicates keeps listening for DuplicateWindow after first was bound,
+// reporting every further ACK with the same transaction ID
+func (client *Client) watchDuplicates(first *Lease) {
+	if client.DuplicateWindow <= 0 {
+		return
+	}
+
+	deadline := time.Now().Add(client.DuplicateWindow)
+	for {
+		_, lease, err := client.waitForResponseUntil(deadline, layers.DHCPMsgTypeAck)
+		if err != nil {
+			return
+		}
+		client.Logger.Debug("ignoring duplicate ack", "server", lease.ServerID, "address", lease.FixedAddress)
+		if cb := client.OnDuplicate; cb != nil {
+			cb(first, lease)
+		}
+	}
+}
+
+// Release tells the server that the current lease is no longer needed and
+// forgets it. It must only be called once the client is stopped. Unlike the
+// packets sent while running, it waits for Pace however long it takes.
+func (client *Client) Release() error {
+	lease := client.Lease
+	if lease == nil {
+		return nil
+	}
+
+	err := client.withConnection(func() error {
+		dhcp := client.newPacket(layers.DHCPMsgTypeRelease, []Option{
+			{layers.DHCPOptServerID, []byte(lease.ServerID.To4())},
+		})
+		dhcp.ClientIP = lease.FixedAddress.To4()
+
+		// RELEASE is always unicast, but the server's hardware address is
+		// only known if replies are restricted to it.
+		if client.serverMAC != nil {
+			client.Logger.Debug("sending packet", "type", layers.DHCPMsgTypeRelease, "server", lease.ServerID)
+			return client.send(dhcp, lease.ServerID, client.serverMAC, nil)
+		}
+
+		client.Logger.Debug("sending packet", "type", layers.DHCPMsgTypeRelease)
+		return client.sendMulticast(dhcp, nil)
+	})
+
+	client.Lease = nil
+	return err
+}
+
+// decline tells the server that the lease won't be used
+func (client *Client) decline(lease *Lease) error {
+	return client.sendPacket(layers.DHCPMsgTypeDecline, []Option{
+		{layers.DHCPOptRequestIP, []byte(lease.FixedAddress.To4())},
+		{layers.DHCPOptServerID, []byte(lease.ServerID.To4())},
+	})
+}
+
+// sendPacket creates and sends a DHCP packet
+func (client *Client) sendPacket(msgType layers.DHCPMsgType, options []Option) error {
+	dhcp := client.newPacket(msgType, options)
+	if msgType == layers.DHCPMsgTypeRequest && client.Server != nil && client.serverMAC != nil {
+		client.Logger.Debug("sending packet", "type", msgType, "server", client.Server)
+		return client.send(dhcp, client.Server, client.serverMAC, client.notify)
+	}
+
+	client.Logger.Debug("sending packet", "type", msgType)
+	return client.sendMulticast(dhcp, client.notify)
+}
+
+// secs returns the value of the secs field, saturating at its maximum
+func (client *Client) secs() uint16 {
+	secs := int64(client.Secs)
+	if client.SecsElapsed && !client.trying.IsZero() {
+		secs += int64(time.Since(client.trying) / time.Second)
+	}
+	if secs > math.MaxUint16 {
+		secs = math.MaxUint16
+	}
+
+	if secs > 0 {
+		client.Logger.Debug("setting secs", "secs", secs)
+	}
+	return uint16(secs)
+}
+
+// newPacket creates a DHCP packet
+func (client *Client) newPacket(msgType layers.DHCPMsgType, options []Option) *layers.DHCPv4 {
+	packet := layers.DHCPv4{
+		Operation:    layers.DHCPOpRequest,
+		HardwareType: layers.LinkTypeEthernet,
+		ClientHWAddr: client.Iface.HardwareAddr,
+		Xid:          client.xid, // Transaction ID
+	}
+
+	if client.HardwareAddr != nil && !bytes.Equal(client.HardwareAddr, client.Iface.HardwareAddr) {
+		packet.ClientHWAddr = client.HardwareAddr
+		packet.Flags = broadcastFlag
+	}
+
+	if msgType == layers.DHCPMsgTypeDiscover || msgType == layers.DHCPMsgTypeRequest {
+		packet.Secs = client.secs()
+	}
+
+	if client.RelayAddr != nil {
+		packet.RelayAgentIP = client.RelayAddr.To4()
+	}
+
+	packet.Options = append(packet.Options, layers.DHCPOption{
+		Type:   layers.DHCPOptMessageType,
+		Data:   []byte{byte(msgType)},
+		Length: 1,
+	})
+
+	// append DHCP options
+	for _, option := range options {
+		packet.Options = append(packet.Options, layers.DHCPOption{
+			Type:   option.Type,
+			Data:   option.Data,
+			Length: uint8(len(option.Data)),
+		})
+	}
+
+	return &packet
+}
+
+func (client *Client) sendMulticast(dhcp *layers.DHCPv4, stop <-chan struct{}) error {
+	return client.send(dhcp, net.IP{255, 255, 255, 255}, layers.EthernetBroadcast, stop)
+}
+
+// send sends a DHCP packet to the given addresses, unless stop is closed while
+// waiting for Pace
+func (client *Client) send(dhcp *layers.DHCPv4, dstIP net.IP, dstMAC net.HardwareAddr, stop <-chan struct{}) error {
+	eth := layers.Ethernet{
+		EthernetType: layers.EthernetTypeIPv4,
+		SrcMAC:       client.Iface.HardwareAddr,
+		DstMAC:       dstMAC,
+	}
+	ip := layers.IPv4{
+		Version:  4,
+		TOS:      client.TOS,
+		TTL:      64,
+		SrcIP:    []byte{0, 0, 0, 0},
+		DstIP:    dstIP.To4(),
+		Protocol: layers.IPProtocolUDP,
+	}
+	udp := layers.UDP{
+		SrcPort: 68,
+		DstPort: 67,
+	}
+
+	// Serialize packet
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{
+		ComputeChecksums: true,
+		FixLengths:       true,
+	}
+	udp.SetNetworkLayerForChecksum(&ip)
+	var payload gopacket.SerializableLayer = dhcp
+	if client.Quirks != nil {
+		data, err := client.Quirks.encode(dhcp)
+		if err != nil {
+			return err
+		}
+		client.Logger.Debug("sending message with quirks", "options", fmt.Sprintf("%x", data[240:]))
+		payload = gopacket.Payload(data)
+	}
+	err := gopacket.SerializeLayers(buf, opts, &eth, &ip, &udp, payload)
+	if err != nil {
+		return err
+	}
+
+	if pace := client.Pace; pace != nil && !pace(stop) {
+		return ErrStopped
+	}
+
+	// Send packet
+	_, err = client.conn.WriteTo(buf.Bytes(), &packet.Addr{HardwareAddr: eth.DstMAC})
+	if err != nil {
+		return err
+	}
+
+	if cb := client.OnBytesSent; cb != nil {
+		cb(len(buf.Bytes()))
+	}
+
+	if cb := client.OnSend; cb != nil {
+		// The UDP length is fixed up during serialization, and covers
+		// just its header besides the DHCP message.
+		cb(messageType(dhcp), int(udp.Length)-8)
+	}
+	return nil
+}
+
+// encode serializes dhcp with the quirks applied
+func (q *Quirks) encode(dhcp *layers.DHCPv4) ([]byte, error) {
+	quirky := *dhcp
+	quirky.Options = append([]layers.DHCPOption{}, dhcp.Options...)
+	for i := 0; i < q.PadOptions; i++ {
+		quirky.Options = append(quirky.Options, layers.DHCPOption{Type: layers.DHCPOptPad})
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := quirky.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		return nil, err
+	}
+
+	data := buf.Bytes()
+	if q.OmitEnd {
+		// The end option is always the last byte written.
+		data = data[:len(data)-1]
+	}
+
+	return append(data, make([]byte, q.TrailingPadding)...), nil
+}
+
+// Chaddr returns the client hardware address sent in requests: HardwareAddr
+// if set, or that of Iface otherwise
+func (client *Client) Chaddr() net.HardwareAddr {
+	if client.HardwareAddr != nil {
+		return client.HardwareAddr
+	}
+	return client.Iface.HardwareAddr
+}
+
+// filtered reports a dropped packet to OnFilter
+func (client *Client) filtered(reason FilterReason) {
+	if cb := client.OnFilter; cb != nil {
+		cb(reason)
+	}
+}
+
+// malformed reports a malformed reply to OnFilter, logging a sample of it
+func (client *Client) malformed(reason FilterReason, reply *layers.DHCPv4) {
+	client.Logger.Debug(
+		"dropping malformed reply", "reason", reason, "op", reply.Operation,
+		"type", messageType(reply), "xid", reply.Xid, "chaddr", reply.ClientHWAddr,
+	)
+	client.filtered(reason)
+}
+
+// receiveBufferSize returns the size of the buffer to read packets into
+func (client *Client) receiveBufferSize() int {
+	if client.ReceiveBufferSize > 0 {
+		return client.ReceiveBufferSize
+	}
+
+	// Ethernet header and VLAN tag
+	const overhead = 14 + 4
+	return max(client.Iface.MTU, 1500) + overhead
+}
+
+// waitForResponse waits for a DHCP packet with matching transaction ID and the given message type
+func (client *Client) waitForResponse(msgTypes ...layers.DHCPMsgType) (layers.DHCPMsgType, *Lease, error) {
+	timeout := client.RetransmitTimeout
+	if timeout <= 0 {
+		timeout = responseTimeout
+	}
+	return client.waitForResponseUntil(time.Now().Add(timeout), msgTypes...)
+}
+
+// waitForResponseUntil waits for a DHCP packet with matching transaction ID
+// and the given message type until deadline
+func (client *Client) waitForResponseUntil(deadline time.Time, msgTypes ...layers.DHCPMsgType) (layers.DHCPMsgType, *Lease, error) {
+	client.conn.SetReadDeadline(deadline)
+
+	recvBuf := make([]byte, client.receiveBufferSize())
+	for {
+		n, length, addr, err := readFrom(client.conn, recvBuf)
+
+		if err != nil {
+			return 0, nil, err
+		}
+		if length > n {
+			client.Logger.Debug("received packet larger than the receive buffer", "length", length, "size", len(recvBuf))
+			if cb := client.OnTruncatedRead; cb != nil {
+				cb(length, len(recvBuf))
+			}
+		}
+
+		reply, toClient := parsePacket(recvBuf[:n])
+		if reply == nil {
+			if toClient {
+				client.filtered(FilterMalformed)
+			}
+			continue
+		}
+		if reply.Operation != layers.DHCPOpReply {
+			// Requests on their way to servers, our own included, are
+			// seen too
+			if toClient {
+				client.malformed(FilterWrongOp, reply)
+			}
+			continue
+		}
+		if reply.Xid != client.xid {
+			client.filtered(FilterWrongXID)
+			continue
+		}
+		if !bytes.Equal(reply.ClientHWAddr, client.Chaddr()) {
+			client.filtered(FilterWrongChaddr)
+			continue
+		}
+		// Checked once the reply is known to be ours, so that BOOTP replies
+		// to other hosts aren't counted as malformed by every client
+		switch messageType(reply) {
+		case layers.DHCPMsgTypeOffer, layers.DHCPMsgTypeAck, layers.DHCPMsgTypeNak:
+		default:
+			client.malformed(FilterBadMessageType, reply)
+			continue
+		}
+		if cb := client.OnBytesReceived; cb != nil {
+			// Count the whole packet, even if it didn't fit the buffer
+			cb(length)
+		}
+
+		msgType, res := newLease(reply)
+
+		if client.Server != nil {
+			if !res.ServerID.Equal(client.Server) {
+				client.Logger.Debug("ignoring packet from other server", "type", msgType, "server", res.ServerID)
+				continue
+			}
+			if hwAddr, ok := addr.(*packet.Addr); ok {
+				client.serverMAC = hwAddr.HardwareAddr
+			}
+		}
+
+		// do we have the expected message type?
+		for _, t := range msgTypes {
+			if t == msgType {
+				client.Logger.Debug("received packet", "type", msgType)
+				return msgType, &res, nil
+			}
+		}
+	}
+}
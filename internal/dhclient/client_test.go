@@ -0,0 +1,252 @@
+package dhclient
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+func TestAddParamRequest(t *testing.T) {
+	client := Client{}
+	if len(client.DHCPOptions) != 0 {
+		t.Fatalf("expected no options, got %d", len(client.DHCPOptions))
+	}
+
+	// Add one option
+	client.AddOption(layers.DHCPOptHostname, []byte("example.com"))
+	if len(client.DHCPOptions) != 1 {
+		t.Fatalf("expected 1 option, got %d", len(client.DHCPOptions))
+	}
+
+	// Add first param request
+	client.AddParamRequest(layers.DHCPOptSubnetMask)
+	if len(client.DHCPOptions) != 2 {
+		t.Fatalf("expected 2 options, got %d", len(client.DHCPOptions))
+	}
+	if len(client.DHCPOptions[1].Data) != 1 {
+		t.Errorf("expected 1 param request, got %d", len(client.DHCPOptions[1].Data))
+	}
+
+	// Add second param request
+	client.AddParamRequest(layers.DHCPOptRouter)
+	if len(client.DHCPOptions[1].Data) != 2 {
+		t.Errorf("expected 2 param requests, got %d", len(client.DHCPOptions[1].Data))
+	}
+
+	// Add existing param request
+	client.AddParamRequest(layers.DHCPOptRouter)
+	if len(client.DHCPOptions[1].Data) != 2 {
+		t.Errorf("expected 2 param requests, got %d", len(client.DHCPOptions[1].Data))
+	}
+}
+
+func TestRequestOptionsReplaceRequestedAddress(t *testing.T) {
+	client := Client{}
+	client.AddOption(layers.DHCPOptHostname, []byte("example.com"))
+	client.AddOption(layers.DHCPOptRequestIP, net.IP{10, 0, 0, 1})
+
+	lease := &Lease{FixedAddress: net.IP{10, 0, 0, 2}, ServerID: net.IP{10, 0, 0, 254}}
+	var requested [][]byte
+	for _, option := range client.requestOptions(lease) {
+		if option.Type == layers.DHCPOptRequestIP {
+			requested = append(requested, option.Data)
+		}
+	}
+	if len(requested) != 1 || !bytes.Equal(requested[0], lease.FixedAddress) {
+		t.Errorf("expected only the lease's address to be requested, got %v", requested)
+	}
+
+	// The client's own options are left alone for the next DISCOVER.
+	if len(client.DHCPOptions) != 2 || !bytes.Equal(client.DHCPOptions[1].Data, net.IP{10, 0, 0, 1}) {
+		t.Errorf("expected the client's options to be unchanged, got %v", client.DHCPOptions)
+	}
+}
+
+func TestQuirksEncode(t *testing.T) {
+	dhcp := &layers.DHCPv4{
+		Operation: layers.DHCPOpRequest,
+		Options: []layers.DHCPOption{
+			{Type: layers.DHCPOptMessageType, Data: []byte{byte(layers.DHCPMsgTypeDiscover)}, Length: 1},
+		},
+	}
+
+	tests := []struct {
+		quirks Quirks
+		want   []byte
+	}{
+		{Quirks{}, []byte{53, 1, 1, 255}},
+		{Quirks{PadOptions: 2}, []byte{53, 1, 1, 0, 0, 255}},
+		{Quirks{OmitEnd: true, TrailingPadding: 3}, []byte{53, 1, 1, 0, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		data, err := tt.quirks.encode(dhcp)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got := data[240:]; !bytes.Equal(got, tt.want) {
+			t.Errorf("%+v: expected options %v, got %v", tt.quirks, tt.want, got)
+		}
+	}
+
+	if len(dhcp.Options) != 1 {
+		t.Errorf("expected the message to be left as is, got %d options", len(dhcp.Options))
+	}
+}
+
+func TestClientOnPanic(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+	lo.HardwareAddr = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+
+	panicked := make(chan any, 1)
+	failed := make(chan error, 1)
+	client := Client{
+		Iface:  lo,
+		OnSend: func(layers.DHCPMsgType, int) { panic("boom") },
+		OnPanic: func(v any, stack []byte) {
+			if len(stack) == 0 {
+				t.Error("expected the stack of the panic")
+			}
+			panicked <- v
+		},
+		OnError: func(err error) {
+			select {
+			case failed <- err:
+			default:
+			}
+		},
+	}
+	client.Start()
+
+	select {
+	case v := <-panicked:
+		if v != "boom" {
+			t.Errorf("unexpected panic %v", v)
+		}
+	case err := <-failed:
+		client.Stop()
+		t.Skipf("unable to send: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the panic to be recovered")
+	}
+
+	client.Stop()
+	client.Stop()
+}
+
+func TestClientOnState(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("no loopback interface: %v", err)
+	}
+	lo.HardwareAddr = net.HardwareAddr{0x02, 0, 0, 0, 0, 0x02}
+
+	states := make(chan State, 10)
+	client := Client{
+		Iface:             lo,
+		RetransmitTimeout: 100 * time.Millisecond,
+		OnState: func(state State) {
+			select {
+			case states <- state:
+			default:
+			}
+		},
+	}
+	conn, err := ListenRaw(lo)
+	if err != nil {
+		t.Skipf("unable to open raw socket: %v", err)
+	}
+	conn.Close()
+	client.Start()
+	defer client.Stop()
+
+	// Nothing answers, so the client goes back to INIT after each DISCOVER.
+	for _, want := range []State{StateInit, StateSelecting, StateInit, StateSelecting} {
+		select {
+		case got := <-states:
+			if got != want {
+				t.Fatalf("expected state %s, got %s", want, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected state %s", want)
+		}
+	}
+}
+
+func TestClientJitteredRenewTime(t *testing.T) {
+	bound := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	lease := &Lease{
+		Bound:  bound,
+		Renew:  bound.Add(30 * time.Minute),
+		Rebind: bound.Add(52*time.Minute + 30*time.Second),
+		Expire: bound.Add(time.Hour),
+	}
+
+	client := Client{Logger: slog.New(&discardHandler{})}
+	if got := client.jitteredRenewTime(lease); !got.Equal(lease.Renew) {
+		t.Errorf("expected T1 without jitter, got %s", got)
+	}
+
+	// A jitter longer than the time between T1 and T2 is cut short at T2.
+	client.RenewJitter = time.Hour
+	seen := map[time.Time]bool{}
+	for i := 0; i < 100; i++ {
+		got := client.jitteredRenewTime(lease)
+		if got.Before(lease.Renew) || got.After(lease.Rebind) {
+			t.Fatalf("expected the renewal between T1 and T2, got %s", got)
+		}
+		seen[got] = true
+	}
+	if len(seen) < 2 {
+		t.Error("expected the renewal to be jittered")
+	}
+	if !seen[lease.Rebind] {
+		t.Error("expected a jitter past T2 to renew at T2")
+	}
+}
+
+func TestClientRenewTime(t *testing.T) {
+	bound := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	lease := &Lease{
+		Bound:  bound,
+		Renew:  bound.Add(30 * time.Minute),
+		Rebind: bound.Add(52*time.Minute + 30*time.Second),
+		Expire: bound.Add(time.Hour),
+	}
+
+	client := Client{}
+	if got := client.RenewTime(lease); !got.Equal(lease.Renew) {
+		t.Errorf("expected T1 without RenewAt, got %s", got)
+	}
+
+	tests := []struct {
+		name    string
+		renewAt time.Time
+		want    time.Time
+	}{
+		{name: "scaled", renewAt: bound.Add(10 * time.Minute), want: bound.Add(10 * time.Minute)},
+		{name: "before bound", renewAt: bound.Add(-time.Minute), want: bound},
+		{name: "past T2", renewAt: bound.Add(55 * time.Minute), want: lease.Rebind},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client.RenewAt = func(*Lease) time.Time { return tt.renewAt }
+			if got := client.RenewTime(lease); !got.Equal(tt.want) {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	infinite := &Lease{Bound: bound, Infinite: true}
+	if got := client.RenewTime(infinite); !got.IsZero() {
+		t.Errorf("expected no renewal for an infinite lease, got %s", got)
+	}
+}
@@ -0,0 +1,9 @@
+// Package dhclient is a DHCPv4 client using raw sockets bound to a specific
+// interface.
+//
+// It is derived from github.com/digineo/go-dhclient, as of commit c8b6d39be079
+// (v1.0.3-0.20240605160009-c8b6d39be079), and is distributed under the same
+// license (see LICENSE). It is kept in-tree so that greedydhcp can hook into
+// parts of the exchange the upstream client doesn't expose. The tests were
+// adapted to drop the upstream dependency on testify.
+package dhclient
@@ -0,0 +1,245 @@
+package dhclient
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// parseIPs slices the data into net.IP pieces of 4 bytes
+func parseIPs(data []byte) []net.IP {
+	result := make([]net.IP, len(data)/4)
+	for i := 0; i+3 < len(data); i += 4 {
+		result[i/4] = net.IP(data[i : i+4])
+	}
+	return result
+}
+
+// parsePacket decodes a DHCPv4 packet. toClient is set if the packet was sent
+// to the client port, so dhcp being nil means it was malformed.
+func parsePacket(data []byte) (dhcp *layers.DHCPv4, toClient bool) {
+	packet := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+	udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+	toClient = ok && udp.DstPort == 68
+
+	dhcpLayer := packet.Layer(layers.LayerTypeDHCPv4)
+	if dhcpLayer == nil {
+		// received packet is not DHCP
+		return nil, toClient
+	}
+	return dhcpLayer.(*layers.DHCPv4), toClient
+}
+
+// messageType returns the DHCP message type option of packet, or 0 if it has
+// none
+func messageType(packet *layers.DHCPv4) layers.DHCPMsgType {
+	for _, option := range packet.Options {
+		if option.Type == layers.DHCPOptMessageType && len(option.Data) == 1 {
+			return layers.DHCPMsgType(option.Data[0])
+		}
+	}
+	return 0
+}
+
+// hasEndOption reports whether the options of the encoded packet data are
+// terminated by an end option
+func hasEndOption(data []byte) bool {
+	for i := 240; i < len(data); {
+		switch layers.DHCPOpt(data[i]) {
+		case layers.DHCPOptEnd:
+			return true
+		case layers.DHCPOptPad:
+			i++
+		default:
+			if i+1 >= len(data) {
+				return false
+			}
+			i += 2 + int(data[i+1])
+		}
+	}
+	return false
+}
+
+// newLease transforms a DHCP offer into a Lease
+func newLease(packet *layers.DHCPv4) (msgType layers.DHCPMsgType, lease Lease) {
+	lease.Bound = time.Now()
+	lease.FixedAddress = packet.YourClientIP
+	lease.NextServer = packet.NextServerIP
+	lease.XID = packet.Xid
+	if relay := packet.RelayAgentIP.To4(); relay != nil && !relay.IsUnspecified() {
+		lease.RelayAddr = relay
+	}
+	// Packets built rather than decoded have no contents to check
+	lease.Truncated = len(packet.Contents) > 240 && !hasEndOption(packet.Contents)
+
+	overloaded := false
+	// Long options are split into several instances, to be concatenated
+	// before decoding (RFC 3396)
+	var domainSearch []byte
+	hasDomainSearch := false
+
+	for _, option := range packet.Options {
+		if option.Type != layers.DHCPOptPad && option.Type != layers.DHCPOptEnd {
+			lease.OptionCodes = append(lease.OptionCodes, option.Type)
+			lease.OptionBytes += 2 + len(option.Data)
+		}
+
+		switch option.Type {
+		case layers.DHCPOptMessageType:
+			if option.Length == 1 {
+				msgType = layers.DHCPMsgType(option.Data[0])
+			}
+		case layers.DHCPOptSubnetMask:
+			lease.Netmask = net.IPMask(option.Data)
+		case layers.DHCPOptBroadcastAddr:
+			lease.Broadcast = net.IP(option.Data)
+		case layers.DHCPOptServerID:
+			lease.ServerID = net.IP(option.Data)
+		case layers.DHCPOptRouter:
+			lease.Router = parseIPs(option.Data)
+		case layers.DHCPOptDNS:
+			lease.DNS = parseIPs(option.Data)
+		case layers.DHCPOptTimeServer:
+			lease.TimeServer = parseIPs(option.Data)
+		case layers.DHCPOptNTPServers:
+			lease.NTPServers = parseIPs(option.Data)
+		case layers.DHCPOptDomainName:
+			lease.DomainName = string(option.Data)
+		case layers.DHCPOptExtOptions:
+			overloaded = true
+		case layers.DHCPOptDomainSearch:
+			domainSearch = append(domainSearch, option.Data...)
+			hasDomainSearch = true
+		case OptTFTPServerName:
+			lease.TFTPServerName = cString(option.Data)
+		case OptBootFileName:
+			lease.BootFileName = cString(option.Data)
+		case layers.DHCPOptMessage:
+			lease.Message = cString(option.Data)
+		case layers.DHCPOptInterfaceMTU:
+			if option.Length == 2 {
+				lease.MTU = binary.BigEndian.Uint16(option.Data)
+			}
+		case layers.DHCPOptLeaseTime:
+			if option.Length == 4 {
+				if secs := binary.BigEndian.Uint32(option.Data); secs == InfiniteLeaseTime {
+					lease.Infinite = true
+				} else {
+					lease.Expire = lease.Bound.Add(time.Second * time.Duration(secs))
+				}
+			}
+		case layers.DHCPOptT1:
+			if option.Length == 4 {
+				lease.Renew = lease.Bound.Add(time.Second * time.Duration(binary.BigEndian.Uint32(option.Data)))
+			}
+		case layers.DHCPOptT2:
+			if option.Length == 4 {
+				lease.Rebind = lease.Bound.Add(time.Second * time.Duration(binary.BigEndian.Uint32(option.Data)))
+			}
+		default:
+			lease.OtherOptions = append(lease.OtherOptions, Option{option.Type, option.Data})
+		}
+	}
+
+	if hasDomainSearch {
+		lease.DomainSearch, lease.DomainSearchErr = parseDomainSearch(domainSearch)
+	}
+
+	// With option overload the header fields hold more options, which
+	// aren't decoded, rather than names.
+	if !overloaded {
+		lease.ServerName = cString(packet.ServerName)
+		lease.BootFile = cString(packet.File)
+	}
+	return
+}
+
+// cString returns data up to its first NUL byte as a string
+func cString(data []byte) string {
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		data = data[:i]
+	}
+	return string(data)
+}
+
+// maxDomainNameLen is the longest a domain name may be in wire format
+const maxDomainNameLen = 255
+
+// parseDomainSearch decodes the domain names of a domain search list, in the
+// RFC 1035 wire format with compression pointers counted from the start of
+// the option data (RFC 3397)
+func parseDomainSearch(data []byte) ([]string, error) {
+	var domains []string
+	for offset := 0; offset < len(data); {
+		name, next, err := parseDomainName(data, offset)
+		if err != nil {
+			return nil, fmt.Errorf("domain %d at offset %d: %w", len(domains), offset, err)
+		}
+		domains = append(domains, name)
+		offset = next
+	}
+	if len(domains) == 0 {
+		return nil, errors.New("empty domain search list")
+	}
+	return domains, nil
+}
+
+// parseDomainName decodes the domain name at offset in data, returning it
+// and the offset following it. Pointers must point before where the labels
+// read so far began, so that they can't loop.
+func parseDomainName(data []byte, offset int) (string, int, error) {
+	var labels []string
+	length := 0
+	next := -1
+	start := offset
+	for pos := offset; ; {
+		if pos >= len(data) {
+			return "", 0, errors.New("truncated name")
+		}
+
+		b := data[pos]
+		switch {
+		case b == 0:
+			if next < 0 {
+				next = pos + 1
+			}
+			if len(labels) == 0 {
+				// The root domain
+				return ".", next, nil
+			}
+			return strings.Join(labels, "."), next, nil
+		case b&0xc0 == 0xc0:
+			if pos+1 >= len(data) {
+				return "", 0, errors.New("truncated pointer")
+			}
+			target := int(binary.BigEndian.Uint16(data[pos:]) & 0x3fff)
+			if target >= start {
+				return "", 0, fmt.Errorf("pointer to offset %d does not point backwards", target)
+			}
+			if next < 0 {
+				next = pos + 2
+			}
+			pos, start = target, target
+		case b&0xc0 != 0:
+			return "", 0, fmt.Errorf("invalid label type %#02x", b&0xc0)
+		default:
+			end := pos + 1 + int(b)
+			if end > len(data) {
+				return "", 0, errors.New("truncated label")
+			}
+			length += 1 + int(b)
+			if length+1 > maxDomainNameLen {
+				return "", 0, fmt.Errorf("name longer than %d bytes", maxDomainNameLen)
+			}
+			labels = append(labels, string(data[pos+1:end]))
+			pos = end
+		}
+	}
+}
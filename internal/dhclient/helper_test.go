@@ -0,0 +1,264 @@
+package dhclient
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func TestParseIPs(t *testing.T) {
+	data := []byte{143, 209, 4, 1, 143, 209, 5, 1}
+	ips := parseIPs(data)
+	if len(ips) != 2 {
+		t.Fatalf("expected 2 ips, got %d", len(ips))
+	}
+	if !ips[0].Equal(net.IP{143, 209, 4, 1}) {
+		t.Errorf("unexpected first ip %s", ips[0])
+	}
+	if !ips[1].Equal(net.IP{143, 209, 5, 1}) {
+		t.Errorf("unexpected second ip %s", ips[1])
+	}
+
+	// not enough bytes
+	if ips := parseIPs([]byte{143, 209, 4}); len(ips) != 0 {
+		t.Errorf("expected no ips, got %d", len(ips))
+	}
+}
+
+func TestParseResponse(t *testing.T) {
+	data, err := os.ReadFile("testdata/offer.packet")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	packet, _ := parsePacket(data)
+	if packet == nil {
+		t.Fatal("unable to parse packet")
+	}
+
+	msgType, lease := newLease(packet)
+	if msgType != layers.DHCPMsgTypeOffer {
+		t.Errorf("expected offer, got %s", msgType)
+	}
+	if !lease.FixedAddress.Equal(net.IP{192, 168, 9, 131}) {
+		t.Errorf("unexpected address %s", lease.FixedAddress)
+	}
+	if len(lease.Router) != 1 || !lease.Router[0].Equal(net.IP{192, 168, 8, 1}) {
+		t.Errorf("unexpected router %v", lease.Router)
+	}
+	if len(lease.DNS) != 1 || !lease.DNS[0].Equal(net.IP{192, 168, 8, 1}) {
+		t.Errorf("unexpected dns %v", lease.DNS)
+	}
+	if lease.Netmask.String() != (net.IPMask{255, 255, 252, 0}).String() {
+		t.Errorf("unexpected netmask %s", lease.Netmask)
+	}
+	if lease.MTU != 1406 {
+		t.Errorf("unexpected mtu %d", lease.MTU)
+	}
+	if len(lease.OtherOptions) != 0 {
+		t.Errorf("expected no other options, got %v", lease.OtherOptions)
+	}
+
+	// check timestamps
+	if lease.Bound.IsZero() {
+		t.Error("expected bound time to be set")
+	}
+	if s := int(lease.Renew.Sub(lease.Bound) / time.Second); s != 1800 {
+		t.Errorf("unexpected renew time %d", s)
+	}
+	if s := int(lease.Rebind.Sub(lease.Bound) / time.Second); s != 3150 {
+		t.Errorf("unexpected rebind time %d", s)
+	}
+	if s := int(lease.Expire.Sub(lease.Bound) / time.Second); s != 3600 {
+		t.Errorf("unexpected expire time %d", s)
+	}
+}
+
+func TestNewLeaseBootSettings(t *testing.T) {
+	packet := &layers.DHCPv4{
+		NextServerIP: net.IP{10, 0, 0, 2},
+		ServerName:   append([]byte("tftp.example.com"), make([]byte, 48)...),
+		File:         append([]byte("pxelinux.0"), make([]byte, 118)...),
+		Options: []layers.DHCPOption{
+			layers.NewDHCPOption(OptTFTPServerName, []byte("10.0.0.3")),
+			layers.NewDHCPOption(OptBootFileName, []byte("ipxe.efi\x00")),
+		},
+	}
+
+	_, lease := newLease(packet)
+	if !lease.NextServer.Equal(net.IP{10, 0, 0, 2}) {
+		t.Errorf("unexpected next server %s", lease.NextServer)
+	}
+	if lease.ServerName != "tftp.example.com" || lease.BootFile != "pxelinux.0" {
+		t.Errorf("unexpected header fields %q and %q", lease.ServerName, lease.BootFile)
+	}
+	if lease.TFTPServerName != "10.0.0.3" || lease.BootFileName != "ipxe.efi" {
+		t.Errorf("unexpected options %q and %q", lease.TFTPServerName, lease.BootFileName)
+	}
+	if lease.OptionBytes != 21 {
+		t.Errorf("expected 21 option bytes, got %d", lease.OptionBytes)
+	}
+
+	// The header fields hold options when they are overloaded.
+	packet.Options = append(packet.Options, layers.NewDHCPOption(layers.DHCPOptExtOptions, []byte{3}))
+	if _, lease := newLease(packet); lease.ServerName != "" || lease.BootFile != "" {
+		t.Errorf("expected overloaded header fields to be ignored, got %q and %q", lease.ServerName, lease.BootFile)
+	}
+}
+
+func TestNewLeaseMessage(t *testing.T) {
+	packet := &layers.DHCPv4{
+		Options: []layers.DHCPOption{
+			layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(layers.DHCPMsgTypeNak)}),
+			layers.NewDHCPOption(layers.DHCPOptMessage, []byte("address not available\x00")),
+		},
+	}
+
+	msgType, lease := newLease(packet)
+	if msgType != layers.DHCPMsgTypeNak {
+		t.Errorf("expected a NAK, got %s", msgType)
+	}
+	if lease.Message != "address not available" {
+		t.Errorf("unexpected message %q", lease.Message)
+	}
+}
+
+func TestParseDomainSearch(t *testing.T) {
+	// The example of RFC 3397 section 3
+	data := []byte("\x03eng\x05apple\x03com\x00\x09marketing\xc0\x04")
+	domains, err := parseDomainSearch(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(domains) != 2 || domains[0] != "eng.apple.com" || domains[1] != "marketing.apple.com" {
+		t.Errorf("unexpected domains %v", domains)
+	}
+
+	for name, data := range map[string][]byte{
+		"empty":           {},
+		"truncated label": []byte("\x05app"),
+		"missing end":     []byte("\x03com"),
+		"forward pointer": []byte("\xc0\x02\x03com\x00"),
+		"pointer loop":    []byte("\x03com\x00\x03foo\xc0\x05"),
+		"invalid label":   []byte("\x40com\x00"),
+	} {
+		if domains, err := parseDomainSearch(data); err == nil {
+			t.Errorf("%s: expected an error, got %v", name, domains)
+		}
+	}
+}
+
+func TestNewLeaseDomainSearch(t *testing.T) {
+	packet := &layers.DHCPv4{
+		Options: []layers.DHCPOption{
+			layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(layers.DHCPMsgTypeAck)}),
+			// Split in two, to be concatenated
+			layers.NewDHCPOption(layers.DHCPOptDomainSearch, []byte("\x03eng\x05ap")),
+			layers.NewDHCPOption(layers.DHCPOptDomainSearch, []byte("ple\x03com\x00")),
+		},
+	}
+
+	_, lease := newLease(packet)
+	if lease.DomainSearchErr != nil {
+		t.Fatal(lease.DomainSearchErr)
+	}
+	if len(lease.DomainSearch) != 1 || lease.DomainSearch[0] != "eng.apple.com" {
+		t.Errorf("unexpected domains %v", lease.DomainSearch)
+	}
+
+	// A common misencoding, sending the names as text
+	packet.Options = append(packet.Options[:1], layers.NewDHCPOption(layers.DHCPOptDomainSearch, []byte("eng.apple.com")))
+	if _, lease := newLease(packet); lease.DomainSearchErr == nil {
+		t.Errorf("expected a misencoded list to fail, got %v", lease.DomainSearch)
+	}
+}
+
+func TestNewLeaseTruncated(t *testing.T) {
+	packet := &layers.DHCPv4{
+		Operation: layers.DHCPOpReply,
+		Options: []layers.DHCPOption{
+			layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(layers.DHCPMsgTypeAck)}),
+			layers.NewDHCPOption(layers.DHCPOptDNS, []byte{10, 0, 0, 1}),
+		},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := packet.SerializeTo(buf, gopacket.SerializeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	for _, tt := range []struct {
+		name      string
+		data      []byte
+		truncated bool
+	}{
+		{"complete", data, false},
+		{"padded after end", append(append([]byte{}, data...), 0, 0, 0), false},
+		{"no end", data[:len(data)-1], true},
+	} {
+		decoded := &layers.DHCPv4{}
+		if err := decoded.DecodeFromBytes(tt.data, gopacket.NilDecodeFeedback); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if _, lease := newLease(decoded); lease.Truncated != tt.truncated {
+			t.Errorf("%s: expected truncated to be %v", tt.name, tt.truncated)
+		}
+	}
+}
+
+func TestNewLeaseInfinite(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		secs     uint32
+		infinite bool
+	}{
+		{"infinite", InfiniteLeaseTime, true},
+		{"hour", 3600, false},
+		{"zero", 0, false},
+	} {
+		leaseTime := binary.BigEndian.AppendUint32(nil, tt.secs)
+		_, lease := newLease(&layers.DHCPv4{
+			Operation: layers.DHCPOpReply,
+			Options: []layers.DHCPOption{
+				layers.NewDHCPOption(layers.DHCPOptMessageType, []byte{byte(layers.DHCPMsgTypeAck)}),
+				layers.NewDHCPOption(layers.DHCPOptLeaseTime, leaseTime),
+			},
+		})
+
+		if lease.Infinite != tt.infinite {
+			t.Errorf("%s: expected infinite to be %v", tt.name, tt.infinite)
+		}
+		if tt.infinite && !lease.Expire.IsZero() {
+			t.Errorf("%s: expected no expiry, got %v", tt.name, lease.Expire)
+		}
+		if !tt.infinite && lease.Expire.Sub(lease.Bound) != time.Duration(tt.secs)*time.Second {
+			t.Errorf("%s: expected a lease time of %ds, got %v", tt.name, tt.secs, lease.Expire.Sub(lease.Bound))
+		}
+	}
+}
+
+func TestNewLeaseRelayAddr(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		giaddr net.IP
+		want   net.IP
+	}{
+		{"relayed", net.IPv4(10, 20, 0, 1), net.IPv4(10, 20, 0, 1)},
+		{"unspecified", net.IPv4zero, nil},
+		{"unset", nil, nil},
+	} {
+		_, lease := newLease(&layers.DHCPv4{
+			Operation:    layers.DHCPOpReply,
+			RelayAgentIP: tt.giaddr,
+		})
+
+		if !lease.RelayAddr.Equal(tt.want) {
+			t.Errorf("%s: expected relay address %v, got %v", tt.name, tt.want, lease.RelayAddr)
+		}
+	}
+}
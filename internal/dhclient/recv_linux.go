@@ -0,0 +1,40 @@
+//go:build linux
+
+package dhclient
+
+import (
+	"errors"
+	"net"
+
+	"github.com/mdlayher/packet"
+	"golang.org/x/sys/unix"
+)
+
+// readFrom reads a packet from conn into b, as conn.ReadFrom does, also
+// returning its length in full, which is more than n if it didn't fit in b.
+func readFrom(conn *packet.Conn, b []byte) (n, length int, addr net.Addr, err error) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	var sa unix.Sockaddr
+	var recvErr error
+	err = rc.Read(func(fd uintptr) bool {
+		// MSG_TRUNC makes recvfrom return the real length of the packet
+		length, sa, recvErr = unix.Recvfrom(int(fd), b, unix.MSG_TRUNC)
+		return !errors.Is(recvErr, unix.EAGAIN)
+	})
+	if err == nil {
+		err = recvErr
+	}
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	if ll, ok := sa.(*unix.SockaddrLinklayer); ok {
+		addr = &packet.Addr{HardwareAddr: net.HardwareAddr(ll.Addr[:ll.Halen])}
+	}
+
+	return min(length, len(b)), length, addr, nil
+}
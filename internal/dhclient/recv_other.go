@@ -0,0 +1,22 @@
+//go:build !linux
+
+package dhclient
+
+import (
+	"net"
+
+	"github.com/mdlayher/packet"
+)
+
+// readFrom reads a packet from conn into b, as conn.ReadFrom does. The real
+// length of a packet isn't known here, so one filling b is taken to have
+// been truncated.
+func readFrom(conn *packet.Conn, b []byte) (n, length int, addr net.Addr, err error) {
+	n, addr, err = conn.ReadFrom(b)
+	length = n
+	if n == len(b) {
+		length++
+	}
+
+	return n, length, addr, err
+}
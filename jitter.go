@@ -0,0 +1,53 @@
+package greedydhcp
+
+import (
+	"math"
+	"time"
+)
+
+// responseWindow holds the most recent response times of a target's server,
+// to measure their jitter. It isn't safe for concurrent use, being only
+// added to from the callbacks of the target's one running client.
+type responseWindow struct {
+	samples []time.Duration
+	// next is the index the next sample replaces once the window is full.
+	next int
+}
+
+// newResponseWindow returns a window of the last size response times.
+func newResponseWindow(size int) *responseWindow {
+	return &responseWindow{samples: make([]time.Duration, 0, size)}
+}
+
+// add records a response time, dropping the oldest one if the window is
+// full.
+func (w *responseWindow) add(rtt time.Duration) {
+	if len(w.samples) < cap(w.samples) {
+		w.samples = append(w.samples, rtt)
+		return
+	}
+
+	w.samples[w.next] = rtt
+	w.next = (w.next + 1) % len(w.samples)
+}
+
+// jitter returns the standard deviation of the response times in the
+// window, and false if there are fewer than two to deviate.
+func (w *responseWindow) jitter() (time.Duration, bool) {
+	if len(w.samples) < 2 {
+		return 0, false
+	}
+
+	var sum float64
+	for _, s := range w.samples {
+		sum += float64(s)
+	}
+	mean := sum / float64(len(w.samples))
+
+	var squares float64
+	for _, s := range w.samples {
+		squares += (float64(s) - mean) * (float64(s) - mean)
+	}
+
+	return time.Duration(math.Sqrt(squares / float64(len(w.samples)))), true
+}
@@ -0,0 +1,32 @@
+package greedydhcp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResponseWindowJitter(t *testing.T) {
+	w := newResponseWindow(4)
+	w.add(10 * time.Millisecond)
+	if _, ok := w.jitter(); ok {
+		t.Fatal("expected no jitter from a single sample")
+	}
+
+	w.add(10 * time.Millisecond)
+	if jitter, ok := w.jitter(); !ok || jitter != 0 {
+		t.Errorf("expected no jitter from equal samples, got %s", jitter)
+	}
+
+	w.add(30 * time.Millisecond)
+	w.add(30 * time.Millisecond)
+	if jitter, _ := w.jitter(); jitter != 10*time.Millisecond {
+		t.Errorf("expected 10ms of jitter, got %s", jitter)
+	}
+
+	// The window is full, so these replace the oldest samples.
+	w.add(30 * time.Millisecond)
+	w.add(30 * time.Millisecond)
+	if jitter, _ := w.jitter(); jitter != 0 {
+		t.Errorf("expected the old samples to be dropped, got %s of jitter", jitter)
+	}
+}
@@ -0,0 +1,162 @@
+package greedydhcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// parseLogFormat parses a log format name: text or json.
+func parseLogFormat(s string) (string, error) {
+	format := strings.ToLower(strings.TrimSpace(s))
+	if format != "text" && format != "json" {
+		return "", fmt.Errorf("log format %q must be text or json", s)
+	}
+
+	return format, nil
+}
+
+// newLogHandler returns a handler writing records to w in format, as
+// returned by parseLogFormat.
+func newLogHandler(format string, w io.Writer, opts *slog.HandlerOptions) slog.Handler {
+	if format == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+
+	return slog.NewTextHandler(w, opts)
+}
+
+// parseLogLevel parses a log level name: debug, info, warn or error.
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return 0, fmt.Errorf("log level %q must be one of debug, info, warn or error", s)
+	}
+
+	return level, nil
+}
+
+// levelHandler drops records below level before passing them on. The handler
+// it wraps must let every level through, so that the level can be lowered as
+// well as raised for part of the program, such as a single target.
+type levelHandler struct {
+	level   slog.Leveler
+	handler slog.Handler
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level.Level() && h.handler.Enabled(ctx, level)
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{level: h.level, handler: h.handler.WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{level: h.level, handler: h.handler.WithGroup(name)}
+}
+
+// withLevel returns logger logging at level instead of its own.
+func withLevel(logger *slog.Logger, level slog.Level) *slog.Logger {
+	handler := logger.Handler()
+	if h, ok := handler.(*levelHandler); ok {
+		handler = h.handler
+	}
+
+	return slog.New(&levelHandler{level: level, handler: handler})
+}
+
+// getLogger returns the logger writing to stderr, or to LOG_FILE if it is
+// set, at the level fromFile sets or else LOG_LEVEL, and in LOG_FORMAT. The
+// file is rotated once it grows past LOG_MAX_SIZE_MB, and every
+// LOG_ROTATE_INTERVAL if that is set.
+func getLogger(fromFile fileSettings) (*slog.Logger, error) {
+	// The handler lets every level through, so that targets can log at a
+	// lower level than the rest.
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+
+	level := slog.LevelInfo
+	levelStr := fromFile.logLevel
+	if levelStr == "" {
+		levelStr = os.Getenv("LOG_LEVEL")
+	}
+	if levelStr != "" {
+		var err error
+		level, err = parseLogLevel(levelStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LOG_LEVEL: %w", err)
+		}
+	}
+
+	format := "text"
+	if formatStr := os.Getenv("LOG_FORMAT"); formatStr != "" {
+		var err error
+		format, err = parseLogFormat(formatStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid LOG_FORMAT: %w", err)
+		}
+	}
+
+	path := os.Getenv("LOG_FILE")
+	if path == "" {
+		return withLevel(slog.New(newLogHandler(format, os.Stderr, opts)), level), nil
+	}
+
+	maxSize, err := getEnvInt("LOG_MAX_SIZE_MB", 100)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse LOG_MAX_SIZE_MB: %w", err)
+	}
+
+	maxBackups, err := getEnvInt("LOG_MAX_BACKUPS", 5)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse LOG_MAX_BACKUPS: %w", err)
+	}
+
+	maxAge, err := getEnvInt("LOG_MAX_AGE_DAYS", 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse LOG_MAX_AGE_DAYS: %w", err)
+	}
+
+	compress, err := getEnvBool("LOG_COMPRESS")
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse LOG_COMPRESS: %w", err)
+	}
+
+	interval, err := getEnvDuration("LOG_ROTATE_INTERVAL", 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse LOG_ROTATE_INTERVAL: %w", err)
+	}
+
+	if maxSize <= 0 || maxBackups < 0 || maxAge < 0 || interval < 0 {
+		return nil, errors.New("LOG_MAX_SIZE_MB must be positive, and LOG_MAX_BACKUPS, LOG_MAX_AGE_DAYS and LOG_ROTATE_INTERVAL must not be negative")
+	}
+
+	file := &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+		MaxAge:     maxAge,
+		Compress:   compress,
+	}
+
+	if interval > 0 {
+		go func() {
+			for range time.Tick(interval) {
+				file.Rotate()
+			}
+		}()
+	}
+
+	return withLevel(slog.New(newLogHandler(format, file, opts)), level), nil
+}
@@ -0,0 +1,62 @@
+package greedydhcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestWithLevel(t *testing.T) {
+	var buf bytes.Buffer
+	base := withLevel(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})), slog.LevelInfo)
+
+	base.Debug("hidden")
+	verbose := withLevel(base, slog.LevelDebug).With("target", "10.0.0.1")
+	verbose.Debug("shown")
+	quiet := withLevel(base, slog.LevelError)
+	quiet.Warn("also hidden")
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("expected records below the level to be dropped, got %q", out)
+	}
+	if !strings.Contains(out, "msg=shown target=10.0.0.1") {
+		t.Errorf("expected a lowered level to let debug records through, got %q", out)
+	}
+}
+
+func TestJSONLogHandler(t *testing.T) {
+	format, err := parseLogFormat(" JSON")
+	if err != nil || format != "json" {
+		t.Fatalf("expected json, got %q and %v", format, err)
+	}
+	if _, err := parseLogFormat("logfmt"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+
+	var buf bytes.Buffer
+	logger := withLevel(slog.New(newLogHandler(format, &buf, &slog.HandlerOptions{Level: slog.LevelDebug})), slog.LevelInfo)
+	logger.With("target", "10.0.0.1").Info("Got lease", "addr", "10.0.0.1")
+	logger.Debug("hidden")
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected a single JSON record, got %q: %v", buf.String(), err)
+	}
+	if record["msg"] != "Got lease" || record["target"] != "10.0.0.1" || record["level"] != "INFO" {
+		t.Errorf("expected the message, level and target attribute, got %v", record)
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	level, err := parseLogLevel(" warn")
+	if err != nil || level != slog.LevelWarn {
+		t.Errorf("expected warn, got %v and %v", level, err)
+	}
+
+	if _, err := parseLogLevel("verbose"); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+}
@@ -0,0 +1,65 @@
+package greedydhcp
+
+import (
+	"container/list"
+	"log/slog"
+	"sync"
+)
+
+// seriesLRU caps the number of targets that have metric series. When a new
+// target would exceed the limit, the series of the least recently updated
+// target are deleted.
+type seriesLRU struct {
+	logger *slog.Logger
+	limit  int
+	// evict deletes the series of a target.
+	evict func(addr string)
+
+	mu      sync.Mutex
+	order   *list.List // of string, most recently touched at the front
+	entries map[string]*list.Element
+}
+
+func newSeriesLRU(logger *slog.Logger, limit int, evict func(addr string)) *seriesLRU {
+	return &seriesLRU{
+		logger:  logger,
+		limit:   limit,
+		evict:   evict,
+		order:   list.New(),
+		entries: map[string]*list.Element{},
+	}
+}
+
+// touch marks the series of addr as updated, evicting the least recently
+// updated target if there are now too many.
+func (l *seriesLRU) touch(addr string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, ok := l.entries[addr]; ok {
+		l.order.MoveToFront(e)
+		return
+	}
+
+	l.entries[addr] = l.order.PushFront(addr)
+
+	for l.limit > 0 && l.order.Len() > l.limit {
+		oldest := l.order.Back()
+		evicted := l.order.Remove(oldest).(string)
+		delete(l.entries, evicted)
+
+		l.logger.Warn("Too many targets with metrics, evicting least recently updated", "target", evicted, "limit", l.limit)
+		l.evict(evicted)
+	}
+}
+
+// forget stops tracking addr, such as once its series were deleted.
+func (l *seriesLRU) forget(addr string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, ok := l.entries[addr]; ok {
+		l.order.Remove(e)
+		delete(l.entries, addr)
+	}
+}
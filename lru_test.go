@@ -0,0 +1,24 @@
+package greedydhcp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSeriesLRUEvictsLeastRecentlyUpdated(t *testing.T) {
+	var evicted []string
+	l := newSeriesLRU(testLogger(t), 2, func(addr string) {
+		evicted = append(evicted, addr)
+	})
+
+	l.touch("10.0.0.1")
+	l.touch("10.0.0.2")
+	l.touch("10.0.0.1")
+	l.touch("10.0.0.3")
+	l.forget("10.0.0.1")
+	l.touch("10.0.0.4")
+
+	if want := []string{"10.0.0.2"}; !reflect.DeepEqual(evicted, want) {
+		t.Errorf("expected %v to be evicted, got %v", want, evicted)
+	}
+}
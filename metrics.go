@@ -0,0 +1,1148 @@
+package greedydhcp
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// durationHistogramOpts adds native buckets to opts if native is set. The
+// classic buckets are kept either way, for scrapers that don't support native
+// histograms.
+func durationHistogramOpts(native bool, opts prometheus.HistogramOpts) prometheus.HistogramOpts {
+	if native {
+		opts.NativeHistogramBucketFactor = 1.1
+		opts.NativeHistogramMaxBucketNumber = 100
+		opts.NativeHistogramMinResetDuration = time.Hour
+	}
+
+	return opts
+}
+
+// parseQuantiles parses a comma separated list of quantiles, e.g.
+// "0.5,0.9,0.99", into summary objectives. The allowed error of each shrinks
+// as it gets closer to 1.
+func parseQuantiles(s string) (map[float64]float64, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	objectives := map[float64]float64{}
+	for _, qStr := range strings.Split(s, ",") {
+		q, err := strconv.ParseFloat(strings.TrimSpace(qStr), 64)
+		if err != nil || q <= 0 || q >= 1 {
+			return nil, fmt.Errorf("quantile %q must be a number between 0 and 1", qStr)
+		}
+		objectives[q] = (1 - q) / 10
+	}
+
+	return objectives, nil
+}
+
+// metrics are the metrics of a prober. Each prober has its own, so that
+// several can run in one process, each registered with a registry of its own.
+type metrics struct {
+	dhcpAcquiredLeasesTotal                  *prometheus.CounterVec
+	dhcpStableLeasesTotal                    *prometheus.CounterVec
+	dhcpSLOBreachesTotal                     *prometheus.CounterVec
+	dhcpExpiredLeasesTotal                   *prometheus.CounterVec
+	dhcpFailedLeasesTotal                    *prometheus.CounterVec
+	dhcpAcquireFailuresTotal                 *prometheus.CounterVec
+	dhcpLostLeasesTotal                      *prometheus.CounterVec
+	dhcpLeaseExpiryTimestampSeconds          *prometheus.GaugeVec
+	dhcpLeaseT1Seconds                       *prometheus.GaugeVec
+	dhcpLeaseRenewTimestampSeconds           *prometheus.GaugeVec
+	dhcpLeaseT2Seconds                       *prometheus.GaugeVec
+	dhcpDistinctServersSeen                  *prometheus.GaugeVec
+	dhcpShortLeasesTotal                     *prometheus.CounterVec
+	dhcpInvalidLeaseTimeTotal                *prometheus.CounterVec
+	dhcpOutOfSubnetOffersTotal               *prometheus.CounterVec
+	dhcpFailuresTotal                        *prometheus.CounterVec
+	dhcpPacketsFilteredTotal                 *prometheus.CounterVec
+	dhcpMalformedRepliesTotal                *prometheus.CounterVec
+	dhcpLastError                            *prometheus.GaugeVec
+	dhcpTargetServerAnswering                *prometheus.GaugeVec
+	dhcpLeasesRestoredTotal                  *prometheus.CounterVec
+	dhcpLeaseImported                        *prometheus.GaugeVec
+	dhcpManualReacquiresTotal                *prometheus.CounterVec
+	dhcpLeaseDNSServers                      *prometheus.GaugeVec
+	dhcpLeaseDNSServerInfo                   *prometheus.GaugeVec
+	dhcpLeaseNetmaskInfo                     *prometheus.GaugeVec
+	dhcpLeaseAddressInfo                     *prometheus.GaugeVec
+	dhcpLeaseRouterInfo                      *prometheus.GaugeVec
+	dhcpLeaseInfo                            *prometheus.GaugeVec
+	dhcpLeaseSearchDomainInfo                *prometheus.GaugeVec
+	dhcpOption119DecodeErrorsTotal           *prometheus.CounterVec
+	dhcpLeaseNTPServerInfo                   *prometheus.GaugeVec
+	dhcpLeaseBootInfo                        *prometheus.GaugeVec
+	dhcpServerMessageInfo                    *prometheus.GaugeVec
+	dhcpLeaseInterfaceMTU                    *prometheus.GaugeVec
+	dhcpLeaseOptionBytes                     *prometheus.GaugeVec
+	dhcpOptionSetChangedTotal                *prometheus.CounterVec
+	dhcpServerOptionSeen                     *prometheus.GaugeVec
+	dhcpGatewayReachable                     *prometheus.GaugeVec
+	dhcpFQDNServerUpdates                    *prometheus.GaugeVec
+	dhcpRetryBackoffSeconds                  *prometheus.GaugeVec
+	dhcpSendRatePPS                          prometheus.Gauge
+	dhcpLinkUpResetsTotal                    prometheus.Counter
+	dhcpTargetNetnsInfo                      *prometheus.GaugeVec
+	dhcpTargetVLANInfo                       *prometheus.GaugeVec
+	dhcpRenewalIntervalSeconds               *prometheus.HistogramVec
+	dhcpDiscoverToOfferSeconds               *prometheus.HistogramVec
+	dhcpRequestToAckSeconds                  *prometheus.HistogramVec
+	dhcpLeaseAcquisitionDurationSeconds      *prometheus.HistogramVec
+	dhcpAcquireDurationSeconds               *prometheus.SummaryVec
+	dhcpMessageSizeBytes                     *prometheus.HistogramVec
+	dhcpPreferenceIndex                      *prometheus.GaugeVec
+	dhcpSquatNAKs                            *prometheus.GaugeVec
+	dhcpSquatOutcomesTotal                   *prometheus.CounterVec
+	dhcpRenewalStreak                        *prometheus.GaugeVec
+	dhcpStateSecondsTotal                    *prometheus.CounterVec
+	dhcpAddressChangedTotal                  *prometheus.CounterVec
+	dhcpLeaseOutageSeconds                   *prometheus.HistogramVec
+	dhcpMaxMessageSizeBytes                  *prometheus.GaugeVec
+	dhcpTruncatedRepliesTotal                *prometheus.CounterVec
+	dhcpRepliesTotal                         *prometheus.CounterVec
+	dhcpReplyRelayInfo                       *prometheus.GaugeVec
+	dhcpAlternativeOffersTotal               *prometheus.CounterVec
+	dhcpTruncatedReadsTotal                  *prometheus.CounterVec
+	dhcpOfferProbesTotal                     *prometheus.CounterVec
+	dhcpNAKToDiscoverTransitionsTotal        *prometheus.CounterVec
+	dhcpResponseJitterSeconds                *prometheus.GaugeVec
+	dhcpBytesSentTotal                       *prometheus.CounterVec
+	dhcpBytesReceivedTotal                   *prometheus.CounterVec
+	dhcpDuplicateAcksTotal                   *prometheus.CounterVec
+	dhcpDelayedRequestsTotal                 *prometheus.CounterVec
+	dhcpInitRebootTotal                      *prometheus.CounterVec
+	dhcpStartWaitSeconds                     *prometheus.HistogramVec
+	dhcpWaitingForStartSlot                  *prometheus.GaugeVec
+	dhcpTargetHardFailed                     *prometheus.GaugeVec
+	dhcpWaitingForDependencies               *prometheus.GaugeVec
+	dhcpChurnCyclesTotal                     *prometheus.CounterVec
+	dhcpChurnReacquiresTotal                 *prometheus.CounterVec
+	dhcpChurnPhase                           *prometheus.GaugeVec
+	dhcpConfiguredTargets                    prometheus.Gauge
+	dhcpRunningClients                       prometheus.Gauge
+	dhcpAnyLeaseAcquired                     prometheus.Gauge
+	dhcpEstimatedPoolSize                    *prometheus.GaugeVec
+	dhcpTargetChaddrInfo                     *prometheus.GaugeVec
+	dhcpStressClientsTotal                   prometheus.Counter
+	dhcpStressAddressesConsumed              prometheus.Gauge
+	dhcpStressLeaseInfo                      *prometheus.GaugeVec
+	dhcpAnonymousBoundLeasesTotal            prometheus.Counter
+	dhcpAnonymousRejectedOffersTotal         prometheus.Counter
+	dhcpAnonymousAddressesHeld               prometheus.Gauge
+	dhcpAnonymousLeaseInfo                   *prometheus.GaugeVec
+	dhcpAnonymousLeaseExpiryTimestampSeconds *prometheus.GaugeVec
+	dhcpInterfaceInfo                        *prometheus.GaugeVec
+	dhcpInterfaceIndexChangesTotal           prometheus.Counter
+	dhcpInterfaceMACChangesTotal             prometheus.Counter
+	dhcpInterfaceReselectionsTotal           prometheus.Counter
+	dhcpCrossInterfaceLeasesTotal            *prometheus.CounterVec
+	dhcpGratuitousARPConflictsTotal          *prometheus.CounterVec
+	dhcpPostBindConflictsTotal               *prometheus.CounterVec
+	dhcpSocketCreateFailuresTotal            *prometheus.CounterVec
+	dhcpDuplicateBoundAddresses              prometheus.Gauge
+	greedydhcpPanicsTotal                    *prometheus.CounterVec
+	greedydhcpStartTimeSeconds               prometheus.Gauge
+	greedydhcpHistoryBytes                   prometheus.Gauge
+	dhcpCircuitBreakerState                  *prometheus.GaugeVec
+	dhcpTargetExpectation                    *prometheus.GaugeVec
+	dhcpTargetInSchedule                     *prometheus.GaugeVec
+	greedydhcpNATSEventsPublishedTotal       prometheus.Counter
+	greedydhcpNATSEventsDroppedTotal         prometheus.Counter
+	greedydhcpNATSPublishErrorsTotal         prometheus.Counter
+
+	// collectors lists every metric by name, so that they can be
+	// registered selectively.
+	collectors []namedCollector
+	// targetVecs lists every metric labeled by target IP.
+	targetVecs []interface {
+		DeletePartialMatch(prometheus.Labels) int
+	}
+}
+
+// namedCollector is a metric and its name.
+type namedCollector struct {
+	name      string
+	collector prometheus.Collector
+}
+
+// newMetrics returns the metrics of a prober. nativeHistograms adds native
+// buckets to the duration histograms, and acquireSummaryQuantiles are the
+// quantiles of the acquire duration summary, which is only registered if
+// there are any.
+func newMetrics(nativeHistograms bool, acquireSummaryQuantiles map[float64]float64) *metrics {
+	m := &metrics{
+		dhcpAcquiredLeasesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_acquired_leases_total",
+				Help: "The number of times a lease was acquired, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpStableLeasesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_stable_leases_total",
+				Help: "The number of acquired leases that were held for the stable grace period without being lost, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpSLOBreachesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_slo_breaches_total",
+				Help: "The number of leases that took longer to acquire than the acquire latency SLO, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpExpiredLeasesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_expired_leases_total",
+				Help: "The number of times a lease has expired, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpFailedLeasesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_failed_leases_total",
+				Help: "The number of times a lease failed to be acquired, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpAcquireFailuresTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_acquire_failures_total",
+				Help: "The number of times a lease couldn't be acquired while none was held, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpLostLeasesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_lost_leases_total",
+				Help: "The number of times a held lease was lost, through expiry or a NAK on renewal, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpLeaseExpiryTimestampSeconds: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_lease_expiry_timestamp_seconds",
+				Help: "A timestamp representing the expiry time for a lease as a unix timestamp, +Inf for an infinite lease, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpLeaseT1Seconds: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_lease_t1_seconds",
+				Help: "The renewal (T1) time granted for a lease in seconds since it was bound, +Inf for an infinite lease, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpLeaseRenewTimestampSeconds: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_lease_renew_timestamp_seconds",
+				Help: "The time a lease is scheduled to be renewed at as a unix timestamp, before any jitter: its T1, unless RENEW_SCALE_FACTOR is set, +Inf for an infinite lease, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpLeaseT2Seconds: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_lease_t2_seconds",
+				Help: "The rebinding (T2) time granted for a lease in seconds since it was bound, +Inf for an infinite lease, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpDistinctServersSeen: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_distinct_servers_seen",
+				Help: "The number of distinct DHCP servers that have answered a target, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpShortLeasesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_short_lease_total",
+				Help: "The number of times a granted lease was shorter than the minimum acceptable lease time, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpInvalidLeaseTimeTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_invalid_lease_time_total",
+				Help: "The number of ACKs granting a lease time of zero, which aren't bound, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpOutOfSubnetOffersTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_out_of_subnet_offers_total",
+				Help: "The number of leases bound outside the subnet expected for a target, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpFailuresTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_failures_total",
+				Help: "The number of failed attempts to acquire or renew a lease, labeled by IP and reason",
+			}, []string{"ip", "reason"},
+		),
+		dhcpPacketsFilteredTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_packets_filtered_total",
+				Help: "The number of received packets dropped while waiting for a reply, labeled by IP and reason",
+			}, []string{"ip", "reason"},
+		),
+		dhcpMalformedRepliesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_malformed_replies_total",
+				Help: "The number of packets sent to the client port dropped for not being sane replies, the subset of dhcp_packets_filtered_total that isn't meant for other clients, labeled by IP and reason",
+			}, []string{"ip", "reason"},
+		),
+		dhcpLastError: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_last_error",
+				Help: "Set to 1 for the reason of the most recent failure of a target, absent after a success, labeled by IP and reason",
+			}, []string{"ip", "reason"},
+		),
+		dhcpTargetServerAnswering: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_target_server_answering",
+				Help: "Set to 1 if the server configured for a target answered its latest attempt, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpLeasesRestoredTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_leases_restored_total",
+				Help: "The number of times a lease saved by a previous run was successfully renewed, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpLeaseImported: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_lease_imported",
+				Help: "Set to 1 if the lease a target started by renewing was read from OS_LEASE_FILES, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpManualReacquiresTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_manual_reacquires_total",
+				Help: "The number of re-acquisitions triggered through the reacquire endpoint, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpLeaseDNSServers: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_lease_dns_servers",
+				Help: "The number of DNS servers handed out with the current lease, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpLeaseDNSServerInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_lease_dns_server_info",
+				Help: "Set to 1 for each DNS server handed out with the current lease, labeled by IP and server",
+			}, []string{"ip", "server"},
+		),
+		dhcpLeaseNetmaskInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_lease_netmask_info",
+				Help: "Set to 1 for the subnet mask handed out with the current lease, labeled by IP and netmask",
+			}, []string{"ip", "netmask"},
+		),
+		dhcpLeaseAddressInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_lease_address_info",
+				Help: "Set to 1 for the address bound by the current lease, labeled by IP and bound address",
+			}, []string{"ip", "bound"},
+		),
+		dhcpLeaseRouterInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_lease_router_info",
+				Help: "Set to 1 for each router handed out with the current lease, labeled by IP and router",
+			}, []string{"ip", "router"},
+		),
+		dhcpLeaseInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_lease_info",
+				Help: "Set to 1 for the current lease, labeled by IP, the server identifier of the server that granted it, and the first router and the subnet mask handed out with it",
+			}, []string{"ip", "server_id", "router", "subnet_mask"},
+		),
+		dhcpLeaseSearchDomainInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_lease_search_domain_info",
+				Help: "Set to 1 for each search domain (option 119) handed out with the current lease, labeled by IP and domain",
+			}, []string{"ip", "domain"},
+		),
+		dhcpOption119DecodeErrorsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_option119_decode_errors_total",
+				Help: "The number of leases whose domain search list (option 119) could not be decoded, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpLeaseNTPServerInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_lease_ntp_server_info",
+				Help: "Set to 1 for each NTP server (option 42) handed out with the current lease, labeled by IP and server",
+			}, []string{"ip", "server"},
+		),
+		dhcpLeaseBootInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_lease_boot_info",
+				Help: "Set to 1 for the boot settings handed out with the current lease, if any: siaddr, the TFTP server (option 66, or the sname field) and the bootfile (option 67, or the file field), labeled by IP",
+			}, []string{"ip", "next_server", "tftp_server", "bootfile"},
+		),
+		dhcpServerMessageInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_server_message_info",
+				Help: "Set to 1 for the latest message (option 56) a server sent with an ACK or NAK, truncated to 128 bytes, labeled by IP, the type of the reply and the message",
+			}, []string{"ip", "type", "message"},
+		),
+		dhcpLeaseInterfaceMTU: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_lease_interface_mtu",
+				Help: "The interface MTU (option 26) handed out with the current lease, or 0 if none, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpLeaseOptionBytes: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_lease_option_bytes",
+				Help: "The encoded size of the options the current lease was acknowledged with, or 0 if none is held, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpOptionSetChangedTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_option_set_changed_total",
+				Help: "The number of times a lease was bound with a different set of options than the previous one, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpServerOptionSeen: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_server_option_seen",
+				Help: "Set to 1 for every option code a target's leases were ever bound with, with TRACK_OPTION_CODES, labeled by IP and code",
+			}, []string{"ip", "code"},
+		),
+		dhcpGatewayReachable: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_gateway_reachable",
+				Help: "Set to 1 if the router handed out with the held lease answered a ping, absent until it is pinged, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpFQDNServerUpdates: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_fqdn_server_updates",
+				Help: "Set to 1 if the server answered the client FQDN option of the held lease saying it updates the A record, 0 if it said it doesn't, absent if it didn't answer, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpRetryBackoffSeconds: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_retry_backoff_seconds",
+				Help: "How long the target waits before retrying after its last failure, 0 once a lease is bound, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpSendRatePPS: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "dhcp_send_rate_pps",
+				Help: "The number of packets sent by all targets during the last second, exported when MAX_PPS is set",
+			},
+		),
+		dhcpLinkUpResetsTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "dhcp_link_up_backoff_resets_total",
+				Help: "The number of times the retry backoff of every target was reset because the interface came back up",
+			},
+		),
+		dhcpTargetNetnsInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_target_netns_info",
+				Help: "Set to 1 for the network namespace the client of a target runs in, labeled by IP and netns",
+			}, []string{"ip", "netns"},
+		),
+		dhcpTargetVLANInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_target_vlan_info",
+				Help: "Set to 1 for the VLAN the client of a target runs on, labeled by IP and VLAN ID",
+			}, []string{"ip", "vlan"},
+		),
+		dhcpRenewalIntervalSeconds: prometheus.NewHistogramVec(
+			durationHistogramOpts(nativeHistograms, prometheus.HistogramOpts{
+				Name:    "dhcp_renewal_interval_seconds",
+				Help:    "The time between consecutive binds of a target's lease, labeled by IP",
+				Buckets: prometheus.ExponentialBuckets(1, 4, 9),
+			}), []string{"ip"},
+		),
+		dhcpDiscoverToOfferSeconds: prometheus.NewHistogramVec(
+			durationHistogramOpts(nativeHistograms, prometheus.HistogramOpts{
+				Name:    "dhcp_discover_to_offer_seconds",
+				Help:    "The time between sending a DISCOVER and receiving an OFFER, labeled by IP",
+				Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+			}), []string{"ip"},
+		),
+		dhcpRequestToAckSeconds: prometheus.NewHistogramVec(
+			durationHistogramOpts(nativeHistograms, prometheus.HistogramOpts{
+				Name:    "dhcp_request_to_ack_seconds",
+				Help:    "The time between sending a REQUEST and receiving an ACK, labeled by IP",
+				Buckets: prometheus.ExponentialBuckets(0.001, 4, 8),
+			}), []string{"ip"},
+		),
+		dhcpLeaseAcquisitionDurationSeconds: prometheus.NewHistogramVec(
+			durationHistogramOpts(nativeHistograms, prometheus.HistogramOpts{
+				Name:    "dhcp_lease_acquisition_duration_seconds",
+				Help:    "The time taken to acquire each lease, from the first attempt at startup or after the last lease was lost until it was bound, labeled by IP",
+				Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+			}), []string{"ip"},
+		),
+		dhcpAcquireDurationSeconds: prometheus.NewSummaryVec(
+			prometheus.SummaryOpts{
+				Name:       "dhcp_acquire_duration_seconds",
+				Help:       "The time taken to acquire each lease, from the first attempt until it was bound, labeled by IP",
+				Objectives: acquireSummaryQuantiles,
+			}, []string{"ip"},
+		),
+		dhcpMessageSizeBytes: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name: "dhcp_message_size_bytes",
+				Help: "The size of each sent DHCP message, labeled by IP and message type",
+				// 576 is the largest message every server must accept, and
+				// 1472 fills a 1500 byte MTU.
+				Buckets: []float64{300, 400, 500, 576, 800, 1000, 1200, 1472},
+			}, []string{"ip", "type"},
+		),
+		dhcpPreferenceIndex: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_preference_index",
+				Help: "The position in its preference list of the address last bound for a target, 0 being the target itself and -1 an address that wasn't listed, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpSquatNAKs: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_squat_naks",
+				Help: "The number of NAKs received while squatting on a target address, until it was won or given up on, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpSquatOutcomesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_squat_outcomes_total",
+				Help: "The number of times squatting on a target address ended, labeled by IP and outcome",
+			}, []string{"ip", "outcome"},
+		),
+		dhcpRenewalStreak: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_renewal_streak",
+				Help: "The number of renewals in a row of the current lease, reset to 0 by any failure, expiry or new acquisition, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpStateSecondsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_state_seconds_total",
+				Help: "The time a target's client spent in each DHCP state, labeled by IP and state",
+			}, []string{"ip", "state"},
+		),
+		dhcpAddressChangedTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_address_changed_total",
+				Help: "The number of times renewing a lease granted a different address than the one held, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpLeaseOutageSeconds: prometheus.NewHistogramVec(
+			durationHistogramOpts(nativeHistograms, prometheus.HistogramOpts{
+				Name:    "dhcp_lease_outage_seconds",
+				Help:    "The time a target was without a lease, from losing one until the next was bound, labeled by IP",
+				Buckets: prometheus.ExponentialBuckets(1, 4, 9),
+			}), []string{"ip"},
+		),
+		dhcpMaxMessageSizeBytes: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_max_message_size_bytes",
+				Help: "The largest message servers may send the target: MAX_MESSAGE_SIZE if it is advertised with option 57, and the 576 bytes every client must accept otherwise, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpTruncatedRepliesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_truncated_replies_total",
+				Help: "The number of ACKs whose options ran to the end of the message without an end option, as when a server truncates them, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpRepliesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_replies_total",
+				Help: "The number of offers, ACKs bound and NAKs received, labeled by IP and path: relayed if the reply had a giaddr set, direct otherwise",
+			}, []string{"ip", "path"},
+		),
+		dhcpReplyRelayInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_reply_relay_info",
+				Help: "Set to 1 for the relay the last reply to a target came through, absent if it came straight from its server, labeled by IP and relay address",
+			}, []string{"ip", "relay"},
+		),
+		dhcpAlternativeOffersTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_alternative_offers_total",
+				Help: "The number of offers of another address than the one requested, labeled by IP, the TARGET_OFFER_POLICY applied and whether the offer was accepted or rejected",
+			}, []string{"ip", "policy", "outcome"},
+		),
+		dhcpTruncatedReadsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_truncated_reads_total",
+				Help: "The number of received packets cut short by the receive buffer, see RECEIVE_BUFFER_SIZE, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpOfferProbesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_offer_probes_total",
+				Help: "The number of offered addresses checked with TARGET_OFFER_PROBE before being requested, labeled by IP and outcome: free, in_use, in which case the address was declined, or error",
+			}, []string{"ip", "outcome"},
+		),
+		dhcpNAKToDiscoverTransitionsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_nak_to_discover_transitions_total",
+				Help: "The number of times a client went back to INIT and sent a fresh DISCOVER after a NAK, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpResponseJitterSeconds: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_response_jitter_seconds",
+				Help: "The standard deviation of the last RESPONSE_JITTER_WINDOW times the server took to answer a DISCOVER or REQUEST, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpBytesSentTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_bytes_sent_total",
+				Help: "The number of bytes of the frames sent, Ethernet header included, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpBytesReceivedTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_bytes_received_total",
+				Help: "The number of bytes of the frames received addressed to the client, Ethernet header included, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpDuplicateAcksTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_duplicate_acks_total",
+				Help: "The number of further ACKs to a REQUEST received within DUPLICATE_ACK_WINDOW of the one bound, and ignored, labeled by IP and outcome: same_address or different_address than the bound one",
+			}, []string{"ip", "outcome"},
+		),
+		dhcpDelayedRequestsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_delayed_requests_total",
+				Help: "The number of requests sent TARGET_REQUEST_DELAY after an offer, labeled by IP and outcome: bound, or the reason they failed, nak meaning the offer was withdrawn",
+			}, []string{"ip", "outcome"},
+		),
+		dhcpInitRebootTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_init_reboot_total",
+				Help: "The number of INIT-REBOOT requests made at startup, labeled by IP and outcome: bound if the target address was acknowledged, and the failure reason otherwise, when discovery is fallen back to",
+			}, []string{"ip", "outcome"},
+		),
+		dhcpStartWaitSeconds: prometheus.NewHistogramVec(
+			durationHistogramOpts(nativeHistograms, prometheus.HistogramOpts{
+				Name:    "dhcp_start_wait_seconds",
+				Help:    "The time a target waited for a MAX_CONCURRENT_START slot before starting, labeled by IP",
+				Buckets: prometheus.ExponentialBuckets(0.01, 4, 9),
+			}), []string{"ip"},
+		),
+		dhcpWaitingForStartSlot: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_waiting_for_start_slot",
+				Help: "Set to 1 while a target waits for MAX_CONCURRENT_START to let it start, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpTargetHardFailed: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_target_hard_failed",
+				Help: "Set to 1 once a target failed MAX_FAILURES attempts in a row, until it gets a lease again, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpWaitingForDependencies: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_waiting_for_dependencies",
+				Help: "Set to 1 while a target waits for the targets it depends on to hold a lease before it starts, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpChurnCyclesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_churn_cycles_total",
+				Help: "The number of times a lease was released after its hold time to be requested again, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpChurnReacquiresTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_churn_reacquires_total",
+				Help: "The number of leases bound after releasing one at the end of the hold time, labeled by IP and outcome: same_address if the released address was bound again, new_address otherwise",
+			}, []string{"ip", "outcome"},
+		),
+		dhcpChurnPhase: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_churn_phase",
+				Help: "Set to 1 for the current phase of a target that periodically releases its lease, labeled by IP and phase",
+			}, []string{"ip", "phase"},
+		),
+		dhcpConfiguredTargets: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "dhcp_configured_targets",
+				Help: "The number of enabled targets in the configuration last applied",
+			},
+		),
+		dhcpRunningClients: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "dhcp_running_clients",
+				Help: "The number of target clients currently running",
+			},
+		),
+		dhcpAnyLeaseAcquired: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "dhcp_any_lease_acquired",
+				Help: "Set to 1 once any target has acquired a lease since startup",
+			},
+		),
+		dhcpEstimatedPoolSize: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_estimated_pool_size",
+				Help: "The number of distinct addresses the pool estimate mode acquired before the server stopped handing out new ones, labeled by subnet. Only set once the estimate is done",
+			}, []string{"subnet"},
+		),
+		dhcpTargetChaddrInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_target_chaddr_info",
+				Help: "Set to 1 for the client hardware address the client of a target sends, labeled by IP and MAC",
+			}, []string{"ip", "mac"},
+		),
+		dhcpStressClientsTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "dhcp_stress_clients_total",
+				Help: "The number of unique clients spawned in stress mode",
+			},
+		),
+		dhcpStressAddressesConsumed: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "dhcp_stress_addresses_consumed",
+				Help: "The number of distinct addresses currently held by stress mode clients",
+			},
+		),
+		dhcpStressLeaseInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_stress_lease_info",
+				Help: "Set to 1 for the address held by each stress mode client, labeled by the client's MAC and the address",
+			}, []string{"mac", "addr"},
+		),
+		dhcpAnonymousBoundLeasesTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "dhcp_anonymous_bound_leases_total",
+				Help: "The number of leases bound by anonymous clients, renewals included",
+			},
+		),
+		dhcpAnonymousRejectedOffersTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "dhcp_anonymous_rejected_offers_total",
+				Help: "The number of offers anonymous clients rejected for being outside of ANONYMOUS_SUBNET",
+			},
+		),
+		dhcpAnonymousAddressesHeld: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "dhcp_anonymous_addresses_held",
+				Help: "The number of anonymous clients currently holding an address",
+			},
+		),
+		dhcpAnonymousLeaseInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_anonymous_lease_info",
+				Help: "Set to 1 for each address held by an anonymous client, labeled by the address, the client's MAC and the server",
+			}, []string{"ip", "mac", "server"},
+		),
+		dhcpAnonymousLeaseExpiryTimestampSeconds: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_anonymous_lease_expiry_timestamp_seconds",
+				Help: "Unix time at which the lease of each address held by an anonymous client expires, absent for infinite leases",
+			}, []string{"ip"},
+		),
+		dhcpInterfaceInfo: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_interface_info",
+				Help: "Set to 1 for the interface clients run on, labeled by name, MAC address, index, type: physical, bridge, bond, vlan, virtual or unknown, and the VRF it belongs to, if any",
+			}, []string{"iface", "mac", "index", "type", "vrf"},
+		),
+		dhcpInterfaceIndexChangesTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "dhcp_interface_index_changes_total",
+				Help: "The number of times the selected interface was found with a different index",
+			},
+		),
+		dhcpInterfaceMACChangesTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "dhcp_interface_mac_changes_total",
+				Help: "The number of times the MAC address of the interface clients run on was found to have changed",
+			},
+		),
+		dhcpInterfaceReselectionsTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "dhcp_interface_reselections_total",
+				Help: "The number of times clients were switched to another interface because theirs had no addresses left",
+			},
+		),
+		dhcpCrossInterfaceLeasesTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_cross_interface_leases_total",
+				Help: "The number of leases bound with an address on the subnet of another interface rather than the one requested on, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpGratuitousARPConflictsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_gratuitous_arp_conflicts_total",
+				Help: "The number of acquired addresses that another host defended after they were announced with a gratuitous ARP, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpPostBindConflictsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_post_bind_conflicts_total",
+				Help: "The number of times another host answered an ARP probe for the address held by the target, every ARP_RECHECK_INTERVAL, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpSocketCreateFailuresTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "dhcp_socket_create_failures_total",
+				Help: "The number of times the raw socket of a client couldn't be opened, labeled by IP",
+			}, []string{"ip"},
+		),
+		dhcpDuplicateBoundAddresses: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "dhcp_duplicate_bound_addresses",
+				Help: "The number of addresses currently bound by more than one target",
+			},
+		),
+		greedydhcpPanicsTotal: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "greedydhcp_panics_total",
+				Help: "The number of panics recovered from, labeled by component",
+			}, []string{"component"},
+		),
+		greedydhcpStartTimeSeconds: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "greedydhcp_start_time_seconds",
+				Help: "The time the process started, as a Unix timestamp",
+			},
+		),
+		greedydhcpHistoryBytes: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "greedydhcp_history_bytes",
+				Help: "The approximate memory taken by the lease events kept for /events, in bytes",
+			},
+		),
+		dhcpCircuitBreakerState: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_circuit_breaker_state",
+				Help: "Set to 1 for the current state of a target's circuit breaker, labeled by IP and state",
+			}, []string{"ip", "state"},
+		),
+		dhcpTargetExpectation: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_target_expectation",
+				Help: "Set to 1 for the outcome of a target's TARGET_EXPECT_TIMEOUT expectation, labeled by IP and outcome",
+			}, []string{"ip", "outcome"},
+		),
+		dhcpTargetInSchedule: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "dhcp_target_in_schedule",
+				Help: "Set to 1 while a target is in one of the windows of its TARGET_SCHEDULE, or always if it has none, and 0 otherwise, labeled by IP",
+			}, []string{"ip"},
+		),
+		greedydhcpNATSEventsPublishedTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "greedydhcp_nats_events_published_total",
+				Help: "The number of lease events published to NATS_URL",
+			},
+		),
+		greedydhcpNATSEventsDroppedTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "greedydhcp_nats_events_dropped_total",
+				Help: "The number of lease events dropped because the NATS_BUFFER_SIZE buffer was full",
+			},
+		),
+		greedydhcpNATSPublishErrorsTotal: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "greedydhcp_nats_publish_errors_total",
+				Help: "The number of lease events that couldn't be published to NATS_URL",
+			},
+		),
+	}
+
+	m.collectors = []namedCollector{
+		{"dhcp_acquired_leases_total", m.dhcpAcquiredLeasesTotal},
+		{"dhcp_stable_leases_total", m.dhcpStableLeasesTotal},
+		{"dhcp_slo_breaches_total", m.dhcpSLOBreachesTotal},
+		{"dhcp_expired_leases_total", m.dhcpExpiredLeasesTotal},
+		{"dhcp_failed_leases_total", m.dhcpFailedLeasesTotal},
+		{"dhcp_acquire_failures_total", m.dhcpAcquireFailuresTotal},
+		{"dhcp_lost_leases_total", m.dhcpLostLeasesTotal},
+		{"dhcp_lease_expiry_timestamp_seconds", m.dhcpLeaseExpiryTimestampSeconds},
+		{"dhcp_lease_t1_seconds", m.dhcpLeaseT1Seconds},
+		{"dhcp_lease_renew_timestamp_seconds", m.dhcpLeaseRenewTimestampSeconds},
+		{"dhcp_lease_t2_seconds", m.dhcpLeaseT2Seconds},
+		{"dhcp_distinct_servers_seen", m.dhcpDistinctServersSeen},
+		{"dhcp_short_lease_total", m.dhcpShortLeasesTotal},
+		{"dhcp_invalid_lease_time_total", m.dhcpInvalidLeaseTimeTotal},
+		{"dhcp_out_of_subnet_offers_total", m.dhcpOutOfSubnetOffersTotal},
+		{"dhcp_failures_total", m.dhcpFailuresTotal},
+		{"dhcp_packets_filtered_total", m.dhcpPacketsFilteredTotal},
+		{"dhcp_malformed_replies_total", m.dhcpMalformedRepliesTotal},
+		{"dhcp_last_error", m.dhcpLastError},
+		{"dhcp_target_server_answering", m.dhcpTargetServerAnswering},
+		{"dhcp_leases_restored_total", m.dhcpLeasesRestoredTotal},
+		{"dhcp_lease_imported", m.dhcpLeaseImported},
+		{"dhcp_manual_reacquires_total", m.dhcpManualReacquiresTotal},
+		{"dhcp_lease_dns_servers", m.dhcpLeaseDNSServers},
+		{"dhcp_lease_dns_server_info", m.dhcpLeaseDNSServerInfo},
+		{"dhcp_lease_netmask_info", m.dhcpLeaseNetmaskInfo},
+		{"dhcp_lease_address_info", m.dhcpLeaseAddressInfo},
+		{"dhcp_lease_router_info", m.dhcpLeaseRouterInfo},
+		{"dhcp_lease_info", m.dhcpLeaseInfo},
+		{"dhcp_lease_ntp_server_info", m.dhcpLeaseNTPServerInfo},
+		{"dhcp_lease_search_domain_info", m.dhcpLeaseSearchDomainInfo},
+		{"dhcp_option119_decode_errors_total", m.dhcpOption119DecodeErrorsTotal},
+		{"dhcp_lease_boot_info", m.dhcpLeaseBootInfo},
+		{"dhcp_server_message_info", m.dhcpServerMessageInfo},
+		{"dhcp_lease_interface_mtu", m.dhcpLeaseInterfaceMTU},
+		{"dhcp_lease_option_bytes", m.dhcpLeaseOptionBytes},
+		{"dhcp_option_set_changed_total", m.dhcpOptionSetChangedTotal},
+		{"dhcp_server_option_seen", m.dhcpServerOptionSeen},
+		{"dhcp_gateway_reachable", m.dhcpGatewayReachable},
+		{"dhcp_fqdn_server_updates", m.dhcpFQDNServerUpdates},
+		{"dhcp_target_netns_info", m.dhcpTargetNetnsInfo},
+		{"dhcp_target_vlan_info", m.dhcpTargetVLANInfo},
+		{"dhcp_target_chaddr_info", m.dhcpTargetChaddrInfo},
+		{"dhcp_retry_backoff_seconds", m.dhcpRetryBackoffSeconds},
+		{"dhcp_link_up_backoff_resets_total", m.dhcpLinkUpResetsTotal},
+		{"dhcp_send_rate_pps", m.dhcpSendRatePPS},
+		{"dhcp_renewal_interval_seconds", m.dhcpRenewalIntervalSeconds},
+		{"dhcp_discover_to_offer_seconds", m.dhcpDiscoverToOfferSeconds},
+		{"dhcp_request_to_ack_seconds", m.dhcpRequestToAckSeconds},
+		{"dhcp_lease_acquisition_duration_seconds", m.dhcpLeaseAcquisitionDurationSeconds},
+		{"dhcp_acquire_duration_seconds", m.dhcpAcquireDurationSeconds},
+		{"dhcp_message_size_bytes", m.dhcpMessageSizeBytes},
+		{"dhcp_preference_index", m.dhcpPreferenceIndex},
+		{"dhcp_squat_naks", m.dhcpSquatNAKs},
+		{"dhcp_squat_outcomes_total", m.dhcpSquatOutcomesTotal},
+		{"dhcp_init_reboot_total", m.dhcpInitRebootTotal},
+		{"dhcp_max_message_size_bytes", m.dhcpMaxMessageSizeBytes},
+		{"dhcp_truncated_replies_total", m.dhcpTruncatedRepliesTotal},
+		{"dhcp_truncated_reads_total", m.dhcpTruncatedReadsTotal},
+		{"dhcp_offer_probes_total", m.dhcpOfferProbesTotal},
+		{"dhcp_nak_to_discover_transitions_total", m.dhcpNAKToDiscoverTransitionsTotal},
+		{"dhcp_response_jitter_seconds", m.dhcpResponseJitterSeconds},
+		{"dhcp_bytes_sent_total", m.dhcpBytesSentTotal},
+		{"dhcp_bytes_received_total", m.dhcpBytesReceivedTotal},
+		{"dhcp_alternative_offers_total", m.dhcpAlternativeOffersTotal},
+		{"dhcp_replies_total", m.dhcpRepliesTotal},
+		{"dhcp_reply_relay_info", m.dhcpReplyRelayInfo},
+		{"dhcp_duplicate_acks_total", m.dhcpDuplicateAcksTotal},
+		{"dhcp_delayed_requests_total", m.dhcpDelayedRequestsTotal},
+		{"dhcp_renewal_streak", m.dhcpRenewalStreak},
+		{"dhcp_state_seconds_total", m.dhcpStateSecondsTotal},
+		{"dhcp_address_changed_total", m.dhcpAddressChangedTotal},
+		{"dhcp_lease_outage_seconds", m.dhcpLeaseOutageSeconds},
+		{"dhcp_waiting_for_start_slot", m.dhcpWaitingForStartSlot},
+		{"dhcp_start_wait_seconds", m.dhcpStartWaitSeconds},
+		{"dhcp_waiting_for_dependencies", m.dhcpWaitingForDependencies},
+		{"dhcp_target_hard_failed", m.dhcpTargetHardFailed},
+		{"dhcp_churn_cycles_total", m.dhcpChurnCyclesTotal},
+		{"dhcp_churn_reacquires_total", m.dhcpChurnReacquiresTotal},
+		{"dhcp_churn_phase", m.dhcpChurnPhase},
+		{"dhcp_configured_targets", m.dhcpConfiguredTargets},
+		{"dhcp_running_clients", m.dhcpRunningClients},
+		{"dhcp_any_lease_acquired", m.dhcpAnyLeaseAcquired},
+		{"dhcp_stress_clients_total", m.dhcpStressClientsTotal},
+		{"dhcp_stress_addresses_consumed", m.dhcpStressAddressesConsumed},
+		{"dhcp_stress_lease_info", m.dhcpStressLeaseInfo},
+		{"dhcp_anonymous_bound_leases_total", m.dhcpAnonymousBoundLeasesTotal},
+		{"dhcp_anonymous_rejected_offers_total", m.dhcpAnonymousRejectedOffersTotal},
+		{"dhcp_anonymous_addresses_held", m.dhcpAnonymousAddressesHeld},
+		{"dhcp_anonymous_lease_info", m.dhcpAnonymousLeaseInfo},
+		{"dhcp_anonymous_lease_expiry_timestamp_seconds", m.dhcpAnonymousLeaseExpiryTimestampSeconds},
+		{"dhcp_estimated_pool_size", m.dhcpEstimatedPoolSize},
+		{"dhcp_interface_info", m.dhcpInterfaceInfo},
+		{"dhcp_interface_index_changes_total", m.dhcpInterfaceIndexChangesTotal},
+		{"dhcp_interface_reselections_total", m.dhcpInterfaceReselectionsTotal},
+		{"dhcp_interface_mac_changes_total", m.dhcpInterfaceMACChangesTotal},
+		{"dhcp_duplicate_bound_addresses", m.dhcpDuplicateBoundAddresses},
+		{"dhcp_socket_create_failures_total", m.dhcpSocketCreateFailuresTotal},
+		{"dhcp_gratuitous_arp_conflicts_total", m.dhcpGratuitousARPConflictsTotal},
+		{"dhcp_post_bind_conflicts_total", m.dhcpPostBindConflictsTotal},
+		{"dhcp_cross_interface_leases_total", m.dhcpCrossInterfaceLeasesTotal},
+		{"greedydhcp_start_time_seconds", m.greedydhcpStartTimeSeconds},
+		{"greedydhcp_panics_total", m.greedydhcpPanicsTotal},
+		{"greedydhcp_history_bytes", m.greedydhcpHistoryBytes},
+		{"dhcp_circuit_breaker_state", m.dhcpCircuitBreakerState},
+		{"dhcp_target_expectation", m.dhcpTargetExpectation},
+		{"dhcp_target_in_schedule", m.dhcpTargetInSchedule},
+		{"greedydhcp_nats_events_published_total", m.greedydhcpNATSEventsPublishedTotal},
+		{"greedydhcp_nats_events_dropped_total", m.greedydhcpNATSEventsDroppedTotal},
+		{"greedydhcp_nats_publish_errors_total", m.greedydhcpNATSPublishErrorsTotal},
+	}
+
+	m.targetVecs = []interface {
+		DeletePartialMatch(prometheus.Labels) int
+	}{
+		m.dhcpAcquiredLeasesTotal,
+		m.dhcpStableLeasesTotal,
+		m.dhcpSLOBreachesTotal,
+		m.dhcpExpiredLeasesTotal,
+		m.dhcpFailedLeasesTotal,
+		m.dhcpAcquireFailuresTotal,
+		m.dhcpLostLeasesTotal,
+		m.dhcpLeaseExpiryTimestampSeconds,
+		m.dhcpLeaseT1Seconds,
+		m.dhcpLeaseT2Seconds,
+		m.dhcpLeaseRenewTimestampSeconds,
+		m.dhcpDistinctServersSeen,
+		m.dhcpShortLeasesTotal,
+		m.dhcpInvalidLeaseTimeTotal,
+		m.dhcpOutOfSubnetOffersTotal,
+		m.dhcpFailuresTotal,
+		m.dhcpPacketsFilteredTotal,
+		m.dhcpMalformedRepliesTotal,
+		m.dhcpLastError,
+		m.dhcpTargetServerAnswering,
+		m.dhcpLeasesRestoredTotal,
+		m.dhcpLeaseImported,
+		m.dhcpManualReacquiresTotal,
+		m.dhcpLeaseDNSServers,
+		m.dhcpLeaseDNSServerInfo,
+		m.dhcpLeaseNetmaskInfo,
+		m.dhcpLeaseAddressInfo,
+		m.dhcpLeaseRouterInfo,
+		m.dhcpLeaseInfo,
+		m.dhcpLeaseNTPServerInfo,
+		m.dhcpLeaseSearchDomainInfo,
+		m.dhcpOption119DecodeErrorsTotal,
+		m.dhcpLeaseBootInfo,
+		m.dhcpServerMessageInfo,
+		m.dhcpLeaseInterfaceMTU,
+		m.dhcpLeaseOptionBytes,
+		m.dhcpOptionSetChangedTotal,
+		m.dhcpServerOptionSeen,
+		m.dhcpGatewayReachable,
+		m.dhcpFQDNServerUpdates,
+		m.dhcpTargetNetnsInfo,
+		m.dhcpTargetVLANInfo,
+		m.dhcpTargetChaddrInfo,
+		m.dhcpRetryBackoffSeconds,
+		m.dhcpSocketCreateFailuresTotal,
+		m.dhcpGratuitousARPConflictsTotal,
+		m.dhcpPostBindConflictsTotal,
+		m.dhcpCrossInterfaceLeasesTotal,
+		m.dhcpRenewalIntervalSeconds,
+		m.dhcpDiscoverToOfferSeconds,
+		m.dhcpRequestToAckSeconds,
+		m.dhcpLeaseAcquisitionDurationSeconds,
+		m.dhcpAcquireDurationSeconds,
+		m.dhcpMessageSizeBytes,
+		m.dhcpPreferenceIndex,
+		m.dhcpSquatNAKs,
+		m.dhcpSquatOutcomesTotal,
+		m.dhcpInitRebootTotal,
+		m.dhcpMaxMessageSizeBytes,
+		m.dhcpTruncatedRepliesTotal,
+		m.dhcpTruncatedReadsTotal,
+		m.dhcpOfferProbesTotal,
+		m.dhcpNAKToDiscoverTransitionsTotal,
+		m.dhcpResponseJitterSeconds,
+		m.dhcpBytesSentTotal,
+		m.dhcpBytesReceivedTotal,
+		m.dhcpAlternativeOffersTotal,
+		m.dhcpRepliesTotal,
+		m.dhcpReplyRelayInfo,
+		m.dhcpDuplicateAcksTotal,
+		m.dhcpDelayedRequestsTotal,
+		m.dhcpRenewalStreak,
+		m.dhcpStateSecondsTotal,
+		m.dhcpAddressChangedTotal,
+		m.dhcpLeaseOutageSeconds,
+		m.dhcpWaitingForStartSlot,
+		m.dhcpStartWaitSeconds,
+		m.dhcpWaitingForDependencies,
+		m.dhcpTargetHardFailed,
+		m.dhcpChurnCyclesTotal,
+		m.dhcpChurnReacquiresTotal,
+		m.dhcpChurnPhase,
+		m.dhcpCircuitBreakerState,
+		m.dhcpTargetExpectation,
+		m.dhcpTargetInSchedule,
+	}
+
+	return m
+}
+
+// knownNames returns the name of every metric that can be disabled.
+func (m *metrics) knownNames() map[string]bool {
+	names := map[string]bool{}
+	for _, c := range m.collectors {
+		names[c.name] = true
+	}
+	for _, name := range leaseCollectorMetrics {
+		names[name] = true
+	}
+
+	return names
+}
+
+// newRegistry returns the registry metrics are registered with and served
+// from. It has the Go runtime and process collectors of the default registry,
+// but nothing else registers with it behind the prober's back.
+func newRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return reg
+}
+
+// register registers every metric, including the collector computed from
+// leases, with reg, except for those named in disabled. It fails if reg
+// already has metrics of the same name, such as those of another prober.
+func (m *metrics) register(reg prometheus.Registerer, leases *leaseRegistry, disabled map[string]bool) error {
+	for _, c := range m.collectors {
+		if disabled[c.name] {
+			continue
+		}
+		if err := reg.Register(c.collector); err != nil {
+			return fmt.Errorf("unable to register %s: %w", c.name, err)
+		}
+	}
+
+	collector := newLeaseCollector(leases)
+	collector.disabled = disabled
+	return reg.Register(collector)
+}
+
+// readMetric returns the current value of a counter or gauge.
+func readMetric(m prometheus.Metric) float64 {
+	pb := &dto.Metric{}
+	if err := m.Write(pb); err != nil {
+		return 0
+	}
+
+	if pb.Counter != nil {
+		return pb.Counter.GetValue()
+	}
+
+	return pb.Gauge.GetValue()
+}
+
+// seriesValues returns the current value of every series of the counter or
+// gauge vector c, keyed by its label values in the order of their names,
+// joined by commas. Unlike WithLabelValues, it creates no series, so that
+// reading doesn't bring back those evicted by a seriesLRU.
+func seriesValues(c prometheus.Collector) map[string]float64 {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+
+	values := map[string]float64{}
+	for m := range ch {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			continue
+		}
+
+		labels := make([]string, 0, len(pb.Label))
+		for _, pair := range pb.Label {
+			labels = append(labels, pair.GetValue())
+		}
+		value := pb.GetGauge().GetValue()
+		if pb.Counter != nil {
+			value = pb.Counter.GetValue()
+		}
+		values[strings.Join(labels, ",")] = value
+	}
+
+	return values
+}
@@ -0,0 +1,122 @@
+package greedydhcp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMetricCollectorNames(t *testing.T) {
+	for _, c := range newMetrics(false, nil).collectors {
+		ch := make(chan *prometheus.Desc, 1)
+		c.collector.Describe(ch)
+		if desc := <-ch; !strings.Contains(desc.String(), `fqName: "`+c.name+`"`) {
+			t.Errorf("metric listed as %s is described as %s", c.name, desc)
+		}
+	}
+}
+
+func TestRegisterMetricsSkipsDisabled(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	disabled := map[string]bool{
+		"dhcp_lease_expiry_timestamp_seconds": true,
+		"dhcp_currently_held_leases":          true,
+	}
+	m := newMetrics(false, nil)
+	if err := m.register(reg, newLeaseRegistry(m.dhcpDuplicateBoundAddresses), disabled); err != nil {
+		t.Fatalf("unable to register metrics: %v", err)
+	}
+
+	m.dhcpLeaseExpiryTimestampSeconds.WithLabelValues("10.0.0.1").Set(1)
+	m.dhcpAcquiredLeasesTotal.WithLabelValues("10.0.0.1").Add(0)
+
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("unable to gather metrics: %v", err)
+	}
+
+	gathered := map[string]bool{}
+	for _, family := range families {
+		gathered[family.GetName()] = true
+	}
+
+	for name := range disabled {
+		if gathered[name] {
+			t.Errorf("expected %s to be disabled", name)
+		}
+	}
+	for _, name := range []string{"dhcp_acquired_leases_total", "dhcp_oldest_lease_age_seconds"} {
+		if !gathered[name] {
+			t.Errorf("expected %s to be registered", name)
+		}
+	}
+}
+
+func TestNewRegistry(t *testing.T) {
+	// Each registry is separate from the default one, and from another.
+	for i := 0; i < 2; i++ {
+		reg := newRegistry()
+		m := newMetrics(false, nil)
+		if err := m.register(reg, newLeaseRegistry(m.dhcpDuplicateBoundAddresses), nil); err != nil {
+			t.Fatalf("unable to register metrics: %v", err)
+		}
+
+		families, err := reg.Gather()
+		if err != nil {
+			t.Fatalf("unable to gather metrics: %v", err)
+		}
+		gathered := map[string]bool{}
+		for _, family := range families {
+			gathered[family.GetName()] = true
+		}
+		for _, name := range []string{"go_goroutines", "process_start_time_seconds", "greedydhcp_start_time_seconds"} {
+			if !gathered[name] {
+				t.Errorf("expected %s to be registered", name)
+			}
+		}
+	}
+}
+
+func TestDurationHistogramOptsNative(t *testing.T) {
+	for _, native := range []bool{false, true} {
+		h := prometheus.NewHistogram(durationHistogramOpts(native, prometheus.HistogramOpts{
+			Name:    "test_seconds",
+			Help:    "help",
+			Buckets: []float64{1, 2},
+		}))
+		h.Observe(1.5)
+
+		pb := &dto.Metric{}
+		if err := h.Write(pb); err != nil {
+			t.Fatal(err)
+		}
+		if got := pb.GetHistogram().Schema != nil; got != native {
+			t.Errorf("native %v: expected native buckets %v, got %v", native, native, got)
+		}
+		if n := len(pb.GetHistogram().GetBucket()); n != 2 {
+			t.Errorf("native %v: expected the classic buckets to be kept, got %d", native, n)
+		}
+	}
+}
+
+func TestParseQuantiles(t *testing.T) {
+	objectives, err := parseQuantiles("0.5, 0.9,0.99")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(objectives) != 3 || objectives[0.9] <= 0 || objectives[0.99] >= objectives[0.5] {
+		t.Errorf("unexpected objectives %v", objectives)
+	}
+
+	if objectives, err := parseQuantiles(""); objectives != nil || err != nil {
+		t.Errorf("expected no objectives, got %v and %v", objectives, err)
+	}
+
+	for _, s := range []string{"1", "0", "p99", "0.5,"} {
+		if _, err := parseQuantiles(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package greedydhcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+)
+
+const (
+	// minMaxMessageSize is the size of the largest message every server
+	// must accept, and the smallest maximum option 57 may give (RFC 2132
+	// section 9.10).
+	minMaxMessageSize = 576
+	// messageOverhead is the part of a message that isn't options: the IP
+	// and UDP headers and the fixed BOOTP fields.
+	messageOverhead = 20 + 8 + 236
+	// reservedOptionBytes is the room the client needs for the options it
+	// adds to every message itself: the magic cookie, the message type, the
+	// requested IP and server ID of a REQUEST and the end option.
+	reservedOptionBytes = 4 + 3 + 6 + 6 + 1
+	// minReceiveBufferSize and maxReceiveBufferSize bound the size of the
+	// buffer received packets are read into: at least the smallest message
+	// behind an Ethernet header, and well past the largest IP packet.
+	minReceiveBufferSize = minMaxMessageSize + 14
+	maxReceiveBufferSize = 1 << 18
+	// optionSizeWarnRatio is how full the room for options may get before
+	// a warning is logged.
+	optionSizeWarnRatio = 0.9
+)
+
+// parseMaxMessageSize parses the maximum message size sent in option 57.
+func parseMaxMessageSize(n int) (uint16, error) {
+	if n < minMaxMessageSize || n > 0xffff {
+		return 0, fmt.Errorf("must be between %d and %d, got %d", minMaxMessageSize, 0xffff, n)
+	}
+
+	return uint16(n), nil
+}
+
+// maxMessageSizeOption returns option 57 advertising size.
+func maxMessageSizeOption(size uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, size)
+}
+
+// optionsLength returns the encoded length of opts.
+func optionsLength(opts []dhclient.Option) int {
+	n := 0
+	for _, opt := range opts {
+		n += 2 + len(opt.Data)
+	}
+
+	return n
+}
+
+// checkOptionsSize logs the size of the options sent with every message
+// against the room a message of at most maxSize bytes leaves for them,
+// warning when they get close to filling it, or overflow it, as servers may
+// then truncate or drop the messages.
+func checkOptionsSize(logger *slog.Logger, opts []dhclient.Option, maxSize uint16, advertised bool) {
+	size := optionsLength(opts) + reservedOptionBytes
+	room := int(maxSize) - messageOverhead
+	logger.Debug("Message size", "options_bytes", size, "max_options_bytes", room, "max_message_size", maxSize)
+
+	switch {
+	case size > room && !advertised:
+		logger.Warn(
+			"Options are too large for the smallest message every server must accept, set MAX_MESSAGE_SIZE to advertise a larger one",
+			"options_bytes", size, "max_options_bytes", room,
+		)
+	case size > room:
+		logger.Warn("Options are too large for MAX_MESSAGE_SIZE", "options_bytes", size, "max_options_bytes", room, "max_message_size", maxSize)
+	case float64(size) > optionSizeWarnRatio*float64(room):
+		logger.Warn("Options nearly fill the largest message size", "options_bytes", size, "max_options_bytes", room, "max_message_size", maxSize)
+	}
+}
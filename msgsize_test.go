@@ -0,0 +1,61 @@
+package greedydhcp
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+)
+
+func TestParseMaxMessageSize(t *testing.T) {
+	if size, err := parseMaxMessageSize(1500); err != nil || size != 1500 {
+		t.Errorf("expected 1500, got %d, %v", size, err)
+	}
+
+	for _, n := range []int{575, 65536} {
+		if _, err := parseMaxMessageSize(n); err == nil {
+			t.Errorf("expected an error for %d", n)
+		}
+	}
+}
+
+func TestCheckOptionsSize(t *testing.T) {
+	// 576 bytes leave 312 for options, 20 of which the client needs itself.
+	option := func(n int) []dhclient.Option {
+		return []dhclient.Option{{Type: layers.DHCPOpt(224), Data: make([]byte, n-2)}}
+	}
+
+	tests := []struct {
+		name       string
+		opts       []dhclient.Option
+		maxSize    uint16
+		advertised bool
+		want       string
+	}{
+		{name: "small", opts: option(100), maxSize: 576},
+		{name: "nearly full", opts: option(290), maxSize: 576, want: "nearly fill"},
+		{name: "too large", opts: option(300), maxSize: 576, want: "set MAX_MESSAGE_SIZE"},
+		{name: "advertised", opts: option(300), maxSize: 1500, advertised: true},
+		{name: "too large for advertised", opts: option(300), maxSize: 576, advertised: true, want: "too large for MAX_MESSAGE_SIZE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			checkOptionsSize(slog.New(slog.NewTextHandler(&logs, nil)), tt.opts, tt.maxSize, tt.advertised)
+
+			if tt.want == "" {
+				if logs.Len() != 0 {
+					t.Errorf("expected no warning, got %s", logs.String())
+				}
+				return
+			}
+			if !strings.Contains(logs.String(), tt.want) {
+				t.Errorf("expected a warning containing %q, got %q", tt.want, logs.String())
+			}
+		})
+	}
+}
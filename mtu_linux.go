@@ -0,0 +1,30 @@
+//go:build linux
+
+package greedydhcp
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// setInterfaceMTU sets the MTU of the named interface.
+func setInterfaceMTU(name string, mtu int) error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("unable to open socket: %w", err)
+	}
+	defer unix.Close(fd)
+
+	ifreq, err := unix.NewIfreq(name)
+	if err != nil {
+		return err
+	}
+
+	ifreq.SetUint32(uint32(mtu))
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFMTU, ifreq); err != nil {
+		return fmt.Errorf("unable to set mtu of %s to %d: %w", name, mtu, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package greedydhcp
+
+import "errors"
+
+// setInterfaceMTU is only supported on Linux.
+func setInterfaceMTU(name string, mtu int) error {
+	return errors.New("setting the interface mtu is only supported on linux")
+}
@@ -0,0 +1,233 @@
+package greedydhcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// natsDialTimeout bounds connecting to the server and the handshake.
+	natsDialTimeout = 5 * time.Second
+	// natsReconnectDelay is how long the publisher waits after failing to
+	// connect before trying again. Events published meanwhile are counted
+	// as errors rather than buffered by the NATS client.
+	natsReconnectDelay = time.Second
+)
+
+// natsPublisher publishes lease events, as the same JSON served at /events,
+// to a subject on a NATS server. Events are queued in a bounded buffer and
+// sent by a single goroutine, so that a slow or unreachable server never
+// holds up a client: once the buffer is full, events are dropped and
+// counted. Delivery is at most once, as with any core NATS publisher. A nil
+// publisher publishes nothing.
+type natsPublisher struct {
+	logger  *slog.Logger
+	metrics *metrics
+	url     string
+	subject string
+	// credsFile, if set, is the credentials file to authenticate with.
+	credsFile string
+
+	// closeMu keeps events from being published to the buffer once it is
+	// closed, which clients still stopping after a shutdown timed out may.
+	closeMu sync.RWMutex
+	closed  bool
+	events  chan leaseEvent
+	done    chan struct{}
+
+	// mu guards conn and disconnected, as the connection is made by the
+	// publishing goroutine and closed by close.
+	mu   sync.Mutex
+	conn *nats.Conn
+	// disconnected is set once close has closed the connection, so that a
+	// connection made after it is closed straight away.
+	disconnected bool
+}
+
+// checkNATSPublisher returns an error if a publisher to subject on the
+// server at rawURL, of the form nats|tls://[user:pass@|token@]host[:port],
+// buffering up to bufferSize events, can't be created.
+func checkNATSPublisher(rawURL, subject string, bufferSize int) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "nats" && u.Scheme != "tls") || u.Host == "" {
+		return fmt.Errorf("%q is not a nats:// or tls://host[:port] URL", rawURL)
+	}
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return fmt.Errorf("invalid subject %q", subject)
+	}
+	if bufferSize <= 0 {
+		return fmt.Errorf("buffer size must be positive, got %d", bufferSize)
+	}
+
+	return nil
+}
+
+// newNATSPublisher returns a publisher to subject on the server at rawURL,
+// as checked by checkNATSPublisher, authenticating with credsFile if set,
+// buffering up to bufferSize events and counting them in m. It connects in
+// the background, so that the server needn't be up when the prober starts.
+func newNATSPublisher(logger *slog.Logger, m *metrics, rawURL, subject, credsFile string, bufferSize int) (*natsPublisher, error) {
+	if err := checkNATSPublisher(rawURL, subject, bufferSize); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &natsPublisher{
+		logger:    logger.With("nats", u.Redacted(), "subject", subject),
+		metrics:   m,
+		url:       rawURL,
+		subject:   subject,
+		credsFile: credsFile,
+		events:    make(chan leaseEvent, bufferSize),
+		done:      make(chan struct{}),
+	}
+	go p.run()
+
+	return p, nil
+}
+
+// publish queues e to be published, dropping it if the buffer is full.
+func (p *natsPublisher) publish(e leaseEvent) {
+	if p == nil {
+		return
+	}
+
+	p.closeMu.RLock()
+	defer p.closeMu.RUnlock()
+	if p.closed {
+		p.metrics.greedydhcpNATSEventsDroppedTotal.Inc()
+		return
+	}
+
+	select {
+	case p.events <- e:
+	default:
+		p.metrics.greedydhcpNATSEventsDroppedTotal.Inc()
+	}
+}
+
+// close publishes the events still buffered, waiting until ctx is done at
+// the latest, then disconnects. Events published after it is called are
+// dropped, and those still buffered once ctx is done fail to publish.
+func (p *natsPublisher) close(ctx context.Context) {
+	if p == nil {
+		return
+	}
+
+	p.closeMu.Lock()
+	p.closed = true
+	close(p.events)
+	p.closeMu.Unlock()
+
+	flushed := waitTimeout(ctx, func() { <-p.done })
+	if !flushed {
+		p.logger.Warn("Timed out publishing buffered lease events", "events", len(p.events))
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.disconnected = true
+	if p.conn == nil {
+		return
+	}
+	if flushed && p.conn.IsConnected() {
+		if err := p.conn.FlushWithContext(ctx); err != nil {
+			p.logger.Warn("Unable to flush lease events", "err", err)
+		}
+	}
+	p.conn.Close()
+}
+
+// run connects to the server, then sends every queued event until the
+// buffer is closed.
+func (p *natsPublisher) run() {
+	defer close(p.done)
+
+	conn, err := p.connect()
+	if err != nil {
+		p.logger.Error("Unable to connect to NATS, lease events won't be published", "err", err)
+	}
+	if conn != nil {
+		if conn.IsConnected() {
+			p.logger.Info("Connected to NATS")
+		}
+		p.mu.Lock()
+		if p.disconnected {
+			conn.Close()
+		} else {
+			p.conn = conn
+		}
+		p.mu.Unlock()
+	}
+
+	for e := range p.events {
+		if err := p.send(conn, e); err != nil {
+			p.logger.Warn("Unable to publish lease event", "event", e.Event, "target", e.Target, "err", err)
+			p.metrics.greedydhcpNATSPublishErrorsTotal.Inc()
+			continue
+		}
+		p.metrics.greedydhcpNATSEventsPublishedTotal.Inc()
+	}
+}
+
+// connect returns a connection to the server, which keeps reconnecting for
+// as long as it is open, even if the server can't be reached at first.
+func (p *natsPublisher) connect() (*nats.Conn, error) {
+	opts := []nats.Option{
+		nats.Name("greedy-dhcp"),
+		nats.Timeout(natsDialTimeout),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(natsReconnectDelay),
+		nats.ReconnectBufSize(-1),
+		// Only called if the first attempt failed.
+		nats.ConnectHandler(func(*nats.Conn) {
+			p.logger.Info("Connected to NATS")
+		}),
+		nats.ReconnectHandler(func(*nats.Conn) {
+			p.logger.Info("Connected to NATS")
+		}),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				p.logger.Warn("Lost connection to NATS", "err", err)
+			}
+		}),
+		nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+			p.logger.Warn("NATS server reported an error", "err", err)
+		}),
+	}
+	if p.credsFile != "" {
+		opts = append(opts, nats.UserCredentials(p.credsFile))
+	}
+
+	return nats.Connect(p.url, opts...)
+}
+
+// send publishes e on conn, which is nil if no connection could be made.
+func (p *natsPublisher) send(conn *nats.Conn, e leaseEvent) error {
+	if conn == nil {
+		return errors.New("not connected")
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return conn.Publish(p.subject, payload)
+}
@@ -0,0 +1,189 @@
+package greedydhcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeNATSServer accepts a single connection, completes the handshake and
+// sends the payload of every PUB to pubs.
+func fakeNATSServer(t *testing.T) (string, <-chan string, <-chan string) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	connects := make(chan string, 1)
+	pubs := make(chan string, 16)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		fmt.Fprint(conn, "INFO {\"server_id\":\"test\",\"max_payload\":1048576}\r\n")
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			op, args, _ := strings.Cut(strings.TrimSpace(line), " ")
+			switch op {
+			case "CONNECT":
+				connects <- args
+			case "PING":
+				fmt.Fprint(conn, "PONG\r\n")
+			case "PUB":
+				var subject string
+				var n int
+				if _, err := fmt.Sscanf(args, "%s %d", &subject, &n); err != nil {
+					t.Errorf("malformed PUB %q: %v", line, err)
+					return
+				}
+				payload := make([]byte, n+2)
+				if _, err := io.ReadFull(r, payload); err != nil {
+					return
+				}
+				pubs <- subject + " " + string(payload[:n])
+			}
+		}
+	}()
+
+	return "nats://secret@" + ln.Addr().String(), connects, pubs
+}
+
+func TestNATSPublisherPublishesEvents(t *testing.T) {
+	url, connects, pubs := fakeNATSServer(t)
+	p, err := newNATSPublisher(testLogger(t), testMetrics, url, "dhcp.events", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := metricValue(t, testMetrics.greedydhcpNATSEventsPublishedTotal)
+	logLeaseEvent(testLogger(t), nil, p, "10.0.0.1", slog.LevelWarn, eventFailed, "DHCP exchange failed", nil, "reason", failureTimeout)
+
+	select {
+	case connect := <-connects:
+		var opts struct {
+			Verbose   bool   `json:"verbose"`
+			AuthToken string `json:"auth_token"`
+		}
+		if err := json.Unmarshal([]byte(connect), &opts); err != nil {
+			t.Fatal(err)
+		}
+		if opts.AuthToken != "secret" || opts.Verbose {
+			t.Errorf("unexpected CONNECT options %s", connect)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for CONNECT")
+	}
+
+	select {
+	case pub := <-pubs:
+		subject, payload, _ := strings.Cut(pub, " ")
+		if subject != "dhcp.events" {
+			t.Errorf("published to %q, want dhcp.events", subject)
+		}
+		var e leaseEvent
+		if err := json.Unmarshal([]byte(payload), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Target != "10.0.0.1" || e.Event != eventFailed || e.Details["reason"] != failureTimeout {
+			t.Errorf("unexpected event %s", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for PUB")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	p.close(ctx)
+	if v := metricValue(t, testMetrics.greedydhcpNATSEventsPublishedTotal); v != before+1 {
+		t.Errorf("expected one event to be counted as published, got %v", v-before)
+	}
+}
+
+func TestNATSPublisherDropsWhenFull(t *testing.T) {
+	// A server that never sends its INFO holds the publisher in its
+	// handshake, so that the buffer fills up.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	p, err := newNATSPublisher(slog.New(slog.NewTextHandler(io.Discard, nil)), testMetrics, "nats://"+ln.Addr().String(), "dhcp.events", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		p.close(ctx)
+	}()
+
+	before := metricValue(t, testMetrics.greedydhcpNATSEventsDroppedTotal)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 5; i++ {
+			p.publish(leaseEvent{Target: "10.0.0.1", Event: eventAcquired})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publishing blocked on a full buffer")
+	}
+	// The first event may or may not have been taken off the buffer yet.
+	if dropped := metricValue(t, testMetrics.greedydhcpNATSEventsDroppedTotal) - before; dropped < 3 {
+		t.Errorf("expected at least 3 events to be dropped, got %v", dropped)
+	}
+}
+
+func TestNewNATSPublisherValidates(t *testing.T) {
+	for _, tc := range []struct {
+		url, subject string
+		buffer       int
+	}{
+		{"http://127.0.0.1:4222", "dhcp.events", 1},
+		{"nats://", "dhcp.events", 1},
+		{"nats://127.0.0.1", "", 1},
+		{"nats://127.0.0.1", "dhcp events", 1},
+		{"nats://127.0.0.1", "dhcp.events", 0},
+	} {
+		if p, err := newNATSPublisher(testLogger(t), testMetrics, tc.url, tc.subject, "", tc.buffer); err == nil {
+			p.close(context.Background())
+			t.Errorf("expected %q, %q, %d to be rejected", tc.url, tc.subject, tc.buffer)
+		}
+	}
+}
+
+func TestNATSPublisherNil(t *testing.T) {
+	var p *natsPublisher
+	p.publish(leaseEvent{})
+	p.close(context.Background())
+}
@@ -0,0 +1,67 @@
+//go:build linux
+
+package greedydhcp
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+	"github.com/mdlayher/packet"
+	"golang.org/x/sys/unix"
+)
+
+// inNetns calls f with the calling goroutine's thread moved into the network
+// namespace at path, e.g. /var/run/netns/blue. Sockets opened by f stay in
+// that namespace after it returns.
+func inNetns(path string, f func() error) error {
+	target, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("unable to open network namespace: %w", err)
+	}
+	defer target.Close()
+
+	runtime.LockOSThread()
+	origin, err := os.Open(fmt.Sprintf("/proc/self/task/%d/ns/net", unix.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("unable to open current network namespace: %w", err)
+	}
+	defer origin.Close()
+
+	if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("unable to enter network namespace %s: %w", path, err)
+	}
+
+	fErr := f()
+
+	// If the thread can't be moved back it is left locked, so that the
+	// runtime discards it when the goroutine exits rather than reusing it
+	// in the wrong namespace.
+	if err := unix.Setns(int(origin.Fd()), unix.CLONE_NEWNET); err != nil {
+		return fmt.Errorf("unable to leave network namespace %s: %w", path, err)
+	}
+	runtime.UnlockOSThread()
+
+	return fErr
+}
+
+// listenInNetns opens the raw socket of a client on the interface named name
+// in the network namespace at path.
+func listenInNetns(path, name string) (*packet.Conn, error) {
+	var conn *packet.Conn
+	err := inNetns(path, func() error {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return err
+		}
+
+		conn, err = dhclient.ListenRaw(iface)
+		return err
+	})
+
+	return conn, err
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package greedydhcp
+
+import (
+	"errors"
+
+	"github.com/mdlayher/packet"
+)
+
+// listenInNetns is only supported on Linux.
+func listenInNetns(path, name string) (*packet.Conn, error) {
+	return nil, errors.New("network namespaces are only supported on linux")
+}
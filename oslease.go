@@ -0,0 +1,268 @@
+package greedydhcp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+)
+
+// errUnknownLeaseFormat is returned for a lease file in neither the ISC
+// dhclient nor the systemd-networkd format.
+var errUnknownLeaseFormat = errors.New("not a dhclient or networkd lease file")
+
+// readOSLeaseFile reads the leases held by the host's own DHCP client from
+// path, keyed by address. Both the ISC dhclient format, as in
+// /var/lib/dhcp/dhclient.leases, and the systemd-networkd one, as in
+// /run/systemd/netif/leases/, are understood. Leases that have already
+// expired are dropped, and of several leases for an address the last one is
+// kept, as both clients append newer leases.
+func readOSLeaseFile(path string, now time.Time) (map[string]dhclient.Lease, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var leases []dhclient.Lease
+	switch {
+	case bytes.Contains(data, []byte("lease {")):
+		leases, err = parseDhclientLeases(data)
+	case bytes.Contains(data, []byte("ADDRESS=")):
+		// networkd only records times relative to when it was bound,
+		// which is when it wrote the file.
+		var info os.FileInfo
+		info, err = os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		var lease dhclient.Lease
+		lease, err = parseNetworkdLease(data, info.ModTime())
+		leases = []dhclient.Lease{lease}
+	default:
+		err = errUnknownLeaseFormat
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+
+	held := make(map[string]dhclient.Lease, len(leases))
+	for _, lease := range leases {
+		if !lease.Infinite && !now.Before(lease.Expire) {
+			continue
+		}
+		held[lease.FixedAddress.String()] = lease
+	}
+
+	return held, nil
+}
+
+// parseDhclientLeases parses the lease blocks of an ISC dhclient lease
+// file, in the order they appear.
+func parseDhclientLeases(data []byte) ([]dhclient.Lease, error) {
+	var (
+		leases  []dhclient.Lease
+		current *dhclient.Lease
+		// leaseTime is the lease time option of the current lease, to work
+		// out when it was bound.
+		leaseTime time.Duration
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if i := strings.Index(text, "#"); i >= 0 {
+			text = strings.TrimSpace(text[:i])
+		}
+
+		switch {
+		case text == "":
+			continue
+		case text == "lease {":
+			if current != nil {
+				return nil, fmt.Errorf("line %d: lease inside a lease", line)
+			}
+			current, leaseTime = &dhclient.Lease{}, 0
+			continue
+		case text == "}":
+			if current == nil {
+				return nil, fmt.Errorf("line %d: unexpected }", line)
+			}
+			if err := completeOSLease(current, leaseTime); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			leases = append(leases, *current)
+			current = nil
+			continue
+		case current == nil:
+			// Only the leases are of interest, not the other statements
+			// dhclient may keep in the file.
+			continue
+		}
+
+		statement := strings.Fields(strings.TrimSuffix(text, ";"))
+		var err error
+		switch {
+		case statement[0] == "fixed-address" && len(statement) == 2:
+			current.FixedAddress = net.ParseIP(statement[1]).To4()
+			if current.FixedAddress == nil {
+				err = fmt.Errorf("invalid address %q", statement[1])
+			}
+		case statement[0] == "option" && len(statement) == 3 && statement[1] == "dhcp-server-identifier":
+			current.ServerID = net.ParseIP(statement[2]).To4()
+			if current.ServerID == nil {
+				err = fmt.Errorf("invalid server identifier %q", statement[2])
+			}
+		case statement[0] == "option" && len(statement) == 3 && statement[1] == "dhcp-lease-time":
+			var secs uint64
+			secs, err = strconv.ParseUint(statement[2], 10, 32)
+			if secs == dhclient.InfiniteLeaseTime {
+				current.Infinite = true
+			}
+			leaseTime = time.Duration(secs) * time.Second
+		case statement[0] == "renew":
+			current.Renew, err = parseDhclientTime(statement[1:])
+		case statement[0] == "rebind":
+			current.Rebind, err = parseDhclientTime(statement[1:])
+		case statement[0] == "expire":
+			current.Expire, err = parseDhclientTime(statement[1:])
+			if err == nil && current.Expire.IsZero() {
+				current.Infinite = true
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if current != nil {
+		return nil, errors.New("unterminated lease")
+	}
+
+	return leases, nil
+}
+
+// parseDhclientTime parses the fields of a dhclient time statement: a
+// weekday followed by a UTC date and time, "epoch" followed by a Unix time,
+// or "never", which yields the zero time.
+func parseDhclientTime(fields []string) (time.Time, error) {
+	switch {
+	case len(fields) == 1 && fields[0] == "never":
+		return time.Time{}, nil
+	case len(fields) == 2 && fields[0] == "epoch":
+		secs, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(secs, 0), nil
+	case len(fields) == 3:
+		return time.Parse("2006/01/02 15:04:05", fields[1]+" "+fields[2])
+	default:
+		return time.Time{}, fmt.Errorf("invalid time %q", strings.Join(fields, " "))
+	}
+}
+
+// parseNetworkdLease parses a systemd-networkd lease file, written when the
+// lease was bound.
+func parseNetworkdLease(data []byte, bound time.Time) (dhclient.Lease, error) {
+	lease := dhclient.Lease{Bound: bound}
+	var t1, t2, lifetime time.Duration
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+
+		var err error
+		switch key {
+		case "ADDRESS":
+			lease.FixedAddress = net.ParseIP(value).To4()
+			if lease.FixedAddress == nil {
+				err = fmt.Errorf("invalid address %q", value)
+			}
+		case "SERVER_ADDRESS":
+			lease.ServerID = net.ParseIP(value).To4()
+			if lease.ServerID == nil {
+				err = fmt.Errorf("invalid server address %q", value)
+			}
+		case "T1", "T2", "LIFETIME":
+			var secs uint64
+			secs, err = strconv.ParseUint(value, 10, 32)
+			d := time.Duration(secs) * time.Second
+			switch key {
+			case "T1":
+				t1 = d
+			case "T2":
+				t2 = d
+			default:
+				lifetime = d
+				lease.Infinite = secs == dhclient.InfiniteLeaseTime
+			}
+		}
+		if err != nil {
+			return lease, fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return lease, err
+	}
+
+	if lifetime == 0 {
+		return lease, errors.New("no lease lifetime")
+	}
+	if !lease.Infinite {
+		lease.Expire = bound.Add(lifetime)
+		if t1 > 0 {
+			lease.Renew = bound.Add(t1)
+		}
+		if t2 > 0 {
+			lease.Rebind = bound.Add(t2)
+		}
+	}
+
+	return lease, completeOSLease(&lease, lifetime)
+}
+
+// completeOSLease checks that lease has what is needed to renew it, and
+// fills in the times the lease file left out, from leaseTime if that is
+// known. As for a lease bound here, an infinite lease has no times.
+func completeOSLease(lease *dhclient.Lease, leaseTime time.Duration) error {
+	if lease.FixedAddress == nil {
+		return errors.New("lease has no address")
+	}
+	if lease.ServerID == nil {
+		return errors.New("lease has no server identifier")
+	}
+
+	if lease.Infinite {
+		lease.Renew, lease.Rebind, lease.Expire = time.Time{}, time.Time{}, time.Time{}
+		return nil
+	}
+	if lease.Expire.IsZero() {
+		return errors.New("lease has no expiry")
+	}
+	if lease.Bound.IsZero() && leaseTime > 0 {
+		lease.Bound = lease.Expire.Add(-leaseTime)
+	}
+	if !lease.Bound.IsZero() {
+		length := lease.Expire.Sub(lease.Bound)
+		if lease.Renew.IsZero() {
+			lease.Renew = lease.Bound.Add(length / 2)
+		}
+		if lease.Rebind.IsZero() {
+			lease.Rebind = lease.Bound.Add(length * 7 / 8)
+		}
+	}
+
+	return nil
+}
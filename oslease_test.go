@@ -0,0 +1,139 @@
+package greedydhcp
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeLeaseFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "leases")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestReadOSLeaseFileDhclient(t *testing.T) {
+	path := writeLeaseFile(t, `default-duid "\000\001";
+lease {
+  interface "eth0";
+  fixed-address 10.0.0.5;
+  option dhcp-lease-time 600;
+  option dhcp-server-identifier 10.0.0.1;
+  renew 4 2026/10/15 12:05:00;
+  rebind 4 2026/10/15 12:08:45;
+  expire 4 2026/10/15 12:10:00;
+}
+lease {
+  interface "eth0";
+  fixed-address 10.0.0.5;
+  option dhcp-lease-time 600;
+  option dhcp-server-identifier 10.0.0.2;
+  expire epoch 1792065600; # Thu Oct 15 12:00:00 2026
+}
+lease {
+  interface "eth0";
+  fixed-address 10.0.0.6;
+  option dhcp-server-identifier 10.0.0.1;
+  expire never;
+}
+lease {
+  interface "eth0";
+  fixed-address 10.0.0.7;
+  option dhcp-server-identifier 10.0.0.1;
+  expire 4 2026/10/15 11:00:00;
+}
+`)
+	now := time.Date(2026, 10, 15, 11, 30, 0, 0, time.UTC)
+
+	leases, err := readOSLeaseFile(path, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(leases) != 2 {
+		t.Fatalf("expected the expired lease to be dropped, got %v", leases)
+	}
+
+	lease := leases["10.0.0.5"]
+	if !lease.ServerID.Equal(net.ParseIP("10.0.0.2")) {
+		t.Errorf("expected the last lease for the address to be kept, got server %s", lease.ServerID)
+	}
+	expire := time.Unix(1792065600, 0)
+	if !lease.Expire.Equal(expire) || !lease.Bound.Equal(expire.Add(-10*time.Minute)) {
+		t.Errorf("unexpected times in lease: %+v", lease)
+	}
+	if !lease.Renew.Equal(expire.Add(-5 * time.Minute)) {
+		t.Errorf("expected renewal to default to half the lease, got %s", lease.Renew)
+	}
+
+	if lease := leases["10.0.0.6"]; !lease.Infinite || !lease.Expire.IsZero() {
+		t.Errorf("expected an infinite lease, got %+v", lease)
+	}
+}
+
+func TestReadOSLeaseFileNetworkd(t *testing.T) {
+	path := writeLeaseFile(t, `# This is private data. Do not parse.
+ADDRESS=10.0.0.5
+NETMASK=255.255.255.0
+ROUTER=10.0.0.1
+SERVER_ADDRESS=10.0.0.1
+T1=300
+T2=525
+LIFETIME=600
+`)
+	bound := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if err := os.Chtimes(path, bound, bound); err != nil {
+		t.Fatal(err)
+	}
+
+	leases, err := readOSLeaseFile(path, time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lease, ok := leases["10.0.0.5"]
+	if !ok {
+		t.Fatalf("expected a lease for 10.0.0.5, got %v", leases)
+	}
+	if !lease.ServerID.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("unexpected server %s", lease.ServerID)
+	}
+	if !lease.Bound.Equal(bound) || !lease.Renew.Equal(bound.Add(300*time.Second)) || !lease.Expire.Equal(bound.Add(600*time.Second)) {
+		t.Errorf("expected times relative to the file's modification, got %+v", lease)
+	}
+}
+
+func TestReadOSLeaseFileErrors(t *testing.T) {
+	if _, err := readOSLeaseFile(filepath.Join(t.TempDir(), "missing"), time.Now()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a missing file error, got %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "unknown format", content: "{}\n", wantErr: errUnknownLeaseFormat.Error()},
+		{name: "unterminated lease", content: "lease {\n  fixed-address 10.0.0.5;\n", wantErr: "unterminated"},
+		{name: "invalid address", content: "lease {\n  fixed-address nope;\n}\n", wantErr: "line 2"},
+		{name: "no server", content: "lease {\n  fixed-address 10.0.0.5;\n  expire never;\n}\n", wantErr: "no server identifier"},
+		{name: "networkd without lifetime", content: "ADDRESS=10.0.0.5\nSERVER_ADDRESS=10.0.0.1\n", wantErr: "no lease lifetime"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := readOSLeaseFile(writeLeaseFile(t, tt.content), time.Now())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package greedydhcp
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sendPacer is a token bucket limiting how many packets all clients send per
+// second. It holds a single token, so packets are spread out evenly rather
+// than sent in bursts.
+type sendPacer struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+	sent int
+}
+
+// newSendPacer returns a pacer allowing pps packets per second, or nil, which
+// doesn't limit sending, if pps isn't positive.
+func newSendPacer(pps int) *sendPacer {
+	if pps <= 0 {
+		return nil
+	}
+
+	return &sendPacer{interval: time.Second / time.Duration(pps)}
+}
+
+// reserve takes the next send slot at or after now, returning how long to
+// wait for it.
+func (p *sendPacer) reserve(now time.Time) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.next.Before(now) {
+		p.next = now
+	}
+	delay := p.next.Sub(now)
+	p.next = p.next.Add(p.interval)
+	p.sent++
+
+	return delay
+}
+
+// wait blocks until a packet may be sent, returning false if stop is closed
+// first. A nil pacer never waits.
+func (p *sendPacer) wait(stop <-chan struct{}) bool {
+	if p == nil {
+		return true
+	}
+
+	delay := p.reserve(time.Now())
+	if delay <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+// reportRate sets rate, dhcp_send_rate_pps, every second to the number of
+// packets let through during the last one, until ctx is done.
+func (p *sendPacer) reportRate(ctx context.Context, rate prometheus.Gauge) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		p.mu.Lock()
+		sent := p.sent
+		p.sent = 0
+		p.mu.Unlock()
+
+		rate.Set(float64(sent))
+	}
+}
@@ -0,0 +1,38 @@
+package greedydhcp
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSendPacerSpreadsPackets(t *testing.T) {
+	p := newSendPacer(10)
+	now := time.Now()
+
+	for i, want := range []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond} {
+		if got := p.reserve(now); got != want {
+			t.Errorf("packet %d: expected to wait %s, got %s", i, want, got)
+		}
+	}
+
+	// Unused slots aren't saved up for a burst later on.
+	if got := p.reserve(now.Add(time.Second)); got != 0 {
+		t.Errorf("expected no wait after an idle period, got %s", got)
+	}
+}
+
+func TestSendPacerWaitStops(t *testing.T) {
+	p := newSendPacer(1)
+	p.reserve(time.Now())
+
+	stop := make(chan struct{})
+	close(stop)
+	if p.wait(stop) {
+		t.Error("expected wait to give up once stopped")
+	}
+
+	var unlimited *sendPacer
+	if !unlimited.wait(stop) {
+		t.Error("expected a nil pacer to never wait")
+	}
+}
@@ -0,0 +1,83 @@
+package greedydhcp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Components a panic may be recovered in, as labels of greedydhcp_panics_total.
+const (
+	panicComponentClient  = "client"
+	panicComponentMetrics = "metrics"
+)
+
+// recordPanic logs a recovered panic with its stack and counts it in panics.
+func recordPanic(logger *slog.Logger, panics *prometheus.CounterVec, component string, v any, stack []byte) {
+	logger.Error("Recovered from panic", "component", component, "panic", v, "stack", string(stack))
+	panics.WithLabelValues(component).Inc()
+}
+
+// superviseClient runs runClient for target until ctx is done, running it
+// again cfg.panicRestartDelay after it panics, so that a bad target doesn't
+// take down every other one.
+func superviseClient(ctx context.Context, wg *sync.WaitGroup, logger *slog.Logger, cfg *clientConfig, target targetConfig) {
+	defer wg.Done()
+
+	for runClientRecovering(ctx, logger, cfg, target) {
+		logger.Warn("Restarting client after panic", "target", target.addr, "delay", cfg.panicRestartDelay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(cfg.panicRestartDelay):
+		}
+
+		// The start slot, if any, was released as the client unwound.
+		target.startSlot = nil
+	}
+}
+
+// runClientRecovering runs runClient, reporting whether it panicked.
+func runClientRecovering(ctx context.Context, logger *slog.Logger, cfg *clientConfig, target targetConfig) (panicked bool) {
+	defer func() {
+		if v := recover(); v != nil {
+			recordPanic(logger.With("target", target.addr), cfg.metrics.greedydhcpPanicsTotal, panicComponentClient, v, debug.Stack())
+			panicked = true
+		}
+	}()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	runClient(ctx, wg, logger, cfg, target)
+
+	return false
+}
+
+// recoverHandler recovers panics in handler, counting them in panics and
+// answering with an internal server error so that the connection is kept and
+// the server keeps serving.
+func recoverHandler(logger *slog.Logger, panics *prometheus.CounterVec, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			// The server handles this one itself, to abort the response.
+			if err, ok := v.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(v)
+			}
+
+			recordPanic(logger.With("path", r.URL.Path), panics, panicComponentMetrics, v, debug.Stack())
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}()
+
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,59 @@
+package greedydhcp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientRestartsAfterPanic(t *testing.T) {
+	// Without an interface the client panics as soon as it listens.
+	cfg := testClientConfig(nil)
+	cfg.recoverPanics = true
+	cfg.panicRestartDelay = 10 * time.Millisecond
+	panics := testMetrics.greedydhcpPanicsTotal.WithLabelValues(panicComponentClient)
+	before := metricValue(t, panics)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go superviseClient(ctx, wg, testLogger(t), cfg, targetConfig{addr: "10.100.0.47"})
+
+	waitFor(t, 10*time.Second, "client to be restarted after panicking", func() bool {
+		return metricValue(t, panics)-before >= 2
+	})
+
+	cancel()
+	wg.Wait()
+}
+
+func TestRecoverHandler(t *testing.T) {
+	panics := testMetrics.greedydhcpPanicsTotal.WithLabelValues(panicComponentMetrics)
+	before := metricValue(t, panics)
+
+	handler := recoverHandler(testLogger(t), testMetrics.greedydhcpPanicsTotal, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected an internal server error, got %d", rec.Code)
+	}
+	if v := metricValue(t, panics) - before; v != 1 {
+		t.Errorf("expected the panic to be counted once, got %v", v)
+	}
+
+	aborting := recoverHandler(testLogger(t), testMetrics.greedydhcpPanicsTotal, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+	defer func() {
+		if v := recover(); v != http.ErrAbortHandler {
+			t.Errorf("expected ErrAbortHandler to be left to the server, got %v", v)
+		}
+	}()
+	aborting.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+}
@@ -0,0 +1,80 @@
+package greedydhcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// errNoEchoReply is returned by pingGateway when nothing answers in time.
+var errNoEchoReply = errors.New("no reply")
+
+// pingGateway sends a single ICMP echo request to addr and waits up to
+// timeout for the reply, returning the round trip time. It needs a raw ICMP
+// socket, so the same privileges as the DHCP clients. The request is sent from
+// the host's own address, as the leased one isn't configured on the interface.
+// If vrf is set, the socket is bound to that VRF device, so that the request
+// is routed with its table.
+func pingGateway(addr net.IP, timeout time.Duration, vrf string) (time.Duration, error) {
+	var lc net.ListenConfig
+	if vrf != "" {
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			return bindToDevice(c, vrf)
+		}
+	}
+	conn, err := lc.ListenPacket(context.Background(), "ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return 0, fmt.Errorf("unable to open icmp socket: %w", err)
+	}
+	defer conn.Close()
+
+	// Every raw ICMP socket sees every reply, so tell ours apart by ID.
+	id := rand.Intn(1 << 16)
+	request := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: id, Seq: 1, Data: []byte("greedydhcp")},
+	}
+	data, err := request.Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	if err := conn.SetDeadline(start.Add(timeout)); err != nil {
+		return 0, err
+	}
+	if _, err := conn.WriteTo(data, &net.IPAddr{IP: addr}); err != nil {
+		return 0, fmt.Errorf("unable to send echo request: %w", err)
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			if os.IsTimeout(err) {
+				return 0, fmt.Errorf("%w within %s", errNoEchoReply, timeout)
+			}
+			return 0, err
+		}
+
+		if ip, ok := peer.(*net.IPAddr); !ok || !ip.IP.Equal(addr) {
+			continue
+		}
+
+		reply, err := icmp.ParseMessage(1, buf[:n])
+		if err != nil || reply.Type != ipv4.ICMPTypeEchoReply {
+			continue
+		}
+		if echo, ok := reply.Body.(*icmp.Echo); ok && echo.ID == id {
+			return time.Since(start), nil
+		}
+	}
+}
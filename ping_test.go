@@ -0,0 +1,19 @@
+package greedydhcp
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPingGatewayLoopback(t *testing.T) {
+	_, err := pingGateway(net.IPv4(127, 0, 0, 1), time.Second, "")
+	if errors.Is(err, os.ErrPermission) {
+		t.Skipf("unable to open icmp socket: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("expected loopback to answer, got %v", err)
+	}
+}
@@ -0,0 +1,193 @@
+package greedydhcp
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+)
+
+// poolEstimateConfig configures the pool estimate mode, in which clients with
+// random hardware addresses acquire addresses one after the other until the
+// server stops handing out new ones, the number of distinct addresses held
+// then being the size of the pool.
+type poolEstimateConfig struct {
+	// subnet is the pool's subnet, addresses outside of it not counting.
+	// The mode is disabled when it is nil.
+	subnet *net.IPNet
+	// maxClients is the most clients spawned, so the most addresses held.
+	maxClients int
+	// maxMisses is the number of clients in a row that must fail to get a
+	// new address for the pool to count as exhausted.
+	maxMisses int
+	// attemptTimeout is how long each client has to get an address.
+	attemptTimeout time.Duration
+	// timeout is how long the whole estimate may take.
+	timeout time.Duration
+}
+
+// getPoolEstimateConfig reads the pool estimate mode settings from the
+// environment.
+func getPoolEstimateConfig() (poolEstimateConfig, error) {
+	var (
+		cfg poolEstimateConfig
+		err error
+	)
+
+	if subnet := os.Getenv("POOL_ESTIMATE_SUBNET"); subnet != "" {
+		_, cfg.subnet, err = net.ParseCIDR(subnet)
+		if err != nil {
+			return cfg, fmt.Errorf("POOL_ESTIMATE_SUBNET must be a CIDR: %w", err)
+		}
+		if cfg.subnet.IP.To4() == nil {
+			return cfg, fmt.Errorf("POOL_ESTIMATE_SUBNET must be an IPv4 subnet, got %s", subnet)
+		}
+	}
+
+	cfg.maxClients, err = getEnvInt("POOL_ESTIMATE_MAX_CLIENTS", 256)
+	if err != nil {
+		return cfg, err
+	}
+
+	if cfg.maxClients <= 0 {
+		return cfg, fmt.Errorf("POOL_ESTIMATE_MAX_CLIENTS must be positive, got %d", cfg.maxClients)
+	}
+
+	cfg.maxMisses, err = getEnvInt("POOL_ESTIMATE_MAX_MISSES", 3)
+	if err != nil {
+		return cfg, err
+	}
+
+	if cfg.maxMisses <= 0 {
+		return cfg, fmt.Errorf("POOL_ESTIMATE_MAX_MISSES must be positive, got %d", cfg.maxMisses)
+	}
+
+	cfg.attemptTimeout, err = getEnvDuration("POOL_ESTIMATE_ATTEMPT_TIMEOUT", 30*time.Second)
+	if err != nil {
+		return cfg, err
+	}
+
+	if cfg.attemptTimeout <= 0 {
+		return cfg, fmt.Errorf("POOL_ESTIMATE_ATTEMPT_TIMEOUT must be positive, got %s", cfg.attemptTimeout)
+	}
+
+	cfg.timeout, err = getEnvDuration("POOL_ESTIMATE_TIMEOUT", 10*time.Minute)
+	if err != nil {
+		return cfg, err
+	}
+
+	if cfg.timeout <= 0 {
+		return cfg, fmt.Errorf("POOL_ESTIMATE_TIMEOUT must be positive, got %s", cfg.timeout)
+	}
+
+	return cfg, nil
+}
+
+// runPoolEstimate estimates the size of the pool est.subnet by exhaustion,
+// spawning one client at a time until est.maxMisses clients in a row fail to
+// get a new address, est.maxClients are holding one or est.timeout is up.
+// Every lease is released once the estimate is done, or ctx is, and the
+// number of distinct addresses held is exported as dhcp_estimated_pool_size.
+func runPoolEstimate(ctx context.Context, wg *sync.WaitGroup, baseLogger *slog.Logger, cfg *clientConfig, est poolEstimateConfig) {
+	defer wg.Done()
+
+	logger := baseLogger.With("mode", "pool_estimate", "subnet", est.subnet)
+	logger.Info("Estimating pool size by exhaustion", "max_clients", est.maxClients, "max_misses", est.maxMisses, "timeout", est.timeout)
+
+	estimateCtx, cancel := context.WithTimeout(ctx, est.timeout)
+	defer cancel()
+
+	start := time.Now()
+	addrs := map[string]bool{}
+	var held []*dhclient.Client
+	// release stops client and gives back its lease, if it got one.
+	release := func(client *dhclient.Client) {
+		client.Stop()
+		if err := client.Release(); err != nil {
+			logger.Warn("Unable to release lease", "mac", client.HardwareAddr, "err", err)
+		}
+	}
+
+	misses := 0
+	outcome := "exhausted"
+	for misses < est.maxMisses {
+		if len(held) >= est.maxClients {
+			outcome = "max_clients"
+			break
+		}
+
+		mac, err := randomMAC()
+		if err != nil {
+			logger.Error("Unable to generate hardware address", "err", err)
+			outcome = "error"
+			break
+		}
+
+		bound := make(chan *dhclient.Lease, 1)
+		failed := make(chan error, 1)
+		client := newProbeClient(cfg, logger.With("mac", mac), mac)
+		client.OnBound = func(lease *dhclient.Lease) {
+			select {
+			case bound <- lease:
+			default:
+			}
+		}
+		client.OnError = func(err error) {
+			select {
+			case failed <- err:
+			default:
+			}
+		}
+		client.Start()
+
+		timer := time.NewTimer(est.attemptTimeout)
+		select {
+		case lease := <-bound:
+			addr := lease.FixedAddress.String()
+			if est.subnet.Contains(lease.FixedAddress) && !addrs[addr] {
+				logger.Debug("Got new address", "addr", addr, "count", len(addrs)+1)
+				addrs[addr] = true
+				held = append(held, client)
+				misses = 0
+			} else {
+				logger.Debug("Got address already held or outside the subnet", "addr", addr)
+				release(client)
+				misses++
+			}
+		case err := <-failed:
+			logger.Debug("Unable to get address", "err", err)
+			release(client)
+			misses++
+		case <-timer.C:
+			logger.Debug("Timed out getting address")
+			release(client)
+			misses++
+		case <-estimateCtx.Done():
+			release(client)
+			outcome = "timeout"
+			misses = est.maxMisses
+		}
+		timer.Stop()
+	}
+
+	// Don't leave the pool drained, whatever the outcome.
+	for _, client := range held {
+		release(client)
+	}
+
+	if ctx.Err() != nil {
+		logger.Warn("Pool estimate cancelled, leases released", "addresses", len(addrs))
+		return
+	}
+
+	logger.Info("Estimated pool size, leases released", "size", len(addrs), "outcome", outcome, "duration", time.Since(start))
+	if outcome != "exhausted" {
+		logger.Warn("Pool was not exhausted, its size may be larger than estimated", "outcome", outcome)
+	}
+	cfg.metrics.dhcpEstimatedPoolSize.WithLabelValues(est.subnet.String()).Set(float64(len(addrs)))
+}
@@ -0,0 +1,39 @@
+package greedydhcp
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunPoolEstimate(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setPoolSize(3)
+
+	_, subnet, _ := net.ParseCIDR("10.200.0.0/24")
+	est := poolEstimateConfig{
+		subnet:         subnet,
+		maxClients:     10,
+		maxMisses:      2,
+		attemptTimeout: 500 * time.Millisecond,
+		timeout:        time.Minute,
+	}
+
+	cfg := testClientConfig(iface)
+	// Stopping a client waits for the transmission it is waiting on.
+	cfg.retransmitTimeout = 100 * time.Millisecond
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	runPoolEstimate(context.Background(), wg, testLogger(t), cfg, est)
+
+	if v := metricValue(t, testMetrics.dhcpEstimatedPoolSize.WithLabelValues(subnet.String())); v != 3 {
+		t.Errorf("expected a pool size of 3, got %v", v)
+	}
+	waitFor(t, 5*time.Second, "every lease to be released", func() bool {
+		return srv.releaseCount() == 3
+	})
+}
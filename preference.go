@@ -0,0 +1,102 @@
+package greedydhcp
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// addressPreferences are the addresses a target requests in turn, its own
+// followed by its fallback_addrs, moving on to the next one at every NAK. It
+// outlives the client restarts between attempts, and is only used by the
+// target's own client, one at a time. A nil addressPreferences, of a target
+// without fallbacks, never requests an address.
+type addressPreferences struct {
+	addrs []net.IP
+	// index is the preference of the address to request next, or
+	// len(addrs) once every one of them has been NAKed.
+	index int
+	// requesting is set while the address at index is being requested.
+	requesting bool
+	// indexMetric is dhcp_preference_index of the target.
+	indexMetric prometheus.Gauge
+}
+
+// newAddressPreferences returns the preferences of a target, which are its
+// address followed by its fallbacks, or nil if it has no fallbacks.
+func newAddressPreferences(addr net.IP, fallbacks []net.IP, indexMetric prometheus.Gauge) *addressPreferences {
+	if len(fallbacks) == 0 {
+		return nil
+	}
+
+	return &addressPreferences{
+		addrs:       append([]net.IP{addr}, fallbacks...),
+		indexMetric: indexMetric,
+	}
+}
+
+// next returns the address to request without discovery, or nil once every
+// one of them has been NAKed, in which case the client discovers.
+func (p *addressPreferences) next() net.IP {
+	if p == nil || p.index >= len(p.addrs) {
+		return nil
+	}
+
+	p.requesting = true
+	return p.addrs[p.index]
+}
+
+// request makes client, which is yet to be started, request the next address
+// from server, which may be nil, without discovery. A client with a lease to
+// renew, such as a restored one, is left to renew it.
+func (p *addressPreferences) request(logger *slog.Logger, client *dhclient.Client, server net.IP) {
+	if client.Lease != nil {
+		return
+	}
+
+	if addr := p.next(); addr != nil {
+		logger.Info("Requesting preferred address without discovery", "addr", addr, "preference", p.index)
+		client.Lease = &dhclient.Lease{FixedAddress: addr, ServerID: server}
+	}
+}
+
+// bound records the binding of lease, starting over from the most preferred
+// address the next time one is requested.
+func (p *addressPreferences) bound(logger *slog.Logger, lease *dhclient.Lease) {
+	if p == nil {
+		return
+	}
+
+	switch {
+	case p.requesting:
+		logger.Info("Got preferred address", "addr", lease.FixedAddress, "preference", p.index)
+		p.indexMetric.Set(float64(p.index))
+		p.requesting = false
+	case p.index == len(p.addrs):
+		p.indexMetric.Set(-1)
+	}
+	p.index = 0
+}
+
+// failed records the failure of an attempt, returning whether to restart the
+// client straight away to request the next address.
+func (p *addressPreferences) failed(logger *slog.Logger, err error) bool {
+	if p == nil || !p.requesting || !errors.Is(err, dhclient.ErrNAK) {
+		return false
+	}
+
+	addr := p.addrs[p.index]
+	p.requesting = false
+	p.index++
+	if p.index < len(p.addrs) {
+		logger.Warn("Preferred address was NAKed, falling back to the next one", "addr", addr, "next", p.addrs[p.index])
+		return true
+	}
+
+	// The client discovers once the NAK drops its lease.
+	logger.Warn("Every preferred address was NAKed, accepting any offer", "addr", addr)
+	return false
+}
@@ -0,0 +1,81 @@
+package greedydhcp
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAddressPreferencesFallBack(t *testing.T) {
+	target := net.ParseIP("10.0.0.5").To4()
+	fallbacks := []net.IP{net.ParseIP("10.0.0.6").To4(), net.ParseIP("10.0.0.7").To4()}
+	index := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_preference_index"})
+	p := newAddressPreferences(target, fallbacks, index)
+	logger := testLogger(t)
+	nak := fmt.Errorf("%w: no", dhclient.ErrNAK)
+
+	if addr := p.next(); !addr.Equal(target) {
+		t.Fatalf("expected the target address to be requested first, got %v", addr)
+	}
+	if !p.failed(logger, nak) {
+		t.Error("expected a NAK to request the next address straight away")
+	}
+	if addr := p.next(); !addr.Equal(fallbacks[0]) {
+		t.Fatalf("expected the first fallback to be requested next, got %v", addr)
+	}
+
+	// Only a NAK moves on to the next address.
+	if p.failed(logger, os.ErrDeadlineExceeded) {
+		t.Error("expected a timeout not to move on")
+	}
+	p.bound(logger, &dhclient.Lease{FixedAddress: fallbacks[0]})
+	if v := metricValue(t, index); v != 1 {
+		t.Errorf("expected preference 1 to be bound, got %v", v)
+	}
+	if addr := p.next(); !addr.Equal(target) {
+		t.Errorf("expected a binding to start over from the target address, got %v", addr)
+	}
+}
+
+func TestAddressPreferencesExhausted(t *testing.T) {
+	index := prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_preference_index"})
+	p := newAddressPreferences(net.ParseIP("10.0.0.5").To4(), []net.IP{net.ParseIP("10.0.0.6").To4()}, index)
+	logger := testLogger(t)
+	nak := fmt.Errorf("%w: no", dhclient.ErrNAK)
+
+	p.next()
+	p.failed(logger, nak)
+	p.next()
+	if p.failed(logger, nak) {
+		t.Error("expected the last NAK to leave the client to discover")
+	}
+	if addr := p.next(); addr != nil {
+		t.Errorf("expected nothing left to request, got %v", addr)
+	}
+
+	// Binding an offered address marks it as none of the preferences.
+	p.bound(logger, &dhclient.Lease{FixedAddress: net.ParseIP("10.0.0.9").To4()})
+	if v := metricValue(t, index); v != -1 {
+		t.Errorf("expected preference -1 for an offered address, got %v", v)
+	}
+}
+
+func TestAddressPreferencesNil(t *testing.T) {
+	p := newAddressPreferences(net.ParseIP("10.0.0.5").To4(), nil, nil)
+	if p != nil {
+		t.Fatal("expected no preferences without fallbacks")
+	}
+
+	logger := testLogger(t)
+	if addr := p.next(); addr != nil {
+		t.Errorf("expected nothing to request, got %v", addr)
+	}
+	if p.failed(logger, dhclient.ErrNAK) {
+		t.Error("expected a NAK not to restart the client")
+	}
+	p.bound(logger, &dhclient.Lease{})
+}
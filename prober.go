@@ -0,0 +1,120 @@
+package greedydhcp
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// metricsShutdownTimeout is how long the metrics server waits for requests
+// in flight to finish on shutdown before closing their connections.
+const metricsShutdownTimeout = 2 * time.Second
+
+// prober holds everything running once Run has started the clients, so
+// that it can be torn down in order without a signal.
+type prober struct {
+	logger *slog.Logger
+	cfg    *clientConfig
+	set    *targetSet
+	// stressWG tracks the stress, pool estimate and anonymous client runs.
+	stressWG *sync.WaitGroup
+	// cancel stops every client.
+	cancel context.CancelFunc
+	// server is the metrics server, nil if it is disabled.
+	server   *http.Server
+	gatherer prometheus.Gatherer
+	// snapshotFile, leaseStateFile and resultsFile, if set, are written on
+	// shutdown.
+	snapshotFile   string
+	leaseStateFile string
+	resultsFile    string
+	// shuttingDown fails the health endpoints while the server drains.
+	shuttingDown atomic.Bool
+
+	once sync.Once
+	err  error
+}
+
+// Shutdown stops serving metrics, stops every client and waits for them,
+// then cleans up VLAN interfaces, saves the leases that were held and writes
+// the results of the run. It is safe to call concurrently and more than
+// once: later calls wait for the first one and return its result, which is
+// ErrShutdownTimeout if the clients didn't stop before ctx was done.
+func (p *prober) Shutdown(ctx context.Context) error {
+	p.once.Do(func() {
+		p.err = p.shutdown(ctx)
+	})
+
+	return p.err
+}
+
+func (p *prober) shutdown(ctx context.Context) error {
+	p.shuttingDown.Store(true)
+
+	// Leases are dropped from the registry as their clients stop, so take
+	// the snapshot to save before cancelling them.
+	held := p.cfg.leases.snapshot()
+	targets := p.set.addrs()
+
+	// Stop serving first, so that nothing scrapes metrics of clients that
+	// are going away. Scrapes in flight are given metricsShutdownTimeout to
+	// finish, so that a stuck one doesn't use up the time the clients have
+	// to stop.
+	if p.server != nil {
+		serverCtx, cancel := context.WithTimeout(ctx, metricsShutdownTimeout)
+		err := p.server.Shutdown(serverCtx)
+		cancel()
+		if err != nil {
+			p.logger.Warn("Unable to stop metrics server cleanly", "err", err)
+			p.server.Close()
+		}
+	}
+
+	// Snapshot before the clients stop, so that it shows the leases still
+	// held rather than the teardown.
+	if p.snapshotFile != "" {
+		if err := writeMetricsSnapshot(p.snapshotFile, p.gatherer); err != nil {
+			p.logger.Error("Unable to write metrics snapshot", "path", p.snapshotFile, "err", err)
+		} else {
+			p.logger.Info("Wrote metrics snapshot", "path", p.snapshotFile)
+		}
+	}
+
+	p.cancel()
+	var err error
+	stopped := waitTimeout(ctx, func() {
+		p.set.wait()
+		p.stressWG.Wait()
+	})
+	if !stopped {
+		err = ErrShutdownTimeout
+	}
+	p.cfg.vlans.cleanup(p.logger)
+	p.cfg.statsd.close()
+	p.cfg.publisher.close(ctx)
+
+	if p.leaseStateFile != "" {
+		if err := saveLeaseState(p.leaseStateFile, held); err != nil {
+			p.logger.Error("Unable to save leases", "path", p.leaseStateFile, "err", err)
+		} else {
+			p.logger.Info("Saved leases", "path", p.leaseStateFile, "leases", len(held))
+		}
+	}
+
+	// The results are only complete once the clients have stopped.
+	if p.resultsFile != "" {
+		report := p.cfg.results.report(time.Now(), p.cfg.currentIface().Name, targets, held)
+		if err := writeResults(p.resultsFile, report); err != nil {
+			p.logger.Error("Unable to write results", "output", p.resultsFile, "err", err)
+		} else {
+			p.logger.Info("Wrote results", "output", p.resultsFile, "targets", len(report.Targets))
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,194 @@
+package greedydhcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProberShutdown(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.45"
+	cfg := testClientConfig(iface)
+	cfg.vlans = newVLANManager()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	set := newTargetSet(ctx, testLogger(t), cfg)
+	set.apply([]targetConfig{{addr: target}})
+
+	p := &prober{
+		logger:         testLogger(t),
+		cfg:            cfg,
+		set:            set,
+		stressWG:       &sync.WaitGroup{},
+		cancel:         cancel,
+		leaseStateFile: filepath.Join(t.TempDir(), "leases.json"),
+	}
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- p.Shutdown(context.Background())
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("expected a clean shutdown, got %v", err)
+		}
+	}
+	if !p.shuttingDown.Load() {
+		t.Error("expected the health endpoints to report shutting down")
+	}
+	if _, err := os.Stat(p.leaseStateFile); err != nil {
+		t.Errorf("expected the held lease to be saved: %v", err)
+	}
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Errorf("expected shutting down again to do nothing, got %v", err)
+	}
+}
+
+func TestProberShutdownTimeout(t *testing.T) {
+	cfg := &clientConfig{leases: newLeaseRegistry(testMetrics.dhcpDuplicateBoundAddresses), vlans: newVLANManager()}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A stress run that never stops.
+	stressWG := &sync.WaitGroup{}
+	stressWG.Add(1)
+	defer stressWG.Done()
+
+	p := &prober{
+		logger:   testLogger(t),
+		cfg:      cfg,
+		set:      newTargetSet(ctx, testLogger(t), cfg),
+		stressWG: stressWG,
+		cancel:   cancel,
+	}
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelShutdown()
+	if err := p.Shutdown(shutdownCtx); !errors.Is(err, ErrShutdownTimeout) {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func TestProberShutdownStopsStuckScrapes(t *testing.T) {
+	cfg := &clientConfig{leases: newLeaseRegistry(testMetrics.dhcpDuplicateBoundAddresses), vlans: newVLANManager()}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	defer close(release)
+	started := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+
+	go http.Get("http://" + listener.Addr().String() + "/metrics")
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the scrape to start")
+	}
+
+	p := &prober{
+		logger:   testLogger(t),
+		cfg:      cfg,
+		set:      newTargetSet(ctx, testLogger(t), cfg),
+		stressWG: &sync.WaitGroup{},
+		cancel:   cancel,
+		server:   server,
+	}
+
+	start := time.Now()
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Errorf("expected a clean shutdown, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > metricsShutdownTimeout+time.Second {
+		t.Errorf("expected the stuck scrape to be given up on after %s, shutting down took %s", metricsShutdownTimeout, elapsed)
+	}
+	if conn, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		conn.Close()
+		t.Error("expected the listener to be closed")
+	}
+}
+
+func TestProberShutdownWritesResults(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	target := "10.100.0.57"
+	cfg := testClientConfig(iface)
+	cfg.vlans = newVLANManager()
+	cfg.results = newRunResults(time.Now())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	set := newTargetSet(ctx, testLogger(t), cfg)
+	set.apply([]targetConfig{{addr: target}})
+
+	p := &prober{
+		logger:      testLogger(t),
+		cfg:         cfg,
+		set:         set,
+		stressWG:    &sync.WaitGroup{},
+		cancel:      cancel,
+		resultsFile: filepath.Join(t.TempDir(), "results.json"),
+	}
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(p.resultsFile)
+	if err != nil {
+		t.Fatalf("expected the results to be written: %v", err)
+	}
+	var report resultsReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		t.Fatalf("unable to parse results: %v", err)
+	}
+
+	if report.Interface != iface.Name || !report.Ended.After(report.Started) {
+		t.Errorf("unexpected run metadata: %+v", report)
+	}
+	if len(report.Targets) != 1 {
+		t.Fatalf("expected the result of one target, got %+v", report.Targets)
+	}
+	result := report.Targets[0]
+	if result.Target != target || result.Acquired != 1 || result.Address != target || result.Server != "127.0.0.1" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if result.AcquireSeconds == nil || result.AcquireSeconds.Min <= 0 {
+		t.Errorf("expected the acquire latency to be summarized, got %+v", result.AcquireSeconds)
+	}
+}
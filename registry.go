@@ -0,0 +1,365 @@
+package greedydhcp
+
+import (
+	"context"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// leaseRegistry tracks the lease currently held by each target. It is shared
+// by every client and read by collectors at scrape time.
+type leaseRegistry struct {
+	mu     sync.RWMutex
+	leases map[string]dhclient.Lease
+	// bound holds every target that has held a lease, even if it no longer
+	// does.
+	bound map[string]bool
+
+	acquired     chan struct{}
+	acquiredOnce sync.Once
+	// changed is closed and replaced each time a lease is recorded.
+	changed chan struct{}
+	// duplicates is dhcp_duplicate_bound_addresses.
+	duplicates prometheus.Gauge
+}
+
+func newLeaseRegistry(duplicates prometheus.Gauge) *leaseRegistry {
+	return &leaseRegistry{leases: map[string]dhclient.Lease{}, bound: map[string]bool{}, acquired: make(chan struct{}), changed: make(chan struct{}), duplicates: duplicates}
+}
+
+// set records lease as the one currently held by target. It returns the
+// other targets holding the same address, which should never happen unless
+// the server hands out an address twice.
+func (r *leaseRegistry) set(target string, lease *dhclient.Lease) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.leases[target] = *lease
+	r.bound[target] = true
+	r.acquiredOnce.Do(func() { close(r.acquired) })
+	close(r.changed)
+	r.changed = make(chan struct{})
+
+	var others []string
+	for other, held := range r.leases {
+		if other != target && held.FixedAddress.Equal(lease.FixedAddress) {
+			others = append(others, other)
+		}
+	}
+	sort.Strings(others)
+	r.updateDuplicates()
+
+	return others
+}
+
+// updateDuplicates sets r.duplicates to the number of
+// addresses held by more than one target. r.mu must be held.
+func (r *leaseRegistry) updateDuplicates() {
+	holders := map[string]int{}
+	for _, lease := range r.leases {
+		if lease.FixedAddress != nil {
+			holders[lease.FixedAddress.String()]++
+		}
+	}
+
+	duplicates := 0
+	for _, n := range holders {
+		if n > 1 {
+			duplicates++
+		}
+	}
+	r.duplicates.Set(float64(duplicates))
+}
+
+// anyAcquired returns a channel closed once any lease has been recorded.
+func (r *leaseRegistry) anyAcquired() <-chan struct{} {
+	return r.acquired
+}
+
+// waitBound blocks until every one of targets holds a lease, returning false
+// if ctx is done first.
+func (r *leaseRegistry) waitBound(ctx context.Context, targets []string) bool {
+	for {
+		r.mu.RLock()
+		missing := false
+		for _, target := range targets {
+			if _, ok := r.leases[target]; !ok {
+				missing = true
+				break
+			}
+		}
+		changed := r.changed
+		r.mu.RUnlock()
+
+		if !missing {
+			return true
+		}
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// neverBound returns those of targets that have never held a lease.
+func (r *leaseRegistry) neverBound(targets []string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var pending []string
+	for _, target := range targets {
+		if !r.bound[target] {
+			pending = append(pending, target)
+		}
+	}
+
+	return pending
+}
+
+// get returns the lease held by target, if any.
+func (r *leaseRegistry) get(target string) (dhclient.Lease, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	lease, ok := r.leases[target]
+	return lease, ok
+}
+
+// remove forgets the lease held by target, if any.
+func (r *leaseRegistry) remove(target string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.leases, target)
+	r.updateDuplicates()
+}
+
+// snapshot returns a copy of the currently held leases keyed by target.
+func (r *leaseRegistry) snapshot() map[string]dhclient.Lease {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	result := make(map[string]dhclient.Lease, len(r.leases))
+	for target, lease := range r.leases {
+		result[target] = lease
+	}
+
+	return result
+}
+
+// count returns the number of leases currently held.
+func (r *leaseRegistry) count() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.leases)
+}
+
+// oldestAge returns the longest time since any held lease was last bound, or
+// zero if no leases are held.
+func (r *leaseRegistry) oldestAge(now time.Time) time.Duration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var oldest time.Duration
+	for _, lease := range r.leases {
+		if age := now.Sub(lease.Bound); age > oldest {
+			oldest = age
+		}
+	}
+
+	return oldest
+}
+
+// deniedTargets is a set of targets that gave up, such as because they aren't
+// allowed to open a raw socket. The zero value is not usable, and a nil set
+// ignores every call.
+type deniedTargets struct {
+	mu      sync.Mutex
+	targets map[string]bool
+	// changed is signalled whenever a target is added.
+	changed chan struct{}
+}
+
+func newDeniedTargets() *deniedTargets {
+	return &deniedTargets{targets: map[string]bool{}, changed: make(chan struct{}, 1)}
+}
+
+// add records that target gave up.
+func (d *deniedTargets) add(target string) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	d.targets[target] = true
+	d.mu.Unlock()
+
+	select {
+	case d.changed <- struct{}{}:
+	default:
+	}
+}
+
+// remove forgets target, which is about to try again.
+func (d *deniedTargets) remove(target string) {
+	if d == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.targets, target)
+}
+
+// covers reports whether every one of targets gave up, and there is at least
+// one of them.
+func (d *deniedTargets) covers(targets []string) bool {
+	if d == nil || len(targets) == 0 {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, target := range targets {
+		if !d.targets[target] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// any reports whether any target gave up.
+func (d *deniedTargets) any() bool {
+	if d == nil {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.targets) > 0
+}
+
+// leaseCollectorMetrics lists the metrics computed by leaseCollector.
+var leaseCollectorMetrics = []string{
+	"dhcp_oldest_lease_age_seconds",
+	"dhcp_currently_held_leases",
+	"dhcp_lease_remaining_seconds",
+	"dhcp_lease_until_renew_seconds",
+	"dhcp_lease_until_rebind_seconds",
+	"dhcp_lease_bound_duration_seconds",
+}
+
+// leaseCollector computes metrics from the lease registry at scrape time, so
+// that time-relative values are always fresh without a ticker updating them.
+type leaseCollector struct {
+	leases *leaseRegistry
+	// disabled holds the names of metrics that aren't collected.
+	disabled map[string]bool
+
+	oldestAge    *prometheus.Desc
+	held         *prometheus.Desc
+	remaining    *prometheus.Desc
+	untilRenew   *prometheus.Desc
+	untilRebind  *prometheus.Desc
+	boundSeconds *prometheus.Desc
+}
+
+func newLeaseCollector(leases *leaseRegistry) *leaseCollector {
+	return &leaseCollector{
+		leases: leases,
+		oldestAge: prometheus.NewDesc(
+			"dhcp_oldest_lease_age_seconds",
+			"The longest time since any currently held lease was last bound or renewed",
+			nil, nil,
+		),
+		held: prometheus.NewDesc(
+			"dhcp_currently_held_leases",
+			"The number of leases currently held across all targets",
+			nil, nil,
+		),
+		remaining: prometheus.NewDesc(
+			"dhcp_lease_remaining_seconds",
+			"The time until the currently held lease expires, labeled by IP",
+			[]string{"ip"}, nil,
+		),
+		untilRenew: prometheus.NewDesc(
+			"dhcp_lease_until_renew_seconds",
+			"The time until the currently held lease is due to be renewed (T1), labeled by IP",
+			[]string{"ip"}, nil,
+		),
+		untilRebind: prometheus.NewDesc(
+			"dhcp_lease_until_rebind_seconds",
+			"The time until the currently held lease is due to be rebound (T2), labeled by IP",
+			[]string{"ip"}, nil,
+		),
+		boundSeconds: prometheus.NewDesc(
+			"dhcp_lease_bound_duration_seconds",
+			"The time since the currently held lease was last bound or renewed, labeled by IP",
+			[]string{"ip"}, nil,
+		),
+	}
+}
+
+// descs returns the descriptions of the metrics that aren't disabled, keyed
+// by name.
+func (c *leaseCollector) descs() map[string]*prometheus.Desc {
+	all := map[string]*prometheus.Desc{
+		"dhcp_oldest_lease_age_seconds":     c.oldestAge,
+		"dhcp_currently_held_leases":        c.held,
+		"dhcp_lease_remaining_seconds":      c.remaining,
+		"dhcp_lease_until_renew_seconds":    c.untilRenew,
+		"dhcp_lease_until_rebind_seconds":   c.untilRebind,
+		"dhcp_lease_bound_duration_seconds": c.boundSeconds,
+	}
+
+	for name := range c.disabled {
+		delete(all, name)
+	}
+
+	return all
+}
+
+func (c *leaseCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.descs() {
+		ch <- desc
+	}
+}
+
+// untilSeconds returns the seconds from now until t, or zero if t has passed.
+func untilSeconds(now, t time.Time) float64 {
+	if d := t.Sub(now); d > 0 {
+		return d.Seconds()
+	}
+
+	return 0
+}
+
+func (c *leaseCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	descs := c.descs()
+
+	gauge := func(name string, value float64, labels ...string) {
+		if desc, ok := descs[name]; ok {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, labels...)
+		}
+	}
+
+	gauge("dhcp_oldest_lease_age_seconds", c.leases.oldestAge(now).Seconds())
+	gauge("dhcp_currently_held_leases", float64(c.leases.count()))
+
+	for target, lease := range c.leases.snapshot() {
+		remaining, renew, rebind := untilSeconds(now, lease.Expire), untilSeconds(now, lease.Renew), untilSeconds(now, lease.Rebind)
+		if lease.Infinite {
+			remaining, renew, rebind = math.Inf(1), math.Inf(1), math.Inf(1)
+		}
+		gauge("dhcp_lease_remaining_seconds", remaining, target)
+		gauge("dhcp_lease_until_renew_seconds", renew, target)
+		gauge("dhcp_lease_until_rebind_seconds", rebind, target)
+		gauge("dhcp_lease_bound_duration_seconds", now.Sub(lease.Bound).Seconds(), target)
+	}
+}
@@ -0,0 +1,129 @@
+package greedydhcp
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLeaseCollectorHeldLeases(t *testing.T) {
+	leases := newLeaseRegistry(testMetrics.dhcpDuplicateBoundAddresses)
+	collector := newLeaseCollector(leases)
+
+	leases.set("10.0.0.1", &dhclient.Lease{Bound: time.Now()})
+	leases.set("10.0.0.2", &dhclient.Lease{Bound: time.Now()})
+	leases.set("10.0.0.2", &dhclient.Lease{Bound: time.Now()})
+	leases.remove("10.0.0.1")
+	leases.set("10.0.0.3", &dhclient.Lease{Bound: time.Now()})
+
+	if n := testutil.CollectAndCount(collector, "dhcp_currently_held_leases"); n != 1 {
+		t.Fatalf("expected one series, got %d", n)
+	}
+	if n := leases.count(); n != 2 {
+		t.Errorf("expected 2 held leases, got %d", n)
+	}
+}
+
+func TestLeaseCollectorTimeRelativeGauges(t *testing.T) {
+	leases := newLeaseRegistry(testMetrics.dhcpDuplicateBoundAddresses)
+	collector := newLeaseCollector(leases)
+
+	now := time.Now()
+	leases.set("10.0.0.1", &dhclient.Lease{
+		Bound:  now.Add(-time.Minute),
+		Renew:  now.Add(-time.Second),
+		Rebind: now.Add(time.Hour),
+		Expire: now.Add(2 * time.Hour),
+	})
+
+	expected := `
+# HELP dhcp_lease_until_renew_seconds The time until the currently held lease is due to be renewed (T1), labeled by IP
+# TYPE dhcp_lease_until_renew_seconds gauge
+dhcp_lease_until_renew_seconds{ip="10.0.0.1"} 0
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "dhcp_lease_until_renew_seconds"); err != nil {
+		t.Errorf("unexpected renew gauge: %v", err)
+	}
+
+	for _, name := range []string{"dhcp_lease_remaining_seconds", "dhcp_lease_until_rebind_seconds", "dhcp_lease_bound_duration_seconds"} {
+		if n := testutil.CollectAndCount(collector, name); n != 1 {
+			t.Errorf("expected one %s series, got %d", name, n)
+		}
+	}
+}
+
+func TestLeaseRegistryAnyAcquired(t *testing.T) {
+	leases := newLeaseRegistry(testMetrics.dhcpDuplicateBoundAddresses)
+
+	select {
+	case <-leases.anyAcquired():
+		t.Fatal("expected no lease to have been acquired yet")
+	default:
+	}
+
+	leases.set("10.0.0.1", &dhclient.Lease{Bound: time.Now()})
+	leases.remove("10.0.0.1")
+	leases.set("10.0.0.2", &dhclient.Lease{Bound: time.Now()})
+
+	select {
+	case <-leases.anyAcquired():
+	default:
+		t.Fatal("expected a lease to have been acquired, even after it was removed")
+	}
+}
+
+func TestLeaseRegistryDuplicateAddresses(t *testing.T) {
+	leases := newLeaseRegistry(testMetrics.dhcpDuplicateBoundAddresses)
+	addr := net.IPv4(10, 0, 0, 50)
+
+	if others := leases.set("10.0.0.1", &dhclient.Lease{FixedAddress: addr}); len(others) != 0 {
+		t.Fatalf("expected no other holders, got %v", others)
+	}
+	others := leases.set("10.0.0.2", &dhclient.Lease{FixedAddress: addr})
+	if !reflect.DeepEqual(others, []string{"10.0.0.1"}) {
+		t.Fatalf("expected the first target to be reported, got %v", others)
+	}
+	leases.set("10.0.0.3", &dhclient.Lease{FixedAddress: net.IPv4(10, 0, 0, 51)})
+	if v := testutil.ToFloat64(testMetrics.dhcpDuplicateBoundAddresses); v != 1 {
+		t.Errorf("expected one duplicate address, got %v", v)
+	}
+
+	leases.remove("10.0.0.1")
+	if v := testutil.ToFloat64(testMetrics.dhcpDuplicateBoundAddresses); v != 0 {
+		t.Errorf("expected no duplicate addresses once one is removed, got %v", v)
+	}
+}
+
+func TestDeniedTargetsCovers(t *testing.T) {
+	denied := newDeniedTargets()
+	targets := []string{"10.0.0.1", "10.0.0.2"}
+
+	denied.add("10.0.0.1")
+	if denied.covers(targets) {
+		t.Fatal("expected a target that hasn't given up to keep the set from covering")
+	}
+
+	denied.add("10.0.0.2")
+	if !denied.covers(targets) {
+		t.Fatal("expected every target to have given up")
+	}
+
+	denied.remove("10.0.0.2")
+	if denied.covers(targets) {
+		t.Error("expected a removed target to no longer count")
+	}
+	if denied.covers(nil) {
+		t.Error("expected no targets to never be covered")
+	}
+
+	var none *deniedTargets
+	none.add("10.0.0.1")
+	if none.covers(targets) {
+		t.Error("expected a nil set to cover nothing")
+	}
+}
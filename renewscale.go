@@ -0,0 +1,39 @@
+package greedydhcp
+
+import (
+	"math"
+	"time"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+)
+
+// minRenewFraction is the earliest point of a lease, as a fraction of its
+// length, that a scaled renewal is scheduled at, so that very short leases
+// aren't renewed as soon as they are bound.
+const minRenewFraction = 0.25
+
+// renewScaling scales when leases are renewed to the lease time granted:
+// leases shorter than reference are renewed earlier than their T1, leaving
+// more time to retry before they expire, and longer ones later, sparing
+// needless renewals. The granted T1 is scaled by (length/reference)^factor,
+// so that a factor of 0 keeps it and one of 1 makes the fraction of a lease
+// waited before renewing it proportional to its length.
+type renewScaling struct {
+	factor    float64
+	reference time.Duration
+}
+
+// renewAt returns the scaled renewal time of lease, no earlier than a quarter
+// into it and no later than its expiry. The client keeps it from passing T2.
+func (s renewScaling) renewAt(lease *dhclient.Lease) time.Time {
+	length := lease.Expire.Sub(lease.Bound)
+	t1 := lease.Renew.Sub(lease.Bound)
+	// Clamped before converting, as a long lease scaled up can overflow a
+	// Duration.
+	scaled := time.Duration(math.Min(float64(t1)*math.Pow(float64(length)/float64(s.reference), s.factor), float64(length)))
+	if earliest := time.Duration(float64(length) * minRenewFraction); scaled < earliest {
+		scaled = earliest
+	}
+
+	return lease.Bound.Add(scaled)
+}
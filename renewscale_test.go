@@ -0,0 +1,38 @@
+package greedydhcp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+)
+
+func TestRenewScalingRenewAt(t *testing.T) {
+	bound := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	newLease := func(length time.Duration) *dhclient.Lease {
+		return &dhclient.Lease{Bound: bound, Renew: bound.Add(length / 2), Expire: bound.Add(length)}
+	}
+
+	tests := []struct {
+		name    string
+		scaling renewScaling
+		length  time.Duration
+		want    time.Duration
+	}{
+		{name: "no factor keeps T1", scaling: renewScaling{factor: 0, reference: time.Hour}, length: 10 * time.Minute, want: 5 * time.Minute},
+		{name: "reference keeps T1", scaling: renewScaling{factor: 1, reference: time.Hour}, length: time.Hour, want: 30 * time.Minute},
+		{name: "longer lease renews later", scaling: renewScaling{factor: 0.5, reference: time.Hour}, length: 4 * time.Hour, want: 4 * time.Hour},
+		{name: "shorter lease renews earlier", scaling: renewScaling{factor: 1, reference: time.Hour}, length: 45 * time.Minute, want: 16*time.Minute + 52500*time.Millisecond},
+		{name: "no earlier than a quarter", scaling: renewScaling{factor: 1, reference: time.Hour}, length: time.Minute, want: 15 * time.Second},
+		{name: "no later than expiry", scaling: renewScaling{factor: 3, reference: time.Second}, length: 365 * 24 * time.Hour, want: 365 * 24 * time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.scaling.renewAt(newLease(tt.length)).Sub(bound)
+			if diff := got - tt.want; diff < -time.Millisecond || diff > time.Millisecond {
+				t.Errorf("expected renewal %s after binding, got %s", tt.want, got)
+			}
+		})
+	}
+}
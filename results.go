@@ -0,0 +1,211 @@
+package greedydhcp
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+)
+
+// resultsReport is the summary of a run written to RESULTS_FILE on exit, for
+// tools that run greedydhcp as a test step rather than scrape it.
+type resultsReport struct {
+	Started         time.Time      `json:"started"`
+	Ended           time.Time      `json:"ended"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	Hostname        string         `json:"hostname,omitempty"`
+	Interface       string         `json:"interface"`
+	Targets         []targetResult `json:"targets"`
+}
+
+// targetResult is the summary of a single target.
+type targetResult struct {
+	Target   string `json:"target"`
+	Acquired int    `json:"acquired"`
+	Failed   int    `json:"failed"`
+	Expired  int    `json:"expired"`
+	// AcquireSeconds is left out if no lease was acquired.
+	AcquireSeconds *latencySummary `json:"acquire_seconds,omitempty"`
+	// Address and Server are those of the lease held at the end of the
+	// run, left out if none was.
+	Address     string   `json:"address,omitempty"`
+	Server      string   `json:"server,omitempty"`
+	ServersSeen []string `json:"servers_seen"`
+	// Expectation is the outcome of the target's expectation, left out if
+	// it has none.
+	Expectation string `json:"expectation,omitempty"`
+}
+
+// latencySummary summarizes a set of durations, in seconds.
+type latencySummary struct {
+	Min float64 `json:"min"`
+	Avg float64 `json:"avg"`
+	Max float64 `json:"max"`
+}
+
+// targetTally is what runResults counts for a target.
+type targetTally struct {
+	acquired, failed, expired int
+	latencies                 int
+	latencySum                time.Duration
+	latencyMin, latencyMax    time.Duration
+	servers                   map[string]bool
+	expectation               string
+}
+
+// runResults counts the lease events of every target over the run, to be
+// reported once it ends. A nil runResults counts nothing.
+type runResults struct {
+	mu      sync.Mutex
+	started time.Time
+	targets map[string]*targetTally
+}
+
+func newRunResults(started time.Time) *runResults {
+	return &runResults{started: started, targets: map[string]*targetTally{}}
+}
+
+// tally returns the tally of target, creating it. r.mu must be held.
+func (r *runResults) tally(target string) *targetTally {
+	t, ok := r.targets[target]
+	if !ok {
+		t = &targetTally{servers: map[string]bool{}}
+		r.targets[target] = t
+	}
+
+	return t
+}
+
+// acquired counts a lease acquired by target from server, latency after the
+// attempt began. A latency of zero, as for an address change counted as an
+// acquisition, is left out of the summary.
+func (r *runResults) acquired(target string, server net.IP, latency time.Duration) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	t := r.tally(target)
+	t.acquired++
+	if server != nil {
+		t.servers[server.String()] = true
+	}
+	if latency <= 0 {
+		return
+	}
+	if t.latencies == 0 || latency < t.latencyMin {
+		t.latencyMin = latency
+	}
+	if latency > t.latencyMax {
+		t.latencyMax = latency
+	}
+	t.latencies++
+	t.latencySum += latency
+}
+
+// failed counts a failed exchange of target.
+func (r *runResults) failed(target string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tally(target).failed++
+}
+
+// expired counts a lease of target that expired.
+func (r *runResults) expired(target string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tally(target).expired++
+}
+
+// expectation records the outcome of the expectation of target.
+func (r *runResults) expectation(target, outcome string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tally(target).expectation = outcome
+}
+
+// report summarizes the run until ended, for every target in targets or with
+// anything counted. held are the leases held at the end of the run.
+func (r *runResults) report(ended time.Time, iface string, targets []string, held map[string]dhclient.Lease) resultsReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, target := range targets {
+		r.tally(target)
+	}
+
+	report := resultsReport{
+		Started:         r.started,
+		Ended:           ended,
+		DurationSeconds: ended.Sub(r.started).Seconds(),
+		Interface:       iface,
+		Targets:         make([]targetResult, 0, len(r.targets)),
+	}
+	report.Hostname, _ = os.Hostname()
+
+	for target, t := range r.targets {
+		result := targetResult{
+			Target:      target,
+			Acquired:    t.acquired,
+			Failed:      t.failed,
+			Expired:     t.expired,
+			ServersSeen: make([]string, 0, len(t.servers)),
+			Expectation: t.expectation,
+		}
+		if t.latencies > 0 {
+			result.AcquireSeconds = &latencySummary{
+				Min: t.latencyMin.Seconds(),
+				Avg: (t.latencySum / time.Duration(t.latencies)).Seconds(),
+				Max: t.latencyMax.Seconds(),
+			}
+		}
+		if lease, ok := held[target]; ok {
+			result.Address = lease.FixedAddress.String()
+			result.Server = lease.ServerID.String()
+		}
+		for server := range t.servers {
+			result.ServersSeen = append(result.ServersSeen, server)
+		}
+		sort.Strings(result.ServersSeen)
+
+		report.Targets = append(report.Targets, result)
+	}
+	sort.Slice(report.Targets, func(i, j int) bool { return report.Targets[i].Target < report.Targets[j].Target })
+
+	return report
+}
+
+// writeResults writes report as JSON to output, either stdout or the path of
+// a file to replace atomically.
+func writeResults(output string, report resultsReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if output == "stdout" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	return writeFileAtomic(output, data)
+}
@@ -0,0 +1,59 @@
+package greedydhcp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+)
+
+func TestRunResultsReport(t *testing.T) {
+	started := time.Now()
+	r := newRunResults(started)
+	server := net.ParseIP("10.0.0.254")
+
+	r.acquired("10.0.0.1", server, time.Second)
+	r.acquired("10.0.0.1", server, 3*time.Second)
+	r.acquired("10.0.0.1", net.ParseIP("10.0.0.253"), 0)
+	r.failed("10.0.0.1")
+	r.expired("10.0.0.1")
+	r.failed("10.0.0.2")
+
+	held := map[string]dhclient.Lease{
+		"10.0.0.1": {FixedAddress: net.ParseIP("10.0.0.1"), ServerID: server},
+	}
+	report := r.report(started.Add(time.Minute), "eth0", []string{"10.0.0.3", "10.0.0.1"}, held)
+
+	if report.DurationSeconds != 60 || report.Interface != "eth0" {
+		t.Errorf("unexpected run metadata: %+v", report)
+	}
+	if len(report.Targets) != 3 {
+		t.Fatalf("expected every target with a result or configured, got %+v", report.Targets)
+	}
+
+	first := report.Targets[0]
+	if first.Target != "10.0.0.1" || first.Acquired != 3 || first.Failed != 1 || first.Expired != 1 {
+		t.Errorf("unexpected counts: %+v", first)
+	}
+	if want := (latencySummary{Min: 1, Avg: 2, Max: 3}); first.AcquireSeconds == nil || *first.AcquireSeconds != want {
+		t.Errorf("expected acquire seconds %+v, got %+v", want, first.AcquireSeconds)
+	}
+	if first.Address != "10.0.0.1" || first.Server != "10.0.0.254" {
+		t.Errorf("expected the held lease, got address %q and server %q", first.Address, first.Server)
+	}
+	if len(first.ServersSeen) != 2 || first.ServersSeen[0] != "10.0.0.253" {
+		t.Errorf("expected both servers to be seen, got %v", first.ServersSeen)
+	}
+
+	if idle := report.Targets[2]; idle.Target != "10.0.0.3" || idle.Acquired != 0 || idle.AcquireSeconds != nil || idle.Address != "" {
+		t.Errorf("expected an empty result for a target without events, got %+v", idle)
+	}
+}
+
+func TestRunResultsNil(t *testing.T) {
+	var r *runResults
+	r.acquired("10.0.0.1", nil, time.Second)
+	r.failed("10.0.0.1")
+	r.expired("10.0.0.1")
+}
@@ -0,0 +1,1371 @@
+package greedydhcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// dumpState logs the held lease and counters in m of every target, with one
+// event per target. The series of targets evicted by METRIC_SERIES_LIMIT are
+// read as zero rather than created again.
+func dumpState(logger *slog.Logger, m *metrics, leases *leaseRegistry, targets []string) {
+	held := leases.snapshot()
+	logger.Info("Dumping state", "targets", len(targets), "held_leases", len(held))
+
+	acquired := seriesValues(m.dhcpAcquiredLeasesTotal)
+	failed := seriesValues(m.dhcpFailedLeasesTotal)
+	expired := seriesValues(m.dhcpExpiredLeasesTotal)
+	distinctServers := seriesValues(m.dhcpDistinctServersSeen)
+	breakers := seriesValues(m.dhcpCircuitBreakerState)
+	for _, target := range targets {
+		attrs := []any{
+			"target", target,
+			"acquired", acquired[target],
+			"failed", failed[target],
+			"expired", expired[target],
+			"distinct_servers", distinctServers[target],
+		}
+
+		for _, state := range breakerStates {
+			if breakers[target+","+state.String()] == 1 {
+				attrs = append(attrs, "breaker", state.String())
+			}
+		}
+
+		if lease, ok := held[target]; ok {
+			attrs = append(attrs,
+				"held", true,
+				"addr", lease.FixedAddress,
+				"server", lease.ServerID,
+				"bound", lease.Bound,
+				"expire", lease.Expire,
+				"infinite", lease.Infinite,
+			)
+		} else {
+			attrs = append(attrs, "held", false)
+		}
+
+		logger.Info("Target state", attrs...)
+	}
+}
+
+// reloadTargets reads the targets again and applies them to set. The running
+// targets are kept if the new configuration is invalid, which includes having
+// targets outside the subnets of iface if strictSubnet is set.
+func reloadTargets(logger *slog.Logger, set *targetSet, iface *net.Interface, strictSubnet bool) {
+	targets, err := loadTargets(logger)
+	if err == nil {
+		err = checkTargetSubnets(logger, iface, targets, strictSubnet)
+	}
+	if err == nil {
+		err = checkNoDefaultParams(targets, set.cfg.noDefaultParams)
+	}
+	if err != nil {
+		logger.Error("Invalid target configuration, keeping current targets", "err", err)
+		return
+	}
+
+	set.apply(targets)
+}
+
+// waitTimeout calls wait, returning false if ctx is done before it returns.
+func waitTimeout(ctx context.Context, wait func()) bool {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// defaultMetricsAddr is where metrics are served unless METRICS_ADDR is set.
+const defaultMetricsAddr = "127.0.0.1:1337"
+
+// Errors returned by ConfigFromEnv and Run, so that callers can tell
+// failures apart.
+var (
+	// ErrInvalidConfig is an invalid setting or target configuration.
+	ErrInvalidConfig = errors.New("invalid configuration")
+	// ErrNoInterface is no interface to bind to being found.
+	ErrNoInterface = errors.New("no interface to bind to")
+	// ErrNoLease is FAIL_FAST giving up after no target acquired a lease
+	// within the startup grace period.
+	ErrNoLease = errors.New("no target acquired a lease within the startup grace period")
+	// ErrNoRawSocket is no target being allowed to open a raw socket.
+	ErrNoRawSocket = errors.New("no target is allowed to open a raw socket")
+	// ErrMetricsListen is the metrics server being unable to listen.
+	ErrMetricsListen = errors.New("unable to listen for metrics")
+	// ErrHardFailed is every target being hard failed.
+	ErrHardFailed = errors.New("every target is hard failed")
+	// ErrExpectationFailed is a target failing its expectation, in a run
+	// that otherwise stopped cleanly.
+	ErrExpectationFailed = errors.New("a target failed its expectation")
+	// ErrShutdownTimeout is the clients not stopping within SHUTDOWN_TIMEOUT
+	// once the prober is done.
+	ErrShutdownTimeout = errors.New("timed out waiting for clients to stop")
+)
+
+// Target is an address to hold a lease for.
+type Target struct {
+	// Addr is the IPv4 address to request.
+	Addr string
+
+	// target is the rest of the target's configuration, as read by
+	// ConfigFromEnv.
+	target targetConfig
+}
+
+// Config is the configuration of a prober run by Run. Only Interface,
+// Targets, MetricsAddr, Logger and Registry can be set by hand, every other
+// setting taking its default unless the Config is read by ConfigFromEnv.
+type Config struct {
+	// Interface is the interface to bind to. If nil, the first suitable
+	// interface is used, and another one is selected should it lose its
+	// addresses.
+	Interface *net.Interface
+	// Targets are the addresses to hold leases for.
+	Targets []Target
+	// MetricsAddr is the host:port or unix:///path/to.sock to serve metrics
+	// and the health endpoints at. Nothing is served if it is empty.
+	MetricsAddr string
+	// Logger is the logger to log to, slog.Default() if nil.
+	Logger *slog.Logger
+	// Registry is the registry the prober's metrics are registered with. If
+	// nil, a registry of its own is used. Two probers can't share one.
+	Registry *prometheus.Registry
+
+	// Reload, when received from, reads the targets from the environment
+	// again and applies them, as on SIGHUP.
+	Reload <-chan struct{}
+	// DumpState, when received from, logs the state of every target, as on
+	// SIGUSR1.
+	DumpState <-chan struct{}
+
+	// settings are the settings read by ConfigFromEnv, or nil for the
+	// defaults.
+	settings *settings
+}
+
+// settings are the settings of a prober that can't be set on a Config by
+// hand.
+type settings struct {
+	client clientSettings
+
+	nativeHistograms        bool
+	acquireSummaryQuantiles map[float64]float64
+	disabledMetrics         map[string]bool
+	seriesLimit             int
+
+	ifaceCheckInterval time.Duration
+	macChange          string
+	ifaceReselectAfter time.Duration
+	// reselectIface is set if the interface wasn't selected by name or
+	// MAC, so can be swapped for another one.
+	reselectIface bool
+
+	maxConcurrentStart int
+	maxPPS             int
+	resetOnLinkUp      bool
+	eventsBufferSize   int
+	maxHistoryBytes    int
+
+	natsURL        string
+	natsSubject    string
+	natsCredsFile  string
+	natsBufferSize int
+	statsdAddr     string
+	instanceID     string
+
+	stress         stressConfig
+	poolEstimate   poolEstimateConfig
+	anonymous      anonymousConfig
+	strictSubnet   bool
+	leaseStateFile string
+	// watchConfigFile is the CONFIG_FILE to reload the targets from when it
+	// changes, if WATCH_CONFIG is set.
+	watchConfigFile string
+
+	shutdownTimeout time.Duration
+	gracePeriod     time.Duration
+	failFast        bool
+	runDuration     time.Duration
+
+	resultsFile    string
+	snapshotFile   string
+	influxOutput   string
+	influxInterval time.Duration
+
+	scrapeCacheTTL      time.Duration
+	metricsPerInterface bool
+	reacquireToken      string
+}
+
+// defaultSettings returns the settings used when none are set in the
+// environment.
+func defaultSettings() settings {
+	return settings{
+		client: clientSettings{
+			hardFailRetryInterval: time.Hour,
+			stableGrace:           time.Minute,
+			retransmitTimeout:     5 * time.Second,
+			responseJitterWindow:  20,
+			recoverPanics:         true,
+			panicRestartDelay:     5 * time.Second,
+			releaseOnExit:         true,
+			arpDefendTimeout:      time.Second,
+			gatewayPingTimeout:    2 * time.Second,
+			retryBackoffMax:       5 * time.Minute,
+		},
+		ifaceCheckInterval: 30 * time.Second,
+		macChange:          macChangeRestart,
+		seriesLimit:        10000,
+		eventsBufferSize:   100,
+		maxHistoryBytes:    1 << 20,
+		natsSubject:        "greedydhcp.lease_events",
+		natsBufferSize:     1000,
+		shutdownTimeout:    10 * time.Second,
+		gracePeriod:        2 * time.Minute,
+		influxInterval:     10 * time.Second,
+		scrapeCacheTTL:     time.Second,
+	}
+}
+
+// ConfigFromEnv reads the configuration of a prober from CONFIG_FILE and the
+// environment, selecting the interface to bind to. The error wraps
+// ErrInvalidConfig or ErrNoInterface.
+func ConfigFromEnv() (Config, error) {
+	// The file's settings are read first, as the logger depends on them.
+	var file fileSettings
+	var settingConflicts []targetConflict
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		var err error
+		file, settingConflicts, err = readFileSettings(path)
+		if err != nil {
+			return Config{}, fmt.Errorf("%w: unable to read settings from CONFIG_FILE %s: %w", ErrInvalidConfig, path, err)
+		}
+	}
+
+	logger, err := getLogger(file)
+	if err != nil {
+		return Config{}, fmt.Errorf("%w: unable to set up logging: %w", ErrInvalidConfig, err)
+	}
+	cfg := Config{Logger: logger, MetricsAddr: file.metricsAddr}
+
+	for _, c := range settingConflicts {
+		logger.Warn(
+			"Setting is set differently by CONFIG_FILE and the environment, using the file's setting",
+			"setting", c.setting, "file", c.file, "env", c.env,
+		)
+	}
+
+	var ifaceMAC net.HardwareAddr
+	if ifaceMACStr := os.Getenv("IFACE_MAC"); ifaceMACStr != "" && file.iface == "" {
+		ifaceMAC, err = net.ParseMAC(ifaceMACStr)
+		if err != nil {
+			return cfg, fmt.Errorf("%w: IFACE_MAC %q is not a valid MAC address: %w", ErrInvalidConfig, ifaceMACStr, err)
+		}
+
+		logger.Debug("Selecting interface by MAC address", "mac", ifaceMAC)
+	}
+
+	ifaceName := file.iface
+	if ifaceName == "" {
+		ifaceName = os.Getenv("IFACE")
+	}
+	if ifaceName != "" && ifaceMAC != nil {
+		return cfg, fmt.Errorf("%w: only one of IFACE and IFACE_MAC may be set", ErrInvalidConfig)
+	}
+
+	cfg.Interface, err = getInterface(logger, ifaceName, ifaceMAC)
+	if err != nil {
+		return cfg, fmt.Errorf("%w: %w", ErrNoInterface, err)
+	}
+
+	iface := cfg.Interface
+	logger.Info("Using interface", "iface", iface.Name, "mac", iface.HardwareAddr, "type", ifaceType(iface), "vrf", interfaceVRF(iface.Name))
+
+	if err := settingsFromEnv(logger, iface, &cfg); err != nil {
+		return cfg, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+	// An interface selected by name or MAC needs no address and can't be
+	// swapped for another one.
+	cfg.settings.reselectIface = ifaceName == "" && ifaceMAC == nil
+
+	return cfg, nil
+}
+
+// targetConfigs returns the configuration of every target of cfg.
+func (cfg Config) targetConfigs() ([]targetConfig, error) {
+	targets := make([]targetConfig, 0, len(cfg.Targets))
+	for _, t := range cfg.Targets {
+		if err := validateTargetAddr(t.Addr); err != nil {
+			return nil, err
+		}
+
+		target := t.target
+		target.addr = t.Addr
+		targets = append(targets, target)
+	}
+
+	return targets, nil
+}
+
+// Check checks, without acquiring any lease, that the targets of cfg could
+// be probed on its interface, which must be set, as --check does. A line is
+// printed to w per check, and it returns whether all of them passed.
+func Check(w io.Writer, cfg Config) bool {
+	targets, err := cfg.targetConfigs()
+	if err != nil {
+		fmt.Fprintf(w, "FAIL  targets: %v\n", err)
+		return false
+	}
+
+	return runChecks(w, cfg.Interface, targets)
+}
+
+// Run runs a prober holding leases for the targets of cfg until ctx is done,
+// releasing them and returning once it has shut down. It returns early with
+// an error wrapping one of ErrInvalidConfig, ErrNoLease, ErrNoRawSocket,
+// ErrMetricsListen or ErrHardFailed should the prober be unable to go on, and
+// ErrExpectationFailed if it stopped cleanly but a target failed its
+// expectation. If the clients don't stop within SHUTDOWN_TIMEOUT, the error
+// also wraps ErrShutdownTimeout. Every prober has metrics of its own, so that
+// more than one can run in a process.
+func Run(ctx context.Context, cfg Config) error {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	s := defaultSettings()
+	if cfg.settings != nil {
+		s = *cfg.settings
+	}
+
+	iface := cfg.Interface
+	if iface == nil {
+		var err error
+		iface, err = getInterface(logger, "", nil)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrNoInterface, err)
+		}
+		s.reselectIface = true
+	}
+
+	targets, err := cfg.targetConfigs()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+	}
+
+	m := newMetrics(s.nativeHistograms, s.acquireSummaryQuantiles)
+	disabledMetrics := maps.Clone(s.disabledMetrics)
+	if disabledMetrics == nil {
+		disabledMetrics = map[string]bool{}
+	}
+	// The summary adds a series per quantile and target, so is left out
+	// unless asked for.
+	if s.acquireSummaryQuantiles == nil {
+		disabledMetrics["dhcp_acquire_duration_seconds"] = true
+	}
+
+	clientCfg := &clientConfig{
+		clientSettings:     s.client,
+		metrics:            m,
+		iface:              iface,
+		ifaceChanged:       newBroadcast(),
+		leases:             newLeaseRegistry(m.dhcpDuplicateBoundAddresses),
+		socketDenied:       newDeniedTargets(),
+		hardFailed:         newDeniedTargets(),
+		expectationsFailed: newDeniedTargets(),
+		vlans:              newVLANManager(),
+		series:             newSeriesLRU(logger, s.seriesLimit, m.deleteTarget),
+		startGate:          newStartGate(s.maxConcurrentStart),
+		pacer:              newSendPacer(s.maxPPS),
+		events:             newEventRing(s.eventsBufferSize, s.maxHistoryBytes, m.greedydhcpHistoryBytes),
+	}
+	if s.resetOnLinkUp {
+		clientCfg.linkUp = newLinkNotifier()
+	}
+	if s.resultsFile != "" {
+		clientCfg.results = newRunResults(time.Now())
+	}
+
+	if s.natsURL != "" {
+		clientCfg.publisher, err = newNATSPublisher(logger, m, s.natsURL, s.natsSubject, s.natsCredsFile, s.natsBufferSize)
+		if err != nil {
+			return fmt.Errorf("%w: invalid NATS_URL, NATS_SUBJECT or NATS_BUFFER_SIZE: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	if s.statsdAddr != "" {
+		instance := s.instanceID
+		if instance == "" {
+			instance, err = os.Hostname()
+			if err != nil {
+				return fmt.Errorf("unable to get hostname for INSTANCE_ID: %w", err)
+			}
+		}
+
+		clientCfg.statsd, err = newStatsdEmitter(s.statsdAddr, instance)
+		if err != nil {
+			return fmt.Errorf("%w: unable to parse STATSD_ADDR %s: %w", ErrInvalidConfig, s.statsdAddr, err)
+		}
+		logger.Info("Sending events to StatsD", "addr", s.statsdAddr, "instance", instance)
+	}
+
+	registry := cfg.Registry
+	if registry == nil {
+		registry = newRegistry()
+	}
+	if err := m.register(registry, clientCfg.leases, disabledMetrics); err != nil {
+		return err
+	}
+	m.greedydhcpStartTimeSeconds.SetToCurrentTime()
+	if clientCfg.recoverPanics {
+		m.greedydhcpPanicsTotal.WithLabelValues(panicComponentClient).Add(0)
+		m.greedydhcpPanicsTotal.WithLabelValues(panicComponentMetrics).Add(0)
+	}
+
+	reloadChan := make(chan struct{}, 1)
+	if s.watchConfigFile != "" {
+		stopWatching, err := watchConfigFile(logger, s.watchConfigFile, reloadChan)
+		if err != nil {
+			return fmt.Errorf("%w: unable to watch config file %s: %w", ErrInvalidConfig, s.watchConfigFile, err)
+		}
+		defer stopWatching()
+
+		logger.Info("Watching config file for changes", "path", s.watchConfigFile)
+	}
+
+	// The clients outlive ctx, as they are stopped by Shutdown once it is
+	// done.
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	set := newTargetSet(runCtx, logger, clientCfg)
+	set.apply(targets)
+
+	go watchInterfaceIndex(runCtx, logger, m, iface, s.ifaceCheckInterval)
+	go watchInterfaceMAC(runCtx, logger, clientCfg, s.ifaceCheckInterval, s.macChange)
+	// An interface selected by name or MAC needs no address and can't be
+	// swapped for another one, so there's nothing to watch for.
+	if s.reselectIface {
+		go watchInterfaceAddrs(runCtx, logger, clientCfg, s.ifaceCheckInterval, s.ifaceReselectAfter, func() (*net.Interface, error) {
+			return getInterface(logger, "", nil)
+		})
+	}
+	if clientCfg.pacer != nil {
+		go clientCfg.pacer.reportRate(runCtx, m.dhcpSendRatePPS)
+	}
+	if clientCfg.linkUp != nil {
+		go watchInterfaceState(runCtx, logger, m, iface, s.ifaceCheckInterval, clientCfg.linkUp)
+	}
+
+	stressWG := &sync.WaitGroup{}
+	if s.stress.clients > 0 {
+		stressWG.Add(1)
+		go runStress(runCtx, stressWG, logger, clientCfg, s.stress)
+	}
+	if s.poolEstimate.subnet != nil {
+		stressWG.Add(1)
+		go runPoolEstimate(runCtx, stressWG, logger, clientCfg, s.poolEstimate)
+	}
+	if s.anonymous.clients > 0 {
+		stressWG.Add(1)
+		go runAnonymous(runCtx, stressWG, logger, clientCfg, s.anonymous)
+	}
+
+	gatherer := tagGatherer{Gatherer: registry, tags: set.tags}
+	if s.influxOutput != "" {
+		logger.Info("Exporting metrics in influx line protocol", "output", s.influxOutput, "interval", s.influxInterval)
+		go runInfluxExporter(runCtx, logger, gatherer, s.influxOutput, s.influxInterval)
+	}
+	p := &prober{
+		logger:         logger,
+		cfg:            clientCfg,
+		set:            set,
+		stressWG:       stressWG,
+		cancel:         cancel,
+		gatherer:       gatherer,
+		snapshotFile:   s.snapshotFile,
+		leaseStateFile: s.leaseStateFile,
+		resultsFile:    s.resultsFile,
+	}
+	serverErr := make(chan error, 1)
+	if cfg.MetricsAddr == "" {
+		logger.Info("Metrics server disabled, metrics are collected but not served")
+		if s.reacquireToken != "" {
+			logger.Warn("REACQUIRE_TOKEN is ignored without the metrics server")
+		}
+	} else {
+		// A mux of its own keeps handlers registered on http.DefaultServeMux
+		// elsewhere from being served.
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.InstrumentMetricHandler(
+			registry, promhttp.HandlerFor(
+				&cachingGatherer{Gatherer: gatherer, ttl: s.scrapeCacheTTL},
+				promhttp.HandlerOpts{EnableOpenMetrics: s.nativeHistograms},
+			),
+		))
+		if s.metricsPerInterface {
+			logger.Info("Serving the metrics of each interface at /metrics/<iface>")
+			mux.Handle("/metrics/", interfaceMetricsHandler(
+				gatherer, set.interfaces,
+				promhttp.HandlerOpts{EnableOpenMetrics: s.nativeHistograms},
+			))
+		}
+		mux.Handle("/livez", livezHandler(&p.shuttingDown))
+		mux.Handle("/healthz", livezHandler(&p.shuttingDown))
+		mux.Handle("/readyz", readyzHandler(&p.shuttingDown, clientCfg.leases, set.addrs))
+		if clientCfg.events != nil {
+			mux.Handle("/events", eventsHandler(clientCfg.events))
+		}
+		if token := s.reacquireToken; token != "" {
+			logger.Info("Enabling reacquire endpoint")
+			mux.Handle("/reacquire", reacquireHandler(logger, token, set.reacquireChan))
+			if clientCfg.trackOptionCodes {
+				mux.Handle("/reset-option-codes", resetOptionCodesHandler(logger, token, set.has, m.dhcpServerOptionSeen))
+			}
+		}
+		listener, err := listenMetrics(cfg.MetricsAddr)
+		if err != nil {
+			return errors.Join(
+				fmt.Errorf("%w at %s: %w", ErrMetricsListen, cfg.MetricsAddr, err),
+				shutdown(p, s.shutdownTimeout),
+			)
+		}
+
+		p.server = &http.Server{Handler: mux}
+		if clientCfg.recoverPanics {
+			p.server.Handler = recoverHandler(logger, m.greedydhcpPanicsTotal, mux)
+		}
+		logger.Info("Serving metrics", "addr", cfg.MetricsAddr)
+		go func() {
+			if err := p.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serverErr <- err
+			}
+		}()
+	}
+
+	// If no target at all gets a lease, the problem is most likely shared by
+	// all of them, such as the wrong interface or no reachable server.
+	acquired := clientCfg.leases.anyAcquired()
+	grace := time.After(s.gracePeriod)
+	var runFor <-chan time.Time
+	if s.runDuration > 0 {
+		logger.Info("Exiting after the run duration", "duration", s.runDuration)
+		runFor = time.After(s.runDuration)
+	}
+
+	err = func() error {
+		for {
+			select {
+			case <-acquired:
+				m.dhcpAnyLeaseAcquired.Set(1)
+				acquired, grace = nil, nil
+			case <-grace:
+				if s.failFast {
+					return fmt.Errorf("%w of %v", ErrNoLease, s.gracePeriod)
+				}
+				logger.Warn("No target acquired a lease within the startup grace period, still retrying", "grace_period", s.gracePeriod)
+			case <-clientCfg.socketDenied.changed:
+				if clientCfg.socketDenied.covers(set.addrs()) {
+					return fmt.Errorf("%w, run as root or grant CAP_NET_RAW", ErrNoRawSocket)
+				}
+			case <-clientCfg.hardFailed.changed:
+				if clientCfg.hardFailed.covers(set.addrs()) {
+					return fmt.Errorf("%w after %d failures", ErrHardFailed, clientCfg.maxFailures)
+				}
+			case <-runFor:
+				logger.Info("Run duration elapsed, exiting", "duration", s.runDuration)
+				return nil
+			case <-cfg.DumpState:
+				dumpState(logger, m, clientCfg.leases, set.addrs())
+			case <-cfg.Reload:
+				reloadTargets(logger, set, iface, s.strictSubnet)
+			case <-reloadChan:
+				reloadTargets(logger, set, iface, s.strictSubnet)
+			case err := <-serverErr:
+				return fmt.Errorf("metrics server failed: %w", err)
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}()
+
+	shutdownErr := shutdown(p, s.shutdownTimeout)
+	if err == nil && clientCfg.expectationsFailed.any() {
+		err = ErrExpectationFailed
+	}
+
+	return errors.Join(err, shutdownErr)
+}
+
+// shutdown shuts p down, giving up after timeout.
+func shutdown(p *prober, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := p.Shutdown(ctx); err != nil {
+		return fmt.Errorf("unable to shut down within %v: %w", timeout, err)
+	}
+
+	return nil
+}
+
+// settingsFromEnv reads every setting of cfg other than the interface from
+// the environment. logger and iface are those already selected.
+func settingsFromEnv(logger *slog.Logger, iface *net.Interface, cfg *Config) error {
+	s := defaultSettings()
+	c := &s.client
+	var err error
+
+	s.ifaceCheckInterval, err = getEnvDuration("IFACE_CHECK_INTERVAL", s.ifaceCheckInterval)
+	if err != nil {
+		return fmt.Errorf("unable to parse IFACE_CHECK_INTERVAL: %w", err)
+	}
+
+	if s.ifaceCheckInterval <= 0 {
+		return fmt.Errorf("IFACE_CHECK_INTERVAL must be positive, got %v", s.ifaceCheckInterval)
+	}
+
+	if macChange := os.Getenv("IFACE_MAC_CHANGE"); macChange != "" {
+		if macChange != macChangeRestart && macChange != macChangeLog {
+			return fmt.Errorf("IFACE_MAC_CHANGE must be restart or log, got %v", macChange)
+		}
+		s.macChange = macChange
+	}
+
+	s.ifaceReselectAfter, err = getEnvDuration("IFACE_RESELECT_AFTER", s.ifaceReselectAfter)
+	if err != nil {
+		return fmt.Errorf("unable to parse IFACE_RESELECT_AFTER: %w", err)
+	}
+
+	if s.ifaceReselectAfter < 0 {
+		return fmt.Errorf("IFACE_RESELECT_AFTER must not be negative, got %v", s.ifaceReselectAfter)
+	}
+
+	s.nativeHistograms, err = getEnvBool("NATIVE_HISTOGRAMS")
+	if err != nil {
+		return fmt.Errorf("unable to parse NATIVE_HISTOGRAMS: %w", err)
+	}
+
+	if s.nativeHistograms {
+		logger.Info("Adding native buckets to duration histograms")
+	}
+
+	s.acquireSummaryQuantiles, err = parseQuantiles(os.Getenv("ACQUIRE_SUMMARY_QUANTILES"))
+	if err != nil {
+		return fmt.Errorf("unable to parse ACQUIRE_SUMMARY_QUANTILES: %w", err)
+	}
+
+	if disabledStr := os.Getenv("DISABLED_METRICS"); disabledStr != "" {
+		s.disabledMetrics = map[string]bool{}
+		known := newMetrics(false, nil).knownNames()
+		for _, name := range strings.Split(disabledStr, ",") {
+			name = strings.TrimSpace(name)
+			if !known[name] {
+				logger.Warn("Ignoring unknown metric in DISABLED_METRICS", "metric", name)
+				continue
+			}
+			s.disabledMetrics[name] = true
+		}
+
+		logger.Info("Disabling metrics", "metrics", len(s.disabledMetrics))
+	}
+
+	s.seriesLimit, err = getEnvInt("METRIC_SERIES_LIMIT", s.seriesLimit)
+	if err != nil {
+		return fmt.Errorf("unable to parse METRIC_SERIES_LIMIT: %w", err)
+	}
+
+	if s.seriesLimit < 0 {
+		return fmt.Errorf("METRIC_SERIES_LIMIT must not be negative, got %v", s.seriesLimit)
+	}
+
+	c.noDefaultParams, err = getEnvBool("NO_DEFAULT_PARAMS")
+	if err != nil {
+		return fmt.Errorf("unable to parse NO_DEFAULT_PARAMS: %w", err)
+	}
+
+	if c.noDefaultParams {
+		logger.Info("Default params disabled, clients will not send a parameter request list", "params", []layers.DHCPOpt{})
+	}
+
+	dscp, err := getEnvInt("DHCP_DSCP", 0)
+	if err != nil {
+		return fmt.Errorf("unable to parse DHCP_DSCP: %w", err)
+	}
+
+	if dscp < 0 || dscp > 63 {
+		return fmt.Errorf("DHCP_DSCP must be between 0 and 63, got %v", dscp)
+	}
+
+	c.dscp = uint8(dscp)
+	if c.dscp != 0 {
+		logger.Info("Marking DHCP packets with DSCP", "dscp", c.dscp)
+	}
+
+	c.minLeaseTime, err = getEnvDuration("MIN_ACCEPTABLE_LEASE_TIME", c.minLeaseTime)
+	if err != nil {
+		return fmt.Errorf("unable to parse MIN_ACCEPTABLE_LEASE_TIME: %w", err)
+	}
+
+	c.acquireSLO, err = getEnvDuration("ACQUIRE_LATENCY_SLO", c.acquireSLO)
+	if err != nil {
+		return fmt.Errorf("unable to parse ACQUIRE_LATENCY_SLO: %w", err)
+	}
+
+	if c.acquireSLO < 0 {
+		return fmt.Errorf("ACQUIRE_LATENCY_SLO must not be negative, got %v", c.acquireSLO)
+	}
+
+	disablePanicRecovery, err := getEnvBool("DISABLE_PANIC_RECOVERY")
+	if err != nil {
+		return fmt.Errorf("unable to parse DISABLE_PANIC_RECOVERY: %w", err)
+	}
+	c.recoverPanics = !disablePanicRecovery
+
+	c.panicRestartDelay, err = getEnvDuration("PANIC_RESTART_DELAY", c.panicRestartDelay)
+	if err != nil {
+		return fmt.Errorf("unable to parse PANIC_RESTART_DELAY: %w", err)
+	}
+
+	if c.panicRestartDelay < 0 {
+		return fmt.Errorf("PANIC_RESTART_DELAY must not be negative, got %v", c.panicRestartDelay)
+	}
+
+	c.maxFailures, err = getEnvInt("MAX_FAILURES", c.maxFailures)
+	if err != nil {
+		return fmt.Errorf("unable to parse MAX_FAILURES: %w", err)
+	}
+
+	if c.maxFailures < 0 {
+		return fmt.Errorf("MAX_FAILURES must not be negative, got %v", c.maxFailures)
+	}
+
+	c.hardFailRetryInterval, err = getEnvDuration("HARD_FAIL_RETRY_INTERVAL", c.hardFailRetryInterval)
+	if err != nil {
+		return fmt.Errorf("unable to parse HARD_FAIL_RETRY_INTERVAL: %w", err)
+	}
+
+	if c.hardFailRetryInterval <= 0 {
+		return fmt.Errorf("HARD_FAIL_RETRY_INTERVAL must be positive, got %v", c.hardFailRetryInterval)
+	}
+
+	if s.statsdAddr = os.Getenv("STATSD_ADDR"); s.statsdAddr != "" {
+		if _, err := net.ResolveUDPAddr("udp", s.statsdAddr); err != nil {
+			return fmt.Errorf("unable to parse STATSD_ADDR %q: %w", s.statsdAddr, err)
+		}
+		s.instanceID = os.Getenv("INSTANCE_ID")
+	}
+
+	c.stableGrace, err = getEnvDuration("STABLE_LEASE_GRACE", c.stableGrace)
+	if err != nil {
+		return fmt.Errorf("unable to parse STABLE_LEASE_GRACE: %w", err)
+	}
+
+	if c.stableGrace < 0 {
+		return fmt.Errorf("STABLE_LEASE_GRACE must not be negative, got %v", c.stableGrace)
+	}
+
+	c.declineShortLeases, err = getEnvBool("DECLINE_SHORT_LEASES")
+	if err != nil {
+		return fmt.Errorf("unable to parse DECLINE_SHORT_LEASES: %w", err)
+	}
+
+	c.addressChangeAcquires, err = getEnvBool("ADDRESS_CHANGE_AS_ACQUISITION")
+	if err != nil {
+		return fmt.Errorf("unable to parse ADDRESS_CHANGE_AS_ACQUISITION: %w", err)
+	}
+
+	if c.minLeaseTime > 0 {
+		logger.Info(
+			"Checking granted lease times",
+			"min_lease_time", c.minLeaseTime, "decline", c.declineShortLeases,
+		)
+	}
+
+	c.retransmits, err = getEnvInt("DHCP_RETRANSMITS", c.retransmits)
+	if err != nil {
+		return fmt.Errorf("unable to parse DHCP_RETRANSMITS: %w", err)
+	}
+
+	if c.retransmits < 0 || c.retransmits > 10 {
+		return fmt.Errorf("DHCP_RETRANSMITS must be between 0 and 10, got %v", c.retransmits)
+	}
+
+	c.retransmitTimeout, err = getEnvDuration("DHCP_RETRANSMIT_TIMEOUT", c.retransmitTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to parse DHCP_RETRANSMIT_TIMEOUT: %w", err)
+	}
+
+	if c.retransmitTimeout < 100*time.Millisecond || c.retransmitTimeout > time.Minute {
+		return fmt.Errorf("DHCP_RETRANSMIT_TIMEOUT must be between 100ms and 1m, got %v", c.retransmitTimeout)
+	}
+
+	logger.Info("Using retransmit settings", "retransmits", c.retransmits, "timeout", c.retransmitTimeout)
+
+	c.receiveBufferSize, err = getEnvInt("RECEIVE_BUFFER_SIZE", c.receiveBufferSize)
+	if err != nil {
+		return fmt.Errorf("unable to parse RECEIVE_BUFFER_SIZE: %w", err)
+	}
+
+	if c.receiveBufferSize != 0 && (c.receiveBufferSize < minReceiveBufferSize || c.receiveBufferSize > maxReceiveBufferSize) {
+		return fmt.Errorf(
+			"RECEIVE_BUFFER_SIZE must be between %d and %d, got %d",
+			minReceiveBufferSize, maxReceiveBufferSize, c.receiveBufferSize,
+		)
+	}
+
+	c.duplicateAckWindow, err = getEnvDuration("DUPLICATE_ACK_WINDOW", c.duplicateAckWindow)
+	if err != nil {
+		return fmt.Errorf("unable to parse DUPLICATE_ACK_WINDOW: %w", err)
+	}
+
+	if c.duplicateAckWindow < 0 || c.duplicateAckWindow > c.retransmitTimeout {
+		return fmt.Errorf("DUPLICATE_ACK_WINDOW must be between 0 and DHCP_RETRANSMIT_TIMEOUT, got %v", c.duplicateAckWindow)
+	}
+
+	if c.duplicateAckWindow > 0 {
+		logger.Info("Watching for duplicate ACKs", "window", c.duplicateAckWindow)
+	}
+
+	c.responseJitterWindow, err = getEnvInt("RESPONSE_JITTER_WINDOW", c.responseJitterWindow)
+	if err != nil {
+		return fmt.Errorf("unable to parse RESPONSE_JITTER_WINDOW: %w", err)
+	}
+
+	if c.responseJitterWindow != 0 && c.responseJitterWindow < 2 {
+		return fmt.Errorf("RESPONSE_JITTER_WINDOW must be at least 2, or 0 to disable it, got %v", c.responseJitterWindow)
+	}
+
+	c.renewJitter, err = getEnvDuration("RENEW_JITTER", c.renewJitter)
+	if err != nil {
+		return fmt.Errorf("unable to parse RENEW_JITTER: %w", err)
+	}
+
+	if c.renewJitter < 0 {
+		return fmt.Errorf("RENEW_JITTER must not be negative, got %v", c.renewJitter)
+	}
+
+	if c.renewJitter > 0 {
+		logger.Info("Jittering renewals", "max_jitter", c.renewJitter)
+	}
+
+	renewScaleFactor, err := getEnvFloat("RENEW_SCALE_FACTOR", 0)
+	if err != nil {
+		return fmt.Errorf("unable to parse RENEW_SCALE_FACTOR: %w", err)
+	}
+
+	if renewScaleFactor < 0 || renewScaleFactor > 1 {
+		return fmt.Errorf("RENEW_SCALE_FACTOR must be between 0 and 1, got %v", renewScaleFactor)
+	}
+
+	renewScaleReference, err := getEnvDuration("RENEW_SCALE_REFERENCE", time.Hour)
+	if err != nil {
+		return fmt.Errorf("unable to parse RENEW_SCALE_REFERENCE: %w", err)
+	}
+
+	if renewScaleReference <= 0 {
+		return fmt.Errorf("RENEW_SCALE_REFERENCE must be positive, got %v", renewScaleReference)
+	}
+
+	if renewScaleFactor > 0 {
+		logger.Info("Scaling renewals to lease time", "factor", renewScaleFactor, "reference", renewScaleReference)
+		c.renewScaling = &renewScaling{factor: renewScaleFactor, reference: renewScaleReference}
+	}
+
+	c.squatMaxNAKs, err = getEnvInt("SQUAT_MAX_NAKS", c.squatMaxNAKs)
+	if err != nil {
+		return fmt.Errorf("unable to parse SQUAT_MAX_NAKS: %w", err)
+	}
+
+	if c.squatMaxNAKs < 0 {
+		return fmt.Errorf("SQUAT_MAX_NAKS must not be negative, got %v", c.squatMaxNAKs)
+	}
+
+	maxMessageSize, err := getEnvInt("MAX_MESSAGE_SIZE", 0)
+	if err != nil {
+		return fmt.Errorf("unable to parse MAX_MESSAGE_SIZE: %w", err)
+	}
+
+	if maxMessageSize != 0 {
+		c.maxMessageSize, err = parseMaxMessageSize(maxMessageSize)
+		if err != nil {
+			return fmt.Errorf("invalid MAX_MESSAGE_SIZE: %w", err)
+		}
+
+		logger.Info("Advertising max message size", "size", c.maxMessageSize)
+		if maxMessageSize > iface.MTU {
+			logger.Warn("MAX_MESSAGE_SIZE is larger than the interface MTU, replies may be fragmented", "size", maxMessageSize, "mtu", iface.MTU)
+		}
+	}
+
+	c.initReboot, err = getEnvBool("INIT_REBOOT")
+	if err != nil {
+		return fmt.Errorf("unable to parse INIT_REBOOT: %w", err)
+	}
+
+	c.breaker, err = getBreakerConfig()
+	if err != nil {
+		return fmt.Errorf("unable to parse circuit breaker config: %w", err)
+	}
+
+	if c.breaker.threshold > 0 {
+		logger.Info(
+			"Circuit breaker enabled",
+			"threshold", c.breaker.threshold, "window", c.breaker.window, "cooldown", c.breaker.cooldown,
+		)
+	}
+
+	if delayStr := os.Getenv("NAK_RETRY_DELAY"); delayStr != "" {
+		delay, err := time.ParseDuration(delayStr)
+		if err != nil {
+			return fmt.Errorf("unable to parse NAK_RETRY_DELAY: %w", err)
+		}
+
+		if delay < 0 {
+			return fmt.Errorf("NAK_RETRY_DELAY must not be negative, got %v", delay)
+		}
+
+		c.nakRetryDelay = &delay
+		logger.Info("Delaying discovery after a NAK", "delay", delay)
+	}
+
+	if seedStr := os.Getenv("XID_SEED"); seedStr != "" {
+		seed, err := strconv.ParseUint(seedStr, 0, 32)
+		if err != nil {
+			return fmt.Errorf("XID_SEED %q is not a valid 32 bit integer: %w", seedStr, err)
+		}
+
+		xidSeed := uint32(seed)
+		c.xidSeed = &xidSeed
+		logger.Warn(
+			"Using predictable transaction IDs, servers may confuse clients that reuse them across runs",
+			"seed", seedStr,
+		)
+	}
+
+	c.pingGateway, err = getEnvBool("GATEWAY_PING")
+	if err != nil {
+		return fmt.Errorf("unable to parse GATEWAY_PING: %w", err)
+	}
+
+	c.gatewayPingTimeout, err = getEnvDuration("GATEWAY_PING_TIMEOUT", c.gatewayPingTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to parse GATEWAY_PING_TIMEOUT: %w", err)
+	}
+
+	if c.gatewayPingTimeout <= 0 {
+		return fmt.Errorf("GATEWAY_PING_TIMEOUT must be positive, got %v", c.gatewayPingTimeout)
+	}
+
+	if c.pingGateway {
+		logger.Info("Pinging the gateway of every bound lease", "timeout", c.gatewayPingTimeout)
+	}
+
+	c.vrf = os.Getenv("VRF")
+	if c.vrf != "" {
+		if err := checkVRF(c.vrf); err != nil {
+			return fmt.Errorf("unable to use VRF %s: %w", c.vrf, err)
+		}
+
+		if vrf := interfaceVRF(iface.Name); vrf != c.vrf {
+			logger.Warn("Interface doesn't belong to VRF, routed traffic may leave through another interface", "iface", iface.Name, "vrf", c.vrf)
+		}
+		logger.Info("Binding routed sockets to VRF", "vrf", c.vrf)
+	}
+
+	c.gratuitousARP, err = getEnvBool("GRATUITOUS_ARP")
+	if err != nil {
+		return fmt.Errorf("unable to parse GRATUITOUS_ARP: %w", err)
+	}
+
+	c.arpDefendTimeout, err = getEnvDuration("ARP_DEFEND_TIMEOUT", c.arpDefendTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to parse ARP_DEFEND_TIMEOUT: %w", err)
+	}
+
+	if c.arpDefendTimeout <= 0 {
+		return fmt.Errorf("ARP_DEFEND_TIMEOUT must be positive, got %v", c.arpDefendTimeout)
+	}
+
+	if c.gratuitousARP {
+		logger.Info("Announcing every acquired address with a gratuitous ARP", "timeout", c.arpDefendTimeout)
+	}
+
+	c.arpRecheckInterval, err = getEnvDuration("ARP_RECHECK_INTERVAL", c.arpRecheckInterval)
+	if err != nil {
+		return fmt.Errorf("unable to parse ARP_RECHECK_INTERVAL: %w", err)
+	}
+
+	if c.arpRecheckInterval < 0 || (c.arpRecheckInterval > 0 && c.arpRecheckInterval <= c.arpDefendTimeout) {
+		return fmt.Errorf("ARP_RECHECK_INTERVAL must be longer than ARP_DEFEND_TIMEOUT of %v, got %v", c.arpDefendTimeout, c.arpRecheckInterval)
+	}
+
+	if c.arpRecheckInterval > 0 {
+		logger.Info("Probing for every held address periodically", "interval", c.arpRecheckInterval, "timeout", c.arpDefendTimeout)
+	}
+
+	s.maxConcurrentStart, err = getEnvInt("MAX_CONCURRENT_START", s.maxConcurrentStart)
+	if err != nil {
+		return fmt.Errorf("unable to parse MAX_CONCURRENT_START: %w", err)
+	}
+
+	if s.maxConcurrentStart < 0 {
+		return fmt.Errorf("MAX_CONCURRENT_START must not be negative, got %v", s.maxConcurrentStart)
+	}
+
+	if s.maxConcurrentStart > 0 {
+		logger.Info("Limiting targets starting at once", "max", s.maxConcurrentStart)
+	}
+
+	c.startupStagger, err = getEnvDuration("STARTUP_STAGGER", c.startupStagger)
+	if err != nil {
+		return fmt.Errorf("unable to parse STARTUP_STAGGER: %w", err)
+	}
+
+	if c.startupStagger < 0 {
+		return fmt.Errorf("STARTUP_STAGGER must not be negative, got %v", c.startupStagger)
+	}
+
+	c.retryBackoffBase, err = getEnvDuration("RETRY_BACKOFF_BASE", c.retryBackoffBase)
+	if err != nil {
+		return fmt.Errorf("unable to parse RETRY_BACKOFF_BASE: %w", err)
+	}
+
+	c.retryBackoffMax, err = getEnvDuration("RETRY_BACKOFF_MAX", c.retryBackoffMax)
+	if err != nil {
+		return fmt.Errorf("unable to parse RETRY_BACKOFF_MAX: %w", err)
+	}
+
+	if c.retryBackoffBase < 0 || c.retryBackoffMax < c.retryBackoffBase {
+		return fmt.Errorf(
+			"RETRY_BACKOFF_BASE must not be negative nor more than RETRY_BACKOFF_MAX, got %v and %v",
+			c.retryBackoffBase, c.retryBackoffMax,
+		)
+	}
+
+	if c.retryBackoffBase > 0 {
+		logger.Info("Backing off after failures", "base", c.retryBackoffBase, "max", c.retryBackoffMax)
+	}
+
+	s.maxPPS, err = getEnvInt("MAX_PPS", s.maxPPS)
+	if err != nil {
+		return fmt.Errorf("unable to parse MAX_PPS: %w", err)
+	}
+
+	if s.maxPPS < 0 {
+		return fmt.Errorf("MAX_PPS must not be negative, got %v", s.maxPPS)
+	}
+
+	if s.maxPPS > 0 {
+		logger.Info("Limiting packets sent per second", "max", s.maxPPS)
+	}
+
+	s.resetOnLinkUp, err = getEnvBool("RESET_BACKOFF_ON_LINK_UP")
+	if err != nil {
+		return fmt.Errorf("unable to parse RESET_BACKOFF_ON_LINK_UP: %w", err)
+	}
+
+	s.eventsBufferSize, err = getEnvInt("EVENTS_BUFFER_SIZE", s.eventsBufferSize)
+	if err != nil {
+		return fmt.Errorf("unable to parse EVENTS_BUFFER_SIZE: %w", err)
+	}
+
+	if s.eventsBufferSize < 0 {
+		return fmt.Errorf("EVENTS_BUFFER_SIZE must not be negative, got %v", s.eventsBufferSize)
+	}
+
+	s.maxHistoryBytes, err = getEnvInt("MAX_HISTORY_BYTES", s.maxHistoryBytes)
+	if err != nil {
+		return fmt.Errorf("unable to parse MAX_HISTORY_BYTES: %w", err)
+	}
+
+	if s.maxHistoryBytes < 0 {
+		return fmt.Errorf("MAX_HISTORY_BYTES must not be negative, got %v", s.maxHistoryBytes)
+	}
+
+	if s.natsURL = os.Getenv("NATS_URL"); s.natsURL != "" {
+		if subject := os.Getenv("NATS_SUBJECT"); subject != "" {
+			s.natsSubject = subject
+		}
+		if s.natsCredsFile = os.Getenv("NATS_CREDS"); s.natsCredsFile != "" {
+			if _, err := os.Stat(s.natsCredsFile); err != nil {
+				return fmt.Errorf("invalid NATS_CREDS: %w", err)
+			}
+		}
+
+		s.natsBufferSize, err = getEnvInt("NATS_BUFFER_SIZE", s.natsBufferSize)
+		if err != nil {
+			return fmt.Errorf("unable to parse NATS_BUFFER_SIZE: %w", err)
+		}
+
+		if err := checkNATSPublisher(s.natsURL, s.natsSubject, s.natsBufferSize); err != nil {
+			return fmt.Errorf("invalid NATS_URL, NATS_SUBJECT or NATS_BUFFER_SIZE: %w", err)
+		}
+		logger.Info("Publishing lease events to NATS", "subject", s.natsSubject, "buffer", s.natsBufferSize)
+	}
+
+	c.applyMTU, err = getEnvBool("APPLY_MTU")
+	if err != nil {
+		return fmt.Errorf("unable to parse APPLY_MTU: %w", err)
+	}
+
+	if c.applyMTU {
+		logger.Warn("Applying the mtu handed out by servers to the interface", "iface", iface.Name)
+	}
+
+	c.requestBootOptions, err = getEnvBool("REQUEST_BOOT_OPTIONS")
+	if err != nil {
+		return fmt.Errorf("unable to parse REQUEST_BOOT_OPTIONS: %w", err)
+	}
+
+	c.requestDomainSearch, err = getEnvBool("REQUEST_DOMAIN_SEARCH")
+	if err != nil {
+		return fmt.Errorf("unable to parse REQUEST_DOMAIN_SEARCH: %w", err)
+	}
+
+	// Both add to the default params, which aren't requested at all with
+	// NO_DEFAULT_PARAMS.
+	if c.noDefaultParams && (c.requestBootOptions || c.requestDomainSearch) {
+		return errors.New("NO_DEFAULT_PARAMS can't be set along with REQUEST_BOOT_OPTIONS or REQUEST_DOMAIN_SEARCH")
+	}
+
+	c.trackOptionCodes, err = getEnvBool("TRACK_OPTION_CODES")
+	if err != nil {
+		return fmt.Errorf("unable to parse TRACK_OPTION_CODES: %w", err)
+	}
+
+	c.logDORA, err = getEnvBool("LOG_DORA")
+	if err != nil {
+		return fmt.Errorf("unable to parse LOG_DORA: %w", err)
+	}
+
+	s.stress, err = getStressConfig()
+	if err != nil {
+		return fmt.Errorf("unable to parse stress mode config: %w", err)
+	}
+
+	s.poolEstimate, err = getPoolEstimateConfig()
+	if err != nil {
+		return fmt.Errorf("unable to parse pool estimate mode config: %w", err)
+	}
+
+	s.anonymous, err = getAnonymousConfig()
+	if err != nil {
+		return fmt.Errorf("unable to parse anonymous client config: %w", err)
+	}
+
+	// Anonymous clients alone are enough to run, without any target.
+	var targets []targetConfig
+	if s.anonymous.clients == 0 || os.Getenv("CONFIG_FILE") != "" || os.Getenv("TARGET_ADDRS") != "" {
+		targets, err = loadTargets(logger)
+		if err != nil {
+			return fmt.Errorf("invalid target configuration: %w", err)
+		}
+	}
+
+	if err := checkNoDefaultParams(targets, c.noDefaultParams); err != nil {
+		return fmt.Errorf("invalid target configuration: %w", err)
+	}
+
+	s.strictSubnet, err = getEnvBool("STRICT_SUBNET")
+	if err != nil {
+		return fmt.Errorf("unable to parse STRICT_SUBNET: %w", err)
+	}
+
+	if err := checkTargetSubnets(logger, iface, targets, s.strictSubnet); err != nil {
+		return fmt.Errorf("invalid target configuration: %w", err)
+	}
+
+	logger.Debug("Pulled list of targets", "targets", len(targets))
+
+	s.leaseStateFile = os.Getenv("LEASE_STATE_FILE")
+
+	// Leases saved to be renewed by the next run mustn't be given back, so
+	// they are only released by default when they aren't saved.
+	c.releaseOnExit = s.leaseStateFile == ""
+	if os.Getenv("RELEASE_ON_EXIT") != "" {
+		c.releaseOnExit, err = getEnvBool("RELEASE_ON_EXIT")
+		if err != nil {
+			return fmt.Errorf("unable to parse RELEASE_ON_EXIT: %w", err)
+		}
+		// Leases imported from OS_LEASE_FILES are the host's, so they are
+		// only released when asked to explicitly.
+		c.releaseImportedOnExit = c.releaseOnExit
+	}
+
+	if c.releaseOnExit && s.leaseStateFile != "" {
+		logger.Warn("RELEASE_ON_EXIT releases the leases saved to LEASE_STATE_FILE, which the next run will find released")
+	}
+
+	if s.leaseStateFile != "" {
+		restored, err := loadLeaseState(s.leaseStateFile, time.Now())
+		if err != nil {
+			logger.Warn("Unable to load saved leases, requesting new ones", "path", s.leaseStateFile, "err", err)
+		} else {
+			logger.Info("Loaded saved leases", "path", s.leaseStateFile, "leases", len(restored))
+		}
+
+		for i := range targets {
+			if lease, ok := restored[targets[i].addr]; ok {
+				targets[i].restoredLease = &lease
+			}
+		}
+	}
+
+	// Leases saved by a previous run take precedence, as they are our own.
+	if osLeaseFiles := os.Getenv("OS_LEASE_FILES"); osLeaseFiles != "" {
+		for _, path := range strings.Split(osLeaseFiles, ",") {
+			imported, err := readOSLeaseFile(path, time.Now())
+			if err != nil {
+				logger.Warn("Unable to read OS lease file, ignoring it", "path", path, "err", err)
+				continue
+			}
+			logger.Debug("Read OS lease file", "path", path, "leases", len(imported))
+
+			for i := range targets {
+				lease, ok := imported[targets[i].addr]
+				if !ok || (targets[i].restoredLease != nil && !targets[i].leaseImported) {
+					continue
+				}
+				logger.Info("Importing lease held by the host", "target", targets[i].addr, "path", path, "server", lease.ServerID)
+				targets[i].restoredLease = &lease
+				targets[i].leaseImported = true
+			}
+		}
+	}
+
+	watchConfig, err := getEnvBool("WATCH_CONFIG")
+	if err != nil {
+		return fmt.Errorf("unable to parse WATCH_CONFIG: %w", err)
+	}
+
+	if watchConfig {
+		s.watchConfigFile = os.Getenv("CONFIG_FILE")
+		if s.watchConfigFile == "" {
+			return errors.New("WATCH_CONFIG requires CONFIG_FILE to be set")
+		}
+	}
+
+	s.shutdownTimeout, err = getEnvDuration("SHUTDOWN_TIMEOUT", s.shutdownTimeout)
+	if err != nil {
+		return fmt.Errorf("unable to parse SHUTDOWN_TIMEOUT: %w", err)
+	}
+
+	if s.shutdownTimeout <= 0 {
+		return fmt.Errorf("SHUTDOWN_TIMEOUT must be positive, got %v", s.shutdownTimeout)
+	}
+
+	s.gracePeriod, err = getEnvDuration("STARTUP_GRACE_PERIOD", s.gracePeriod)
+	if err != nil {
+		return fmt.Errorf("unable to parse STARTUP_GRACE_PERIOD: %w", err)
+	}
+
+	if s.gracePeriod <= 0 {
+		return fmt.Errorf("STARTUP_GRACE_PERIOD must be positive, got %v", s.gracePeriod)
+	}
+
+	s.failFast, err = getEnvBool("FAIL_FAST")
+	if err != nil {
+		return fmt.Errorf("unable to parse FAIL_FAST: %w", err)
+	}
+
+	s.runDuration, err = getEnvDuration("RUN_DURATION", s.runDuration)
+	if err != nil {
+		return fmt.Errorf("unable to parse RUN_DURATION: %w", err)
+	}
+
+	if s.runDuration < 0 {
+		return fmt.Errorf("RUN_DURATION must not be negative, got %v", s.runDuration)
+	}
+
+	s.resultsFile = os.Getenv("RESULTS_FILE")
+	if s.resultsFile != "" {
+		logger.Info("Writing results on exit", "output", s.resultsFile)
+	}
+	s.snapshotFile = os.Getenv("METRICS_SNAPSHOT_FILE")
+
+	disableServer, err := getEnvBool("DISABLE_METRICS_SERVER")
+	if err != nil {
+		return fmt.Errorf("unable to parse DISABLE_METRICS_SERVER: %w", err)
+	}
+
+	if disableServer {
+		cfg.MetricsAddr = ""
+	} else {
+		// An address from CONFIG_FILE is already set.
+		if cfg.MetricsAddr == "" {
+			cfg.MetricsAddr = os.Getenv("METRICS_ADDR")
+		}
+		if cfg.MetricsAddr == "" {
+			cfg.MetricsAddr = defaultMetricsAddr
+		}
+		if err := checkMetricsAddr(cfg.MetricsAddr); err != nil {
+			return fmt.Errorf("invalid METRICS_ADDR: %w", err)
+		}
+	}
+	s.reacquireToken = os.Getenv("REACQUIRE_TOKEN")
+
+	s.influxOutput = os.Getenv("INFLUX_OUTPUT")
+	if s.influxOutput != "" {
+		if s.influxOutput != "stdout" {
+			if u, err := url.Parse(s.influxOutput); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				return fmt.Errorf("INFLUX_OUTPUT must be stdout or an http(s) URL, got %v", s.influxOutput)
+			}
+		}
+
+		s.influxInterval, err = getEnvDuration("INFLUX_INTERVAL", s.influxInterval)
+		if err != nil {
+			return fmt.Errorf("unable to parse INFLUX_INTERVAL: %w", err)
+		}
+
+		if s.influxInterval <= 0 {
+			return fmt.Errorf("INFLUX_INTERVAL must be positive, got %v", s.influxInterval)
+		}
+	}
+
+	s.scrapeCacheTTL, err = getEnvDuration("METRICS_CACHE_TTL", s.scrapeCacheTTL)
+	if err != nil {
+		return fmt.Errorf("unable to parse METRICS_CACHE_TTL: %w", err)
+	}
+
+	if s.scrapeCacheTTL < 0 {
+		return fmt.Errorf("METRICS_CACHE_TTL must not be negative, got %v", s.scrapeCacheTTL)
+	}
+
+	s.metricsPerInterface, err = getEnvBool("METRICS_PER_INTERFACE")
+	if err != nil {
+		return fmt.Errorf("unable to parse METRICS_PER_INTERFACE: %w", err)
+	}
+
+	cfg.Targets = make([]Target, 0, len(targets))
+	for _, target := range targets {
+		cfg.Targets = append(cfg.Targets, Target{Addr: target.addr, target: target})
+	}
+	cfg.settings = &s
+
+	return nil
+}
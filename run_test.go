@@ -0,0 +1,368 @@
+package greedydhcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// gatheredTargets returns the targets of the series of name gathered from
+// registry.
+func gatheredTargets(t *testing.T, registry *prometheus.Registry, name string) map[string]float64 {
+	t.Helper()
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("unable to gather metrics: %v", err)
+	}
+
+	targets := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, pair := range m.GetLabel() {
+				if pair.GetName() == "ip" {
+					targets[pair.GetValue()] = m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+
+	return targets
+}
+
+// gatheredGauge returns the value of the gauge of name without labels
+// gathered from registry, and whether it was gathered.
+func gatheredGauge(t *testing.T, registry *prometheus.Registry, name string) (float64, bool) {
+	t.Helper()
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("unable to gather metrics: %v", err)
+	}
+
+	for _, family := range families {
+		if family.GetName() == name && len(family.GetMetric()) == 1 {
+			return family.GetMetric()[0].GetGauge().GetValue(), true
+		}
+	}
+
+	return 0, false
+}
+
+func TestDumpState(t *testing.T) {
+	m := newMetrics(false, nil)
+	m.dhcpAcquiredLeasesTotal.WithLabelValues("10.0.0.1").Add(3)
+	m.dhcpCircuitBreakerState.WithLabelValues("10.0.0.1", breakerOpen.String()).Set(1)
+	leases := newLeaseRegistry(m.dhcpDuplicateBoundAddresses)
+	leases.set("10.0.0.1", &dhclient.Lease{FixedAddress: net.ParseIP("10.0.0.1").To4()})
+
+	var logs bytes.Buffer
+	dumpState(slog.New(slog.NewTextHandler(&logs, nil)), m, leases, []string{"10.0.0.1", "10.0.0.2"})
+
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected a summary and a line per target, got %q", logs.String())
+	}
+	for _, want := range []string{"target=10.0.0.1", "acquired=3", "breaker=open", "held=true"} {
+		if !strings.Contains(lines[1], want) {
+			t.Errorf("expected %s in %q", want, lines[1])
+		}
+	}
+	if !strings.Contains(lines[2], "acquired=0") || !strings.Contains(lines[2], "held=false") {
+		t.Errorf("expected a target without series to read as zero, got %q", lines[2])
+	}
+
+	// The series of a target evicted by the LRU stay deleted.
+	if hasSeries(t, m.dhcpAcquiredLeasesTotal, map[string]string{"ip": "10.0.0.2"}) {
+		t.Error("expected dumping not to create series")
+	}
+}
+
+func TestRunDumpsStateOnRequest(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	target := "10.100.0.96"
+	registry := prometheus.NewRegistry()
+	dump := make(chan struct{})
+	logs := &logBuffer{}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- Run(ctx, Config{
+			Interface: iface,
+			Targets:   []Target{{Addr: target}},
+			Logger:    logs.logger(),
+			Registry:  registry,
+			DumpState: dump,
+		})
+	}()
+
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return gatheredTargets(t, registry, "dhcp_acquired_leases_total")[target] >= 1
+	})
+
+	// Dumping is done by Run's own loop, and doesn't stop it.
+	dump <- struct{}{}
+	waitFor(t, 5*time.Second, "state to be dumped", func() bool {
+		for _, line := range strings.Split(logs.String(), "\n") {
+			if strings.Contains(line, `msg="Target state" target=`+target) && strings.Contains(line, "held=true") {
+				return true
+			}
+		}
+		return false
+	})
+	select {
+	case err := <-errs:
+		t.Fatalf("expected Run to keep running after dumping, got %v", err)
+	default:
+	}
+
+	cancel()
+	if err := <-errs; err != nil {
+		t.Errorf("expected Run to stop cleanly, got %v", err)
+	}
+}
+
+func TestSettingsFromEnvNoDefaultParams(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+	}{
+		{name: "target params", env: map[string]string{"TARGET_PARAMS": "10.0.0.1=3;1"}},
+		{name: "boot options", env: map[string]string{"REQUEST_BOOT_OPTIONS": "1"}},
+		{name: "domain search", env: map[string]string{"REQUEST_DOMAIN_SEARCH": "1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CONFIG_FILE", "")
+			t.Setenv("TARGET_ADDRS", "10.0.0.1")
+			t.Setenv("NO_DEFAULT_PARAMS", "1")
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			err := settingsFromEnv(testLogger(t), loopbackInterface(t), &Config{})
+			if err == nil || !strings.Contains(err.Error(), "NO_DEFAULT_PARAMS") {
+				t.Errorf("expected NO_DEFAULT_PARAMS to be rejected, got %v", err)
+			}
+		})
+	}
+}
+
+func TestSettingsFromEnvDSCP(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("TARGET_ADDRS", "10.0.0.1")
+
+	for value, want := range map[string]uint8{"": 0, "46": 46, "63": 63} {
+		t.Setenv("DHCP_DSCP", value)
+		cfg := &Config{}
+		if err := settingsFromEnv(testLogger(t), loopbackInterface(t), cfg); err != nil {
+			t.Fatalf("DHCP_DSCP=%q: unexpected error: %v", value, err)
+		}
+		if got := cfg.settings.client.dscp; got != want {
+			t.Errorf("DHCP_DSCP=%q: expected %d, got %d", value, want, got)
+		}
+	}
+
+	for _, value := range []string{"-1", "64", "ef"} {
+		t.Setenv("DHCP_DSCP", value)
+		if err := settingsFromEnv(testLogger(t), loopbackInterface(t), &Config{}); err == nil {
+			t.Errorf("expected an error for DHCP_DSCP=%q", value)
+		}
+	}
+}
+
+func TestRunProbersHaveMetricsOfTheirOwn(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	targets := []string{"10.100.0.84", "10.100.0.85"}
+	registries := make([]*prometheus.Registry, len(targets))
+	errs := make(chan error, len(targets))
+	for i, target := range targets {
+		registries[i] = prometheus.NewRegistry()
+		go func(cfg Config) {
+			errs <- Run(ctx, cfg)
+		}(Config{
+			Interface: iface,
+			Targets:   []Target{{Addr: target}},
+			Logger:    testLogger(t),
+			Registry:  registries[i],
+		})
+	}
+
+	for i, target := range targets {
+		waitFor(t, 10*time.Second, "lease to be acquired for "+target, func() bool {
+			return gatheredTargets(t, registries[i], "dhcp_acquired_leases_total")[target] >= 1
+		})
+	}
+
+	for i, target := range targets {
+		got := gatheredTargets(t, registries[i], "dhcp_acquired_leases_total")
+		if len(got) != 1 {
+			t.Errorf("expected the registry of %s to only have its own series, got %v", target, got)
+		}
+	}
+
+	cancel()
+	for range targets {
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Errorf("expected Run to stop cleanly, got %v", err)
+			}
+		case <-time.After(15 * time.Second):
+			t.Fatal("timed out waiting for Run to return")
+		}
+	}
+}
+
+func TestRunSetsStartTime(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	registry := prometheus.NewRegistry()
+	before := time.Now()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- Run(ctx, Config{
+			Interface: iface,
+			Targets:   []Target{{Addr: "10.100.0.99"}},
+			Logger:    testLogger(t),
+			Registry:  registry,
+		})
+	}()
+
+	var started float64
+	waitFor(t, 10*time.Second, "start time to be exported", func() bool {
+		var ok bool
+		started, ok = gatheredGauge(t, registry, "greedydhcp_start_time_seconds")
+		return ok && started != 0
+	})
+	if started < float64(before.Unix()) || started > float64(time.Now().Unix()+1) {
+		t.Errorf("expected a start time between %v and now, got %v", before.Unix(), started)
+	}
+
+	cancel()
+	if err := <-errs; err != nil {
+		t.Errorf("expected Run to stop cleanly, got %v", err)
+	}
+}
+
+func TestRunWithoutMetricsServer(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	// A port nothing else listens on, which the disabled server mustn't
+	// take either.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	target := "10.100.0.100"
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("TARGET_ADDRS", target)
+	t.Setenv("METRICS_ADDR", addr)
+	t.Setenv("DISABLE_METRICS_SERVER", "1")
+	logs := &logBuffer{}
+	cfg := Config{Interface: iface, Logger: logs.logger(), Registry: prometheus.NewRegistry()}
+	if err := settingsFromEnv(cfg.Logger, iface, &cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.MetricsAddr != "" {
+		t.Fatalf("expected no metrics address, got %q", cfg.MetricsAddr)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- Run(ctx, cfg)
+	}()
+
+	// Metrics are still collected.
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return gatheredTargets(t, cfg.Registry, "dhcp_acquired_leases_total")[target] >= 1
+	})
+	if !strings.Contains(logs.String(), `msg="Metrics server disabled, metrics are collected but not served"`) {
+		t.Error("expected the metrics server to be logged as disabled")
+	}
+	if conn, err := net.Dial("tcp", addr); err == nil {
+		conn.Close()
+		t.Errorf("expected nothing to listen on %s", addr)
+	}
+
+	cancel()
+	if err := <-errs; err != nil {
+		t.Errorf("expected Run to stop cleanly, got %v", err)
+	}
+}
+
+func TestRunReturnsShutdownTimeout(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+	srv.setSilent(true)
+
+	// The client waits out its retransmit timeout for an answer before it
+	// stops, which is longer than the shutdown timeout.
+	s := defaultSettings()
+	s.shutdownTimeout = 50 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- Run(ctx, Config{
+			Interface: iface,
+			Targets:   []Target{{Addr: "10.100.0.88"}},
+			Logger:    slog.New(slog.NewTextHandler(io.Discard, nil)),
+			Registry:  prometheus.NewRegistry(),
+			settings:  &s,
+		})
+	}()
+
+	waitFor(t, 10*time.Second, "DISCOVER to be sent", func() bool {
+		discovers, _ := srv.counts()
+		return discovers >= 1
+	})
+	cancel()
+
+	if err := <-errs; !errors.Is(err, ErrShutdownTimeout) {
+		t.Errorf("expected ErrShutdownTimeout, got %v", err)
+	}
+}
+
+func TestRunRejectsInvalidTarget(t *testing.T) {
+	err := Run(context.Background(), Config{
+		Interface: loopbackInterface(t),
+		Targets:   []Target{{Addr: "not-an-ip"}},
+		Logger:    testLogger(t),
+		Registry:  prometheus.NewRegistry(),
+	})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig, got %v", err)
+	}
+}
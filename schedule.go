@@ -0,0 +1,328 @@
+package greedydhcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scheduleCheckInterval is how often the schedule of a target is checked
+// against the wall clock.
+const scheduleCheckInterval = time.Second
+
+// weekdayNames are the names of the days of a schedule window, indexed by
+// time.Weekday.
+var weekdayNames = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// scheduleWindow is a time of day a target runs during, on some days of the
+// week, in local time.
+type scheduleWindow struct {
+	// days has bit d set for each time.Weekday d the window starts on.
+	days uint8
+	// start and end are the times of day the window starts and ends at. A
+	// window ending no later than it starts ends the next day.
+	start, end time.Duration
+}
+
+// schedule is the windows a target runs during. A target without any runs
+// all the time.
+type schedule []scheduleWindow
+
+// parseSchedule parses windows separated by ';', each a start and end time
+// of day such as "08:00-17:00", optionally preceded by the day or range of
+// days it starts on, such as "Mon-Fri 08:00-17:00" or "Sat 22:00-02:00".
+func parseSchedule(s string) (schedule, error) {
+	var sched schedule
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		window := scheduleWindow{days: 0x7f}
+		times := entry
+		if days, rest, ok := strings.Cut(entry, " "); ok {
+			var err error
+			window.days, err = parseWeekdays(days)
+			if err != nil {
+				return nil, fmt.Errorf("window %q: %w", entry, err)
+			}
+			times = strings.TrimSpace(rest)
+		}
+
+		start, end, ok := strings.Cut(times, "-")
+		if !ok {
+			return nil, fmt.Errorf("window %q is not of the form HH:MM-HH:MM", entry)
+		}
+		var err error
+		if window.start, err = parseTimeOfDay(start); err != nil {
+			return nil, fmt.Errorf("window %q: %w", entry, err)
+		}
+		if window.end, err = parseTimeOfDay(end); err != nil {
+			return nil, fmt.Errorf("window %q: %w", entry, err)
+		}
+		if window.start == window.end {
+			return nil, fmt.Errorf("window %q is empty", entry)
+		}
+
+		sched = append(sched, window)
+	}
+
+	if len(sched) == 0 {
+		return nil, errors.New("schedule has no windows")
+	}
+
+	return sched, nil
+}
+
+// parseWeekdays parses a day, such as "Mon", or a range of days, such as
+// "Mon-Fri" or "Fri-Mon", into a bitmask of time.Weekday values.
+func parseWeekdays(s string) (uint8, error) {
+	first, last, isRange := strings.Cut(s, "-")
+	from, err := parseWeekday(first)
+	if err != nil {
+		return 0, err
+	}
+	to := from
+	if isRange {
+		if to, err = parseWeekday(last); err != nil {
+			return 0, err
+		}
+	}
+
+	var days uint8
+	for d := from; ; d = (d + 1) % 7 {
+		days |= 1 << d
+		if d == to {
+			return days, nil
+		}
+	}
+}
+
+func parseWeekday(s string) (int, error) {
+	for d, name := range weekdayNames {
+		if strings.EqualFold(s, name) {
+			return d, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown day %q, must be one of %s", s, strings.Join(weekdayNames, ", "))
+}
+
+// parseTimeOfDay parses a time of day of the form HH:MM, from 00:00 to
+// 24:00.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	var hours, minutes int
+	if n, err := fmt.Sscanf(s, "%d:%d", &hours, &minutes); err != nil || n != 2 || len(s) != 5 {
+		return 0, fmt.Errorf("invalid time of day %q, must be HH:MM", s)
+	}
+	if hours < 0 || minutes < 0 || minutes > 59 || hours > 24 || hours == 24 && minutes != 0 {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+// active reports whether t falls in any of the windows, a window starting on
+// one day possibly ending on the next.
+func (s schedule) active(t time.Time) bool {
+	for _, window := range s {
+		for _, back := range []int{0, 1} {
+			day := time.Date(t.Year(), t.Month(), t.Day()-back, 0, 0, 0, 0, t.Location())
+			if window.days&(1<<day.Weekday()) == 0 {
+				continue
+			}
+			// Adding clock times to the wall clock of the day, rather than
+			// durations to its midnight, keeps windows in step across
+			// daylight saving changes.
+			start := atTimeOfDay(day, window.start)
+			end := atTimeOfDay(day, window.end)
+			if window.end <= window.start {
+				end = atTimeOfDay(day.AddDate(0, 0, 1), window.end)
+			}
+			if !t.Before(start) && t.Before(end) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// atTimeOfDay returns the time of day d on the day of midnight.
+func atTimeOfDay(midnight time.Time, d time.Duration) time.Time {
+	return time.Date(midnight.Year(), midnight.Month(), midnight.Day(), 0, int(d/time.Minute), 0, 0, midnight.Location())
+}
+
+// String formats the schedule as parseSchedule reads it.
+func (s schedule) String() string {
+	windows := make([]string, 0, len(s))
+	for _, window := range s {
+		var b strings.Builder
+		if window.days != 0x7f {
+			b.WriteString(formatWeekdays(window.days))
+			b.WriteByte(' ')
+		}
+		fmt.Fprintf(&b, "%02d:%02d-%02d:%02d",
+			int(window.start/time.Hour), int(window.start%time.Hour/time.Minute),
+			int(window.end/time.Hour), int(window.end%time.Hour/time.Minute))
+		windows = append(windows, b.String())
+	}
+
+	return strings.Join(windows, ";")
+}
+
+// formatWeekdays formats a bitmask of days as the range parseWeekdays read
+// it from.
+func formatWeekdays(days uint8) string {
+	// The range starts at the first day whose previous day isn't in it.
+	for from := 0; from < 7; from++ {
+		if days&(1<<from) == 0 || days&(1<<((from+6)%7)) != 0 {
+			continue
+		}
+		to := from
+		for days&(1<<((to+1)%7)) != 0 {
+			to = (to + 1) % 7
+		}
+		if to == from {
+			return weekdayNames[from]
+		}
+		return weekdayNames[from] + "-" + weekdayNames[to]
+	}
+
+	return ""
+}
+
+// scheduleGate tells a client when its schedule opens or closes.
+type scheduleGate struct {
+	*broadcast
+
+	sched schedule
+	// onChange is called with whether the gate is open each time that
+	// changes, and once when it is created.
+	onChange func(open bool)
+
+	mu   sync.Mutex
+	open bool
+}
+
+// startScheduleGate returns the gate for the schedule of targetAddr, checking
+// it until ctx is done and exporting whether it is open in m, or nil if sched
+// is empty.
+func startScheduleGate(ctx context.Context, logger *slog.Logger, m *metrics, targetAddr string, sched schedule) *scheduleGate {
+	inSchedule := m.dhcpTargetInSchedule.WithLabelValues(targetAddr)
+	inSchedule.Set(1)
+	g := newScheduleGate(sched, time.Now(), func(open bool) {
+		if open {
+			inSchedule.Set(1)
+		} else {
+			inSchedule.Set(0)
+		}
+	})
+	if g != nil {
+		logger.Info("Running on a schedule", "schedule", sched)
+		go g.run(ctx, scheduleCheckInterval)
+	}
+
+	return g
+}
+
+// newScheduleGate returns a gate for sched, open if now is in one of its
+// windows, or nil, which is always open, if sched is empty.
+func newScheduleGate(sched schedule, now time.Time, onChange func(open bool)) *scheduleGate {
+	if len(sched) == 0 {
+		return nil
+	}
+
+	g := &scheduleGate{broadcast: newBroadcast(), sched: sched, onChange: onChange, open: sched.active(now)}
+	onChange(g.open)
+
+	return g
+}
+
+// isOpen reports whether the client may run.
+func (g *scheduleGate) isOpen() bool {
+	if g == nil {
+		return true
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.open
+}
+
+// wait returns a channel closed the next time the gate opens or closes. A
+// nil gate returns a nil channel, which never is.
+func (g *scheduleGate) wait() <-chan struct{} {
+	if g == nil {
+		return nil
+	}
+
+	return g.broadcast.wait()
+}
+
+// waitOpen waits until the gate is open or ctx is done, returning a channel
+// closed the next time the gate changes after that, and whether it had to
+// wait.
+func (g *scheduleGate) waitOpen(ctx context.Context, logger *slog.Logger) (changed <-chan struct{}, waited bool) {
+	// Take the channel before checking, so as not to miss the gate opening
+	// in between.
+	changed = g.wait()
+	if g.isOpen() {
+		return changed, false
+	}
+
+	logger.Info("Outside of schedule, waiting for the next window", "schedule", g.sched)
+	for !g.isOpen() {
+		select {
+		case <-ctx.Done():
+			return nil, true
+		case <-changed:
+			changed = g.wait()
+		}
+	}
+	logger.Info("Schedule window started, starting client")
+
+	return changed, true
+}
+
+// observe opens or closes the gate for the time now, waking the waiting
+// client if that changed it.
+func (g *scheduleGate) observe(now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	open := g.sched.active(now)
+	if open == g.open {
+		return
+	}
+
+	g.open = open
+	g.onChange(open)
+	g.notify()
+}
+
+// run checks the schedule against the wall clock every interval until ctx
+// is done.
+func (g *scheduleGate) run(ctx context.Context, interval time.Duration) {
+	if g == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			g.observe(now)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package greedydhcp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    string
+		wantErr string
+	}{
+		{in: "08:00-17:00", want: "08:00-17:00"},
+		{in: "mon-fri 08:00-17:00; Sat 22:00-02:00", want: "Mon-Fri 08:00-17:00;Sat 22:00-02:00"},
+		{in: "Fri-Mon 00:00-24:00", want: "Fri-Mon 00:00-24:00"},
+		{in: "", wantErr: "no windows"},
+		{in: "08:00", wantErr: "HH:MM-HH:MM"},
+		{in: "8:00-17:00", wantErr: "invalid time of day"},
+		{in: "08:00-17:60", wantErr: "invalid time of day"},
+		{in: "Someday 08:00-17:00", wantErr: "unknown day"},
+		{in: "10:00-10:00", wantErr: "empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseSchedule(tt.in)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestScheduleActive(t *testing.T) {
+	sched, err := parseSchedule("Mon-Fri 08:00-17:00;Sat 22:00-02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// October 12th 2026 is a Monday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name string
+		t    time.Time
+		want bool
+	}{
+		{name: "weekday morning", t: at(12, 8, 0), want: true},
+		{name: "weekday before", t: at(12, 7, 59), want: false},
+		{name: "weekday end", t: at(16, 17, 0), want: false},
+		{name: "saturday night", t: at(17, 23, 0), want: true},
+		{name: "past midnight", t: at(18, 1, 59), want: true},
+		{name: "sunday night", t: at(18, 23, 0), want: false},
+		{name: "sunday daytime", t: at(18, 12, 0), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sched.active(tt.t); got != tt.want {
+				t.Errorf("expected active %v at %s, got %v", tt.want, tt.t, got)
+			}
+		})
+	}
+}
+
+func TestScheduleGate(t *testing.T) {
+	sched, err := parseSchedule("08:00-17:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var changes []bool
+	gate := newScheduleGate(sched, time.Date(2026, 10, 14, 7, 0, 0, 0, time.Local), func(open bool) {
+		changes = append(changes, open)
+	})
+	if gate.isOpen() {
+		t.Fatal("expected the gate to start closed")
+	}
+
+	changed := gate.wait()
+	gate.observe(time.Date(2026, 10, 14, 7, 30, 0, 0, time.Local))
+	select {
+	case <-changed:
+		t.Fatal("expected no change within the same state")
+	default:
+	}
+
+	gate.observe(time.Date(2026, 10, 14, 8, 0, 0, 0, time.Local))
+	select {
+	case <-changed:
+	default:
+		t.Fatal("expected the gate opening to be signalled")
+	}
+	if !gate.isOpen() {
+		t.Error("expected the gate to be open")
+	}
+	if len(changes) != 2 || changes[0] || !changes[1] {
+		t.Errorf("unexpected changes %v", changes)
+	}
+
+	var none *scheduleGate
+	if !none.isOpen() || none.wait() != nil {
+		t.Error("expected a nil gate to always be open")
+	}
+}
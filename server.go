@@ -0,0 +1,171 @@
+package greedydhcp
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// unixScheme prefixes METRICS_ADDR values that name a Unix domain socket.
+const unixScheme = "unix://"
+
+// checkMetricsAddr checks that addr is either a host:port pair or
+// unix:///path/to.sock, so that a malformed METRICS_ADDR fails at startup
+// rather than once the clients are running.
+func checkMetricsAddr(addr string) error {
+	if path, ok := strings.CutPrefix(addr, unixScheme); ok {
+		if path == "" {
+			return fmt.Errorf("metrics address %q has an empty socket path", addr)
+		}
+		return nil
+	}
+
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return fmt.Errorf("metrics address %q is not of the form host:port or %s/path: %w", addr, unixScheme, err)
+	}
+
+	return nil
+}
+
+// listenMetrics opens the listener for the metrics server at addr, as
+// checked by checkMetricsAddr. A stale socket file left behind by a previous
+// run is removed first; closing the listener removes the one it creates.
+func listenMetrics(addr string) (net.Listener, error) {
+	if err := checkMetricsAddr(addr); err != nil {
+		return nil, err
+	}
+
+	if path, ok := strings.CutPrefix(addr, unixScheme); ok {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("unable to remove stale socket %s: %w", path, err)
+		}
+
+		return net.Listen("unix", path)
+	}
+
+	return net.Listen("tcp", addr)
+}
+
+// cachingGatherer runs at most one Gather of the wrapped gatherer at a time.
+// Callers arriving while one is running wait for its result, and the result
+// is served to every caller for ttl after, so that simultaneous scrapes from
+// several Prometheus replicas don't each walk every target. All callers of a
+// single Gather get the same families, which must not be modified.
+type cachingGatherer struct {
+	prometheus.Gatherer
+	ttl time.Duration
+
+	mu       sync.Mutex
+	families []*dto.MetricFamily
+	err      error
+	gathered time.Time
+	// running, if set, is closed once the running Gather is done.
+	running chan struct{}
+}
+
+func (g *cachingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	g.mu.Lock()
+	if !g.gathered.IsZero() && time.Since(g.gathered) < g.ttl {
+		defer g.mu.Unlock()
+		return g.families, g.err
+	}
+
+	if running := g.running; running != nil {
+		g.mu.Unlock()
+		<-running
+
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.families, g.err
+	}
+
+	running := make(chan struct{})
+	g.running = running
+	g.mu.Unlock()
+
+	// The waiters are woken even if the wrapped Gather panics, so that a
+	// recovered panic doesn't block every later scrape.
+	defer func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		g.running = nil
+		close(running)
+	}()
+
+	families, err := g.Gatherer.Gather()
+
+	g.mu.Lock()
+	g.families, g.err, g.gathered = families, err, time.Now()
+	g.mu.Unlock()
+
+	return families, err
+}
+
+// interfaceGatherer keeps only the series of the targets running on iface,
+// dropping the rest along with series that belong to no target, so that each
+// interface can be scraped on its own.
+type interfaceGatherer struct {
+	prometheus.Gatherer
+	iface string
+	// ifaces returns the interface of every target, keyed by IP.
+	ifaces func() map[string]string
+}
+
+func (g interfaceGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	ifaces := g.ifaces()
+
+	var kept []*dto.MetricFamily
+	for _, family := range families {
+		var metrics []*dto.Metric
+		for _, m := range family.Metric {
+			if ip, ok := labelValue(m, "ip"); ok && ifaces[ip] == g.iface {
+				metrics = append(metrics, m)
+			}
+		}
+		if len(metrics) == 0 {
+			continue
+		}
+
+		kept = append(kept, &dto.MetricFamily{
+			Name:   family.Name,
+			Help:   family.Help,
+			Type:   family.Type,
+			Unit:   family.Unit,
+			Metric: metrics,
+		})
+	}
+
+	return kept, err
+}
+
+// interfaceMetricsHandler serves the metrics of the targets on each interface
+// at /metrics/<iface>, answering 404 for interfaces no target runs on.
+func interfaceMetricsHandler(g prometheus.Gatherer, ifaces func() map[string]string, opts promhttp.HandlerOpts) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/metrics/")
+
+		found := false
+		for _, iface := range ifaces() {
+			if name != "" && iface == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+
+		promhttp.HandlerFor(interfaceGatherer{Gatherer: g, iface: name, ifaces: ifaces}, opts).ServeHTTP(w, r)
+	})
+}
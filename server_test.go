@@ -0,0 +1,177 @@
+package greedydhcp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestListenMetricsUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.sock")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := listenMetrics(unixScheme + path)
+	if err != nil {
+		t.Fatalf("expected the stale socket to be replaced, got %v", err)
+	}
+
+	if listener.Addr().Network() != "unix" {
+		t.Errorf("expected a unix listener, got %s", listener.Addr().Network())
+	}
+
+	listener.Close()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the socket to be removed on close, got %v", err)
+	}
+}
+
+func TestListenMetricsInvalidAddr(t *testing.T) {
+	for _, addr := range []string{"1337", "unix://", "localhost"} {
+		t.Run(addr, func(t *testing.T) {
+			if err := checkMetricsAddr(addr); err == nil {
+				t.Error("expected the address to be rejected at startup")
+			}
+			if listener, err := listenMetrics(addr); err == nil {
+				listener.Close()
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+// countingGatherer counts its calls, blocking each one until release is
+// closed.
+type countingGatherer struct {
+	calls   atomic.Int32
+	release chan struct{}
+}
+
+func (g *countingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	g.calls.Add(1)
+	<-g.release
+	return []*dto.MetricFamily{{}}, nil
+}
+
+func TestCachingGathererCoalescesScrapes(t *testing.T) {
+	inner := &countingGatherer{release: make(chan struct{})}
+	g := &cachingGatherer{Gatherer: inner, ttl: time.Hour}
+
+	results := make(chan []*dto.MetricFamily, 5)
+	for i := 0; i < cap(results); i++ {
+		go func() {
+			families, _ := g.Gather()
+			results <- families
+		}()
+	}
+
+	// Give every caller the chance to reach the running Gather.
+	time.Sleep(50 * time.Millisecond)
+	close(inner.release)
+
+	first := <-results
+	for i := 1; i < cap(results); i++ {
+		if families := <-results; &families[0] != &first[0] {
+			t.Error("expected every caller to get the same families")
+		}
+	}
+
+	g.Gather()
+	if n := inner.calls.Load(); n != 1 {
+		t.Errorf("expected a single gather within the ttl, got %d", n)
+	}
+}
+
+// panickingGatherer panics on its first call.
+type panickingGatherer struct {
+	calls atomic.Int32
+}
+
+func (g *panickingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	if g.calls.Add(1) == 1 {
+		panic("gather failed")
+	}
+	return []*dto.MetricFamily{{}}, nil
+}
+
+func TestCachingGathererRecoversFromPanic(t *testing.T) {
+	g := &cachingGatherer{Gatherer: &panickingGatherer{}, ttl: time.Hour}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected the panic to reach the caller")
+			}
+		}()
+		g.Gather()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		g.Gather()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the next gather not to block after a panic")
+	}
+}
+
+func TestCachingGathererExpires(t *testing.T) {
+	inner := &countingGatherer{release: make(chan struct{})}
+	close(inner.release)
+	g := &cachingGatherer{Gatherer: inner}
+
+	g.Gather()
+	g.Gather()
+	if n := inner.calls.Load(); n != 2 {
+		t.Errorf("expected every gather to run without a ttl, got %d", n)
+	}
+}
+
+func TestInterfaceGatherer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	perTarget := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "per_target"}, []string{"ip"})
+	shared := prometheus.NewGauge(prometheus.GaugeOpts{Name: "shared"})
+	reg.MustRegister(perTarget, shared)
+	perTarget.WithLabelValues("10.0.0.1").Set(1)
+	perTarget.WithLabelValues("10.0.0.2").Set(2)
+
+	ifaces := func() map[string]string {
+		return map[string]string{"10.0.0.1": "eth0", "10.0.0.2": "eth0.100"}
+	}
+	families, err := interfaceGatherer{Gatherer: reg, iface: "eth0.100", ifaces: ifaces}.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(families) != 1 || families[0].GetName() != "per_target" {
+		t.Fatalf("expected only per_target to be kept, got %v", families)
+	}
+	if metrics := families[0].Metric; len(metrics) != 1 || metrics[0].GetGauge().GetValue() != 2 {
+		t.Errorf("expected only the series of 10.0.0.2, got %v", metrics)
+	}
+}
+
+func TestInterfaceMetricsHandlerUnknownInterface(t *testing.T) {
+	ifaces := func() map[string]string { return map[string]string{"10.0.0.1": "eth0"} }
+	handler := interfaceMetricsHandler(prometheus.NewRegistry(), ifaces, promhttp.HandlerOpts{})
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics/eth1", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,105 @@
+package greedydhcp
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Outcomes of squatting on a target address.
+const (
+	squatWon    = "won"
+	squatGaveUp = "gave_up"
+)
+
+var squatOutcomes = []string{squatWon, squatGaveUp}
+
+// squatter requests the address of a target without discovery, requesting it
+// again at every NAK until maxNAKs of them are received, after which the
+// client accepts whatever is offered. It outlives the client restarts between
+// attempts, and is only used by the target's own client, one at a time. A nil
+// squatter, of a target not squatting, never requests an address.
+type squatter struct {
+	logger     *slog.Logger
+	metrics    *metrics
+	targetAddr string
+	maxNAKs    int
+	// naks counts the NAKs received since squatting began.
+	naks int
+	// nakMetric is dhcp_squat_naks of the target.
+	nakMetric prometheus.Gauge
+	// retry is signalled to restart the client straight away after a NAK,
+	// to request the address again.
+	retry chan<- struct{}
+}
+
+// newSquatter returns the squatter of targetAddr, or nil if maxNAKs isn't
+// positive.
+func newSquatter(logger *slog.Logger, m *metrics, targetAddr string, maxNAKs int, retry chan<- struct{}) *squatter {
+	if maxNAKs <= 0 {
+		return nil
+	}
+
+	logger.Info("Squatting on target address", "max_naks", maxNAKs)
+	s := &squatter{
+		logger:     logger,
+		metrics:    m,
+		targetAddr: targetAddr,
+		maxNAKs:    maxNAKs,
+		nakMetric:  m.dhcpSquatNAKs.WithLabelValues(targetAddr),
+		retry:      retry,
+	}
+	s.nakMetric.Set(0)
+	for _, outcome := range squatOutcomes {
+		m.dhcpSquatOutcomesTotal.WithLabelValues(targetAddr, outcome).Add(0)
+	}
+
+	return s
+}
+
+// request makes client, which is yet to be started, request the target
+// address from server, which may be nil, counting the NAKs it gets.
+func (s *squatter) request(client *dhclient.Client, server net.IP) {
+	s.logger.Info("Requesting target address without discovery", "naks", s.naks)
+	// Starting with a lease makes the client skip the DISCOVER and go
+	// straight to a REQUEST for its address.
+	client.Lease = &dhclient.Lease{FixedAddress: net.ParseIP(s.targetAddr).To4(), ServerID: server}
+	squatting := true
+
+	onBound := client.OnBound
+	client.OnBound = func(lease *dhclient.Lease) {
+		if squatting {
+			s.logger.Info("Won target address", "addr", lease.FixedAddress, "naks", s.naks)
+			s.metrics.dhcpSquatOutcomesTotal.WithLabelValues(s.targetAddr, squatWon).Inc()
+			squatting = false
+			s.naks = 0
+		}
+		onBound(lease)
+	}
+
+	onError := client.OnError
+	client.OnError = func(err error) {
+		if squatting && errors.Is(err, dhclient.ErrNAK) {
+			s.naks++
+			s.nakMetric.Set(float64(s.naks))
+			if s.naks >= s.maxNAKs {
+				// The client discovers once the NAK drops its lease, so
+				// carry on accepting whatever is offered.
+				s.logger.Warn("Giving up on target address", "naks", s.naks)
+				s.metrics.dhcpSquatOutcomesTotal.WithLabelValues(s.targetAddr, squatGaveUp).Inc()
+				squatting = false
+				s.naks = 0
+			} else {
+				s.logger.Warn("Target address was NAKed, requesting it again", "naks", s.naks, "max_naks", s.maxNAKs)
+				select {
+				case s.retry <- struct{}{}:
+				default:
+				}
+			}
+		}
+		onError(err)
+	}
+}
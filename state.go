@@ -0,0 +1,137 @@
+package greedydhcp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+// persistedLease is the on-disk form of a held lease.
+type persistedLease struct {
+	Target  string    `json:"target"`
+	Address string    `json:"address"`
+	Server  string    `json:"server"`
+	XID     uint32    `json:"xid"`
+	Bound   time.Time `json:"bound"`
+	Renew   time.Time `json:"renew"`
+	Rebind  time.Time `json:"rebind"`
+	Expire  time.Time `json:"expire"`
+	// Infinite is set for a lease that never expires, whose times are
+	// unset.
+	Infinite bool `json:"infinite,omitempty"`
+}
+
+// saveLeaseState writes the given leases to path, replacing it atomically.
+func saveLeaseState(path string, leases map[string]dhclient.Lease) error {
+	persisted := make([]persistedLease, 0, len(leases))
+	for target, lease := range leases {
+		persisted = append(persisted, persistedLease{
+			Target:   target,
+			Address:  lease.FixedAddress.String(),
+			Server:   lease.ServerID.String(),
+			XID:      lease.XID,
+			Bound:    lease.Bound,
+			Renew:    lease.Renew,
+			Rebind:   lease.Rebind,
+			Expire:   lease.Expire,
+			Infinite: lease.Infinite,
+		})
+	}
+
+	data, err := json.MarshalIndent(persisted, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+// writeMetricsSnapshot writes every metric gathered from g to path in the
+// text exposition format, replacing it atomically.
+func writeMetricsSnapshot(path string, g prometheus.Gatherer) error {
+	families, err := g.Gather()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(&buf, family); err != nil {
+			return err
+		}
+	}
+
+	return writeFileAtomic(path, buf.Bytes())
+}
+
+// writeFileAtomic replaces the file at path with data, so that readers never
+// see it partially written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// loadLeaseState reads the leases saved at path, keyed by target. Leases that
+// have already expired are dropped. A missing file yields no leases.
+func loadLeaseState(path string, now time.Time) (map[string]dhclient.Lease, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]dhclient.Lease{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var persisted []persistedLease
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+
+	leases := make(map[string]dhclient.Lease, len(persisted))
+	for _, p := range persisted {
+		if !p.Infinite && !now.Before(p.Expire) {
+			continue
+		}
+
+		addr, server := net.ParseIP(p.Address), net.ParseIP(p.Server)
+		if addr == nil || server == nil {
+			return nil, fmt.Errorf("invalid lease for target %s in %s", p.Target, path)
+		}
+
+		leases[p.Target] = dhclient.Lease{
+			FixedAddress: addr.To4(),
+			ServerID:     server.To4(),
+			XID:          p.XID,
+			Bound:        p.Bound,
+			Renew:        p.Renew,
+			Rebind:       p.Rebind,
+			Expire:       p.Expire,
+			Infinite:     p.Infinite,
+		}
+	}
+
+	return leases, nil
+}
@@ -0,0 +1,121 @@
+package greedydhcp
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestLeaseStateRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases.json")
+	now := time.Now().Truncate(time.Second)
+
+	leases := map[string]dhclient.Lease{
+		"10.0.0.1": {
+			FixedAddress: net.ParseIP("10.0.0.1").To4(),
+			ServerID:     net.ParseIP("10.0.0.254").To4(),
+			XID:          0xdeadbeef,
+			Bound:        now,
+			Expire:       now.Add(time.Hour),
+		},
+		"10.0.0.2": {
+			FixedAddress: net.ParseIP("10.0.0.2").To4(),
+			ServerID:     net.ParseIP("10.0.0.254").To4(),
+			Bound:        now.Add(-2 * time.Hour),
+			Expire:       now.Add(-time.Hour),
+		},
+	}
+
+	if err := saveLeaseState(path, leases); err != nil {
+		t.Fatalf("unable to save leases: %v", err)
+	}
+
+	restored, err := loadLeaseState(path, now)
+	if err != nil {
+		t.Fatalf("unable to load leases: %v", err)
+	}
+
+	if len(restored) != 1 {
+		t.Fatalf("expected only the unexpired lease to be restored, got %v", restored)
+	}
+
+	lease, ok := restored["10.0.0.1"]
+	if !ok {
+		t.Fatalf("expected lease for 10.0.0.1, got %v", restored)
+	}
+	if !lease.FixedAddress.Equal(net.ParseIP("10.0.0.1")) || !lease.ServerID.Equal(net.ParseIP("10.0.0.254")) {
+		t.Errorf("unexpected addresses in restored lease: %+v", lease)
+	}
+	if lease.XID != 0xdeadbeef || !lease.Expire.Equal(now.Add(time.Hour)) {
+		t.Errorf("unexpected xid or expiry in restored lease: %+v", lease)
+	}
+}
+
+func TestLeaseStateKeepsInfiniteLeases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases.json")
+	now := time.Now().Truncate(time.Second)
+
+	leases := map[string]dhclient.Lease{
+		"10.0.0.3": {
+			FixedAddress: net.ParseIP("10.0.0.3").To4(),
+			ServerID:     net.ParseIP("10.0.0.254").To4(),
+			Bound:        now.Add(-24 * time.Hour),
+			Infinite:     true,
+		},
+	}
+	if err := saveLeaseState(path, leases); err != nil {
+		t.Fatalf("unable to save leases: %v", err)
+	}
+
+	restored, err := loadLeaseState(path, now)
+	if err != nil {
+		t.Fatalf("unable to load leases: %v", err)
+	}
+	if lease, ok := restored["10.0.0.3"]; !ok || !lease.Infinite {
+		t.Errorf("expected the infinite lease to be restored, got %v", restored)
+	}
+}
+
+func TestLoadLeaseStateMissingFile(t *testing.T) {
+	restored, err := loadLeaseState(filepath.Join(t.TempDir(), "missing.json"), time.Now())
+	if err != nil {
+		t.Fatalf("expected a missing file to be ignored, got %v", err)
+	}
+	if len(restored) != 0 {
+		t.Errorf("expected no leases, got %v", restored)
+	}
+}
+
+func TestWriteMetricsSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.prom")
+
+	reg := prometheus.NewRegistry()
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_snapshot_total", Help: "A test counter"})
+	reg.MustRegister(counter)
+	counter.Add(3)
+
+	if err := writeMetricsSnapshot(path, reg); err != nil {
+		t.Fatalf("unable to write snapshot: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(f)
+	if err != nil {
+		t.Fatalf("unable to parse snapshot: %v", err)
+	}
+	if v := families["test_snapshot_total"].GetMetric()[0].GetCounter().GetValue(); v != 3 {
+		t.Errorf("expected the counter to be 3, got %v", v)
+	}
+}
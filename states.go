@@ -0,0 +1,60 @@
+package greedydhcp
+
+import (
+	"sync"
+	"time"
+
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// stateFlushInterval is how often the time spent in the current state is
+// added to dhcp_state_seconds_total, so that a client stuck in one shows up
+// before it leaves it.
+const stateFlushInterval = 10 * time.Second
+
+// stateClock accumulates the time a target's client spends in each state in
+// dhcp_state_seconds_total. It is kept across the clients of the target, so
+// the time between them counts towards the state the last one left off in.
+type stateClock struct {
+	counters map[dhclient.State]prometheus.Counter
+
+	mu    sync.Mutex
+	state dhclient.State
+	since time.Time
+}
+
+func newStateClock(seconds *prometheus.CounterVec, target string) *stateClock {
+	c := &stateClock{counters: map[dhclient.State]prometheus.Counter{}}
+	for _, state := range dhclient.States {
+		c.counters[state] = seconds.WithLabelValues(target, string(state))
+		c.counters[state].Add(0)
+	}
+
+	return c
+}
+
+// enter moves the clock to state, counting the time spent in the last one.
+func (c *stateClock) enter(state dhclient.State) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.flushLocked()
+	c.state = state
+}
+
+// flush counts the time spent in the current state so far.
+func (c *stateClock) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.flushLocked()
+}
+
+func (c *stateClock) flushLocked() {
+	now := time.Now()
+	if counter, ok := c.counters[c.state]; ok {
+		counter.Add(now.Sub(c.since).Seconds())
+	}
+	c.since = now
+}
@@ -0,0 +1,67 @@
+package greedydhcp
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statsdTagEscaper strips the characters that delimit DogStatsD tags.
+var statsdTagEscaper = strings.NewReplacer(",", "_", "|", "_", "#", "_", ":", "_")
+
+// statsdEmitter sends lease events as StatsD counters and timers over UDP.
+// Prometheus stays the source of truth: the emitter only mirrors the events
+// as they happen, tagged with the target and the instance, in the DogStatsD
+// tag format. A nil emitter sends nothing.
+type statsdEmitter struct {
+	conn     net.Conn
+	instance string
+}
+
+// newStatsdEmitter returns an emitter sending to addr, a host:port UDP
+// address, tagging every metric with instance.
+func newStatsdEmitter(addr, instance string) (*statsdEmitter, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return &statsdEmitter{conn: conn, instance: instance}, nil
+}
+
+// count increments the counter greedydhcp.<name> of target by one. tags are
+// further name and value pairs.
+func (e *statsdEmitter) count(name, target string, tags ...string) {
+	e.send(name, "1|c", target, tags)
+}
+
+// timing records d in the timer greedydhcp.<name> of target.
+func (e *statsdEmitter) timing(name, target string, d time.Duration, tags ...string) {
+	e.send(name, strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', -1, 64)+"|ms", target, tags)
+}
+
+func (e *statsdEmitter) send(name, value, target string, tags []string) {
+	if e == nil {
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "greedydhcp.%s:%s|#instance:%s,ip:%s", name, value, statsdTagEscaper.Replace(e.instance), target)
+	for i := 0; i+1 < len(tags); i += 2 {
+		fmt.Fprintf(&b, ",%s:%s", tags[i], statsdTagEscaper.Replace(tags[i+1]))
+	}
+
+	// Like any StatsD client, drop the metric rather than block or fail
+	// when nothing listens.
+	e.conn.Write([]byte(b.String()))
+}
+
+func (e *statsdEmitter) close() error {
+	if e == nil {
+		return nil
+	}
+
+	return e.conn.Close()
+}
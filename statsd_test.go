@@ -0,0 +1,49 @@
+package greedydhcp
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStatsdEmitter(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	e, err := newStatsdEmitter(conn.LocalAddr().String(), "probe,1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.close()
+
+	e.count("failed", "10.0.0.1", "reason", "timeout")
+	e.timing("acquire_duration", "10.0.0.1", 1500*time.Microsecond)
+
+	want := []string{
+		"greedydhcp.failed:1|c|#instance:probe_1,ip:10.0.0.1,reason:timeout",
+		"greedydhcp.acquire_duration:1.5|ms|#instance:probe_1,ip:10.0.0.1",
+	}
+	buf := make([]byte, 1500)
+	for _, w := range want {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != w {
+			t.Errorf("got %q, want %q", got, w)
+		}
+	}
+}
+
+func TestStatsdEmitterNil(t *testing.T) {
+	var e *statsdEmitter
+	e.count("acquired", "10.0.0.1")
+	e.timing("acquire_duration", "10.0.0.1", time.Second)
+	if err := e.close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package greedydhcp
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/learnitall/greedy-dhcp/internal/dhclient"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// stressConfig configures the pool stress mode, in which many ephemeral
+// clients with random hardware addresses each acquire whatever address the
+// server hands out.
+type stressConfig struct {
+	// clients is the number of clients to spawn. Stress mode is disabled
+	// when it is zero.
+	clients int
+	// interval is the time between spawning clients.
+	interval time.Duration
+}
+
+// getStressConfig reads the stress mode settings from the environment.
+func getStressConfig() (stressConfig, error) {
+	var (
+		cfg stressConfig
+		err error
+	)
+
+	cfg.clients, err = getEnvInt("STRESS_CLIENTS", 0)
+	if err != nil {
+		return cfg, err
+	}
+
+	if cfg.clients < 0 {
+		return cfg, fmt.Errorf("STRESS_CLIENTS must not be negative, got %d", cfg.clients)
+	}
+
+	cfg.interval, err = getEnvDuration("STRESS_SPAWN_INTERVAL", 100*time.Millisecond)
+	if err != nil {
+		return cfg, err
+	}
+
+	if cfg.interval <= 0 {
+		return cfg, fmt.Errorf("STRESS_SPAWN_INTERVAL must be positive, got %s", cfg.interval)
+	}
+
+	return cfg, nil
+}
+
+// randomMAC returns a random locally administered unicast hardware address.
+func randomMAC() (net.HardwareAddr, error) {
+	mac := make(net.HardwareAddr, 6)
+	if _, err := rand.Read(mac); err != nil {
+		return nil, err
+	}
+
+	mac[0] = mac[0]&0xfe | 0x02
+	return mac, nil
+}
+
+// newProbeClient returns a client for an ephemeral identity with hardware
+// address mac, such as a stress client, which isn't started.
+func newProbeClient(cfg *clientConfig, logger *slog.Logger, mac net.HardwareAddr) *dhclient.Client {
+	client := &dhclient.Client{
+		Iface:             cfg.currentIface(),
+		Logger:            logger,
+		HardwareAddr:      mac,
+		TOS:               cfg.dscp << 2,
+		Retransmits:       cfg.retransmits,
+		RetransmitTimeout: cfg.retransmitTimeout,
+		RenewJitter:       cfg.renewJitter,
+	}
+
+	if !cfg.noDefaultParams {
+		for _, param := range dhclient.DefaultParamsRequestList {
+			client.AddParamRequest(layers.DHCPOpt(param))
+		}
+	}
+
+	// Servers may key leases on the client identifier rather than chaddr,
+	// so send one derived from the random address.
+	client.AddOption(layers.DHCPOptClientID, append([]byte{byte(layers.LinkTypeEthernet)}, mac...))
+
+	return client
+}
+
+// stressAddresses tracks the address held by each stress client.
+type stressAddresses struct {
+	mu   sync.Mutex
+	held map[string]string
+}
+
+// set records addr as held by the client with the given hardware address, or
+// forgets the client's address if addr is empty. It returns the number of
+// distinct addresses held.
+func (s *stressAddresses) set(mac string, addr string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if addr == "" {
+		delete(s.held, mac)
+	} else {
+		s.held[mac] = addr
+	}
+
+	distinct := make(map[string]struct{}, len(s.held))
+	for _, a := range s.held {
+		distinct[a] = struct{}{}
+	}
+
+	return len(distinct)
+}
+
+// runStress spawns stress.clients clients, one every stress.interval, and
+// stops them all once ctx is done.
+func runStress(ctx context.Context, wg *sync.WaitGroup, baseLogger *slog.Logger, cfg *clientConfig, stress stressConfig) {
+	defer wg.Done()
+
+	cfg.metrics.dhcpStressClientsTotal.Add(0)
+	cfg.metrics.dhcpStressAddressesConsumed.Set(0)
+
+	logger := baseLogger.With("mode", "stress")
+	logger.Info("Spawning stress clients", "clients", stress.clients, "interval", stress.interval)
+
+	addresses := &stressAddresses{held: map[string]string{}}
+	var clients []*dhclient.Client
+	defer func() {
+		logger.Info("Stopping stress clients", "clients", len(clients))
+		for _, client := range clients {
+			client.Stop()
+		}
+	}()
+
+	ticker := time.NewTicker(stress.interval)
+	defer ticker.Stop()
+
+	for len(clients) < stress.clients {
+		mac, err := randomMAC()
+		if err != nil {
+			logger.Error("Unable to generate hardware address, no longer spawning clients", "err", err)
+			break
+		}
+
+		clientLogger := logger.With("mac", mac)
+		key := mac.String()
+		client := newProbeClient(cfg, clientLogger, mac)
+		client.OnBound = func(lease *dhclient.Lease) {
+			clientLogger.Debug("Stress client got lease", "addr", lease.FixedAddress)
+			cfg.metrics.dhcpStressAddressesConsumed.Set(float64(addresses.set(key, lease.FixedAddress.String())))
+			cfg.metrics.dhcpStressLeaseInfo.DeletePartialMatch(prometheus.Labels{"mac": key})
+			cfg.metrics.dhcpStressLeaseInfo.WithLabelValues(key, lease.FixedAddress.String()).Set(1)
+		}
+		client.OnExpire = func(lease *dhclient.Lease) {
+			cfg.metrics.dhcpStressAddressesConsumed.Set(float64(addresses.set(key, "")))
+			cfg.metrics.dhcpStressLeaseInfo.DeletePartialMatch(prometheus.Labels{"mac": key})
+		}
+
+		client.Start()
+		clients = append(clients, client)
+		cfg.metrics.dhcpStressClientsTotal.Inc()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+
+	<-ctx.Done()
+}
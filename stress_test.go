@@ -0,0 +1,44 @@
+package greedydhcp
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunStressConsumesDistinctAddresses(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go runStress(ctx, wg, testLogger(t), testClientConfig(iface), stressConfig{clients: 3, interval: 10 * time.Millisecond})
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	waitFor(t, 10*time.Second, "stress clients to hold distinct addresses", func() bool {
+		return metricValue(t, testMetrics.dhcpStressAddressesConsumed) == 3
+	})
+
+	if v := metricValue(t, testMetrics.dhcpStressClientsTotal); v != 3 {
+		t.Errorf("expected 3 stress clients, got %v", v)
+	}
+	if !hasSeries(t, testMetrics.dhcpStressLeaseInfo, map[string]string{}) {
+		t.Error("expected the addresses held by stress clients to be exported")
+	}
+}
+
+func TestRandomMAC(t *testing.T) {
+	mac, err := randomMAC()
+	if err != nil {
+		t.Fatalf("unable to generate mac: %v", err)
+	}
+
+	if mac[0]&0x01 != 0 || mac[0]&0x02 == 0 {
+		t.Errorf("expected a locally administered unicast address, got %s", mac)
+	}
+}
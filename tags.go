@@ -0,0 +1,162 @@
+package greedydhcp
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// maxTagValueLen is the longest tag value accepted.
+const maxTagValueLen = 128
+
+// Tag cardinality above which a warning is logged, as every tag multiplies
+// the series of each target.
+const (
+	maxSafeTagKeys   = 5
+	maxSafeTagValues = 20
+)
+
+var tagNameRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedTagNames are the labels already used by target metrics, which a tag
+// would clash with.
+var reservedTagNames = map[string]bool{
+	"bootfile": true, "bound": true, "code": true, "domain": true, "ip": true, "message": true, "netmask": true, "netns": true, "next_server": true,
+	"outcome": true, "path": true, "phase": true, "policy": true, "reason": true, "relay": true, "router": true, "server": true, "server_id": true, "state": true,
+	"subnet_mask": true, "tftp_server": true, "type": true, "vlan": true, "le": true, "quantile": true, "mac": true,
+}
+
+// validateTag checks that name and value can be used as a label.
+func validateTag(name, value string) error {
+	if !tagNameRegexp.MatchString(name) || strings.HasPrefix(name, "__") {
+		return fmt.Errorf("tag name %q is not a valid label name", name)
+	}
+
+	if reservedTagNames[name] {
+		return fmt.Errorf("tag name %q is already used as a label", name)
+	}
+
+	if !utf8.ValidString(value) {
+		return fmt.Errorf("value of tag %q is not valid UTF-8", name)
+	}
+
+	if len(value) > maxTagValueLen {
+		return fmt.Errorf("value of tag %q is longer than %d bytes", name, maxTagValueLen)
+	}
+
+	return nil
+}
+
+// parseTags parses a list of tags of the form "name=value;name=value", e.g.
+// "team=netops;owner=alice".
+func parseTags(s string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, entry := range strings.Split(s, ";") {
+		name, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("tag %q is not of the form name=value", entry)
+		}
+
+		if _, ok := tags[name]; ok {
+			return nil, fmt.Errorf("tag %q is listed more than once", name)
+		}
+
+		if err := validateTag(name, value); err != nil {
+			return nil, err
+		}
+
+		tags[name] = value
+	}
+
+	return tags, nil
+}
+
+// warnTagCardinality logs a warning if the tags of targets are likely to
+// create a large number of series.
+func warnTagCardinality(logger *slog.Logger, targets []targetConfig) {
+	values := map[string]map[string]bool{}
+	for _, target := range targets {
+		for name, value := range target.tags {
+			if values[name] == nil {
+				values[name] = map[string]bool{}
+			}
+			values[name][value] = true
+		}
+	}
+
+	if len(values) > maxSafeTagKeys {
+		logger.Warn("Many distinct tag names are in use, each one is added to every target metric", "tags", len(values), "max_safe", maxSafeTagKeys)
+	}
+
+	for name, v := range values {
+		if len(v) > maxSafeTagValues {
+			logger.Warn("Tag has many distinct values, which may create too many series", "tag", name, "values", len(v), "max_safe", maxSafeTagValues)
+		}
+	}
+}
+
+// tagGatherer adds the tags of each target as labels to every series
+// labeled with its IP. Every such series gets a label for each tag name in
+// use, left empty if its target doesn't have the tag, so that the labels of a
+// metric stay consistent.
+type tagGatherer struct {
+	prometheus.Gatherer
+	// tags returns the tags of every target, keyed by IP.
+	tags func() map[string]map[string]string
+}
+
+func (g tagGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+
+	tags := g.tags()
+	seen := map[string]bool{}
+	var names []string
+	for _, t := range tags {
+		for name := range t {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	if len(names) == 0 {
+		return families, err
+	}
+	sort.Strings(names)
+
+	for _, family := range families {
+		for _, m := range family.Metric {
+			ip, ok := labelValue(m, "ip")
+			if !ok {
+				continue
+			}
+
+			for _, name := range names {
+				name, value := name, tags[ip][name]
+				m.Label = append(m.Label, &dto.LabelPair{Name: &name, Value: &value})
+			}
+			sort.Slice(m.Label, func(i, j int) bool {
+				return m.Label[i].GetName() < m.Label[j].GetName()
+			})
+		}
+	}
+
+	return families, err
+}
+
+// labelValue returns the value of the label name of m.
+func labelValue(m *dto.Metric, name string) (string, bool) {
+	for _, label := range m.Label {
+		if label.GetName() == name {
+			return label.GetValue(), true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,74 @@
+package greedydhcp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseTags(t *testing.T) {
+	tags, err := parseTags("team=netops; owner=alice")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tags["team"] != "netops" || tags["owner"] != "alice" || len(tags) != 2 {
+		t.Errorf("unexpected tags %v", tags)
+	}
+}
+
+func TestParseTagsErrors(t *testing.T) {
+	tests := []struct {
+		tags    string
+		wantErr string
+	}{
+		{tags: "team", wantErr: "not of the form name=value"},
+		{tags: "team=a;team=b", wantErr: "listed more than once"},
+		{tags: "1team=a", wantErr: "not a valid label name"},
+		{tags: "__team=a", wantErr: "not a valid label name"},
+		{tags: "reason=a", wantErr: "already used as a label"},
+		{tags: "team=" + strings.Repeat("a", maxTagValueLen+1), wantErr: "longer than"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tags, func(t *testing.T) {
+			_, err := parseTags(tt.tags)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestTagGathererAddsLabels(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test_gauge"}, []string{"ip"})
+	reg.MustRegister(vec, prometheus.NewGauge(prometheus.GaugeOpts{Name: "test_untagged"}))
+	vec.WithLabelValues("10.0.0.1").Set(1)
+	vec.WithLabelValues("10.0.0.2").Set(1)
+
+	g := tagGatherer{Gatherer: reg, tags: func() map[string]map[string]string {
+		return map[string]map[string]string{"10.0.0.1": {"team": "netops"}}
+	}}
+	families, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"10.0.0.1": "netops", "10.0.0.2": ""}
+	for _, family := range families {
+		for _, m := range family.Metric {
+			ip, ok := labelValue(m, "ip")
+			team, tagged := labelValue(m, "team")
+			if !ok {
+				if tagged {
+					t.Errorf("expected %s without an ip not to be tagged", family.GetName())
+				}
+				continue
+			}
+			if !tagged || team != want[ip] {
+				t.Errorf("expected %s to have team %q, got %q", ip, want[ip], team)
+			}
+		}
+	}
+}
@@ -0,0 +1,303 @@
+package greedydhcp
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// errTargetRemoved is the cause of the cancellation of a target's client
+// when the target is removed or disabled, which releases its lease whether
+// or not RELEASE_ON_EXIT is set: nothing is left to renew it. A lease
+// imported from the host is still only released as RELEASE_ON_EXIT says.
+var errTargetRemoved = errors.New("target removed")
+
+// errTargetChanged is the cause of the cancellation of a target's client when
+// it is restarted with new settings.
+var errTargetChanged = errors.New("target settings changed")
+
+// runningTarget is a target whose client is running.
+type runningTarget struct {
+	target targetConfig
+	cancel context.CancelCauseFunc
+	wg     *sync.WaitGroup
+}
+
+// stop cancels the target's client with cause and waits for it to exit.
+func (r *runningTarget) stop(cause error) {
+	r.cancel(cause)
+	r.wg.Wait()
+}
+
+// targetSet runs a client for every target and can be updated with a new
+// list of targets while running.
+type targetSet struct {
+	ctx    context.Context
+	logger *slog.Logger
+	cfg    *clientConfig
+
+	mu      sync.Mutex
+	running map[string]*runningTarget
+	// disabled holds the targets that are configured but disabled, whose
+	// metrics are kept until they are removed.
+	disabled map[string]bool
+}
+
+func newTargetSet(ctx context.Context, logger *slog.Logger, cfg *clientConfig) *targetSet {
+	return &targetSet{ctx: ctx, logger: logger, cfg: cfg, running: map[string]*runningTarget{}, disabled: map[string]bool{}}
+}
+
+// sameSettings reports whether a and b configure a target identically, so the
+// running client doesn't need to be restarted.
+func sameSettings(a, b targetConfig) bool {
+	// These are runtime state rather than settings.
+	a.restoredLease, b.restoredLease = nil, nil
+	a.reacquire, b.reacquire = nil, nil
+	a.startSlot, b.startSlot = nil, nil
+	a.startDelay, b.startDelay = 0, 0
+	// The priority only orders the start of targets, so a change doesn't
+	// need an already running one restarted.
+	a.priority, b.priority = 0, 0
+	// Tags are only added when metrics are gathered.
+	a.tags, b.tags = nil, nil
+
+	return reflect.DeepEqual(a, b)
+}
+
+// apply starts clients for targets that aren't running, stops the ones no
+// longer listed or disabled and restarts those whose settings changed.
+// Targets that are unchanged are left alone and keep their lease. Disabled
+// targets keep their metrics until they are removed.
+func (s *targetSet) apply(targets []targetConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wanted := make(map[string]targetConfig, len(targets))
+	disabled := map[string]bool{}
+	for _, target := range targets {
+		if target.disabled {
+			disabled[target.addr] = true
+			continue
+		}
+		wanted[target.addr] = target
+	}
+
+	s.cfg.metrics.dhcpConfiguredTargets.Set(float64(len(wanted)))
+	warnTagCardinality(s.logger, targets)
+	if len(disabled) > 0 {
+		s.logger.Info("Some targets are disabled", "targets", sortedKeys(disabled))
+	}
+
+	var added, removed, changed, disabling []string
+	for addr, r := range s.running {
+		target, ok := wanted[addr]
+		switch {
+		case disabled[addr]:
+			disabling = append(disabling, addr)
+		case !ok:
+			removed = append(removed, addr)
+		case !sameSettings(r.target, target):
+			changed = append(changed, addr)
+		default:
+			r.target.tags = target.tags
+		}
+	}
+	for _, target := range targets {
+		if _, ok := s.running[target.addr]; !ok && !target.disabled {
+			added = append(added, target.addr)
+		}
+	}
+	// Disabled targets that are no longer listed at all lose their metrics,
+	// like running ones.
+	var forgotten []string
+	for addr := range s.disabled {
+		if _, ok := wanted[addr]; !ok && !disabled[addr] {
+			forgotten = append(forgotten, addr)
+		}
+	}
+	s.disabled = disabled
+
+	if len(added)+len(removed)+len(changed)+len(disabling)+len(forgotten) == 0 {
+		s.logger.Info("Targets unchanged")
+		return
+	}
+
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	sort.Strings(disabling)
+	s.logger.Info("Applying target changes", "added", added, "removed", removed, "changed", changed, "disabled", disabling)
+
+	stop := func(addrs []string, cause error) {
+		for _, addr := range addrs {
+			s.logger.Debug("Stopping client for target address", "target", addr, "cause", cause)
+			s.running[addr].stop(cause)
+			delete(s.running, addr)
+		}
+	}
+	stop(removed, errTargetRemoved)
+	stop(changed, errTargetChanged)
+	stop(disabling, errTargetRemoved)
+
+	for _, addr := range append(removed, forgotten...) {
+		s.cfg.metrics.deleteTarget(addr)
+		s.cfg.series.forget(addr)
+	}
+
+	// Targets are queued for a start slot in the order they are started, so
+	// start those with the highest priority first. Each one starting also
+	// waits for the stagger of those before it.
+	starting := append(added, changed...)
+	sort.SliceStable(starting, func(i, j int) bool {
+		return wanted[starting[i]].priority > wanted[starting[j]].priority
+	})
+	for i, addr := range starting {
+		target := wanted[addr]
+		target.startDelay = time.Duration(i) * s.cfg.startupStagger
+		s.start(target)
+	}
+}
+
+// start runs a client for target. s.mu must be held.
+func (s *targetSet) start(target targetConfig) {
+	s.logger.Debug("Starting client for target address", "target", target.addr)
+
+	ctx, cancel := context.WithCancelCause(s.ctx)
+	target.reacquire = make(chan struct{}, 1)
+	// Targets with dependencies queue once those are bound.
+	if len(target.dependsOn) == 0 {
+		target.startSlot = s.cfg.startGate.enqueue(target.priority)
+	}
+	r := &runningTarget{target: target, cancel: cancel, wg: &sync.WaitGroup{}}
+
+	r.wg.Add(1)
+	if s.cfg.recoverPanics {
+		go superviseClient(ctx, r.wg, s.logger, s.cfg, target)
+	} else {
+		go runClient(ctx, r.wg, s.logger, s.cfg, target)
+	}
+	s.running[target.addr] = r
+}
+
+// wait blocks until every client has exited, which happens once the context
+// given to newTargetSet is done.
+func (s *targetSet) wait() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, r := range s.running {
+		r.wg.Wait()
+	}
+}
+
+// addrs returns the address of every running target, sorted.
+func (s *targetSet) addrs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addrs := make([]string, 0, len(s.running))
+	for addr := range s.running {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+
+	return addrs
+}
+
+// tags returns the tags of every running target that has any, keyed by
+// address.
+func (s *targetSet) tags() map[string]map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tags := map[string]map[string]string{}
+	for addr, r := range s.running {
+		if len(r.target.tags) > 0 {
+			tags[addr] = r.target.tags
+		}
+	}
+
+	return tags
+}
+
+// interfaces returns the interface the client of every running target runs
+// on, keyed by address. See targetInterface.
+func (s *targetSet) interfaces() map[string]string {
+	parent := s.cfg.currentIface().Name
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ifaces := make(map[string]string, len(s.running))
+	for addr, r := range s.running {
+		ifaces[addr] = targetInterface(parent, r.target)
+	}
+
+	return ifaces
+}
+
+// targetInterface names the interface target runs on, when clients run on
+// parent: the VLAN interface created for it, or parent@netns for an interface
+// of the same name in another network namespace, netns being the base name of
+// its path. It is empty if the target can't run, its VLAN interface having a
+// name too long.
+func targetInterface(parent string, target targetConfig) string {
+	switch {
+	case target.vlan != 0:
+		name, _ := vlanInterfaceName(parent, target.vlan)
+		return name
+	case target.netns != "":
+		return parent + "@" + filepath.Base(target.netns)
+	default:
+		return parent
+	}
+}
+
+// has reports whether target ip is running.
+func (s *targetSet) has(ip string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.running[ip]
+	return ok
+}
+
+// reacquireChan returns the channel that makes the client of target ip
+// re-acquire its lease.
+func (s *targetSet) reacquireChan(ip string) (chan struct{}, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r, ok := s.running[ip]
+	if !ok {
+		return nil, false
+	}
+
+	return r.target.reacquire, true
+}
+
+// sortedKeys returns the keys of m, sorted.
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// deleteTarget removes every series labeled with the given target, so targets
+// that are no longer configured stop being exported.
+func (m *metrics) deleteTarget(addr string) {
+	for _, vec := range m.targetVecs {
+		vec.DeletePartialMatch(prometheus.Labels{"ip": addr})
+	}
+}
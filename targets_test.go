@@ -0,0 +1,148 @@
+package greedydhcp
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTargetSetApply(t *testing.T) {
+	iface := loopbackInterface(t)
+	srv := newFakeDHCPServer(t, iface)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	set := newTargetSet(ctx, testLogger(t), testClientConfig(iface))
+	t.Cleanup(func() {
+		cancel()
+		set.wait()
+	})
+
+	kept, removed := "10.100.0.15", "10.100.0.16"
+	set.apply([]targetConfig{{addr: kept}, {addr: removed}})
+
+	for _, target := range []string{kept, removed} {
+		acquired := testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)
+		waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+			return metricValue(t, acquired) >= 1
+		})
+	}
+
+	running := set.running[kept]
+	released := srv.releaseCount()
+	set.apply([]targetConfig{{addr: kept}})
+
+	if set.running[kept] != running {
+		t.Error("expected the unchanged target to keep running")
+	}
+	if addrs := set.addrs(); len(addrs) != 1 || addrs[0] != kept {
+		t.Errorf("expected only %s to be running, got %v", kept, addrs)
+	}
+	if hasSeries(t, testMetrics.dhcpAcquiredLeasesTotal, map[string]string{"ip": removed}) {
+		t.Error("expected the metrics of the removed target to be deleted")
+	}
+	if _, ok := set.reacquireChan(removed); ok {
+		t.Error("expected the removed target to be unknown")
+	}
+	// Only the removed target's lease is released, even without
+	// RELEASE_ON_EXIT.
+	waitFor(t, 5*time.Second, "the removed target's lease to be released", func() bool {
+		return srv.releaseCount() > released
+	})
+	if n := srv.releaseCount() - released; n != 1 {
+		t.Errorf("expected a single release, got %d", n)
+	}
+}
+
+func TestTargetSetDisable(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	set := newTargetSet(ctx, testLogger(t), testClientConfig(iface))
+	t.Cleanup(func() {
+		cancel()
+		set.wait()
+	})
+
+	target := "10.100.0.28"
+	set.apply([]targetConfig{{addr: target}})
+	waitFor(t, 10*time.Second, "lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(target)) >= 1
+	})
+
+	set.apply([]targetConfig{{addr: target, disabled: true}})
+	if addrs := set.addrs(); len(addrs) != 0 {
+		t.Errorf("expected the disabled target to be stopped, got %v running", addrs)
+	}
+	if v := metricValue(t, testMetrics.dhcpConfiguredTargets); v != 0 {
+		t.Errorf("expected disabled targets not to be counted as configured, got %v", v)
+	}
+	if !hasSeries(t, testMetrics.dhcpAcquiredLeasesTotal, map[string]string{"ip": target}) {
+		t.Error("expected the metrics of the disabled target to be kept")
+	}
+
+	set.apply([]targetConfig{{addr: target}})
+	if addrs := set.addrs(); len(addrs) != 1 {
+		t.Errorf("expected the enabled target to be started again, got %v running", addrs)
+	}
+
+	set.apply([]targetConfig{{addr: target, disabled: true}})
+	set.apply(nil)
+	if hasSeries(t, testMetrics.dhcpAcquiredLeasesTotal, map[string]string{"ip": target}) {
+		t.Error("expected the metrics of the removed disabled target to be deleted")
+	}
+}
+
+func TestTargetInterface(t *testing.T) {
+	for _, tc := range []struct {
+		target targetConfig
+		want   string
+	}{
+		{targetConfig{}, "eth0"},
+		{targetConfig{vlan: 100}, "eth0.100"},
+		{targetConfig{netns: "/var/run/netns/blue"}, "eth0@blue"},
+	} {
+		if got := targetInterface("eth0", tc.target); got != tc.want {
+			t.Errorf("expected %+v to run on %s, got %s", tc.target, tc.want, got)
+		}
+	}
+}
+
+func TestTargetSetStaggersStarts(t *testing.T) {
+	iface := loopbackInterface(t)
+	newFakeDHCPServer(t, iface)
+
+	cfg := testClientConfig(iface)
+	cfg.startupStagger = 500 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	set := newTargetSet(ctx, testLogger(t), cfg)
+	t.Cleanup(func() {
+		cancel()
+		set.wait()
+	})
+
+	targets := []string{"10.100.0.75", "10.100.0.76", "10.100.0.77"}
+	set.apply([]targetConfig{{addr: targets[2]}, {addr: targets[0]}, {addr: targets[1]}})
+
+	for i, addr := range targets {
+		if got, want := set.running[addr].target.startDelay, time.Duration(i)*cfg.startupStagger; got != want {
+			t.Errorf("expected %s to wait %s to start, got %s", addr, want, got)
+		}
+	}
+
+	waitFor(t, 10*time.Second, "first lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(targets[0])) >= 1
+	})
+	if v := metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(targets[2])); v != 0 {
+		t.Errorf("expected the last target to still be waiting to start, got %v leases", v)
+	}
+	waitFor(t, 10*time.Second, "last lease to be acquired", func() bool {
+		return metricValue(t, testMetrics.dhcpAcquiredLeasesTotal.WithLabelValues(targets[2])) >= 1
+	})
+
+	running := set.running[targets[2]]
+	set.apply([]targetConfig{{addr: targets[0]}, {addr: targets[1]}, {addr: targets[2]}})
+	if set.running[targets[2]] != running {
+		t.Error("expected the start delay not to count as a changed setting")
+	}
+}
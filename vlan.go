@@ -0,0 +1,33 @@
+package greedydhcp
+
+import (
+	"fmt"
+	"sync"
+)
+
+// maxIfaceNameLen is the longest name an interface can have on Linux.
+const maxIfaceNameLen = 15
+
+// vlanManager creates the VLAN interfaces targets run on, on top of the
+// interface clients run on, and removes the ones it created on cleanup.
+// Interfaces that already exist are used as they are and left in place.
+type vlanManager struct {
+	mu sync.Mutex
+	// created holds the names of the interfaces created so far.
+	created map[string]bool
+}
+
+func newVLANManager() *vlanManager {
+	return &vlanManager{created: map[string]bool{}}
+}
+
+// vlanInterfaceName returns the name of the interface of VLAN id on parent,
+// e.g. eth0.10.
+func vlanInterfaceName(parent string, id int) (string, error) {
+	name := fmt.Sprintf("%s.%d", parent, id)
+	if len(name) > maxIfaceNameLen {
+		return "", fmt.Errorf("interface name %s is longer than %d bytes", name, maxIfaceNameLen)
+	}
+
+	return name, nil
+}
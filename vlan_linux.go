@@ -0,0 +1,62 @@
+//go:build linux
+
+package greedydhcp
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+
+	"github.com/vishvananda/netlink"
+)
+
+// ensure returns the interface of VLAN id on parent, creating and bringing it
+// up if it doesn't exist yet.
+func (m *vlanManager) ensure(parent *net.Interface, id int) (*net.Interface, error) {
+	name, err := vlanInterfaceName(parent.Name, id)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if iface, err := net.InterfaceByName(name); err == nil {
+		return iface, nil
+	}
+
+	link := &netlink.Vlan{
+		LinkAttrs: netlink.LinkAttrs{Name: name, ParentIndex: parent.Index},
+		VlanId:    id,
+	}
+	if err := netlink.LinkAdd(link); err != nil {
+		return nil, fmt.Errorf("unable to create %s: %w", name, err)
+	}
+	m.created[name] = true
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return nil, fmt.Errorf("unable to bring up %s: %w", name, err)
+	}
+
+	return net.InterfaceByName(name)
+}
+
+// cleanup removes every interface created by m.
+func (m *vlanManager) cleanup(logger *slog.Logger) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for name := range m.created {
+		link, err := netlink.LinkByName(name)
+		if err == nil {
+			err = netlink.LinkDel(link)
+		}
+		if err != nil {
+			logger.Warn("Unable to remove VLAN interface", "iface", name, "err", err)
+			continue
+		}
+
+		logger.Info("Removed VLAN interface", "iface", name)
+		delete(m.created, name)
+	}
+}
@@ -0,0 +1,52 @@
+//go:build linux
+
+package greedydhcp
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestVLANManagerCreatesAndRemoves(t *testing.T) {
+	parent := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "gdtest0"}, PeerName: "gdtest1"}
+	if err := netlink.LinkAdd(parent); err != nil {
+		if errors.Is(err, os.ErrPermission) || errors.Is(err, errors.ErrUnsupported) {
+			t.Skipf("unable to create a veth pair: %v", err)
+		}
+		t.Fatalf("unable to create a veth pair: %v", err)
+	}
+	t.Cleanup(func() { netlink.LinkDel(parent) })
+
+	parentIface, err := net.InterfaceByName("gdtest0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := newVLANManager()
+	iface, err := m.ensure(parentIface, 10)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skipf("VLAN interfaces aren't supported by the kernel: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("unable to create the VLAN interface: %v", err)
+	}
+	if iface.Name != "gdtest0.10" || iface.Flags&net.FlagUp == 0 {
+		t.Errorf("expected gdtest0.10 to be up, got %s with flags %s", iface.Name, iface.Flags)
+	}
+
+	again, err := m.ensure(parentIface, 10)
+	if err != nil || again.Index != iface.Index {
+		t.Errorf("expected the existing interface to be reused, got %v and %v", again, err)
+	}
+
+	m.cleanup(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if _, err := net.InterfaceByName("gdtest0.10"); err == nil {
+		t.Error("expected the VLAN interface to be removed")
+	}
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package greedydhcp
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+)
+
+// ensure is only supported on Linux.
+func (m *vlanManager) ensure(parent *net.Interface, id int) (*net.Interface, error) {
+	return nil, errors.New("VLAN interfaces are only supported on linux")
+}
+
+// cleanup has nothing to remove, as no interface is ever created.
+func (m *vlanManager) cleanup(logger *slog.Logger) {}
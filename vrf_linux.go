@@ -0,0 +1,57 @@
+//go:build linux
+
+package greedydhcp
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// checkVRF returns an error unless name is a VRF device.
+func checkVRF(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return err
+	}
+	if link.Type() != "vrf" {
+		return fmt.Errorf("%s is a %s device, not a vrf", name, link.Type())
+	}
+
+	return nil
+}
+
+// interfaceVRF returns the name of the VRF the named interface is enslaved
+// to, empty if it isn't.
+func interfaceVRF(name string) string {
+	link, err := netlink.LinkByName(name)
+	if err != nil || link.Attrs().MasterIndex == 0 {
+		return ""
+	}
+
+	master, err := netlink.LinkByIndex(link.Attrs().MasterIndex)
+	if err != nil || master.Type() != "vrf" {
+		return ""
+	}
+
+	return master.Attrs().Name
+}
+
+// bindToDevice binds the socket c to device with SO_BINDTODEVICE, so that it
+// is routed with the table of the VRF device is or belongs to.
+func bindToDevice(c syscall.RawConn, device string) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, device)
+	})
+	if err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("unable to bind to %s: %w", device, sockErr)
+	}
+
+	return nil
+}
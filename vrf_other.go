@@ -0,0 +1,23 @@
+//go:build !linux
+
+package greedydhcp
+
+import (
+	"errors"
+	"syscall"
+)
+
+// checkVRF fails, as VRFs are only supported on Linux.
+func checkVRF(name string) error {
+	return errors.New("VRFs are only supported on linux")
+}
+
+// interfaceVRF finds no VRF outside of Linux.
+func interfaceVRF(name string) string {
+	return ""
+}
+
+// bindToDevice is only supported on Linux.
+func bindToDevice(c syscall.RawConn, device string) error {
+	return errors.New("binding to a device is only supported on linux")
+}